@@ -0,0 +1,232 @@
+package can
+
+import "strconv"
+
+// A BusError contains the details of an error condition reported
+// by a CAN controller. Its fields correspond to the contents of
+// SocketCAN error frames, as defined in linux/can/error.h. Drivers
+// attach a BusError to status messages using Msg.SetBusError.
+type BusError struct {
+	Class ErrorClass
+
+	// LostArbBit is the bit position where arbitration
+	// has been lost, if Class contains ClassLostArb; zero
+	// if unspecified.
+	LostArbBit uint8
+
+	Ctrl ControllerStatus // if Class contains ClassCtrl
+
+	// Prot and ProtLoc describe the kind, and the location of
+	// a protocol violation, if Class contains ClassProt.
+	Prot    ProtViolation
+	ProtLoc ProtLocation
+
+	Trx uint8 // transceiver status, if Class contains ClassTrx
+
+	// Error counters, valid if Class contains ClassCounters.
+	TxErrCount uint8
+	RxErrCount uint8
+}
+
+// ErrorClass is a bit mask describing the classes of errors reported.
+type ErrorClass uint32
+
+const (
+	ClassTxTimeout ErrorClass = 1 << iota // TX timeout (by netdevice driver)
+	ClassLostArb                          // lost arbitration
+	ClassCtrl                             // controller problems, see BusError.Ctrl
+	ClassProt                             // protocol violations, see BusError.Prot
+	ClassTrx                              // transceiver status, see BusError.Trx
+	ClassAck                              // received no ACK on transmission
+	ClassBusOff                           // bus off
+	ClassBusError                         // bus error (may flood!)
+	ClassRestarted                        // controller restarted
+	ClassCounters                         // TX and RX error counters are valid
+)
+
+// ControllerStatus is a bit mask describing problems of the controller.
+type ControllerStatus uint8
+
+const (
+	CtrlRxOverflow ControllerStatus = 1 << iota // RX buffer overflow
+	CtrlTxOverflow                              // TX buffer overflow
+	CtrlRxWarning                               // reached warning level for RX errors
+	CtrlTxWarning                               // reached warning level for TX errors
+	CtrlRxPassive                               // reached error passive status RX
+	CtrlTxPassive                               // reached error passive status TX
+	CtrlActive                                  // recovered to error active state
+)
+
+// ProtViolation is a bit mask describing the kind of a protocol violation.
+type ProtViolation uint8
+
+const (
+	ProtBit      ProtViolation = 1 << iota // single bit error
+	ProtForm                               // frame format error
+	ProtStuff                              // bit stuffing error
+	ProtBit0                               // unable to send dominant bit
+	ProtBit1                               // unable to send recessive bit
+	ProtOverload                           // bus overload
+	ProtActive                             // active error announcement
+	ProtTx                                 // error occurred on transmission
+)
+
+// ProtLocation denotes the location of a protocol violation within a frame.
+type ProtLocation uint8
+
+const (
+	LocUnspec  ProtLocation = 0x00
+	LocSOF     ProtLocation = 0x03 // start of frame
+	LocID28_21 ProtLocation = 0x02 // ID bits 28 - 21 (SFF: 10 - 3)
+	LocID20_18 ProtLocation = 0x06 // ID bits 20 - 18 (SFF: 2 - 0)
+	LocSRTR    ProtLocation = 0x04 // substitute RTR (SFF: RTR)
+	LocIDE     ProtLocation = 0x05 // identifier extension
+	LocID17_13 ProtLocation = 0x07 // ID bits 17-13
+	LocID12_05 ProtLocation = 0x0F // ID bits 12-5
+	LocID04_00 ProtLocation = 0x0E // ID bits 4-0
+	LocRTR     ProtLocation = 0x0C // RTR
+	LocRes1    ProtLocation = 0x0D // reserved bit 1
+	LocRes0    ProtLocation = 0x09 // reserved bit 0
+	LocDLC     ProtLocation = 0x0B // data length code
+	LocData    ProtLocation = 0x0A // data section
+	LocCRCSeq  ProtLocation = 0x08 // CRC sequence
+	LocCRCDel  ProtLocation = 0x18 // CRC delimiter
+	LocAck     ProtLocation = 0x19 // ACK slot
+	LocAckDel  ProtLocation = 0x1B // ACK delimiter
+	LocEOF     ProtLocation = 0x1A // end of frame
+	LocInterm  ProtLocation = 0x12 // intermission
+)
+
+// State denotes the error state of a CAN controller.
+type State int
+
+const (
+	StateUnknown State = iota
+	StateErrorActive
+	StateErrorWarning
+	StateErrorPassive
+	StateBusOff
+	StateStopped
+)
+
+var stateNames = [...]string{
+	StateUnknown:      "unknown",
+	StateErrorActive:  "error-active",
+	StateErrorWarning: "error-warning",
+	StateErrorPassive: "error-passive",
+	StateBusOff:       "bus-off",
+	StateStopped:      "stopped",
+}
+
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return "state(" + strconv.Itoa(int(s)) + ")"
+	}
+	return stateNames[s]
+}
+
+// ControllerState is the state of a CAN controller,
+// as reported by a StateReporter.
+type ControllerState struct {
+	State State
+
+	// TxErrCount and RxErrCount are the controller's error
+	// counters; they are only valid if HasCounters is true.
+	TxErrCount  int
+	RxErrCount  int
+	HasCounters bool
+
+	// Restarts is the number of times the controller
+	// has been restarted after a bus-off condition.
+	Restarts int
+}
+
+// State returns the controller state as far as it can be
+// derived from the error; StateUnknown is returned if the
+// error does not indicate a state.
+func (e *BusError) State() State {
+	switch {
+	case e.Class&ClassBusOff != 0:
+		return StateBusOff
+	case e.Class&ClassCtrl != 0 && e.Ctrl&(CtrlRxPassive|CtrlTxPassive) != 0:
+		return StateErrorPassive
+	case e.Class&ClassCtrl != 0 && e.Ctrl&(CtrlRxWarning|CtrlTxWarning) != 0:
+		return StateErrorWarning
+	case e.Class&ClassCtrl != 0 && e.Ctrl&CtrlActive != 0,
+		e.Class&ClassRestarted != 0:
+		return StateErrorActive
+	}
+	return StateUnknown
+}
+
+// Flags returns the status message flags summarizing the error.
+func (e *BusError) Flags() Flags {
+	f := StatusMsg
+	if e.Class&ClassAck != 0 {
+		f |= MissingAck
+	}
+	if e.Class&ClassCtrl != 0 && e.Ctrl&CtrlRxOverflow != 0 {
+		f |= ReceiveBufferOverflow
+	}
+	switch e.State() {
+	case StateBusOff:
+		f |= BusOff
+	case StateErrorPassive:
+		f |= ErrorPassive
+	case StateErrorWarning:
+		f |= ErrorWarning
+	case StateErrorActive:
+		f |= ErrorActive
+	}
+	return f
+}
+
+// knownClasses contains all defined ErrorClass bits.
+const knownClasses = ClassCounters<<1 - 1
+
+// busErrorLen is the length of the data of a status
+// message carrying a BusError, like SocketCAN's error frames.
+const busErrorLen = 8
+
+// SetBusError turns m into a status message carrying e. The flags of
+// m are set as returned by e.Flags. Like SocketCAN error frames, the
+// message's Id contains the error class, and the data contains the
+// remaining fields of e.
+func (m *Msg) SetBusError(e *BusError) {
+	m.Id = uint32(e.Class)
+	m.Flags = e.Flags()
+	m.XL = XLHeader{}
+	data := []byte{
+		e.LostArbBit,
+		byte(e.Ctrl),
+		byte(e.Prot),
+		byte(e.ProtLoc),
+		e.Trx,
+		0, // controller specific
+		e.TxErrCount,
+		e.RxErrCount,
+	}
+	m.SetData(data)
+}
+
+// BusError returns the details of an error reported by a status
+// message, if the message carries them, i.e. if its Id contains
+// error class bits, and the data has the expected length.
+func (m *Msg) BusError() (e BusError, ok bool) {
+	data := m.Data()
+	if !m.IsStatus() || len(data) != busErrorLen {
+		return e, false
+	}
+	if m.Id == 0 || m.Id&^uint32(knownClasses) != 0 {
+		return e, false
+	}
+	e.Class = ErrorClass(m.Id)
+	e.LostArbBit = data[0]
+	e.Ctrl = ControllerStatus(data[1])
+	e.Prot = ProtViolation(data[2])
+	e.ProtLoc = ProtLocation(data[3])
+	e.Trx = data[4]
+	e.TxErrCount = data[6]
+	e.RxErrCount = data[7]
+	return e, true
+}
@@ -0,0 +1,25 @@
+package can
+
+import "testing"
+
+func TestMsgBusError(t *testing.T) {
+	var m Msg
+	m.SetBusError(&BusError{Class: ClassCtrl | ClassCounters, Ctrl: CtrlRxPassive, TxErrCount: 1, RxErrCount: 130})
+	e, ok := m.BusError()
+	if !ok {
+		t.Fatal("bus error not found")
+	}
+	if e.Class != ClassCtrl|ClassCounters || e.Ctrl != CtrlRxPassive || e.RxErrCount != 130 {
+		t.Errorf("unexpected bus error: %+v", e)
+	}
+	if m.Flags != StatusMsg|ErrorPassive {
+		t.Errorf("unexpected flags: %#x", m.Flags)
+	}
+
+	for _, id := range []uint32{0, uint32(ClassCounters) << 1} {
+		m.Id = id
+		if _, ok := m.BusError(); ok {
+			t.Errorf("%#x: unexpected bus error", id)
+		}
+	}
+}
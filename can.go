@@ -53,6 +53,20 @@ type Device interface {
 	Close() error
 }
 
+// A StateReporter is a Device that is able to report
+// the state of its CAN controller. Drivers implement this
+// interface optionally; use a type assertion to check
+// whether a Device supports it.
+type StateReporter interface {
+	ControllerState() (*ControllerState, error)
+}
+
+// A Restarter is a Device that allows its CAN controller
+// to be restarted manually after a bus-off condition.
+type Restarter interface {
+	Restart() error
+}
+
 type DeviceInfo struct {
 	ID string
 
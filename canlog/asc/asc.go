@@ -0,0 +1,395 @@
+// Package asc reads and writes Vector ASCII trace files (.asc),
+// as created by CANoe and CANalyzer.
+//
+// Classic CAN frames, CAN FD frames, error frames, and chip status
+// events are supported; other events are skipped when reading.
+package asc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+	"github.com/knieriem/can/canlog/internal/dlc"
+)
+
+var ErrSyntax = errors.New("asc: syntax error")
+
+// flags of CANFD events
+const (
+	fdFlagRTR = 0x0010
+	fdFlagEDL = 0x1000
+	fdFlagBRS = 0x2000
+	fdFlagESI = 0x4000
+)
+
+const dateLayout = "Mon Jan 2 03:04:05.000 pm 2006"
+
+var dateLayouts = []string{
+	dateLayout,
+	"Mon Jan 2 3:04:05.000 PM 2006",
+	"Mon Jan 2 15:04:05.000 2006",
+	"Mon Jan 2 3:04:05 pm 2006",
+	"Mon Jan 2 3:04:05 PM 2006",
+	"Mon Jan 2 15:04:05 2006",
+}
+
+// Writer writes messages to an ASC file. Timestamps are written
+// relative to the time of the first message, which is also
+// written as start date into the file header.
+type Writer struct {
+	w     *bufio.Writer
+	start can.Time
+	begun bool
+}
+
+// NewWriter returns a Writer writing to w.
+// Close must be called after the last message has been written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+func (w *Writer) begin(t can.Time) {
+	w.start = t - t%1000
+	date := t.Time().Format(dateLayout)
+	fmt.Fprintf(w.w, "date %s\n", date)
+	fmt.Fprintf(w.w, "base hex  timestamps absolute\n")
+	fmt.Fprintf(w.w, "no internal events logged\n")
+	fmt.Fprintf(w.w, "Begin Triggerblock %s\n", date)
+	fmt.Fprintf(w.w, "%11.6f Start of measurement\n", 0.0)
+	w.begun = true
+}
+
+// WriteMsg writes message m. Messages should be
+// written in the order of their timestamps.
+func (w *Writer) WriteMsg(m *can.Msg, info canlog.Info) error {
+	if m.XLFrame() {
+		return canlog.ErrXLNotSupported
+	}
+	if !w.begun {
+		w.begin(m.Rx.Time)
+	}
+	t := float64(m.Rx.Time-w.start) / 1e6
+	ch := max(info.Channel, 1)
+
+	var err error
+	if m.IsStatus() {
+		if s := chipStatus(m.Flags); s != "" {
+			_, err = fmt.Fprintf(w.w, "%11.6f CAN %d Status:chip status %s\n", t, ch, s)
+		} else {
+			_, err = fmt.Fprintf(w.w, "%11.6f %-2d ErrorFrame\n", t, ch)
+		}
+		return err
+	}
+
+	id := strconv.FormatUint(uint64(m.Id), 16)
+	if m.ExtFrame() {
+		id += "x"
+	}
+	data := m.Data()
+	_, needsFD, _ := can.VerifyDataLenFD(len(data))
+	if needsFD || m.Test(can.ForceFD) {
+		flags := fdFlagEDL
+		brs, esi := 0, 0
+		if m.Test(can.FDSwitchBitrate) {
+			flags |= fdFlagBRS
+			brs = 1
+		}
+		if m.Test(can.FDErrorStateInd) {
+			flags |= fdFlagESI
+			esi = 1
+		}
+		_, err = fmt.Fprintf(w.w, "%11.6f CANFD %3d %-4s %8s %d %d %x %2d%s %8d %4d %8x %8x %8x %8x %8x %8x\n",
+			t, ch, info.Dir, id, brs, esi, dlc.FromLen(len(data)), len(data), hexBytes(data),
+			0, 0, flags, 0, 0, 0, 0, 0)
+		return err
+	}
+	if m.Test(can.RTRMsg) {
+		_, err = fmt.Fprintf(w.w, "%11.6f %-2d %-15s %-4s r\n", t, ch, id, info.Dir)
+	} else {
+		_, err = fmt.Fprintf(w.w, "%11.6f %-2d %-15s %-4s d %d%s\n", t, ch, id, info.Dir, len(data), hexBytes(data))
+	}
+	return err
+}
+
+// Close terminates the trigger block and flushes buffered data.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if !w.begun {
+		w.begin(can.Now())
+	}
+	fmt.Fprintf(w.w, "End TriggerBlock\n")
+	return w.w.Flush()
+}
+
+func hexBytes(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		fmt.Fprintf(&b, " %02x", c)
+	}
+	return b.String()
+}
+
+func chipStatus(f can.Flags) string {
+	switch {
+	case f.Test(can.BusOff):
+		return "bus off"
+	case f.Test(can.ErrorPassive):
+		return "error passive"
+	case f.Test(can.ErrorWarning):
+		return "warning level"
+	case f.Test(can.ErrorActive):
+		return "error active"
+	}
+	return ""
+}
+
+// Reader reads messages from an ASC file.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+
+	// Start is the start time of the measurement,
+	// as specified in the file header. It is zero
+	// if the header is missing or could not be parsed.
+	Start time.Time
+
+	start    can.Time
+	base     int
+	relative bool
+	last     can.Time
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r), base: 16}
+}
+
+// ReadMsg reads the next message into m. Message timestamps
+// are absolute, if the start time of the measurement is known,
+// or relative to the start of the measurement otherwise.
+// At the end of the input, io.EOF is returned.
+func (r *Reader) ReadMsg(m *can.Msg) (canlog.Info, error) {
+	for r.s.Scan() {
+		r.line++
+		f := strings.Fields(r.s.Text())
+		if len(f) == 0 {
+			continue
+		}
+		ok, info, err := r.parseLine(f, m)
+		if err != nil {
+			return info, fmt.Errorf("%w: line %d: %v", ErrSyntax, r.line, err)
+		}
+		if ok {
+			return info, nil
+		}
+	}
+	if err := r.s.Err(); err != nil {
+		return canlog.Info{}, err
+	}
+	return canlog.Info{}, io.EOF
+}
+
+func (r *Reader) parseLine(f []string, m *can.Msg) (ok bool, info canlog.Info, err error) {
+	switch f[0] {
+	case "date":
+		for _, layout := range dateLayouts {
+			t, err := time.ParseInLocation(layout, strings.Join(f[1:], " "), time.Local)
+			if err == nil {
+				r.Start = t
+				r.start = can.Time(t.UnixMicro())
+				break
+			}
+		}
+		return false, info, nil
+	case "base":
+		for i := 1; i+1 < len(f); i++ {
+			switch f[i] {
+			case "hex":
+				r.base = 16
+			case "dec":
+				r.base = 10
+			case "timestamps":
+				r.relative = f[i+1] == "relative"
+			}
+		}
+		return false, info, nil
+	}
+	ts, err := strconv.ParseFloat(f[0], 64)
+	if err != nil || len(f) < 3 {
+		return false, info, nil
+	}
+	t := can.Time(ts*1e6 + 0.5)
+	if r.relative {
+		t += r.last
+		r.last = t
+	}
+	m.Reset()
+	m.Rx.Time = r.start + t
+
+	if f[1] == "CANFD" {
+		return r.parseFD(f[2:], m)
+	}
+	if f[1] == "CAN" && len(f) > 3 && strings.HasPrefix(f[3], "Status:") {
+		info.Channel, err = strconv.Atoi(f[2])
+		if err != nil {
+			return false, info, err
+		}
+		m.Flags = can.StatusMsg | parseChipStatus(strings.Join(f[3:], " "))
+		return m.Flags != can.StatusMsg, info, nil
+	}
+	info.Channel, err = strconv.Atoi(f[1])
+	if err != nil {
+		// other kind of event
+		return false, info, nil
+	}
+	if f[2] == "ErrorFrame" {
+		m.Flags = can.StatusMsg
+		return true, info, nil
+	}
+	if len(f) < 5 {
+		return false, info, nil
+	}
+	switch f[3] {
+	case "Rx":
+	case "Tx":
+		info.Dir = canlog.Tx
+	default:
+		// transmit requests, or other events
+		return false, info, nil
+	}
+	if err := r.parseID(f[2], m); err != nil {
+		return false, info, err
+	}
+	switch f[4] {
+	case "r":
+		m.Flags |= can.RTRMsg
+		return true, info, nil
+	case "d":
+	default:
+		return false, info, fmt.Errorf("unknown frame type %q", f[4])
+	}
+	if len(f) < 6 {
+		return false, info, errors.New("missing DLC")
+	}
+	code, err := strconv.ParseUint(f[5], 16, 4)
+	if err != nil {
+		return false, info, err
+	}
+	return true, info, setData(m, f[6:], dlc.Len(byte(code), false))
+}
+
+func (r *Reader) parseID(s string, m *can.Msg) error {
+	s, ext := strings.CutSuffix(s, "x")
+	id, err := strconv.ParseUint(s, r.base, 29)
+	if err != nil {
+		return err
+	}
+	m.Id = uint32(id)
+	if ext {
+		m.Flags |= can.ExtFrame
+	} else if id > 0x7FF {
+		return fmt.Errorf("invalid identifier %q", s)
+	}
+	return nil
+}
+
+// parseFD parses the fields of a CANFD event following the keyword:
+//
+//	<channel> <dir> <id> [<name>] <brs> <esi> <dlc> <len> <data>... <duration> <bits> <flags> ...
+func (r *Reader) parseFD(f []string, m *can.Msg) (ok bool, info canlog.Info, err error) {
+	if len(f) < 7 {
+		return false, info, errors.New("incomplete CANFD event")
+	}
+	info.Channel, err = strconv.Atoi(f[0])
+	if err != nil {
+		return false, info, err
+	}
+	switch f[1] {
+	case "Rx":
+	case "Tx":
+		info.Dir = canlog.Tx
+	default:
+		return false, info, nil
+	}
+	if err := r.parseID(f[2], m); err != nil {
+		return false, info, err
+	}
+	i := 3
+	if !isBit(f[i]) || !isBit(f[i+1]) {
+		// symbolic name
+		i++
+	}
+	if len(f) < i+4 {
+		return false, info, errors.New("incomplete CANFD event")
+	}
+	if f[i] == "1" {
+		m.Flags |= can.FDSwitchBitrate
+	}
+	if f[i+1] == "1" {
+		m.Flags |= can.FDErrorStateInd
+	}
+	n, err := strconv.Atoi(f[i+3])
+	if err != nil || n < 0 || n > len(f)-i-4 {
+		return false, info, fmt.Errorf("invalid data length %q", f[i+3])
+	}
+	flags := fdFlagEDL
+	if j := i + 4 + n + 2; j < len(f) {
+		v, err := strconv.ParseUint(f[j], 16, 32)
+		if err != nil {
+			return false, info, err
+		}
+		flags = int(v)
+	}
+	if flags&fdFlagEDL != 0 {
+		m.Flags |= can.ForceFD
+	} else {
+		m.Flags &^= can.FDSwitchBitrate | can.FDErrorStateInd
+	}
+	if flags&fdFlagRTR != 0 {
+		m.Flags |= can.RTRMsg
+		return true, info, nil
+	}
+	return true, info, setData(m, f[i+4:], n)
+}
+
+func isBit(s string) bool {
+	return s == "0" || s == "1"
+}
+
+func setData(m *can.Msg, f []string, n int) error {
+	if len(f) < n {
+		return errors.New("missing data bytes")
+	}
+	data := make([]byte, n)
+	for i := range data {
+		v, err := strconv.ParseUint(f[i], 16, 8)
+		if err != nil {
+			return err
+		}
+		data[i] = byte(v)
+	}
+	m.SetData(data)
+	return nil
+}
+
+func parseChipStatus(s string) can.Flags {
+	s = strings.ToLower(s)
+	switch {
+	case strings.Contains(s, "bus off") || strings.Contains(s, "busoff"):
+		return can.BusOff
+	case strings.Contains(s, "passive"):
+		return can.ErrorPassive
+	case strings.Contains(s, "warning"):
+		return can.ErrorWarning
+	case strings.Contains(s, "active"):
+		return can.ErrorActive
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+package asc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+const testASC = `date Wed Jan 10 09:34:14.123 am 2024
+base hex  timestamps absolute
+internal events logged
+// version 13.0.0
+Begin Triggerblock Wed Jan 10 09:34:14.123 am 2024
+   0.000000 Start of measurement
+   0.001234 1  123             Rx   d 8 01 02 03 04 05 06 07 08  Length = 0 BitCount = 0 ID = 291
+   0.002000 2  1abcdefx        Tx   d 2 0a 0b
+   0.002500 1  7ff             TxRq d 0
+   0.003000 1  100             Rx   r
+   0.004000 1  ErrorFrame
+   0.004500 CAN 1 Status:chip status error passive
+   0.005000 CANFD   1 Rx        123  Engine                           1 0 9 12 01 02 03 04 05 06 07 08 09 0a 0b 0c   130000  130     3000 0 0 0 0 0
+   0.006000 CANFD   3 Tx        456                                   0 0 2  2 aa bb   0  0     0 0 0 0 0 0
+   1.000000 1  Statistic: D 0 R 0 XD 0 XR 0 E 0 O 0 B 0.00%
+End TriggerBlock
+`
+
+type record struct {
+	id    uint32
+	flags can.Flags
+	data  string
+	t     can.Time
+	ch    int
+	dir   canlog.Dir
+}
+
+func TestRead(t *testing.T) {
+	r := NewReader(strings.NewReader(testASC))
+	want := []record{
+		{0x123, 0, "\x01\x02\x03\x04\x05\x06\x07\x08", 1234, 1, canlog.Rx},
+		{0x1ABCDEF, can.ExtFrame, "\x0a\x0b", 2000, 2, canlog.Tx},
+		{0x100, can.RTRMsg, "", 3000, 1, canlog.Rx},
+		{0, can.StatusMsg, "", 4000, 1, canlog.Rx},
+		{0, can.StatusMsg | can.ErrorPassive, "", 4500, 1, canlog.Rx},
+		{0x123, can.ForceFD | can.FDSwitchBitrate, "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c", 5000, 1, canlog.Rx},
+		{0x456, 0, "\xaa\xbb", 6000, 3, canlog.Tx},
+	}
+	var got []record
+	for {
+		var m can.Msg
+		info, err := r.ReadMsg(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, record{m.Id, m.Flags, string(m.Data()), m.Rx.Time - can.Time(r.Start.UnixMicro()), info.Channel, info.Dir})
+	}
+	if r.Start.Hour() != 9 || r.Start.Nanosecond() != 123e6 {
+		t.Errorf("got start time %v", r.Start)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const t0 = can.Time(1700000000123456)
+	in := []record{
+		{0x7FF, 0, "\x01\x02\x03", t0, 1, canlog.Rx},
+		{0x1FFFFFFF, can.ExtFrame | can.RTRMsg, "", t0 + 10, 2, canlog.Tx},
+		{0x10, can.ForceFD | can.FDErrorStateInd, "", t0 + 20, 1, canlog.Rx},
+		{0x10, can.ForceFD, strings.Repeat("\x55", 64), t0 + 1e6, 1, canlog.Tx},
+		{0, can.StatusMsg | can.BusOff, "", t0 + 2e6, 4, canlog.Rx},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, rec := range in {
+		var m can.Msg
+		m.Id = rec.id
+		m.Flags = rec.flags
+		m.SetData([]byte(rec.data))
+		m.Rx.Time = rec.t
+		if err := w.WriteMsg(&m, canlog.Info{Channel: rec.ch, Dir: rec.dir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(&buf)
+	for i, rec := range in {
+		var m can.Msg
+		info, err := r.ReadMsg(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := record{m.Id, m.Flags, string(m.Data()), m.Rx.Time, info.Channel, info.Dir}
+		if got != rec {
+			t.Errorf("message %d: got %+v, want %+v", i, got, rec)
+		}
+	}
+	if _, err := r.ReadMsg(new(can.Msg)); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
+
+func TestWriteXL(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var m can.Msg
+	m.Id = 0x123
+	m.Flags = can.XLFrame
+	m.SetData(make([]byte, 100))
+	if err := w.WriteMsg(&m, canlog.Info{}); err != canlog.ErrXLNotSupported {
+		t.Errorf("got %v, want ErrXLNotSupported", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output: %q", buf.String())
+	}
+}
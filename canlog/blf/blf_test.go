@@ -0,0 +1,148 @@
+package blf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+type record struct {
+	id    uint32
+	flags can.Flags
+	data  string
+	t     can.Time
+	ch    int
+	dir   canlog.Dir
+}
+
+func readAll(t *testing.T, r *Reader) []record {
+	t.Helper()
+	var recs []record
+	for {
+		var m can.Msg
+		info, err := r.ReadMsg(&m)
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, record{m.Id, m.Flags, string(m.Data()), m.Rx.Time, info.Channel, info.Dir})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const t0 = can.Time(1700000000123456)
+	in := []record{
+		{0x7FF, 0, "\x01\x02\x03", t0, 1, canlog.Rx},
+		{0x1FFFFFFF, can.ExtFrame | can.RTRMsg, "", t0 + 10, 2, canlog.Tx},
+		{0x10, can.ForceFD | can.FDSwitchBitrate | can.FDErrorStateInd, "", t0 + 20, 1, canlog.Rx},
+		{0, can.StatusMsg, "", t0 + 30, 3, canlog.Rx},
+	}
+	// enough messages to fill multiple containers
+	for i := range 3000 {
+		in = append(in, record{uint32(i), can.ForceFD, strings.Repeat(string(rune('A'+i%26)), 64), t0 + 1e6 + can.Time(i), 1, canlog.Tx})
+	}
+
+	name := filepath.Join(t.TempDir(), "test.blf")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := NewWriter(f)
+	for _, rec := range in {
+		var m can.Msg
+		m.Id = rec.id
+		m.Flags = rec.flags
+		m.SetData([]byte(rec.data))
+		m.Rx.Time = rec.t
+		if err := w.WriteMsg(&m, canlog.Info{Channel: rec.ch, Dir: rec.dir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	var h fileHeader
+	if err := binary.Read(f, le, &h); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := f.Stat()
+	if h.ObjectCount != uint32(len(in)) || h.FileSize != uint64(fi.Size()) {
+		t.Errorf("header: got %d objects, file size %d", h.ObjectCount, h.FileSize)
+	}
+	f.Seek(0, io.SeekStart)
+
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(t, r)
+	if len(got) != len(in) {
+		t.Fatalf("got %d messages, want %d", len(got), len(in))
+	}
+	for i := range in {
+		if got[i] != in[i] {
+			t.Fatalf("message %d: got %+v, want %+v", i, got[i], in[i])
+		}
+	}
+}
+
+// TestCANFDMessage reads a CAN_FD_MESSAGE object, which is not
+// used by the writer, from an uncompressed container.
+func TestCANFDMessage(t *testing.T) {
+	var w Writer
+	p := make([]byte, 84)
+	le.PutUint16(p[0:], 2)
+	p[2] = msgFlagTx
+	p[3] = 9
+	le.PutUint32(p[4:], 0x123)
+	p[13] = fdMsgEDL | fdMsgBRS
+	p[14] = 12
+	copy(p[20:], "0123456789AB")
+	w.writeObject(objCANFDMessage, 5e6, p)
+
+	var b bytes.Buffer
+	w.writeHeader(&b)
+	var h [objBaseSize + 16]byte
+	copy(h[:], objSignature)
+	le.PutUint16(h[4:], objBaseSize)
+	le.PutUint32(h[8:], uint32(len(h)+w.buf.Len()))
+	le.PutUint32(h[12:], objLogContainer)
+	le.PutUint32(h[objBaseSize+8:], uint32(w.buf.Len()))
+	b.Write(h[:])
+	b.Write(w.buf.Bytes())
+
+	r, err := NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(t, r)
+	want := record{0x123, can.ForceFD | can.FDSwitchBitrate, "0123456789AB", can.Time(r.Start.UnixMicro()) + 5000, 2, canlog.Tx}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestWriteXL(t *testing.T) {
+	w := NewWriter(io.Discard)
+	var m can.Msg
+	m.Id = 0x123
+	m.Flags = can.XLFrame
+	m.SetData(make([]byte, 100))
+	if err := w.WriteMsg(&m, canlog.Info{}); err != canlog.ErrXLNotSupported {
+		t.Errorf("got %v, want ErrXLNotSupported", err)
+	}
+}
@@ -0,0 +1,294 @@
+package blf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+	"github.com/knieriem/can/canlog/internal/dlc"
+	"github.com/knieriem/can/canlog/internal/msgdata"
+)
+
+// Reader reads messages from a BLF file.
+type Reader struct {
+	r *bufio.Reader
+
+	// Start is the start time of the measurement,
+	// as specified in the file header.
+	Start time.Time
+	start can.Time
+
+	// buf contains object data read from log containers,
+	// or uncompressed objects, starting at offset off.
+	buf []byte
+	off int
+	eof bool
+}
+
+// NewReader returns a Reader reading from r,
+// after reading and verifying the file header.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	var h fileHeader
+	if err := binary.Read(br, le, &h); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if string(h.Signature[:]) != fileSignature || h.HeaderSize < uint32(binary.Size(h)) {
+		return nil, ErrFormat
+	}
+	if _, err := br.Discard(int(h.HeaderSize) - binary.Size(h)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	rd := &Reader{r: br}
+	rd.Start = h.StartTime.time()
+	rd.start = canTime(rd.Start)
+	return rd, nil
+}
+
+// ReadMsg reads the next CAN message, or error, into m.
+// At the end of the file, io.EOF is returned.
+func (r *Reader) ReadMsg(m *can.Msg) (canlog.Info, error) {
+	for {
+		obj, err := r.nextObject()
+		if err != nil {
+			return canlog.Info{}, err
+		}
+		ok, info, err := r.decode(obj, m)
+		if err != nil || ok {
+			return info, err
+		}
+	}
+}
+
+// nextObject returns the next object, including its header.
+func (r *Reader) nextObject() ([]byte, error) {
+	for {
+		b := r.buf[r.off:]
+
+		// skip padding
+		if i := bytes.Index(b[:min(len(b), 8+len(objSignature))], []byte(objSignature)); i > 0 {
+			r.off += i
+			b = b[i:]
+		}
+		if len(b) >= objBaseSize && string(b[:4]) == objSignature {
+			size := int(le.Uint32(b[8:]))
+			if size < objBaseSize {
+				return nil, fmt.Errorf("%w: object size %d", ErrFormat, size)
+			}
+			if len(b) >= size {
+				r.off += size
+				return b[:size], nil
+			}
+		} else if len(b) >= 8+len(objSignature) {
+			return nil, fmt.Errorf("%w: object signature not found", ErrFormat)
+		}
+		if r.eof {
+			if bytes.HasPrefix(b, []byte(objSignature)) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fill reads the next object from the file, and appends
+// its contents, if it is a log container, or the object
+// itself to the buffer.
+func (r *Reader) fill() error {
+	n := copy(r.buf, r.buf[r.off:])
+	r.buf = r.buf[:n]
+	r.off = 0
+
+	var hdr [objBaseSize]byte
+	_, err := io.ReadFull(r.r, hdr[:])
+	if err == io.EOF {
+		r.eof = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if string(hdr[:4]) != objSignature {
+		return fmt.Errorf("%w: object signature not found", ErrFormat)
+	}
+	size := int(le.Uint32(hdr[8:]))
+	if size < objBaseSize || size > maxObjectSize {
+		return fmt.Errorf("%w: %d bytes", ErrObjectTooLarge, size)
+	}
+	obj := make([]byte, size)
+	copy(obj, hdr[:])
+	if _, err := io.ReadFull(r.r, obj[objBaseSize:]); err != nil {
+		return err
+	}
+	if pad := size % 4; pad != 0 {
+		r.r.Discard(4 - pad)
+	}
+
+	if le.Uint32(hdr[12:]) != objLogContainer {
+		r.buf = append(r.buf, obj...)
+		return nil
+	}
+	hsize := int(le.Uint16(hdr[4:]))
+	if size < hsize+16 {
+		return fmt.Errorf("%w: short log container", ErrFormat)
+	}
+	p := obj[hsize:]
+	method := le.Uint16(p[0:])
+	usize := int(le.Uint32(p[8:]))
+	data := p[16:]
+	switch method {
+	case compressNone:
+		r.buf = append(r.buf, data...)
+	case compressZlib:
+		if usize > maxObjectSize {
+			return fmt.Errorf("%w: %d bytes", ErrObjectTooLarge, usize)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("blf: log container: %w", err)
+		}
+		n := len(r.buf)
+		r.buf = append(r.buf, make([]byte, usize)...)
+		_, err = io.ReadFull(zr, r.buf[n:])
+		zr.Close()
+		if err != nil {
+			return fmt.Errorf("blf: log container: %w", err)
+		}
+	default:
+		return fmt.Errorf("%w: %d", ErrCompression, method)
+	}
+	return nil
+}
+
+// decode converts an object into a message. If the
+// object is not of a supported type, ok is false.
+func (r *Reader) decode(obj []byte, m *can.Msg) (ok bool, info canlog.Info, err error) {
+	hsize := int(le.Uint16(obj[4:]))
+	if hsize < objHeaderSize || hsize > len(obj) {
+		return false, info, fmt.Errorf("%w: object header size %d", ErrFormat, hsize)
+	}
+	typ := le.Uint32(obj[12:])
+	flags := le.Uint32(obj[16:])
+	ts := le.Uint64(obj[24:])
+	p := obj[hsize:]
+
+	m.Reset()
+	if flags&timeOneNans != 0 {
+		m.Rx.Time = r.start + can.Time(ts/1000)
+	} else {
+		m.Rx.Time = r.start + can.Time(ts*10)
+	}
+
+	short := func(n int) bool {
+		if len(p) < n {
+			err = fmt.Errorf("%w: object type %d: short object", ErrFormat, typ)
+			return true
+		}
+		return false
+	}
+	switch typ {
+	case objCANMessage, objCANMessage2:
+		if short(16) {
+			return false, info, err
+		}
+		info.Channel = int(le.Uint16(p[0:]))
+		f := p[2]
+		if f&msgFlagTx != 0 {
+			info.Dir = canlog.Tx
+		}
+		setID(m, le.Uint32(p[4:]))
+		if f&msgFlagRTR != 0 {
+			m.Flags |= can.RTRMsg
+			return true, info, nil
+		}
+		msgdata.Set(m, p[8:8+dlc.Len(p[3], false)])
+
+	case objCANFDMessage:
+		if short(84) {
+			return false, info, err
+		}
+		info.Channel = int(le.Uint16(p[0:]))
+		f := p[2]
+		if f&msgFlagTx != 0 {
+			info.Dir = canlog.Tx
+		}
+		setID(m, le.Uint32(p[4:]))
+		fdf := p[13]
+		n := min(int(p[14]), 64)
+		if fdf&fdMsgEDL == 0 {
+			if f&msgFlagRTR != 0 {
+				m.Flags |= can.RTRMsg
+				return true, info, nil
+			}
+			n = min(n, 8)
+		} else {
+			m.Flags |= can.ForceFD
+			if fdf&fdMsgBRS != 0 {
+				m.Flags |= can.FDSwitchBitrate
+			}
+			if fdf&fdMsgESI != 0 {
+				m.Flags |= can.FDErrorStateInd
+			}
+		}
+		msgdata.Set(m, p[20:20+n])
+
+	case objCANFDMessage64:
+		if short(40) {
+			return false, info, err
+		}
+		info.Channel = int(p[0])
+		n := int(p[2])
+		setID(m, le.Uint32(p[4:]))
+		f := le.Uint32(p[12:])
+		if p[34] != 0 {
+			info.Dir = canlog.Tx
+		}
+		if f&fd64EDL != 0 {
+			m.Flags |= can.ForceFD
+			if f&fd64BRS != 0 {
+				m.Flags |= can.FDSwitchBitrate
+			}
+			if f&fd64ESI != 0 {
+				m.Flags |= can.FDErrorStateInd
+			}
+		} else {
+			if f&fd64RTR != 0 {
+				m.Flags |= can.RTRMsg
+				return true, info, nil
+			}
+			n = min(n, 8)
+		}
+		if short(40 + n) {
+			return false, info, err
+		}
+		msgdata.Set(m, p[40:40+n])
+
+	case objCANError, objCANErrorExt:
+		if short(2) {
+			return false, info, err
+		}
+		info.Channel = int(le.Uint16(p[0:]))
+		m.Flags = can.StatusMsg
+
+	default:
+		return false, info, nil
+	}
+	return true, info, nil
+}
+
+func setID(m *can.Msg, id uint32) {
+	if id&msgExtID != 0 {
+		m.Flags |= can.ExtFrame
+		id &^= msgExtID
+	}
+	m.Id = id
+}
@@ -0,0 +1,232 @@
+package blf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+	"github.com/knieriem/can/canlog/internal/dlc"
+)
+
+// containerSize is the amount of uncompressed object data
+// collected before a log container is written.
+const containerSize = 128 << 10
+
+// Writer writes messages to a BLF file. The start time of the
+// measurement is set to the time of the first message.
+type Writer struct {
+	w     io.Writer
+	start can.Time
+	last  can.Time
+	begun bool
+
+	buf  bytes.Buffer
+	zbuf bytes.Buffer
+	zw   *zlib.Writer
+
+	headerWritten bool
+	size          uint64
+	usize         uint64
+	count         uint32
+	err           error
+}
+
+// NewWriter returns a Writer writing to w. Close must be called after
+// the last message has been written. If w implements io.WriteSeeker,
+// Close updates the file header with the final statistics.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteMsg writes message m. Messages should be
+// written in the order of their timestamps.
+func (w *Writer) WriteMsg(m *can.Msg, info canlog.Info) error {
+	if w.err != nil {
+		return w.err
+	}
+	if m.XLFrame() {
+		return canlog.ErrXLNotSupported
+	}
+	if !w.begun {
+		w.start = m.Rx.Time - m.Rx.Time%1000
+		w.begun = true
+	}
+	w.last = m.Rx.Time
+	ch := max(info.Channel, 1)
+	data := m.Data()
+
+	var p []byte
+	var typ uint32
+	_, needsFD, _ := can.VerifyDataLenFD(len(data))
+	switch {
+	case m.IsStatus():
+		typ = objCANErrorExt
+		p = make([]byte, 32)
+		le.PutUint16(p[0:], uint16(ch))
+
+	case needsFD || m.Test(can.ForceFD):
+		typ = objCANFDMessage64
+		p = make([]byte, 40, 40+len(data))
+		p[0] = byte(ch)
+		p[1] = dlc.FromLen(len(data))
+		p[2] = byte(len(data))
+		le.PutUint32(p[4:], msgID(m))
+		f := uint32(fd64EDL)
+		if m.Test(can.FDSwitchBitrate) {
+			f |= fd64BRS
+		}
+		if m.Test(can.FDErrorStateInd) {
+			f |= fd64ESI
+		}
+		le.PutUint32(p[12:], f)
+		p[34] = byte(info.Dir)
+		p = append(p, data...)
+
+	default:
+		typ = objCANMessage
+		p = make([]byte, 16)
+		le.PutUint16(p[0:], uint16(ch))
+		if info.Dir == canlog.Tx {
+			p[2] |= msgFlagTx
+		}
+		le.PutUint32(p[4:], msgID(m))
+		if m.Test(can.RTRMsg) {
+			p[2] |= msgFlagRTR
+		} else {
+			p[3] = byte(len(data))
+			copy(p[8:], data)
+		}
+	}
+	w.writeObject(typ, uint64(m.Rx.Time-w.start)*1000, p)
+	if w.buf.Len() >= containerSize {
+		w.flushContainer()
+	}
+	return w.err
+}
+
+func msgID(m *can.Msg) uint32 {
+	if m.ExtFrame() {
+		return m.Id | msgExtID
+	}
+	return m.Id
+}
+
+// writeObject appends an object using a version 1 header,
+// and timestamps in nanoseconds, to the container buffer.
+func (w *Writer) writeObject(typ uint32, ts uint64, p []byte) {
+	var h [objHeaderSize]byte
+	copy(h[:], objSignature)
+	le.PutUint16(h[4:], objHeaderSize)
+	le.PutUint16(h[6:], 1)
+	le.PutUint32(h[8:], uint32(objHeaderSize+len(p)))
+	le.PutUint32(h[12:], typ)
+	le.PutUint32(h[16:], timeOneNans)
+	le.PutUint64(h[24:], ts)
+	w.buf.Write(h[:])
+	w.buf.Write(p)
+	w.buf.Write(padding(len(h) + len(p)))
+	w.count++
+}
+
+func padding(n int) []byte {
+	return make([]byte, (4-n%4)%4)
+}
+
+func (w *Writer) flushContainer() {
+	if w.err != nil || w.buf.Len() == 0 {
+		return
+	}
+	if !w.headerWritten {
+		w.writeHeader(w.w)
+		w.headerWritten = true
+	}
+	w.zbuf.Reset()
+	if w.zw == nil {
+		w.zw = zlib.NewWriter(&w.zbuf)
+	} else {
+		w.zw.Reset(&w.zbuf)
+	}
+	w.zw.Write(w.buf.Bytes())
+	if w.err = w.zw.Close(); w.err != nil {
+		return
+	}
+
+	var h [objBaseSize + 16]byte
+	copy(h[:], objSignature)
+	le.PutUint16(h[4:], objBaseSize)
+	le.PutUint16(h[6:], 1)
+	le.PutUint32(h[8:], uint32(len(h)+w.zbuf.Len()))
+	le.PutUint32(h[12:], objLogContainer)
+	le.PutUint16(h[objBaseSize:], compressZlib)
+	le.PutUint32(h[objBaseSize+8:], uint32(w.buf.Len()))
+
+	n := len(h) + w.zbuf.Len()
+	w.zbuf.Write(padding(n))
+	if _, w.err = w.w.Write(h[:]); w.err != nil {
+		return
+	}
+	if _, w.err = w.w.Write(w.zbuf.Bytes()); w.err != nil {
+		return
+	}
+	w.size += uint64(len(h) + w.zbuf.Len())
+	w.usize += uint64(len(h) + w.buf.Len())
+	w.buf.Reset()
+}
+
+func (w *Writer) writeHeader(dst io.Writer) {
+	var h fileHeader
+	copy(h.Signature[:], fileSignature)
+	h.HeaderSize = fileHeaderSize
+	h.BinLogMajor = 4
+	h.BinLogMinor = 1
+	h.FileSize = fileHeaderSize + w.size
+	h.UncompressedSize = fileHeaderSize + w.usize
+	h.ObjectCount = w.count
+	if w.begun {
+		h.StartTime = newSystemTime(w.start.Time())
+		h.LastTime = newSystemTime(w.last.Time())
+	} else {
+		h.StartTime = newSystemTime(time.Now())
+		h.LastTime = h.StartTime
+	}
+	var b bytes.Buffer
+	binary.Write(&b, le, &h)
+	b.Write(make([]byte, fileHeaderSize-b.Len()))
+	_, w.err = dst.Write(b.Bytes())
+}
+
+// Close writes any buffered objects. If the underlying writer
+// implements io.WriteSeeker, the file header is updated.
+// Close does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.flushContainer()
+	if w.err != nil {
+		return w.err
+	}
+	if !w.headerWritten {
+		w.writeHeader(w.w)
+		w.headerWritten = true
+		return w.err
+	}
+	ws, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	end, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := ws.Seek(end-int64(fileHeaderSize+w.size), io.SeekStart); err != nil {
+		return err
+	}
+	w.writeHeader(ws)
+	if w.err != nil {
+		return w.err
+	}
+	_, err = ws.Seek(end, io.SeekStart)
+	return err
+}
@@ -0,0 +1,245 @@
+// Package canlog reads and writes CAN log files in the format
+// written by candump -l, and read by canplayer, from can-utils:
+//
+//	(1436509052.249713) can0 123#DEADBEEF
+//	(1436509052.249850) can0 12345678#R
+//	(1436509052.250008) can1 123##1112233445566778899AABB
+//	(1436509052.251011) can0 20000080#0000000000000000
+//
+// Timestamps are seconds since the Unix epoch with microsecond resolution.
+// Standard frame identifiers have three, extended frame identifiers eight
+// hex digits. CAN FD frames use a double separator followed by a flags
+// nibble (BRS = 1, ESI = 2). Error frames are encoded as in SocketCAN,
+// with CAN_ERR_FLAG set in the identifier.
+package canlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog/internal/errframe"
+)
+
+var ErrSyntax = errors.New("canlog: syntax error")
+
+// AppendMsg appends the log file representation of m,
+// received or sent on interface iface, to b. No newline is appended.
+func AppendMsg(b []byte, iface string, m *can.Msg) []byte {
+	t := m.Rx.Time
+	b = append(b, '(')
+	b = strconv.AppendInt(b, int64(t/1e6), 10)
+	b = append(b, '.')
+	b = appendDec(b, int64(t%1e6), 6)
+	b = append(b, ") "...)
+	b = append(b, iface...)
+	b = append(b, ' ')
+
+	if m.IsStatus() {
+		id, data := errframe.Encode(m)
+		b = appendHex(b, uint64(id), 8)
+		b = append(b, '#')
+		return appendData(b, data)
+	}
+	if m.XLFrame() {
+		return appendXLFrame(b, m)
+	}
+	if m.ExtFrame() {
+		b = appendHex(b, uint64(m.Id), 8)
+	} else {
+		b = appendHex(b, uint64(m.Id), 3)
+	}
+	b = append(b, '#')
+	if m.Test(can.RTRMsg) {
+		return append(b, 'R')
+	}
+	data := m.Data()
+	if _, needsFD, _ := can.VerifyDataLenFD(len(data)); needsFD || m.Test(can.ForceFD) {
+		var flags uint64
+		if m.Test(can.FDSwitchBitrate) {
+			flags |= 1
+		}
+		if m.Test(can.FDErrorStateInd) {
+			flags |= 2
+		}
+		b = append(b, '#')
+		b = appendHex(b, flags, 1)
+	}
+	return appendData(b, data)
+}
+
+// appendXLFrame appends a CAN XL frame in the format
+// <vcid><prio>#<flags>:<sdt>:<af>#<data>.
+func appendXLFrame(b []byte, m *can.Msg) []byte {
+	flags := uint64(0x80) // XLF
+	if m.Test(can.XLSecure) {
+		flags |= 1
+	}
+	b = appendHex(b, uint64(m.XL.VCID), 2)
+	b = appendHex(b, uint64(m.Id), 3)
+	b = append(b, '#')
+	b = appendHex(b, flags, 2)
+	b = append(b, ':')
+	b = appendHex(b, uint64(m.XL.SDT), 2)
+	b = append(b, ':')
+	b = appendHex(b, uint64(m.XL.AF), 8)
+	b = append(b, '#')
+	return appendData(b, m.Data())
+}
+
+func appendDec(b []byte, v int64, width int) []byte {
+	s := strconv.FormatInt(v, 10)
+	for range width - len(s) {
+		b = append(b, '0')
+	}
+	return append(b, s...)
+}
+
+func appendHex(b []byte, v uint64, width int) []byte {
+	s := strconv.FormatUint(v, 16)
+	for range width - len(s) {
+		b = append(b, '0')
+	}
+	return append(b, strings.ToUpper(s)...)
+}
+
+func appendData(b []byte, data []byte) []byte {
+	const digits = "0123456789ABCDEF"
+	for _, c := range data {
+		b = append(b, digits[c>>4], digits[c&0xF])
+	}
+	return b
+}
+
+// ParseLine parses a line of a log file into m,
+// and returns the name of the interface.
+func ParseLine(line string, m *can.Msg) (iface string, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", fmt.Errorf("%w: %q", ErrSyntax, line)
+	}
+	ts, ok1 := strings.CutPrefix(fields[0], "(")
+	ts, ok2 := strings.CutSuffix(ts, ")")
+	if !ok1 || !ok2 {
+		return "", fmt.Errorf("%w: timestamp: %q", ErrSyntax, fields[0])
+	}
+	t, err := parseTime(ts)
+	if err != nil {
+		return "", fmt.Errorf("%w: timestamp: %q", ErrSyntax, fields[0])
+	}
+
+	m.Reset()
+	expr := fields[2]
+	id, _, ok := strings.Cut(expr, "#")
+	if !ok || len(id) != 3 && len(id) != 5 && len(id) != 8 {
+		return "", fmt.Errorf("%w: frame: %q", ErrSyntax, expr)
+	}
+	if err := m.FromExpr(expr); err != nil {
+		return "", fmt.Errorf("%w: frame: %q: %v", ErrSyntax, expr, err)
+	}
+	if m.XLFrame() {
+		if m.Id > 0x7FF {
+			return "", fmt.Errorf("%w: invalid priority: %q", ErrSyntax, expr)
+		}
+		if err := can.VerifyDataLenXL(len(m.Data())); err != nil {
+			return "", fmt.Errorf("%w: frame: %q: %v", ErrSyntax, expr, err)
+		}
+	} else if len(id) == 5 {
+		return "", fmt.Errorf("%w: frame: %q", ErrSyntax, expr)
+	} else if m.ExtFrame() && m.Id&errframe.Flag != 0 {
+		errframe.Decode(m, m.Id, m.Data())
+	} else if m.Id > 0x1FFFFFFF || !m.ExtFrame() && m.Id > 0x7FF {
+		return "", fmt.Errorf("%w: invalid identifier: %q", ErrSyntax, expr)
+	} else if _, _, err := can.VerifyDataLenFD(len(m.Data())); err != nil {
+		return "", fmt.Errorf("%w: frame: %q: %v", ErrSyntax, expr, err)
+	}
+	m.Rx.Time = t
+	return fields[1], nil
+}
+
+// parseTime parses a timestamp consisting of seconds
+// and an optional fractional part.
+func parseTime(s string) (can.Time, error) {
+	sec, frac, _ := strings.Cut(s, ".")
+	v, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	t := can.Time(v) * 1e6
+	if frac == "" {
+		return t, nil
+	}
+	if len(frac) > 6 {
+		frac = frac[:6]
+	}
+	µs, err := strconv.ParseUint(frac, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	for range 6 - len(frac) {
+		µs *= 10
+	}
+	return t + can.Time(µs), nil
+}
+
+// Writer writes messages to a log file.
+type Writer struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+// NewWriter returns a Writer writing to w. Output is buffered;
+// Flush must be called after the last message has been written.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// WriteMsg writes a line containing message m,
+// received or sent on interface iface.
+func (w *Writer) WriteMsg(iface string, m *can.Msg) error {
+	w.buf = append(AppendMsg(w.buf[:0], iface, m), '\n')
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Reader reads messages from a log file.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// ReadMsg reads the next message into m, and returns the interface
+// name. Empty lines are skipped. At the end of the input, io.EOF
+// is returned.
+func (r *Reader) ReadMsg(m *can.Msg) (iface string, err error) {
+	for r.s.Scan() {
+		r.line++
+		l := strings.TrimSpace(r.s.Text())
+		if l == "" {
+			continue
+		}
+		iface, err := ParseLine(l, m)
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return iface, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
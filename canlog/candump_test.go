@@ -0,0 +1,94 @@
+package canlog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/knieriem/can"
+)
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		line  string
+		id    uint32
+		flags can.Flags
+		data  string
+	}{
+		{"(1436509052.249713) can0 123#DEADBEEF", 0x123, 0, "\xDE\xAD\xBE\xEF"},
+		{"(1436509052.249713) can0 001#", 1, 0, ""},
+		{"(0.000001) vcan1 12345678#R", 0x12345678, can.ExtFrame | can.RTRMsg, ""},
+		{"(1436509052.250008) can1 123##1112233445566778899AABBCC", 0x123,
+			can.ForceFD | can.FDSwitchBitrate, "\x11\x22\x33\x44\x55\x66\x77\x88\x99\xAA\xBB\xCC"},
+		{"(1436509052.250008) can1 1FFFFFFF##20011", 0x1FFFFFFF,
+			can.ExtFrame | can.ForceFD | can.FDErrorStateInd, "\x00\x11"},
+		{"(1436509052.252000) xlcan0 45123#81:70:12345678#112233", 0x123,
+			can.XLFrame | can.XLSecure, "\x11\x22\x33"},
+		{"(1436509052.251011) can0 20000044#003D000000000000", 0x44,
+			can.StatusMsg | can.BusOff | can.ReceiveBufferOverflow, "\x00\x3D\x00\x00\x00\x00\x00\x00"},
+		{"(1436509052.251012) can0 20000208#0000000000005A80", 0x208,
+			can.StatusMsg, "\x00\x00\x00\x00\x00\x00\x5A\x80"},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, tt := range tests {
+		var m can.Msg
+		iface, err := ParseLine(tt.line, &m)
+		if err != nil {
+			t.Errorf("%s: %v", tt.line, err)
+			continue
+		}
+		if m.Id != tt.id || m.Flags != tt.flags || string(m.Data()) != tt.data {
+			t.Errorf("%s: got id %#x, flags %#x, data % x", tt.line, m.Id, m.Flags, m.Data())
+		}
+		if err := w.WriteMsg(iface, &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	r := NewReader(&buf)
+	for _, tt := range tests {
+		var m can.Msg
+		iface, err := r.ReadMsg(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(AppendMsg(nil, iface, &m)); got != tt.line {
+			t.Errorf("got %q, want %q", got, tt.line)
+		}
+	}
+	if _, err := r.ReadMsg(new(can.Msg)); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, line := range []string{
+		"can0 123#00",
+		"(1.0) can0",
+		"(x) can0 123#00",
+		"(1.0) can0 1234#00",
+		"(1.0) can0 800#00",
+		"(1.0) can0 123#0",
+		"(1.0) can0 123##",
+		"(1.0) can0 123##0112233445566778899AABB",
+		"(1.0) can0 12345#00",
+		"(1.0) xlcan0 00800#80:00:00000000#00",
+		"(1.0) xlcan0 00123#80:00:00000000#",
+	} {
+		if _, err := ParseLine(line, new(can.Msg)); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: got %v", line, err)
+		}
+	}
+}
+
+func TestAppendStatus(t *testing.T) {
+	var m can.Msg
+	m.Flags = can.StatusMsg | can.BusOff | can.ErrorPassive
+	want := "(0.000000) can0 20000044#0030000000000000"
+	if got := string(AppendMsg(nil, "can0", &m)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
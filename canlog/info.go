@@ -0,0 +1,32 @@
+package canlog
+
+import "errors"
+
+// ErrXLNotSupported is returned by writers of trace
+// file formats that cannot store CAN XL frames.
+var ErrXLNotSupported = errors.New("canlog: CAN XL frames not supported by file format")
+
+// Dir is the direction of a logged message.
+type Dir uint8
+
+const (
+	Rx Dir = iota
+	Tx
+)
+
+func (d Dir) String() string {
+	if d == Tx {
+		return "Tx"
+	}
+	return "Rx"
+}
+
+// Info contains properties of a logged message that are
+// not part of can.Msg. It is used by the trace file formats
+// in the subdirectories of this package.
+type Info struct {
+	// Channel is the channel number, starting at 1,
+	// as used by Vector and PEAK tools.
+	Channel int
+	Dir     Dir
+}
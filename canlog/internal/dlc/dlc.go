@@ -0,0 +1,33 @@
+// Package dlc converts between CAN data lengths and
+// data length codes, as stored in trace files.
+package dlc
+
+import "github.com/knieriem/can"
+
+// FromLen returns the data length code for a data field of n bytes.
+// Lengths between valid CAN FD sizes are rounded up.
+func FromLen(n int) byte {
+	if n <= 8 {
+		return byte(n)
+	}
+	for i, nFD := range can.ValidFDSizes {
+		if n <= nFD {
+			return 9 + byte(i)
+		}
+	}
+	return 15
+}
+
+// Len returns the data length corresponding to a data length code.
+// For classic CAN frames, codes larger than 8 mean 8 bytes.
+func Len(dlc byte, fd bool) int {
+	switch {
+	case dlc <= 8:
+		return int(dlc)
+	case !fd:
+		return 8
+	case int(dlc-9) < len(can.ValidFDSizes):
+		return can.ValidFDSizes[dlc-9]
+	}
+	return can.ValidFDSizes[len(can.ValidFDSizes)-1]
+}
@@ -0,0 +1,68 @@
+// Package errframe converts between status messages
+// and SocketCAN error frames, as stored in trace files.
+package errframe
+
+import (
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog/internal/msgdata"
+)
+
+// Flag is set in the identifier of error frames (CAN_ERR_FLAG).
+const Flag = 0x20000000
+
+// DataLen is the length of the data field of error frames.
+const DataLen = 8
+
+// Encode converts a status message into the identifier, including
+// Flag, and data of an error frame. If the message carries a
+// [can.BusError], its identifier and data are used verbatim,
+// otherwise they are derived from the status flags.
+func Encode(m *can.Msg) (id uint32, data []byte) {
+	if _, ok := m.BusError(); ok {
+		return Flag | m.Id, m.Data()
+	}
+	e := busError(m.Flags)
+	var em can.Msg
+	em.SetBusError(&e)
+	return Flag | em.Id, em.Data()
+}
+
+// busError returns a BusError that, if passed to
+// Msg.SetBusError, reproduces the status flags f.
+func busError(f can.Flags) (e can.BusError) {
+	if f.Test(can.MissingAck) {
+		e.Class |= can.ClassAck
+	}
+	if f.Test(can.BusOff) {
+		e.Class |= can.ClassBusOff
+	}
+	if f.Test(can.DataOverrun) || f.Test(can.ReceiveBufferOverflow) {
+		e.Ctrl |= can.CtrlRxOverflow
+	}
+	if f.Test(can.ErrorWarning) {
+		e.Ctrl |= can.CtrlRxWarning | can.CtrlTxWarning
+	}
+	if f.Test(can.ErrorPassive) {
+		e.Ctrl |= can.CtrlRxPassive | can.CtrlTxPassive
+	}
+	if f.Test(can.ErrorActive) {
+		e.Ctrl |= can.CtrlActive
+	}
+	if e.Ctrl != 0 {
+		e.Class |= can.ClassCtrl
+	}
+	return e
+}
+
+// Decode converts an error frame into a status message. The
+// identifier, without Flag, and the data are stored verbatim;
+// the status flags are derived from the [can.BusError] they
+// represent, as returned by [can.BusError.Flags].
+func Decode(m *can.Msg, id uint32, data []byte) {
+	m.Id = id &^ Flag
+	m.Flags = can.StatusMsg
+	msgdata.Set(m, data)
+	if e, ok := m.BusError(); ok {
+		m.Flags = e.Flags()
+	}
+}
@@ -0,0 +1,19 @@
+// Package msgdata helps trace file readers
+// storing data into messages.
+package msgdata
+
+import (
+	"bytes"
+
+	"github.com/knieriem/can"
+)
+
+// Set copies b into the message. Since Msg.SetData refers to
+// slices of more than eight bytes, those are copied first, as the
+// reader's buffer will be reused.
+func Set(m *can.Msg, b []byte) {
+	if len(b) > 8 {
+		b = bytes.Clone(b)
+	}
+	m.SetData(b)
+}
@@ -0,0 +1,153 @@
+// Package mdf reads and writes ASAM MDF 4 measurement data files
+// (.mf4), following the ASAM MDF bus logging conventions for CAN.
+//
+// The writer creates an MDF 4.10 file containing a single, unsorted
+// data group. CAN data frames are stored in CAN_DataFrame channel
+// groups, one for classic frames with up to 8 data bytes, and one
+// for CAN FD frames with up to 64 data bytes. Remote frames are
+// stored in a CAN_RemoteFrame, status messages in a CAN_ErrorFrame
+// channel group. Besides the standard ErrorType, error frames contain
+// a non-standard Status channel holding the controller state.
+// Timestamps are stored relative to the start time of the file, which
+// is the time of the first message. Since the file header is updated
+// when the writer is closed, an io.WriteSeeker is required.
+//
+// The reader accepts sorted and unsorted data groups, data lists,
+// compressed data blocks, and byte arrays stored as variable length
+// signal data, either in signal data blocks or in VLSD channel groups.
+// Messages of multiple data groups are merged in the order of their
+// timestamps.
+package mdf
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrFormat      = errors.New("mdf: invalid file format")
+	ErrVersion     = errors.New("mdf: unsupported version")
+	ErrUnsupported = errors.New("mdf: unsupported feature")
+)
+
+var le = binary.LittleEndian
+
+const (
+	idSize          = 64
+	blockHeaderSize = 24
+
+	fileVersion = 410
+
+	maxBlockSize = 1 << 24
+)
+
+// channel types
+const (
+	cnFixed  = 0
+	cnVLSD   = 1
+	cnMaster = 2
+)
+
+// channel data types
+const (
+	dtUintLE   = 0
+	dtUintBE   = 1
+	dtIntLE    = 2
+	dtIntBE    = 3
+	dtFloatLE  = 4
+	dtFloatBE  = 5
+	dtByteArr  = 10
+	syncNone   = 0
+	syncTime   = 1
+	cnBusEvent = 0x400
+)
+
+// channel group flags
+const (
+	cgVLSD          = 0x1
+	cgBusEvent      = 0x2
+	cgPlainBusEvent = 0x4
+)
+
+// conversion types
+const (
+	ccIdentity = 0
+	ccLinear   = 1
+)
+
+// source information
+const (
+	siTypeBus = 2
+	siBusCAN  = 2
+)
+
+// compression types of DZ blocks
+const (
+	zipDeflate    = 0
+	zipTransposed = 1
+)
+
+// names of bus event channel groups, and
+// the top-level channels they contain
+const (
+	dataFrame   = "CAN_DataFrame"
+	remoteFrame = "CAN_RemoteFrame"
+	errorFrame  = "CAN_ErrorFrame"
+)
+
+// Record layout used by the writer. Offsets are relative to the
+// beginning of a record, after the record ID. The bus event channel
+// starts after the timestamp.
+const (
+	offTime       = 0
+	offBusChannel = 8
+	offID         = 9
+	offDLC        = 13
+	offDataLength = 14
+	offFlags      = 15
+	offDataBytes  = 16
+	offErrorType  = 16
+	offStatus     = 17
+
+	remoteFrameSize = 16
+	errorFrameSize  = 18
+)
+
+// bits of the flags byte
+const (
+	flagDir = 1 << iota
+	flagEDL
+	flagBRS
+	flagESI
+)
+
+// error types of CAN_ErrorFrame
+const (
+	errTypeUnknown = 0
+	errTypeAck     = 5
+)
+
+// bits of the non-standard CAN_ErrorFrame.Status channel
+const (
+	statusErrorActive = 1 << iota
+	statusErrorWarning
+	statusErrorPassive
+	statusBusOff
+	statusDataOverrun
+	statusRxBufferOverflow
+)
+
+// record IDs of the channel groups created by the writer
+const (
+	recDataFrame = 1 + iota
+	recDataFrameFD
+	recRemoteFrame
+	recErrorFrame
+	nRecords = recErrorFrame
+)
+
+// pad8 returns the number of padding bytes
+// needed to align n to eight bytes.
+func pad8(n int) int {
+	return (8 - n%8) % 8
+}
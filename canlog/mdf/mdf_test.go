@@ -0,0 +1,295 @@
+package mdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+type record struct {
+	id    uint32
+	flags can.Flags
+	data  string
+	t     can.Time
+	ch    int
+	dir   canlog.Dir
+}
+
+func readAll(t *testing.T, r *Reader) []record {
+	t.Helper()
+	var recs []record
+	for {
+		var m can.Msg
+		info, err := r.ReadMsg(&m)
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, record{m.Id, m.Flags, string(m.Data()), m.Rx.Time, info.Channel, info.Dir})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const t0 = can.Time(1700000000123456)
+	in := []record{
+		{0x7FF, 0, "\x01\x02\x03", t0, 1, canlog.Rx},
+		{0x1FFFFFFF, can.ExtFrame | can.RTRMsg, "", t0 + 10, 2, canlog.Tx},
+		{0x10, can.ForceFD | can.FDErrorStateInd, "", t0 + 20, 1, canlog.Rx},
+		{0x10, can.ForceFD | can.FDSwitchBitrate, strings.Repeat("\x55", 64), t0 + 1e6, 3, canlog.Tx},
+		{0x123, can.ExtFrame, "\x01\x02\x03\x04\x05\x06\x07\x08", t0 + 3600e6 + 1, 1, canlog.Rx},
+		{0, can.StatusMsg | can.MissingAck | can.ErrorPassive, "", t0 + 7200e6, 4, canlog.Rx},
+	}
+	name := filepath.Join(t.TempDir(), "test.mf4")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := NewWriter(f)
+	for _, rec := range in {
+		var m can.Msg
+		m.Id = rec.id
+		m.Flags = rec.flags
+		m.SetData([]byte(rec.data))
+		m.Rx.Time = rec.t
+		if err := w.WriteMsg(&m, canlog.Info{Channel: rec.ch, Dir: rec.dir}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Start.UnixMicro() != int64(t0) {
+		t.Errorf("got start time %v", r.Start)
+	}
+	got := readAll(t, r)
+	if len(got) != len(in) {
+		t.Fatalf("got %d messages, want %d", len(got), len(in))
+	}
+	for i := range in {
+		if got[i] != in[i] {
+			t.Errorf("message %d: got %+v, want %+v", i, got[i], in[i])
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "empty.mf4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := NewWriter(f).Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, r); len(got) != 0 {
+		t.Errorf("got %d messages", len(got))
+	}
+}
+
+func TestWriteXL(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "xl.mf4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := NewWriter(f)
+	var m can.Msg
+	m.Id = 0x123
+	m.Flags = can.XLFrame
+	m.SetData(make([]byte, 100))
+	if err := w.WriteMsg(&m, canlog.Info{}); err != canlog.ErrXLNotSupported {
+		t.Errorf("got %v, want ErrXLNotSupported", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLinkCountOverflow reads a header block with a link count,
+// which overflows when multiplied by the size of a link.
+func TestLinkCountOverflow(t *testing.T) {
+	b := make([]byte, 92)
+	copy(b, "MDF     4.10    ")
+	le.PutUint16(b[28:], 410)
+	copy(b[64:], "##HD")
+	le.PutUint64(b[72:], 28)
+	le.PutUint64(b[80:], 1<<61)
+	_, err := NewReader(bytes.NewReader(b))
+	if !errors.Is(err, ErrFormat) {
+		t.Errorf("got %v, want %v", err, ErrFormat)
+	}
+}
+
+// TestSortedCompressed reads a sorted data group, with two
+// CAN_DataFrame groups, one containing a transposed DZ block
+// in a data list, and data bytes stored in a signal data block,
+// the other one using a VLSD channel group.
+func TestSortedCompressed(t *testing.T) {
+	var buf bytes.Buffer
+	w := &Writer{w: bufio.NewWriter(&buf)}
+	var id [idSize]byte
+	copy(id[:], "MDF     4.20    test")
+	le.PutUint16(id[28:], 420)
+	w.write(id[:])
+	hd := &block{id: "HD", links: make([]int64, 6), data: make([]byte, 32)}
+	le.PutUint64(hd.data, 1e18)
+	w.writeBlock(hd) // the link to the first data group is set below
+
+	// records: time in ns as uint64, converted using a CC block (8), BusChannel (1), ID+IDE (4), DataLength (1), SD offset (8)
+	var sd, recs []byte
+	for i, s := range []string{"\x01\x02", strings.Repeat("\xAA", 12)} {
+		r := le.AppendUint64(nil, uint64(i+1)*1e6)
+		r = append(r, 2)
+		r = le.AppendUint32(r, 0x100+uint32(i))
+		r = append(r, byte(len(s)))
+		r = le.AppendUint64(r, uint64(len(sd)))
+		recs = append(recs, r...)
+		sd = le.AppendUint32(sd, uint32(len(s)))
+		sd = append(sd, s...)
+	}
+	const recSize = 22
+	tr := make([]byte, len(recs))
+	for r := range 2 {
+		for c := range recSize {
+			tr[c*2+r] = recs[r*recSize+c]
+		}
+	}
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(tr)
+	zw.Close()
+	dzData := make([]byte, 24, 24+z.Len())
+	copy(dzData, "DT")
+	dzData[2] = zipTransposed
+	le.PutUint32(dzData[4:], recSize)
+	le.PutUint64(dzData[8:], uint64(len(tr)))
+	le.PutUint64(dzData[16:], uint64(z.Len()))
+	dz := w.writeBlock(&block{id: "DZ", data: append(dzData, z.Bytes()...)})
+	dlData := make([]byte, 16)
+	le.PutUint32(dlData[4:], 1)
+	dl := w.writeBlock(&block{id: "DL", links: []int64{0, dz}, data: dlData})
+	sdb := w.writeBlock(&block{id: "SD", data: sd})
+
+	cc := &block{id: "CC", links: make([]int64, 4), data: make([]byte, 40)}
+	cc.data[0] = ccLinear
+	le.PutUint16(cc.data[6:], 2)
+	le.PutUint64(cc.data[32:], math.Float64bits(1e-9))
+	ccPos := w.writeBlock(cc)
+
+	chans := []channel{
+		{name: "t", typ: cnMaster, sync: syncTime, dataType: dtUintLE, bits: 64},
+		{name: "CAN_DataFrame", dataType: dtByteArr, byteOff: 8, bits: 8 * 14, flags: cnBusEvent, comp: []channel{
+			{name: "CAN_DataFrame.BusChannel", byteOff: 8, bits: 8},
+			{name: "CAN_DataFrame.ID", byteOff: 9, bits: 29},
+			{name: "CAN_DataFrame.IDE", byteOff: 12, bitOff: 7, bits: 1},
+			{name: "CAN_DataFrame.DataLength", byteOff: 13, bits: 8},
+			{name: "CAN_DataFrame.DataBytes", typ: cnVLSD, dataType: dtByteArr, byteOff: 14, bits: 64},
+		}},
+	}
+	cn := w.writeChannels(chans)
+	patchLink(t, w, &buf, cn, 4, ccPos)                           // time conversion
+	patchLink(t, w, &buf, findChannel(t, w, &buf, cn, 5), 5, sdb) // DataBytes -> SD
+	cg := &block{id: "CG", links: []int64{0, cn, w.writeText("CAN_DataFrame"), 0, 0, 0}, data: make([]byte, 32)}
+	le.PutUint64(cg.data[8:], 2)
+	le.PutUint32(cg.data[24:], recSize)
+	cgPos := w.writeBlock(cg)
+	dg := w.writeBlock(&block{id: "DG", links: []int64{0, cgPos, dl, 0}, data: make([]byte, 8)})
+
+	// second data group: unsorted, with a VLSD channel group,
+	// and the time in seconds
+	var recs2 []byte
+	recs2 = append(recs2, 2)
+	recs2 = le.AppendUint32(recs2, 3)
+	recs2 = append(recs2, 7, 8, 9)
+	recs2 = append(recs2, 1)
+	recs2 = le.AppendUint64(recs2, math.Float64bits(1.5e-3))
+	recs2 = append(recs2, 1)
+	recs2 = le.AppendUint32(recs2, 0x80012345)
+	recs2 = le.AppendUint64(recs2, 0)
+	dt2 := w.writeBlock(&block{id: "DT", data: recs2})
+	chans2 := []channel{
+		{name: "t", typ: cnMaster, sync: syncTime, dataType: dtFloatLE, bits: 64},
+		{name: "CAN_DataFrame", dataType: dtByteArr, byteOff: 8, bits: 8 * 13, flags: cnBusEvent, comp: []channel{
+			{name: "CAN_DataFrame.BusChannel", byteOff: 8, bits: 8},
+			{name: "CAN_DataFrame.ID", byteOff: 9, bits: 32},
+			{name: "CAN_DataFrame.DataBytes", typ: cnVLSD, dataType: dtByteArr, byteOff: 13, bits: 64},
+		}},
+	}
+	cn2 := w.writeChannels(chans2)
+	vcg := &block{id: "CG", links: make([]int64, 6), data: make([]byte, 32)}
+	le.PutUint64(vcg.data[0:], 2)
+	le.PutUint16(vcg.data[16:], cgVLSD)
+	vcgPos := w.writeBlock(vcg)
+	patchLink(t, w, &buf, findChannel(t, w, &buf, cn2, 3), 5, vcgPos)
+	cg2 := &block{id: "CG", links: []int64{vcgPos, cn2, 0, 0, 0, 0}, data: make([]byte, 32)}
+	le.PutUint64(cg2.data[0:], 1)
+	le.PutUint32(cg2.data[24:], 21)
+	cg2Pos := w.writeBlock(cg2)
+	dg2 := w.writeBlock(&block{id: "DG", links: []int64{dg, cg2Pos, dt2, 0}, data: []byte{1, 0, 0, 0, 0, 0, 0, 0}})
+	w.w.Flush()
+	le.PutUint64(buf.Bytes()[idSize+blockHeaderSize:], uint64(dg2))
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := can.Time(1e15)
+	want := []record{
+		{0x100, 0, "\x01\x02", t0 + 1000, 2, canlog.Rx},
+		{0x12345, can.ExtFrame, "\x07\x08\x09", t0 + 1500, 1, canlog.Rx},
+		{0x101, can.ForceFD, strings.Repeat("\xAA", 12), t0 + 2000, 2, canlog.Rx},
+	}
+	got := readAll(t, r)
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// findChannel returns the position of the n-th sub-channel
+// of the composition of the second channel in a list.
+func findChannel(t *testing.T, w *Writer, buf *bytes.Buffer, first int64, n int) int64 {
+	t.Helper()
+	w.w.Flush()
+	b := buf.Bytes()
+	link := func(pos int64, i int) int64 {
+		return int64(le.Uint64(b[pos+blockHeaderSize+8*int64(i):]))
+	}
+	pos := link(link(first, 0), 1)
+	for range n - 1 {
+		pos = link(pos, 0)
+	}
+	return pos
+}
+
+func patchLink(t *testing.T, w *Writer, buf *bytes.Buffer, pos int64, i int, target int64) {
+	t.Helper()
+	w.w.Flush()
+	le.PutUint64(buf.Bytes()[pos+blockHeaderSize+8*int64(i):], uint64(target))
+}
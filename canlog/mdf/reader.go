@@ -0,0 +1,796 @@
+package mdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+	"github.com/knieriem/can/canlog/internal/dlc"
+)
+
+// Reader reads CAN bus events from an MDF 4 file.
+type Reader struct {
+	r io.ReaderAt
+
+	// Start is the start time of the measurement,
+	// as specified in the file header.
+	Start time.Time
+	start can.Time
+
+	streams []*stream
+}
+
+// NewReader returns a Reader reading from r, after reading
+// the file's meta data. Data groups not containing CAN bus
+// events are ignored.
+func NewReader(r io.ReaderAt) (*Reader, error) {
+	var id [idSize]byte
+	if _, err := r.ReadAt(id[:], 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if string(id[:8]) != "MDF     " {
+		return nil, ErrFormat
+	}
+	if v := le.Uint16(id[28:]); v < 400 || v >= 500 {
+		return nil, fmt.Errorf("%w: %d", ErrVersion, v)
+	}
+	rd := &Reader{r: r}
+	hd, err := rd.readBlock(idSize, "HD")
+	if err != nil {
+		return nil, err
+	}
+	if len(hd.links) < 1 || len(hd.data) < 13 {
+		return nil, fmt.Errorf("%w: short header block", ErrFormat)
+	}
+	ns := int64(le.Uint64(hd.data[0:]))
+	rd.Start = time.Unix(0, ns)
+	if hd.data[12]&1 != 0 {
+		// local time
+		t := rd.Start.UTC()
+		rd.Start = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+	}
+	rd.start = can.Time(rd.Start.UnixMicro())
+
+	for dg := hd.links[0]; dg != 0; {
+		b, err := rd.readBlock(dg, "DG")
+		if err != nil {
+			return nil, err
+		}
+		if len(b.links) < 3 || len(b.data) < 1 {
+			return nil, fmt.Errorf("%w: short data group block", ErrFormat)
+		}
+		s, err := rd.newStream(b)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			rd.streams = append(rd.streams, s)
+		}
+		dg = b.links[0]
+	}
+	return rd, nil
+}
+
+// ReadMsg reads the next message into m. If the file contains
+// multiple data groups, the message with the earliest timestamp
+// is returned. At the end of the file, io.EOF is returned.
+func (r *Reader) ReadMsg(m *can.Msg) (canlog.Info, error) {
+	var next *stream
+	for _, s := range r.streams {
+		if !s.valid {
+			if err := s.advance(r.start); err != nil {
+				return canlog.Info{}, err
+			}
+		}
+		if s.eof {
+			continue
+		}
+		if next == nil || s.msg.Rx.Time < next.msg.Rx.Time {
+			next = s
+		}
+	}
+	if next == nil {
+		return canlog.Info{}, io.EOF
+	}
+	next.valid = false
+	*m = next.msg
+	return next.info, nil
+}
+
+type readBlock struct {
+	id    string
+	links []int64
+	data  []byte
+}
+
+// readBlock reads the block at position pos, which is
+// expected to be of the specified type, if id is not empty.
+func (r *Reader) readBlock(pos int64, id string) (*readBlock, error) {
+	var h [blockHeaderSize]byte
+	if _, err := r.r.ReadAt(h[:], pos); err != nil {
+		return nil, fmt.Errorf("%w: block at %d: %v", ErrFormat, pos, err)
+	}
+	bid := string(h[2:4])
+	if string(h[:2]) != "##" || id != "" && bid != id {
+		return nil, fmt.Errorf("%w: block at %d: expected %s block", ErrFormat, pos, id)
+	}
+	size := le.Uint64(h[8:])
+	nlinks := le.Uint64(h[16:])
+	if size > maxBlockSize || size < blockHeaderSize || nlinks > (size-blockHeaderSize)/8 {
+		return nil, fmt.Errorf("%w: %s block at %d: size %d", ErrFormat, bid, pos, size)
+	}
+	p := make([]byte, size-blockHeaderSize)
+	if _, err := r.r.ReadAt(p, pos+blockHeaderSize); err != nil {
+		return nil, fmt.Errorf("%w: %s block at %d: %v", ErrFormat, bid, pos, err)
+	}
+	b := &readBlock{id: bid, links: make([]int64, nlinks)}
+	for i := range b.links {
+		b.links[i] = int64(le.Uint64(p[8*i:]))
+	}
+	b.data = p[8*nlinks:]
+	return b, nil
+}
+
+// readText returns the contents of a TX or MD block.
+func (r *Reader) readText(pos int64) (string, error) {
+	if pos == 0 {
+		return "", nil
+	}
+	b, err := r.readBlock(pos, "")
+	if err != nil {
+		return "", err
+	}
+	if b.id != "TX" && b.id != "MD" {
+		return "", fmt.Errorf("%w: block at %d: expected text block", ErrFormat, pos)
+	}
+	s, _, _ := bytes.Cut(b.data, []byte{0})
+	return string(s), nil
+}
+
+// dataReader returns a reader for the contents of a data block,
+// which may be a DT or SD block, a data list, a header list,
+// or a compressed data block.
+func (r *Reader) dataReader(pos int64) (io.Reader, error) {
+	var list []io.Reader
+	if err := r.appendData(&list, pos); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(list...), nil
+}
+
+func (r *Reader) appendData(list *[]io.Reader, pos int64) error {
+	if pos == 0 {
+		return nil
+	}
+	var h [blockHeaderSize]byte
+	if _, err := r.r.ReadAt(h[:], pos); err != nil {
+		return fmt.Errorf("%w: data block at %d: %v", ErrFormat, pos, err)
+	}
+	size := int64(le.Uint64(h[8:]))
+	switch string(h[:4]) {
+	case "##DT", "##SD":
+		if size < blockHeaderSize {
+			return fmt.Errorf("%w: data block at %d: size %d", ErrFormat, pos, size)
+		}
+		*list = append(*list, io.NewSectionReader(r.r, pos+blockHeaderSize, size-blockHeaderSize))
+	case "##DZ":
+		*list = append(*list, &dzReader{r: r.r, pos: pos, size: size})
+	case "##DL":
+		for pos != 0 {
+			b, err := r.readBlock(pos, "DL")
+			if err != nil {
+				return err
+			}
+			if len(b.links) < 1 || len(b.data) < 8 {
+				return fmt.Errorf("%w: short data list", ErrFormat)
+			}
+			n := int(le.Uint32(b.data[4:]))
+			if n > len(b.links)-1 {
+				return fmt.Errorf("%w: data list count", ErrFormat)
+			}
+			for _, l := range b.links[1 : 1+n] {
+				if err := r.appendData(list, l); err != nil {
+					return err
+				}
+			}
+			pos = b.links[0]
+		}
+	case "##HL":
+		b, err := r.readBlock(pos, "HL")
+		if err != nil {
+			return err
+		}
+		if len(b.links) < 1 {
+			return fmt.Errorf("%w: short header list", ErrFormat)
+		}
+		return r.appendData(list, b.links[0])
+	default:
+		return fmt.Errorf("%w: unexpected %q block at %d", ErrFormat, h[:4], pos)
+	}
+	return nil
+}
+
+// dzReader decompresses a DZ block on the first read.
+type dzReader struct {
+	r    io.ReaderAt
+	pos  int64
+	size int64
+	data io.Reader
+}
+
+func (z *dzReader) Read(p []byte) (int, error) {
+	if z.data == nil {
+		b, err := z.decompress()
+		if err != nil {
+			return 0, err
+		}
+		z.data = bytes.NewReader(b)
+	}
+	return z.data.Read(p)
+}
+
+func (z *dzReader) decompress() ([]byte, error) {
+	const hsize = blockHeaderSize + 24
+	if z.size < hsize {
+		return nil, fmt.Errorf("%w: short DZ block", ErrFormat)
+	}
+	var h [hsize]byte
+	if _, err := z.r.ReadAt(h[:], z.pos); err != nil {
+		return nil, err
+	}
+	typ := h[blockHeaderSize+2]
+	param := int(le.Uint32(h[blockHeaderSize+4:]))
+	orgLen := le.Uint64(h[blockHeaderSize+8:])
+	zLen := int64(le.Uint64(h[blockHeaderSize+16:]))
+	if orgLen > maxBlockSize*4 || zLen > z.size-hsize {
+		return nil, fmt.Errorf("%w: DZ block at %d: size", ErrFormat, z.pos)
+	}
+	if typ != zipDeflate && typ != zipTransposed {
+		return nil, fmt.Errorf("%w: DZ compression type %d", ErrUnsupported, typ)
+	}
+	zr, err := zlib.NewReader(io.NewSectionReader(z.r, z.pos+hsize, zLen))
+	if err != nil {
+		return nil, fmt.Errorf("%w: DZ block at %d: %v", ErrFormat, z.pos, err)
+	}
+	b := make([]byte, orgLen)
+	_, err = io.ReadFull(zr, b)
+	zr.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%w: DZ block at %d: %v", ErrFormat, z.pos, err)
+	}
+	if typ == zipTransposed && param > 0 {
+		b = untranspose(b, param)
+	}
+	return b, nil
+}
+
+// untranspose reverts the transposition of data consisting of
+// records of the specified size. Remaining bytes not forming
+// a complete record have not been transposed.
+func untranspose(b []byte, cols int) []byte {
+	rows := len(b) / cols
+	out := make([]byte, len(b))
+	for c := range cols {
+		for r := range rows {
+			out[r*cols+c] = b[c*rows+r]
+		}
+	}
+	copy(out[rows*cols:], b[rows*cols:])
+	return out
+}
+
+// stream reads messages from the records of a data group.
+type stream struct {
+	rd        *Reader
+	data      *bufio.Reader
+	recIDSize int
+	groups    map[uint64]*group
+	single    *group // the channel group of a sorted data group
+
+	// next message
+	msg   can.Msg
+	info  canlog.Info
+	valid bool
+	eof   bool
+
+	rec []byte
+}
+
+type frameKind int
+
+const (
+	otherGroup frameKind = iota
+	dataFrameGroup
+	remoteFrameGroup
+	errorFrameGroup
+	vlsdGroup
+)
+
+// group describes the records of a channel group.
+type group struct {
+	kind frameKind
+	size int
+
+	time       *field
+	busChannel *field
+	id         *field
+	ide        *field
+	dlc        *field
+	dataLength *field
+	dir        *field
+	edl        *field
+	brs        *field
+	esi        *field
+	dataBytes  *field
+	errorType  *field
+	status     *field
+
+	// data of the last VLSD record, referring to this group
+	vlsd []byte
+}
+
+// field describes the location of a channel's value within a record.
+type field struct {
+	typ      byte
+	dataType byte
+	byteOff  int
+	bitOff   int
+	bits     int
+
+	// linear conversion
+	offset, factor float64
+	conv           bool
+
+	// signal data, if the channel is stored in an SD block
+	sd     []byte
+	sdPos  int64
+	sdRead bool
+
+	// VLSD channel group
+	vlsd *group
+}
+
+func (r *Reader) newStream(dg *readBlock) (*stream, error) {
+	s := &stream{rd: r, recIDSize: int(dg.data[0]), groups: make(map[uint64]*group)}
+	switch s.recIDSize {
+	case 0, 1, 2, 4, 8:
+	default:
+		return nil, fmt.Errorf("%w: record ID size %d", ErrFormat, s.recIDSize)
+	}
+	vlsdRefs := make(map[int64]*field)
+	cgPos := make(map[int64]*group)
+	nBus := 0
+	for pos := dg.links[1]; pos != 0; {
+		cg, err := r.readBlock(pos, "CG")
+		if err != nil {
+			return nil, err
+		}
+		if len(cg.links) < 2 || len(cg.data) < 32 {
+			return nil, fmt.Errorf("%w: short channel group block", ErrFormat)
+		}
+		g := new(group)
+		recID := le.Uint64(cg.data[0:])
+		if le.Uint16(cg.data[16:])&cgVLSD != 0 {
+			g.kind = vlsdGroup
+		} else {
+			g.size = int(le.Uint32(cg.data[24:]) + le.Uint32(cg.data[28:]))
+			name, err := r.readText(cg.links[2])
+			if err != nil {
+				return nil, err
+			}
+			if err := r.parseChannels(g, cg.links[1], name, vlsdRefs); err != nil {
+				return nil, err
+			}
+			if g.kind != otherGroup {
+				nBus++
+			}
+		}
+		s.groups[recID] = g
+		cgPos[pos] = g
+		pos = cg.links[0]
+	}
+	for pos, f := range vlsdRefs {
+		if g, ok := cgPos[pos]; ok {
+			f.vlsd = g
+		} else {
+			f.sdPos = pos
+		}
+	}
+	if nBus == 0 {
+		return nil, nil
+	}
+	if s.recIDSize == 0 {
+		if len(s.groups) != 1 {
+			return nil, fmt.Errorf("%w: sorted data group with multiple channel groups", ErrFormat)
+		}
+		for _, g := range s.groups {
+			s.single = g
+		}
+	}
+	data, err := r.dataReader(dg.links[2])
+	if err != nil {
+		return nil, err
+	}
+	s.data = bufio.NewReader(data)
+	return s, nil
+}
+
+// parseChannels walks through the channels of a channel group, including
+// compositions, and records the locations of the channels of interest.
+func (r *Reader) parseChannels(g *group, pos int64, cgName string, vlsdRefs map[int64]*field) error {
+	for pos != 0 {
+		cn, err := r.readBlock(pos, "")
+		if err != nil {
+			return err
+		}
+		if cn.id != "CN" {
+			// e.g. a channel array
+			return nil
+		}
+		if len(cn.links) < 8 || len(cn.data) < 16 {
+			return fmt.Errorf("%w: short channel block", ErrFormat)
+		}
+		name, err := r.readText(cn.links[2])
+		if err != nil {
+			return err
+		}
+		f := &field{
+			typ:      cn.data[0],
+			dataType: cn.data[2],
+			bitOff:   int(cn.data[3]),
+			byteOff:  int(le.Uint32(cn.data[4:])),
+			bits:     int(le.Uint32(cn.data[8:])),
+		}
+		if f.typ == cnMaster && cn.data[1] == syncTime {
+			if err := r.parseConversion(f, cn.links[4]); err != nil {
+				return err
+			}
+			g.time = f
+		}
+		if f.typ == cnVLSD && cn.links[5] != 0 {
+			vlsdRefs[cn.links[5]] = f
+		}
+		switch name {
+		case dataFrame:
+			g.kind = dataFrameGroup
+		case remoteFrame:
+			g.kind = remoteFrameGroup
+		case errorFrame:
+			g.kind = errorFrameGroup
+		}
+		if _, sub, ok := strings.Cut(name, "."); ok && strings.HasPrefix(name, "CAN_") {
+			g.setField(sub, f)
+		} else if !ok && strings.HasPrefix(cgName, "CAN_") {
+			g.setField(name, f)
+		}
+		if err := r.parseChannels(g, cn.links[1], cgName, vlsdRefs); err != nil {
+			return err
+		}
+		pos = cn.links[0]
+	}
+	return nil
+}
+
+func (g *group) setField(name string, f *field) {
+	switch name {
+	case "BusChannel":
+		g.busChannel = f
+	case "ID":
+		g.id = f
+	case "IDE":
+		g.ide = f
+	case "DLC":
+		g.dlc = f
+	case "DataLength":
+		g.dataLength = f
+	case "Dir":
+		g.dir = f
+	case "EDL":
+		g.edl = f
+	case "BRS":
+		g.brs = f
+	case "ESI":
+		g.esi = f
+	case "DataBytes":
+		g.dataBytes = f
+	case "ErrorType":
+		g.errorType = f
+	case "Status":
+		g.status = f
+	}
+}
+
+func (r *Reader) parseConversion(f *field, pos int64) error {
+	if pos == 0 {
+		return nil
+	}
+	cc, err := r.readBlock(pos, "CC")
+	if err != nil {
+		return err
+	}
+	if len(cc.data) < 24 {
+		return fmt.Errorf("%w: short conversion block", ErrFormat)
+	}
+	switch cc.data[0] {
+	case ccIdentity:
+	case ccLinear:
+		if len(cc.data) < 40 {
+			return fmt.Errorf("%w: short conversion block", ErrFormat)
+		}
+		f.conv = true
+		f.offset = math.Float64frombits(le.Uint64(cc.data[24:]))
+		f.factor = math.Float64frombits(le.Uint64(cc.data[32:]))
+	default:
+		return fmt.Errorf("%w: time conversion type %d", ErrUnsupported, cc.data[0])
+	}
+	return nil
+}
+
+// advance reads records until the next bus event has been decoded.
+func (s *stream) advance(start can.Time) error {
+	for {
+		g, rec, err := s.readRecord()
+		if err == io.EOF {
+			s.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if g.kind == otherGroup || g.kind == vlsdGroup {
+			continue
+		}
+		if err := s.decode(g, rec, start); err != nil {
+			return err
+		}
+		s.valid = true
+		return nil
+	}
+}
+
+func (s *stream) readRecord() (*group, []byte, error) {
+	g := s.single
+	if g == nil {
+		var b [8]byte
+		if _, err := io.ReadFull(s.data, b[:s.recIDSize]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, nil, fmt.Errorf("%w: truncated record", ErrFormat)
+			}
+			return nil, nil, err
+		}
+		id := le.Uint64(b[:])
+		g = s.groups[id]
+		if g == nil {
+			return nil, nil, fmt.Errorf("%w: unknown record ID %d", ErrFormat, id)
+		}
+	}
+	size := g.size
+	if g.kind == vlsdGroup {
+		var b [4]byte
+		if _, err := io.ReadFull(s.data, b[:]); err != nil {
+			return nil, nil, fmt.Errorf("%w: truncated record", ErrFormat)
+		}
+		size = int(le.Uint32(b[:]))
+		if size > maxBlockSize {
+			return nil, nil, fmt.Errorf("%w: VLSD record size %d", ErrFormat, size)
+		}
+	}
+	if cap(s.rec) < size {
+		s.rec = make([]byte, size)
+	}
+	rec := s.rec[:size]
+	if _, err := io.ReadFull(s.data, rec); err != nil {
+		if err == io.EOF && s.recIDSize == 0 {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("%w: truncated record", ErrFormat)
+	}
+	if g.kind == vlsdGroup {
+		g.vlsd = append(g.vlsd[:0], rec...)
+	}
+	return g, rec, nil
+}
+
+func (s *stream) decode(g *group, rec []byte, start can.Time) error {
+	m := &s.msg
+	m.Reset()
+	s.info = canlog.Info{Channel: 1}
+	if g.time != nil {
+		t, err := g.time.float(rec)
+		if err != nil {
+			return err
+		}
+		m.Rx.Time = start + can.Time(math.Round(t*1e6))
+	}
+	if v, ok := g.busChannel.uint(rec); ok {
+		s.info.Channel = int(v)
+	}
+	if v, _ := g.dir.uint(rec); v != 0 {
+		s.info.Dir = canlog.Tx
+	}
+	if g.kind == errorFrameGroup {
+		m.Flags = can.StatusMsg
+		if v, _ := g.errorType.uint(rec); v == errTypeAck {
+			m.Flags |= can.MissingAck
+		}
+		st, _ := g.status.uint(rec)
+		m.Flags |= statusFlags(st)
+		return nil
+	}
+
+	id, _ := g.id.uint(rec)
+	ide, ok := g.ide.uint(rec)
+	if !ok {
+		ide = id >> 31
+	}
+	if ide != 0 || id > 0x7FF {
+		m.Flags |= can.ExtFrame
+	}
+	m.Id = uint32(id & 0x1FFFFFFF)
+	if g.kind == remoteFrameGroup {
+		m.Flags |= can.RTRMsg
+		return nil
+	}
+
+	data, err := s.dataBytes(g, rec)
+	if err != nil {
+		return err
+	}
+	edl, _ := g.edl.uint(rec)
+	n, ok := g.dataLength.uint(rec)
+	if !ok {
+		n = uint64(len(data))
+		if code, ok := g.dlc.uint(rec); ok {
+			n = uint64(dlc.Len(byte(code), edl != 0))
+		}
+	}
+	if n > uint64(len(data)) {
+		return fmt.Errorf("%w: data length %d exceeds data bytes", ErrFormat, n)
+	}
+	data = data[:n]
+	if edl != 0 || n > 8 {
+		m.Flags |= can.ForceFD
+		if v, _ := g.brs.uint(rec); v != 0 {
+			m.Flags |= can.FDSwitchBitrate
+		}
+		if v, _ := g.esi.uint(rec); v != 0 {
+			m.Flags |= can.FDErrorStateInd
+		}
+	}
+	if len(data) > 8 {
+		data = bytes.Clone(data)
+	}
+	m.SetData(data)
+	return nil
+}
+
+// dataBytes returns the data bytes of a data frame,
+// which may be stored in the record, or as signal data.
+func (s *stream) dataBytes(g *group, rec []byte) ([]byte, error) {
+	f := g.dataBytes
+	if f == nil {
+		return nil, nil
+	}
+	if f.typ != cnVLSD {
+		end := f.byteOff + f.bits/8
+		if end > len(rec) {
+			return nil, fmt.Errorf("%w: channel exceeds record", ErrFormat)
+		}
+		return rec[f.byteOff:end], nil
+	}
+	if f.vlsd != nil {
+		return f.vlsd.vlsd, nil
+	}
+	if !f.sdRead {
+		data, err := s.rd.dataReader(f.sdPos)
+		if err != nil {
+			return nil, err
+		}
+		if f.sd, err = io.ReadAll(data); err != nil {
+			return nil, err
+		}
+		f.sdRead = true
+	}
+	off, _ := f.uint(rec)
+	if off+4 > uint64(len(f.sd)) {
+		return nil, fmt.Errorf("%w: signal data offset", ErrFormat)
+	}
+	n := uint64(le.Uint32(f.sd[off:]))
+	if off+4+n > uint64(len(f.sd)) {
+		return nil, fmt.Errorf("%w: signal data length", ErrFormat)
+	}
+	return f.sd[off+4 : off+4+n], nil
+}
+
+// uint returns the value of an integer channel.
+// If the field is not defined, ok is false.
+func (f *field) uint(rec []byte) (v uint64, ok bool) {
+	if f == nil {
+		return 0, false
+	}
+	bits := f.bits
+	if f.typ == cnVLSD {
+		bits = 64
+	}
+	nb := (f.bitOff + bits + 7) / 8
+	if bits > 64 || nb > 8 || f.byteOff+nb > len(rec) {
+		return 0, false
+	}
+	var b [8]byte
+	p := rec[f.byteOff : f.byteOff+nb]
+	switch f.dataType {
+	case dtUintBE, dtIntBE, dtFloatBE:
+		copy(b[8-nb:], p)
+		v = binary.BigEndian.Uint64(b[:]) >> f.bitOff
+	default:
+		copy(b[:], p)
+		v = le.Uint64(b[:]) >> f.bitOff
+	}
+	if bits < 64 {
+		v &= 1<<bits - 1
+	}
+	return v, true
+}
+
+// float returns the physical value of a numeric channel.
+func (f *field) float(rec []byte) (float64, error) {
+	var v float64
+	switch f.dataType {
+	case dtFloatLE, dtFloatBE:
+		raw, ok := f.uint(rec)
+		if !ok {
+			return 0, fmt.Errorf("%w: channel exceeds record", ErrFormat)
+		}
+		switch f.bits {
+		case 64:
+			v = math.Float64frombits(raw)
+		case 32:
+			v = float64(math.Float32frombits(uint32(raw)))
+		default:
+			return 0, fmt.Errorf("%w: float of %d bits", ErrUnsupported, f.bits)
+		}
+	case dtUintLE, dtUintBE:
+		raw, ok := f.uint(rec)
+		if !ok {
+			return 0, fmt.Errorf("%w: channel exceeds record", ErrFormat)
+		}
+		v = float64(raw)
+	default:
+		return 0, fmt.Errorf("%w: time channel data type %d", ErrUnsupported, f.dataType)
+	}
+	if f.conv {
+		v = f.offset + f.factor*v
+	}
+	return v, nil
+}
+
+func statusFlags(st uint64) can.Flags {
+	var f can.Flags
+	if st&statusErrorActive != 0 {
+		f |= can.ErrorActive
+	}
+	if st&statusErrorWarning != 0 {
+		f |= can.ErrorWarning
+	}
+	if st&statusErrorPassive != 0 {
+		f |= can.ErrorPassive
+	}
+	if st&statusBusOff != 0 {
+		f |= can.BusOff
+	}
+	if st&statusDataOverrun != 0 {
+		f |= can.DataOverrun
+	}
+	if st&statusRxBufferOverflow != 0 {
+		f |= can.ReceiveBufferOverflow
+	}
+	return f
+}
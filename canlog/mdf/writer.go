@@ -0,0 +1,392 @@
+package mdf
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+	"github.com/knieriem/can/canlog/internal/dlc"
+)
+
+// Writer writes messages to an MDF 4 file.
+type Writer struct {
+	ws  io.WriteSeeker
+	w   *bufio.Writer
+	pos int64
+	err error
+
+	begun bool
+	start can.Time
+	hdPos int64
+	dtPos int64
+
+	counts [nRecords + 1]uint64
+	rec    []byte
+}
+
+// NewWriter returns a Writer writing to w. Close must
+// be called after the last message has been written.
+func NewWriter(w io.WriteSeeker) *Writer {
+	return &Writer{ws: w, w: bufio.NewWriter(w)}
+}
+
+// block is an MDF block, consisting of a block type,
+// a list of links to other blocks, and data.
+type block struct {
+	id    string
+	links []int64
+	data  []byte
+}
+
+func (b *block) size() int {
+	return blockHeaderSize + 8*len(b.links) + len(b.data)
+}
+
+// writeBlock writes b at the current position, which it returns.
+// Padding bytes are appended, not being part of the block, so
+// that the next block is aligned to eight bytes.
+func (w *Writer) writeBlock(b *block) int64 {
+	pos := w.pos
+	h := make([]byte, blockHeaderSize, b.size()+pad8(len(b.data)))
+	copy(h, "##"+b.id)
+	le.PutUint64(h[8:], uint64(b.size()))
+	le.PutUint64(h[16:], uint64(len(b.links)))
+	for _, l := range b.links {
+		h = le.AppendUint64(h, uint64(l))
+	}
+	h = append(h, b.data...)
+	h = append(h, make([]byte, pad8(len(b.data)))...)
+	w.write(h)
+	return pos
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(b)
+	w.pos += int64(n)
+}
+
+// writeText writes a TX block, or, if the text is an XML
+// fragment, an MD block. An empty string results in a nil link.
+func (w *Writer) writeText(s string) int64 {
+	if s == "" {
+		return 0
+	}
+	id := "TX"
+	if s[0] == '<' {
+		id = "MD"
+	}
+	return w.writeBlock(&block{id: id, data: append([]byte(s), 0)})
+}
+
+func (w *Writer) begin(t can.Time) {
+	w.begun = true
+	w.start = t
+
+	var id [idSize]byte
+	copy(id[:], "MDF     4.10    knieriem")
+	le.PutUint16(id[28:], fileVersion)
+	w.write(id[:])
+
+	hd := &block{id: "HD", links: make([]int64, 6), data: make([]byte, 32)}
+	fh := &block{id: "FH", links: make([]int64, 2), data: make([]byte, 16)}
+	w.hdPos = w.pos
+	hd.links[1] = w.hdPos + int64(hd.size()) // HD and FH need no padding
+	le.PutUint64(hd.data[0:], uint64(t)*1000)
+	w.writeBlock(hd)
+
+	fh.links[1] = w.pos + int64(fh.size())
+	le.PutUint64(fh.data[0:], uint64(time.Now().UnixNano()))
+	w.writeBlock(fh)
+	w.writeText("<FHcomment><TX>created</TX><tool_id>can</tool_id>" +
+		"<tool_vendor>github.com/knieriem/can</tool_vendor>" +
+		"<tool_version>1</tool_version></FHcomment>")
+
+	// The DT block's header is written now; its
+	// length will be updated by Close.
+	w.dtPos = w.pos
+	var dt [blockHeaderSize]byte
+	copy(dt[:], "##DT")
+	w.write(dt[:])
+}
+
+// WriteMsg writes message m. Messages should be written in the
+// order of their timestamps, starting at the file's start time.
+func (w *Writer) WriteMsg(m *can.Msg, info canlog.Info) error {
+	if w.err != nil {
+		return w.err
+	}
+	if m.XLFrame() {
+		return canlog.ErrXLNotSupported
+	}
+	if !w.begun {
+		w.begin(m.Rx.Time)
+	}
+	data := m.Data()
+	_, needsFD, _ := can.VerifyDataLenFD(len(data))
+	fd := needsFD || m.Test(can.ForceFD)
+
+	var recID, size int
+	switch {
+	case m.IsStatus():
+		recID, size = recErrorFrame, errorFrameSize
+	case fd:
+		recID, size = recDataFrameFD, offDataBytes+64
+	case m.Test(can.RTRMsg):
+		recID, size = recRemoteFrame, remoteFrameSize
+	default:
+		recID, size = recDataFrame, offDataBytes+8
+	}
+	r := w.rec[:0]
+	r = append(r, byte(recID))
+	r = append(r, make([]byte, size)...)
+	w.rec = r
+	r = r[1:]
+
+	le.PutUint64(r[offTime:], math.Float64bits(float64(m.Rx.Time-w.start)/1e6))
+	r[offBusChannel] = byte(max(info.Channel, 1))
+	var flags byte
+	if info.Dir == canlog.Tx {
+		flags |= flagDir
+	}
+	switch recID {
+	case recErrorFrame:
+		r[offErrorType] = errTypeUnknown
+		if m.Test(can.MissingAck) {
+			r[offErrorType] = errTypeAck
+		}
+		r[offStatus] = statusBits(m.Flags)
+	case recRemoteFrame:
+		setID(r, m)
+	default:
+		setID(r, m)
+		r[offDLC] = dlc.FromLen(len(data))
+		r[offDataLength] = byte(len(data))
+		copy(r[offDataBytes:], data)
+		if fd {
+			flags |= flagEDL
+			if m.Test(can.FDSwitchBitrate) {
+				flags |= flagBRS
+			}
+			if m.Test(can.FDErrorStateInd) {
+				flags |= flagESI
+			}
+		}
+	}
+	r[offFlags] = flags
+	w.write(w.rec)
+	w.counts[recID]++
+	return w.err
+}
+
+func setID(r []byte, m *can.Msg) {
+	id := m.Id
+	if m.ExtFrame() {
+		id |= 1 << 31
+	}
+	le.PutUint32(r[offID:], id)
+}
+
+func statusBits(f can.Flags) byte {
+	var st byte
+	if f.Test(can.ErrorActive) {
+		st |= statusErrorActive
+	}
+	if f.Test(can.ErrorWarning) {
+		st |= statusErrorWarning
+	}
+	if f.Test(can.ErrorPassive) {
+		st |= statusErrorPassive
+	}
+	if f.Test(can.BusOff) {
+		st |= statusBusOff
+	}
+	if f.Test(can.DataOverrun) {
+		st |= statusDataOverrun
+	}
+	if f.Test(can.ReceiveBufferOverflow) {
+		st |= statusRxBufferOverflow
+	}
+	return st
+}
+
+// channel describes a channel to be written
+// into the file's meta data.
+type channel struct {
+	name     string
+	typ      byte
+	sync     byte
+	dataType byte
+	byteOff  uint32
+	bitOff   byte
+	bits     uint32
+	flags    uint32
+	unit     string
+	comp     []channel
+}
+
+// busChannels returns the sub-channels of a bus event
+// channel, with data bytes of the specified length.
+func busChannels(name string, recID int, nData int) []channel {
+	sub := []channel{
+		{name: "BusChannel", byteOff: offBusChannel, bits: 8},
+		{name: "ID", byteOff: offID, bits: 29},
+		{name: "IDE", byteOff: offID + 3, bitOff: 7, bits: 1},
+	}
+	size := uint32(remoteFrameSize)
+	switch recID {
+	case recDataFrame, recDataFrameFD:
+		size = uint32(offDataBytes + nData)
+		sub = append(sub,
+			channel{name: "DLC", byteOff: offDLC, bits: 4},
+			channel{name: "DataLength", byteOff: offDataLength, bits: 8},
+			channel{name: "Dir", byteOff: offFlags, bits: 1},
+			channel{name: "EDL", byteOff: offFlags, bitOff: 1, bits: 1},
+			channel{name: "BRS", byteOff: offFlags, bitOff: 2, bits: 1},
+			channel{name: "ESI", byteOff: offFlags, bitOff: 3, bits: 1},
+			channel{name: "DataBytes", dataType: dtByteArr, byteOff: offDataBytes, bits: uint32(8 * nData)},
+		)
+	case recRemoteFrame:
+		sub = append(sub,
+			channel{name: "DLC", byteOff: offDLC, bits: 4},
+			channel{name: "DataLength", byteOff: offDataLength, bits: 8},
+			channel{name: "Dir", byteOff: offFlags, bits: 1},
+		)
+	case recErrorFrame:
+		size = errorFrameSize
+		sub = append(sub,
+			channel{name: "Dir", byteOff: offFlags, bits: 1},
+			channel{name: "ErrorType", byteOff: offErrorType, bits: 4},
+			channel{name: "Status", byteOff: offStatus, bits: 8},
+		)
+	}
+	for i := range sub {
+		sub[i].name = name + "." + sub[i].name
+	}
+	return []channel{
+		{name: "Timestamp", typ: cnMaster, sync: syncTime, dataType: dtFloatLE, byteOff: offTime, bits: 64, unit: "s"},
+		{name: name, dataType: dtByteArr, byteOff: offBusChannel, bits: 8 * (size - offBusChannel), flags: cnBusEvent, comp: sub},
+	}
+}
+
+// writeChannels writes a list of channels, including their
+// compositions, and returns the position of the first one.
+// Blocks are written in reverse order, so that each block
+// refers to blocks already written only.
+func (w *Writer) writeChannels(list []channel) int64 {
+	var next int64
+	for i := len(list) - 1; i >= 0; i-- {
+		c := &list[i]
+		comp := w.writeChannels(c.comp)
+		name := w.writeText(c.name)
+		unit := w.writeText(c.unit)
+		b := &block{id: "CN", links: make([]int64, 8), data: make([]byte, 72)}
+		b.links[0] = next
+		b.links[1] = comp
+		b.links[2] = name
+		b.links[6] = unit
+		b.data[0] = c.typ
+		b.data[1] = c.sync
+		b.data[2] = c.dataType
+		b.data[3] = c.bitOff
+		le.PutUint32(b.data[4:], c.byteOff)
+		le.PutUint32(b.data[8:], c.bits)
+		le.PutUint32(b.data[12:], c.flags)
+		next = w.writeBlock(b)
+	}
+	return next
+}
+
+// writeMetaData writes data group, channel groups and
+// channels after the data block, and returns the
+// position of the data group.
+func (w *Writer) writeMetaData(dt int64) int64 {
+	groups := []struct {
+		recID int
+		name  string
+		nData int
+	}{
+		{recDataFrame, dataFrame, 8},
+		{recDataFrameFD, dataFrame, 64},
+		{recRemoteFrame, remoteFrame, 0},
+		{recErrorFrame, errorFrame, 0},
+	}
+	si := w.writeBlock(&block{id: "SI", links: []int64{w.writeText("CAN"), 0, 0}, data: []byte{siTypeBus, siBusCAN, 0, 0, 0, 0, 0, 0}})
+
+	var next int64
+	for i := len(groups) - 1; i >= 0; i-- {
+		g := &groups[i]
+		if w.counts[g.recID] == 0 {
+			continue
+		}
+		chans := busChannels(g.name, g.recID, g.nData)
+		cn := w.writeChannels(chans)
+		b := &block{id: "CG", links: make([]int64, 6), data: make([]byte, 32)}
+		b.links[0] = next
+		b.links[1] = cn
+		b.links[2] = w.writeText(g.name)
+		b.links[3] = si
+		le.PutUint64(b.data[0:], uint64(g.recID))
+		le.PutUint64(b.data[8:], w.counts[g.recID])
+		le.PutUint16(b.data[16:], cgBusEvent|cgPlainBusEvent)
+		le.PutUint16(b.data[18:], '.')
+		last := chans[len(chans)-1]
+		le.PutUint32(b.data[24:], last.byteOff+last.bits/8)
+		next = w.writeBlock(b)
+	}
+	if next == 0 {
+		return 0
+	}
+	dg := &block{id: "DG", links: []int64{0, next, dt, 0}, data: make([]byte, 8)}
+	dg.data[0] = 1
+	return w.writeBlock(dg)
+}
+
+// Close writes the file's meta data, and updates the
+// file header. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if !w.begun {
+		w.begin(can.Now())
+	}
+	if w.err != nil {
+		return w.err
+	}
+	dtLen := w.pos - w.dtPos
+	w.write(make([]byte, pad8(int(dtLen))))
+	dt := w.dtPos
+	if dtLen == blockHeaderSize {
+		dt = 0
+	}
+	dg := w.writeMetaData(dt)
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.w.Flush(); err != nil {
+		return err
+	}
+
+	// update the data block length, and the link to the data group
+	var b [8]byte
+	le.PutUint64(b[:], uint64(dtLen))
+	if _, err := w.ws.Seek(w.dtPos+8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.ws.Write(b[:]); err != nil {
+		return err
+	}
+	le.PutUint64(b[:], uint64(dg))
+	if _, err := w.ws.Seek(w.hdPos+blockHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.ws.Write(b[:]); err != nil {
+		return err
+	}
+	_, err := w.ws.Seek(w.pos, io.SeekStart)
+	return err
+}
@@ -0,0 +1,184 @@
+// Package pcap reads and writes capture files in pcap and pcapng
+// format, using link type LINKTYPE_CAN_SOCKETCAN (227), so that
+// CAN traffic can be analyzed using tools like Wireshark.
+//
+// Each packet consists of a SocketCAN frame, with the identifier
+// stored in network byte order. Status messages are stored as
+// SocketCAN error frames. Timestamps are written with nanosecond
+// resolution.
+//
+// In pcapng files, an interface is defined for each channel;
+// interface n corresponds to channel n+1. The direction of
+// a frame is stored in the flags of enhanced packet blocks.
+// Since pcap files lack this information, frames read from
+// them are reported as received on channel 1.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog/internal/errframe"
+	"github.com/knieriem/can/canlog/internal/msgdata"
+)
+
+var (
+	ErrFormat   = errors.New("pcap: invalid file format")
+	ErrLinkType = errors.New("pcap: unsupported link type")
+	ErrChannel  = errors.New("pcap: channel number out of range")
+)
+
+// Format specifies the file format created by a Writer.
+type Format int
+
+const (
+	Pcap Format = iota
+	PcapNG
+)
+
+func (f Format) String() string {
+	if f == PcapNG {
+		return "pcapng"
+	}
+	return "pcap"
+}
+
+const linkTypeCANSocketCAN = 227
+
+// pcap file header
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	pcapHeaderSize  = 24
+	pcapRecordSize  = 16
+)
+
+// pcapng block types, and options
+const (
+	blockSHB = 0x0a0d0d0a
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+
+	byteOrderMagic = 0x1a2b3c4d
+
+	optEnd       = 0
+	optIfName    = 2
+	optIfTsResol = 9
+	optEPBFlags  = 2
+
+	epbInbound  = 0x1
+	epbOutbound = 0x2
+)
+
+// maxChannel limits the number of interfaces of a pcapng file.
+const maxChannel = 256
+
+const maxPacketSize = 1 << 18
+
+// SocketCAN frame layout
+const (
+	effFlag = 0x80000000
+	rtrFlag = 0x40000000
+	effMask = 0x1fffffff
+	sffMask = 0x7ff
+
+	fdBRS = 0x01
+	fdESI = 0x02
+	fdFDF = 0x04
+
+	frameHeaderSize = 8
+	canMTU          = 16
+	canFDMTU        = 72
+)
+
+var le = binary.LittleEndian
+
+// appendFrame appends the SocketCAN frame representation
+// of m to b. Frames are padded to the respective MTU,
+// as when captured from a SocketCAN interface.
+func appendFrame(b []byte, m *can.Msg) []byte {
+	var id uint32
+	var flags byte
+	data := m.Data()
+	mtu := canMTU
+	switch {
+	case m.IsStatus():
+		id, data = errframe.Encode(m)
+	default:
+		id = m.Id
+		if m.ExtFrame() {
+			id |= effFlag
+		}
+		_, needsFD, _ := can.VerifyDataLenFD(len(data))
+		if needsFD || m.Test(can.ForceFD) {
+			mtu = canFDMTU
+			flags = fdFDF
+			if m.Test(can.FDSwitchBitrate) {
+				flags |= fdBRS
+			}
+			if m.Test(can.FDErrorStateInd) {
+				flags |= fdESI
+			}
+		} else if m.Test(can.RTRMsg) {
+			id |= rtrFlag
+			data = nil
+		}
+	}
+	b = binary.BigEndian.AppendUint32(b, id)
+	b = append(b, byte(len(data)), flags, 0, 0)
+	b = append(b, data...)
+	return append(b, make([]byte, mtu-frameHeaderSize-len(data))...)
+}
+
+// decodeFrame decodes a SocketCAN frame into m. Frames of
+// canFDMTU bytes are considered CAN FD frames, even if
+// the FDF flag is not set, as in older captures.
+func decodeFrame(m *can.Msg, p []byte) error {
+	if len(p) < frameHeaderSize {
+		return errors.New("short frame")
+	}
+	id := binary.BigEndian.Uint32(p)
+	n := int(p[4])
+	flags := p[5]
+	data := p[frameHeaderSize:]
+	if n > len(data) {
+		return errors.New("truncated frame")
+	}
+	data = data[:n]
+
+	m.Reset()
+	if id&errframe.Flag != 0 {
+		errframe.Decode(m, id, data)
+		return nil
+	}
+	if id&effFlag != 0 {
+		m.Flags |= can.ExtFrame
+		m.Id = id & effMask
+	} else {
+		m.Id = id & sffMask
+	}
+	if flags&fdFDF != 0 || len(p) == canFDMTU {
+		if _, _, err := can.VerifyDataLenFD(n); err != nil {
+			return err
+		}
+		m.Flags |= can.ForceFD
+		if flags&fdBRS != 0 {
+			m.Flags |= can.FDSwitchBitrate
+		}
+		if flags&fdESI != 0 {
+			m.Flags |= can.FDErrorStateInd
+		}
+		msgdata.Set(m, data)
+		return nil
+	}
+	if n > 8 {
+		return can.ErrInvalidMsgLen
+	}
+	if id&rtrFlag != 0 {
+		m.Flags |= can.RTRMsg
+		return nil
+	}
+	msgdata.Set(m, data)
+	return nil
+}
@@ -0,0 +1,155 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+type record struct {
+	id    uint32
+	flags can.Flags
+	data  string
+	t     can.Time
+	ch    int
+	dir   canlog.Dir
+}
+
+func readAll(t *testing.T, r *Reader) []record {
+	t.Helper()
+	var recs []record
+	for {
+		var m can.Msg
+		info, err := r.ReadMsg(&m)
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, record{m.Id, m.Flags, string(m.Data()), m.Rx.Time, info.Channel, info.Dir})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const t0 = can.Time(1700000000123456)
+	in := []record{
+		{0x7FF, 0, "\x01\x02\x03", t0, 1, canlog.Rx},
+		{0x1FFFFFFF, can.ExtFrame | can.RTRMsg, "", t0 + 10, 2, canlog.Tx},
+		{0x10, can.ForceFD | can.FDErrorStateInd, "", t0 + 20, 1, canlog.Rx},
+		{0x10, can.ForceFD | can.FDSwitchBitrate, strings.Repeat("\x55", 64), t0 + 1e6, 3, canlog.Tx},
+		{0x44, can.StatusMsg | can.BusOff, "\x00\x30\x00\x00\x00\x00\x00\x00", t0 + 2e6, 1, canlog.Rx},
+		{0x208, can.StatusMsg, "\x00\x00\x00\x00\x00\x00\x60\x00", t0 + 3e6, 1, canlog.Rx},
+	}
+	for _, f := range []Format{Pcap, PcapNG} {
+		t.Run(f.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, f)
+			for _, rec := range in {
+				var m can.Msg
+				m.Id = rec.id
+				m.Flags = rec.flags
+				m.SetData([]byte(rec.data))
+				m.Rx.Time = rec.t
+				if err := w.WriteMsg(&m, canlog.Info{Channel: rec.ch, Dir: rec.dir}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Format != f {
+				t.Errorf("got format %v", r.Format)
+			}
+			got := readAll(t, r)
+			if len(got) != len(in) {
+				t.Fatalf("got %d messages, want %d", len(got), len(in))
+			}
+			for i, want := range in {
+				if f == Pcap {
+					want.ch = 1
+					want.dir = canlog.Rx
+				}
+				if got[i] != want {
+					t.Errorf("message %d: got %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteInvalidLen(t *testing.T) {
+	for _, f := range []Format{Pcap, PcapNG} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, f)
+		var m can.Msg
+		m.Id = 0x123
+		m.Flags = can.XLFrame
+		m.SetData(make([]byte, 100))
+		if err := w.WriteMsg(&m, canlog.Info{}); err != can.ErrInvalidMsgLen {
+			t.Errorf("%v: XL frame: got %v", f, err)
+		}
+		m.Flags = can.ForceFD
+		m.SetData(make([]byte, 65))
+		if err := w.WriteMsg(&m, canlog.Info{}); err != can.ErrInvalidMsgLen {
+			t.Errorf("%v: 65 bytes: got %v", f, err)
+		}
+		m.SetData(make([]byte, 9))
+		if err := w.WriteMsg(&m, canlog.Info{}); err != can.ErrInvalidMsgLen {
+			t.Errorf("%v: 9 bytes: got %v", f, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestReadPcap reads a big-endian pcap file with microsecond
+// timestamps, containing a classic frame padded to CAN_MTU, and
+// a CAN FD frame without FDF flag, padded to CANFD_MTU.
+func TestReadPcap(t *testing.T) {
+	h := "a1b2c3d4" + "00020004" + "00000000" + "00000000" + "00040000" + "000000e3" +
+		"5f5e1000" + "0001e240" + "00000010" + "00000010" +
+		"00000123" + "02000000" + "aabb000000000000" +
+		"5f5e1001" + "00000001" + "00000048" + "00000048" +
+		"80000456" + "0c010000" + "0102030405060708090a0b0c" + strings.Repeat("00", 52)
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readAll(t, r)
+	want := []record{
+		{0x123, 0, "\xaa\xbb", 1600000000123456, 1, canlog.Rx},
+		{0x456, can.ExtFrame | can.ForceFD | can.FDSwitchBitrate, "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c", 1600000001000001, 1, canlog.Rx},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d messages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLinkType(t *testing.T) {
+	b, _ := hex.DecodeString("d4c3b2a1" + "02000400" + "00000000" + "00000000" + "00000400" + "01000000")
+	if _, err := NewReader(bytes.NewReader(b)); !errors.Is(err, ErrLinkType) {
+		t.Errorf("got %v, want ErrLinkType", err)
+	}
+}
@@ -0,0 +1,300 @@
+package pcap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+// Reader reads messages from a pcap or pcapng file. Packets of
+// link types other than LINKTYPE_CAN_SOCKETCAN are skipped in
+// pcapng files; in pcap files, they result in ErrLinkType.
+type Reader struct {
+	r *bufio.Reader
+
+	// Format is the format of the file, as detected
+	// from the file header.
+	Format Format
+
+	bo binary.ByteOrder
+
+	// pcap
+	nanos bool
+
+	// pcapng
+	ifaces []iface
+
+	buf []byte
+}
+
+type iface struct {
+	linkType uint16
+	// timestamp resolution: units per second, or, if
+	// pow2 is set, the binary logarithm thereof
+	res  uint64
+	pow2 bool
+}
+
+// NewReader returns a Reader reading from r, after
+// reading the file header, and detecting the format.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{r: bufio.NewReader(r)}
+	var h [pcapHeaderSize]byte
+	if _, err := io.ReadFull(rd.r, h[:4]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if le.Uint32(h[:]) == blockSHB {
+		rd.Format = PcapNG
+		if err := rd.readSHB(); err != nil {
+			return nil, err
+		}
+		return rd, nil
+	}
+
+	switch {
+	case le.Uint32(h[:]) == pcapMagicMicros:
+		rd.bo = le
+	case le.Uint32(h[:]) == pcapMagicNanos:
+		rd.bo = le
+		rd.nanos = true
+	case binary.BigEndian.Uint32(h[:]) == pcapMagicMicros:
+		rd.bo = binary.BigEndian
+	case binary.BigEndian.Uint32(h[:]) == pcapMagicNanos:
+		rd.bo = binary.BigEndian
+		rd.nanos = true
+	default:
+		return nil, ErrFormat
+	}
+	if _, err := io.ReadFull(rd.r, h[4:]); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if lt := rd.bo.Uint32(h[20:]) & 0xffff; lt != linkTypeCANSocketCAN {
+		return nil, fmt.Errorf("%w: %d", ErrLinkType, lt)
+	}
+	return rd, nil
+}
+
+// ReadMsg reads the next message into m.
+// At the end of the file, io.EOF is returned.
+func (r *Reader) ReadMsg(m *can.Msg) (canlog.Info, error) {
+	if r.Format == Pcap {
+		return r.readRecord(m)
+	}
+	for {
+		typ, body, err := r.readBlock()
+		if err != nil {
+			return canlog.Info{}, err
+		}
+		switch typ {
+		case blockSHB:
+			r.ifaces = r.ifaces[:0]
+		case blockIDB:
+			if err := r.parseIDB(body); err != nil {
+				return canlog.Info{}, err
+			}
+		case blockEPB:
+			ok, info, err := r.parseEPB(body, m)
+			if err != nil || ok {
+				return info, err
+			}
+		}
+	}
+}
+
+func (r *Reader) readRecord(m *can.Msg) (canlog.Info, error) {
+	info := canlog.Info{Channel: 1}
+	var h [pcapRecordSize]byte
+	if _, err := io.ReadFull(r.r, h[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return info, err
+		}
+		return info, io.EOF
+	}
+	n := r.bo.Uint32(h[8:])
+	if n > maxPacketSize {
+		return info, fmt.Errorf("%w: packet size %d", ErrFormat, n)
+	}
+	p := r.alloc(int(n))
+	if _, err := io.ReadFull(r.r, p); err != nil {
+		return info, io.ErrUnexpectedEOF
+	}
+	if err := decodeFrame(m, p); err != nil {
+		return info, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	sub := int64(r.bo.Uint32(h[4:]))
+	if r.nanos {
+		sub /= 1000
+	}
+	m.Rx.Time = can.Time(int64(r.bo.Uint32(h[0:]))*1e6 + sub)
+	return info, nil
+}
+
+func (r *Reader) alloc(n int) []byte {
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	return r.buf[:n]
+}
+
+// readSHB reads the remaining part of a section header block,
+// after the block type has been read.
+func (r *Reader) readSHB() error {
+	var h [8]byte
+	if _, err := io.ReadFull(r.r, h[:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if err := r.setByteOrder(h[4:]); err != nil {
+		return err
+	}
+	_, err := r.readBody(r.bo.Uint32(h[:]), 4)
+	return err
+}
+
+func (r *Reader) setByteOrder(b []byte) error {
+	switch {
+	case le.Uint32(b) == byteOrderMagic:
+		r.bo = le
+	case binary.BigEndian.Uint32(b) == byteOrderMagic:
+		r.bo = binary.BigEndian
+	default:
+		return fmt.Errorf("%w: byte order magic", ErrFormat)
+	}
+	return nil
+}
+
+// readBlock reads the next block, and returns its type
+// and body, excluding the block type and lengths.
+func (r *Reader) readBlock() (typ uint32, body []byte, err error) {
+	var h [12]byte
+	if _, err := io.ReadFull(r.r, h[:8]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, err
+		}
+		return 0, nil, io.EOF
+	}
+	typ = r.bo.Uint32(h[0:])
+	if typ == blockSHB {
+		// a new section, possibly using a different byte order
+		if _, err := io.ReadFull(r.r, h[8:]); err != nil {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		if err := r.setByteOrder(h[8:]); err != nil {
+			return 0, nil, err
+		}
+		body, err = r.readBody(r.bo.Uint32(h[4:]), 4)
+		return typ, body, err
+	}
+	body, err = r.readBody(r.bo.Uint32(h[4:]), 0)
+	return typ, body, err
+}
+
+// readBody reads the body of a block of the specified total length,
+// after block type, length, and skip more bytes have been read.
+func (r *Reader) readBody(size uint32, skip int) ([]byte, error) {
+	if size < uint32(12+skip) || size%4 != 0 || size > maxPacketSize {
+		return nil, fmt.Errorf("%w: block size %d", ErrFormat, size)
+	}
+	b := r.alloc(int(size) - 8 - skip)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if r.bo.Uint32(b[len(b)-4:]) != size {
+		return nil, fmt.Errorf("%w: block length mismatch", ErrFormat)
+	}
+	return b[:len(b)-4], nil
+}
+
+func (r *Reader) parseIDB(b []byte) error {
+	if len(b) < 8 {
+		return fmt.Errorf("%w: short interface description", ErrFormat)
+	}
+	ifc := iface{linkType: r.bo.Uint16(b[0:]), res: 1e6}
+	err := r.parseOptions(b[8:], func(code uint16, v []byte) {
+		if code == optIfTsResol && len(v) > 0 {
+			if v[0]&0x80 != 0 {
+				ifc.pow2 = true
+				ifc.res = uint64(v[0] & 0x7f)
+			} else if v[0] <= 19 {
+				ifc.res = uint64(math.Pow10(int(v[0])))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	r.ifaces = append(r.ifaces, ifc)
+	return nil
+}
+
+func (r *Reader) parseEPB(b []byte, m *can.Msg) (ok bool, info canlog.Info, err error) {
+	if len(b) < 20 {
+		return false, info, fmt.Errorf("%w: short packet block", ErrFormat)
+	}
+	id := r.bo.Uint32(b[0:])
+	if int(id) >= len(r.ifaces) {
+		return false, info, fmt.Errorf("%w: undefined interface %d", ErrFormat, id)
+	}
+	ifc := &r.ifaces[id]
+	if ifc.linkType != linkTypeCANSocketCAN {
+		return false, info, nil
+	}
+	info.Channel = int(id) + 1
+	ts := uint64(r.bo.Uint32(b[4:]))<<32 | uint64(r.bo.Uint32(b[8:]))
+	n := int(r.bo.Uint32(b[12:]))
+	b = b[20:]
+	if n > len(b) {
+		return false, info, fmt.Errorf("%w: packet exceeds block", ErrFormat)
+	}
+	if err := decodeFrame(m, b[:n]); err != nil {
+		return false, info, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	m.Rx.Time = ifc.time(ts)
+
+	n += pad4(n)
+	if n > len(b) {
+		n = len(b)
+	}
+	err = r.parseOptions(b[n:], func(code uint16, v []byte) {
+		if code == optEPBFlags && len(v) >= 4 && r.bo.Uint32(v)&3 == epbOutbound {
+			info.Dir = canlog.Tx
+		}
+	})
+	return err == nil, info, err
+}
+
+// time converts a timestamp into a can.Time.
+func (ifc *iface) time(ts uint64) can.Time {
+	if ifc.pow2 {
+		sec := ts >> ifc.res
+		frac := ts & (1<<ifc.res - 1)
+		return can.Time(sec*1e6 + frac*1e6>>ifc.res)
+	}
+	sec := ts / ifc.res
+	frac := ts % ifc.res
+	if ifc.res >= 1e6 {
+		return can.Time(sec*1e6 + frac/(ifc.res/1e6))
+	}
+	return can.Time(sec*1e6 + frac*(1e6/ifc.res))
+}
+
+func (r *Reader) parseOptions(b []byte, f func(code uint16, v []byte)) error {
+	for len(b) >= 4 {
+		code := r.bo.Uint16(b[0:])
+		n := int(r.bo.Uint16(b[2:]))
+		if code == optEnd {
+			break
+		}
+		b = b[4:]
+		if n > len(b) {
+			return fmt.Errorf("%w: option length", ErrFormat)
+		}
+		f(code, b[:n])
+		b = b[min(n+pad4(n), len(b)):]
+	}
+	return nil
+}
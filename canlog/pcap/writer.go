@@ -0,0 +1,159 @@
+package pcap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+// Writer writes messages to a pcap or pcapng file.
+type Writer struct {
+	w      *bufio.Writer
+	format Format
+	begun  bool
+	nIface int
+	buf    []byte
+	err    error
+}
+
+// NewWriter returns a Writer writing to w using the specified
+// format. Close must be called after the last message has
+// been written.
+func NewWriter(w io.Writer, f Format) *Writer {
+	return &Writer{w: bufio.NewWriter(w), format: f}
+}
+
+func (w *Writer) begin() {
+	w.begun = true
+	if w.format == Pcap {
+		b := make([]byte, pcapHeaderSize)
+		le.PutUint32(b[0:], pcapMagicNanos)
+		le.PutUint16(b[4:], 2)
+		le.PutUint16(b[6:], 4)
+		le.PutUint32(b[16:], canFDMTU)
+		le.PutUint32(b[20:], linkTypeCANSocketCAN)
+		w.write(b)
+		return
+	}
+	b := make([]byte, 16, 28)
+	le.PutUint32(b[0:], byteOrderMagic)
+	le.PutUint16(b[4:], 1)
+	le.PutUint16(b[6:], 0)
+	le.PutUint64(b[8:], 0xffffffffffffffff) // section length unknown
+	w.writeBlock(blockSHB, b)
+}
+
+// addInterfaces writes interface description blocks,
+// until an interface exists for channel ch.
+func (w *Writer) addInterfaces(ch int) {
+	for w.nIface < ch {
+		b := make([]byte, 8, 32)
+		le.PutUint16(b[0:], linkTypeCANSocketCAN)
+		le.PutUint32(b[4:], canFDMTU)
+		b = appendOption(b, optIfName, fmt.Appendf(nil, "can%d", w.nIface))
+		b = appendOption(b, optIfTsResol, []byte{9})
+		b = appendOption(b, optEnd, nil)
+		w.writeBlock(blockIDB, b)
+		w.nIface++
+	}
+}
+
+// WriteMsg writes message m, using the time of reception
+// as timestamp. In pcap files, the channel and direction
+// are not stored.
+func (w *Writer) WriteMsg(m *can.Msg, info canlog.Info) error {
+	if w.err != nil {
+		return w.err
+	}
+	if !m.IsStatus() {
+		// CAN XL frames would require LINKTYPE_CAN_SOCKETCAN
+		// with a larger MTU, which is not supported.
+		if m.XLFrame() {
+			return can.ErrInvalidMsgLen
+		}
+		if _, _, err := can.VerifyDataLenFD(len(m.Data())); err != nil {
+			return err
+		}
+	}
+	ch := max(info.Channel, 1)
+	if w.format == PcapNG && ch > maxChannel {
+		return fmt.Errorf("%w: %d", ErrChannel, ch)
+	}
+	if !w.begun {
+		w.begin()
+	}
+	ns := uint64(m.Rx.Time) * 1000
+	if w.format == Pcap {
+		b := make([]byte, pcapRecordSize, pcapRecordSize+canFDMTU)
+		b = appendFrame(b, m)
+		le.PutUint32(b[0:], uint32(ns/1e9))
+		le.PutUint32(b[4:], uint32(ns%1e9))
+		le.PutUint32(b[8:], uint32(len(b)-pcapRecordSize))
+		le.PutUint32(b[12:], uint32(len(b)-pcapRecordSize))
+		w.write(b)
+		return w.err
+	}
+
+	w.addInterfaces(ch)
+	b := make([]byte, 20, 20+canFDMTU+16)
+	b = appendFrame(b, m)
+	n := len(b) - 20
+	le.PutUint32(b[0:], uint32(ch-1))
+	le.PutUint32(b[4:], uint32(ns>>32))
+	le.PutUint32(b[8:], uint32(ns))
+	le.PutUint32(b[12:], uint32(n))
+	le.PutUint32(b[16:], uint32(n))
+	flags := uint32(epbInbound)
+	if info.Dir == canlog.Tx {
+		flags = epbOutbound
+	}
+	b = appendOption(b, optEPBFlags, le.AppendUint32(nil, flags))
+	b = appendOption(b, optEnd, nil)
+	w.writeBlock(blockEPB, b)
+	return w.err
+}
+
+// appendOption appends a pcapng option, padded to 32 bits.
+func appendOption(b []byte, code uint16, v []byte) []byte {
+	b = le.AppendUint16(b, code)
+	b = le.AppendUint16(b, uint16(len(v)))
+	b = append(b, v...)
+	return append(b, make([]byte, pad4(len(v)))...)
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// writeBlock writes a pcapng block with body b, which
+// must be padded to a multiple of four bytes already.
+func (w *Writer) writeBlock(typ uint32, b []byte) {
+	var h [8]byte
+	size := uint32(len(h) + len(b) + 4)
+	le.PutUint32(h[0:], typ)
+	le.PutUint32(h[4:], size)
+	w.write(h[:])
+	w.write(b)
+	w.write(le.AppendUint32(h[:0], size))
+}
+
+func (w *Writer) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+// Close writes the file header, if no message has been written,
+// and flushes buffered data. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if !w.begun {
+		w.begin()
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return w.w.Flush()
+}
@@ -0,0 +1,601 @@
+// Package trc reads and writes PCAN trace files (.trc),
+// as created by PCAN-View and PCAN-Explorer.
+//
+// File format versions 1.1, 2.0, and 2.1 are supported. CAN FD frames
+// require version 2.0 or later; the bus number is available in version
+// 2.1 only. Status messages are mapped to PCAN status codes.
+package trc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+	"github.com/knieriem/can/canlog/internal/dlc"
+)
+
+var (
+	ErrSyntax      = errors.New("trc: syntax error")
+	ErrVersion     = errors.New("trc: unsupported file version")
+	ErrUnsupported = errors.New("trc: message not supported by file version")
+)
+
+// Version is a TRC file format version.
+type Version int
+
+const (
+	Version11 Version = 11
+	Version20 Version = 20
+	Version21 Version = 21
+)
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v/10, v%10)
+}
+
+// PCAN status codes, as written into status messages
+const (
+	statusOverrun    = 0x00002
+	statusBusLight   = 0x00004
+	statusBusHeavy   = 0x00008
+	statusBusOff     = 0x00010
+	statusQOverrun   = 0x00040
+	statusBusPassive = 0x40000
+)
+
+const columns21 = "N,O,T,B,I,d,R,L,D"
+
+// oleEpoch is the origin of the $STARTTIME value, which is
+// specified in days of local time, as an OLE automation date.
+var oleEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func oleDate(t time.Time) float64 {
+	t = t.Local()
+	wall := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	return wall.Sub(oleEpoch).Hours() / 24
+}
+
+func fromOLEDate(days float64) time.Time {
+	us := math.Round(days * 24 * 3600 * 1e6)
+	t := oleEpoch.Add(time.Duration(us) * time.Microsecond)
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local)
+}
+
+func statusCode(f can.Flags) uint32 {
+	var st uint32
+	if f.Test(can.DataOverrun) {
+		st |= statusOverrun
+	}
+	if f.Test(can.ReceiveBufferOverflow) {
+		st |= statusQOverrun
+	}
+	if f.Test(can.ErrorWarning) {
+		st |= statusBusHeavy
+	}
+	if f.Test(can.ErrorPassive) {
+		st |= statusBusPassive
+	}
+	if f.Test(can.BusOff) {
+		st |= statusBusOff
+	}
+	return st
+}
+
+func statusFlags(st uint32) can.Flags {
+	f := can.StatusMsg
+	if st&statusOverrun != 0 {
+		f |= can.DataOverrun
+	}
+	if st&statusQOverrun != 0 {
+		f |= can.ReceiveBufferOverflow
+	}
+	if st&(statusBusLight|statusBusHeavy) != 0 {
+		f |= can.ErrorWarning
+	}
+	if st&statusBusPassive != 0 {
+		f |= can.ErrorPassive
+	}
+	if st&statusBusOff != 0 {
+		f |= can.BusOff
+	}
+	if f == can.StatusMsg {
+		f |= can.ErrorActive
+	}
+	return f
+}
+
+// Writer writes messages to a TRC file. The start time
+// is set to the time of the first message.
+type Writer struct {
+	w       *bufio.Writer
+	version Version
+	start   can.Time
+	begun   bool
+	n       int
+}
+
+// NewWriter returns a Writer writing to w using the specified
+// file version. Close must be called after the last message
+// has been written.
+func NewWriter(w io.Writer, v Version) (*Writer, error) {
+	switch v {
+	case Version11, Version20, Version21:
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrVersion, v)
+	}
+	return &Writer{w: bufio.NewWriter(w), version: v}, nil
+}
+
+func (w *Writer) begin(t can.Time) {
+	w.start = t - t%1000
+	st := w.start.Time()
+	p := func(format string, args ...any) {
+		fmt.Fprintf(w.w, format+"\n", args...)
+	}
+	p(";$FILEVERSION=%v", w.version)
+	p(";$STARTTIME=%s", strconv.FormatFloat(oleDate(st), 'f', -1, 64))
+	if w.version == Version21 {
+		p(";$COLUMNS=%s", columns21)
+	}
+	p(";")
+	p(";   Start time: %s.0", st.Format("1/2/2006 15:04:05.000"))
+	p(";   Generated by github.com/knieriem/can")
+	p(";")
+	switch w.version {
+	case Version11:
+		p(";   Message Number")
+		p(";   |         Time Offset (ms)")
+		p(";   |         |        Type")
+		p(";   |         |        |        ID (hex)")
+		p(";   |         |        |        |     Data Length")
+		p(";   |         |        |        |     |   Data Bytes (hex) ...")
+		p(";   |         |        |        |     |   |")
+		p(";---+--   ----+----  --+--  ----+---  +  -+ -- -- -- -- -- -- --")
+	case Version20:
+		p(";   Message   Time    Type ID     Rx/Tx")
+		p(";   Number    Offset  |    [hex]  |  Data Length")
+		p(";   |         [ms]    |    |      |  |  Data [hex] ...")
+		p(";   |         |       |    |      |  |  |")
+		p(";---+-- ------+------ +- --+----- +- +- +- -- -- -- -- -- -- --")
+	case Version21:
+		p(";   Message   Time    Type    ID     Rx/Tx")
+		p(";   Number    Offset  |  Bus  [hex]  |  Reserved")
+		p(";   |         [ms]    |  |    |      |  |  Data Length Code")
+		p(";   |         |       |  |    |      |  |  |    Data [hex] ...")
+		p(";   |         |       |  |    |      |  |  |    |")
+		p(";---+-- ------+------ +- +- --+----- +- +- +--- +- -- -- -- -- -- -- --")
+	}
+	w.begun = true
+}
+
+// WriteMsg writes message m. Messages should be written in the order
+// of their timestamps. Using version 1.1, CAN FD frames cannot be
+// written. Since the channel can be stored in version 2.1 files only,
+// it is ignored for other versions.
+func (w *Writer) WriteMsg(m *can.Msg, info canlog.Info) error {
+	if m.XLFrame() {
+		return canlog.ErrXLNotSupported
+	}
+	data := m.Data()
+	_, needsFD, _ := can.VerifyDataLenFD(len(data))
+	fd := needsFD || m.Test(can.ForceFD)
+	if fd && w.version == Version11 {
+		return fmt.Errorf("%w: CAN FD frame", ErrUnsupported)
+	}
+	if !w.begun {
+		w.begin(m.Rx.Time)
+	}
+	w.n++
+	ms := float64(m.Rx.Time-w.start) / 1e3
+
+	id := fmt.Sprintf("%04X", m.Id)
+	if m.ExtFrame() {
+		id = fmt.Sprintf("%08X", m.Id)
+	}
+	var err error
+	if w.version == Version11 {
+		typ := info.Dir.String()
+		switch {
+		case m.IsStatus():
+			st := statusCode(m.Flags)
+			_, err = fmt.Fprintf(w.w, "%6d)%11.1f  %-5s  %8s  4 %s\n", w.n, ms, "Warng", "FFFFFFFF",
+				hexBytes([]byte{byte(st >> 24), byte(st >> 16), byte(st >> 8), byte(st)}))
+		case m.Test(can.RTRMsg):
+			_, err = fmt.Fprintf(w.w, "%6d)%11.1f  %-5s  %8s  %d  RTR\n", w.n, ms, typ, id, len(data))
+		default:
+			_, err = fmt.Fprintf(w.w, "%6d)%11.1f  %-5s  %8s  %d %s\n", w.n, ms, typ, id, len(data), hexBytes(data))
+		}
+		return err
+	}
+
+	bus := ""
+	if w.version == Version21 {
+		bus = fmt.Sprintf(" %-2d", max(info.Channel, 1))
+	}
+	if m.IsStatus() {
+		st := statusCode(m.Flags)
+		_, err = fmt.Fprintf(w.w, "%7d %13.3f ST%s %8s %s %s\n", w.n, ms, bus, "", info.Dir,
+			hexBytes([]byte{byte(st >> 24), byte(st >> 16), byte(st >> 8), byte(st)}))
+		return err
+	}
+	typ := "DT"
+	switch {
+	case m.Test(can.RTRMsg):
+		typ = "RR"
+	case fd && m.Test(can.FDSwitchBitrate|can.FDErrorStateInd):
+		typ = "BI"
+	case fd && m.Test(can.FDSwitchBitrate):
+		typ = "FB"
+	case fd && m.Test(can.FDErrorStateInd):
+		typ = "FE"
+	case fd:
+		typ = "FD"
+	}
+	if typ == "RR" {
+		data = nil
+	}
+	if w.version == Version20 {
+		_, err = fmt.Fprintf(w.w, "%7d %13.3f %s %8s %s %d %s\n", w.n, ms, typ, id, info.Dir, len(data), hexBytes(data))
+	} else {
+		_, err = fmt.Fprintf(w.w, "%7d %13.3f %s%s %8s %s - %-4d %s\n", w.n, ms, typ, bus, id, info.Dir, dlc.FromLen(len(data)), hexBytes(data))
+	}
+	return err
+}
+
+// Close flushes buffered data.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if !w.begun {
+		w.begin(can.Now())
+	}
+	return w.w.Flush()
+}
+
+func hexBytes(data []byte) string {
+	var b strings.Builder
+	for i, c := range data {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%02X", c)
+	}
+	return b.String()
+}
+
+// Reader reads messages from a TRC file.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+
+	// Version is the file format version, as specified
+	// in the file header. Files without version information
+	// are assumed to be version 1.1 files.
+	Version Version
+
+	// Start is the start time of the measurement, as specified
+	// in the file header, or the zero time, if it is unknown.
+	Start time.Time
+	start can.Time
+
+	columns []string
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r), Version: Version11}
+}
+
+// ReadMsg reads the next message into m.
+// At the end of the file, io.EOF is returned.
+func (r *Reader) ReadMsg(m *can.Msg) (canlog.Info, error) {
+	for r.s.Scan() {
+		r.line++
+		l := strings.TrimSpace(r.s.Text())
+		if l == "" {
+			continue
+		}
+		if l[0] == ';' {
+			if err := r.parseHeader(l[1:]); err != nil {
+				return canlog.Info{}, fmt.Errorf("%w: line %d: %v", ErrSyntax, r.line, err)
+			}
+			continue
+		}
+		var ok bool
+		var info canlog.Info
+		var err error
+		if r.Version == Version11 {
+			ok, info, err = r.parse11(strings.Fields(l), m)
+		} else {
+			ok, info, err = r.parse2x(strings.Fields(l), m)
+		}
+		if err != nil {
+			return info, fmt.Errorf("%w: line %d: %v", ErrSyntax, r.line, err)
+		}
+		if ok {
+			return info, nil
+		}
+	}
+	if err := r.s.Err(); err != nil {
+		return canlog.Info{}, err
+	}
+	return canlog.Info{}, io.EOF
+}
+
+func (r *Reader) parseHeader(l string) error {
+	key, value, ok := strings.Cut(l, "=")
+	if !ok {
+		return nil
+	}
+	switch strings.TrimSpace(key) {
+	case "$FILEVERSION":
+		switch strings.TrimSpace(value) {
+		case "1.1":
+			r.Version = Version11
+		case "2.0":
+			r.Version = Version20
+			r.columns = strings.Split("N,O,T,I,d,l,D", ",")
+		case "2.1":
+			r.Version = Version21
+			if r.columns == nil {
+				r.columns = strings.Split(columns21, ",")
+			}
+		default:
+			return fmt.Errorf("%w: %s", ErrVersion, value)
+		}
+	case "$STARTTIME":
+		days, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return err
+		}
+		r.Start = fromOLEDate(days)
+		r.start = can.Time(r.Start.UnixMicro())
+	case "$COLUMNS":
+		r.columns = strings.Split(strings.TrimSpace(value), ",")
+		if len(r.columns) == 0 || r.columns[len(r.columns)-1] != "D" {
+			return errors.New("data column must be the last column")
+		}
+	}
+	return nil
+}
+
+func (r *Reader) setTime(m *can.Msg, s string) error {
+	ms, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	m.Rx.Time = r.start + can.Time(math.Round(ms*1e3))
+	return nil
+}
+
+// parse11 parses a message line of a version 1.1 file:
+//
+//	<number>) <offset> <type> <id> <len> <data>...
+func (r *Reader) parse11(f []string, m *can.Msg) (ok bool, info canlog.Info, err error) {
+	info.Channel = 1
+	if len(f) < 4 || !strings.HasSuffix(f[0], ")") {
+		return false, info, errors.New("incomplete message")
+	}
+	m.Reset()
+	if err := r.setTime(m, f[1]); err != nil {
+		return false, info, err
+	}
+	switch f[2] {
+	case "Rx":
+	case "Tx":
+		info.Dir = canlog.Tx
+	case "Warng":
+		if len(f) < 9 {
+			return false, info, errors.New("incomplete status message")
+		}
+		st, err := parseStatus(f[5:9])
+		if err != nil {
+			return false, info, err
+		}
+		m.Flags = statusFlags(st)
+		return true, info, nil
+	case "Error":
+		m.Flags = can.StatusMsg
+		return true, info, nil
+	default:
+		return false, info, nil
+	}
+	if len(f) < 5 {
+		return false, info, errors.New("incomplete message")
+	}
+	if err := parseID(m, f[3]); err != nil {
+		return false, info, err
+	}
+	n, err := strconv.Atoi(f[4])
+	if err != nil || n < 0 || n > 8 {
+		return false, info, fmt.Errorf("invalid data length %q", f[4])
+	}
+	if len(f) > 5 && f[5] == "RTR" {
+		m.Flags |= can.RTRMsg
+		return true, info, nil
+	}
+	return true, info, setData(m, f[5:], n)
+}
+
+// parse2x parses a message line of a version 2.0 or 2.1 file,
+// according to the column definitions.
+func (r *Reader) parse2x(f []string, m *can.Msg) (ok bool, info canlog.Info, err error) {
+	info.Channel = 1
+	m.Reset()
+	col := make(map[string]string, len(r.columns))
+	var data []string
+	for i, c := range r.columns {
+		if i >= len(f) {
+			break
+		}
+		if c == "D" {
+			data = f[i:]
+			break
+		}
+		col[c] = f[i]
+		if c == "T" && isEvent(f[i]) {
+			// events do not follow the column layout,
+			// except for the bus number
+			data = f[i+1:]
+			if i+1 < len(r.columns) && r.columns[i+1] == "B" && len(data) > 0 {
+				col["B"] = data[0]
+				data = data[1:]
+			}
+			break
+		}
+	}
+	if err := r.setTime(m, col["O"]); err != nil {
+		return false, info, err
+	}
+	if b := col["B"]; b != "" && b != "-" {
+		if info.Channel, err = strconv.Atoi(b); err != nil {
+			return false, info, err
+		}
+	}
+
+	typ := col["T"]
+	if isEvent(typ) {
+		return parseEvent(typ, data, m, info)
+	}
+	switch col["d"] {
+	case "Rx":
+	case "Tx":
+		info.Dir = canlog.Tx
+	default:
+		return false, info, fmt.Errorf("invalid direction %q", col["d"])
+	}
+	fd := true
+	switch typ {
+	case "DT":
+		fd = false
+	case "RR":
+		fd = false
+		m.Flags |= can.RTRMsg
+	case "FD":
+	case "FB":
+		m.Flags |= can.FDSwitchBitrate
+	case "FE":
+		m.Flags |= can.FDErrorStateInd
+	case "BI":
+		m.Flags |= can.FDSwitchBitrate | can.FDErrorStateInd
+	default:
+		return false, info, fmt.Errorf("unknown message type %q", typ)
+	}
+	if fd {
+		m.Flags |= can.ForceFD
+	}
+	if err := parseID(m, col["I"]); err != nil {
+		return false, info, err
+	}
+	if m.Test(can.RTRMsg) {
+		return true, info, nil
+	}
+
+	var n int
+	if s, ok := col["l"]; ok {
+		n, err = strconv.Atoi(s)
+	} else if s, ok := col["L"]; ok {
+		var code uint64
+		code, err = strconv.ParseUint(s, 10, 4)
+		n = dlc.Len(byte(code), fd)
+	} else {
+		n = len(data)
+	}
+	if err != nil {
+		return false, info, err
+	}
+	if _, _, err := can.VerifyDataLenFD(n); err != nil || !fd && n > 8 {
+		return false, info, fmt.Errorf("invalid data length %d", n)
+	}
+	return true, info, setData(m, data, n)
+}
+
+// isEvent reports whether typ denotes a line
+// that does not contain a CAN frame.
+func isEvent(typ string) bool {
+	switch typ {
+	case "ST", "EC", "ER", "EV":
+		return true
+	}
+	return false
+}
+
+// parseEvent converts status lines (ST), and error frames (ER)
+// into status messages; other events are skipped. The fields
+// following the type and bus are expected to contain a direction,
+// and, for status lines, the status code as the last four bytes.
+func parseEvent(typ string, f []string, m *can.Msg, info canlog.Info) (ok bool, _ canlog.Info, err error) {
+	for _, s := range f {
+		if s == "Tx" {
+			info.Dir = canlog.Tx
+			break
+		}
+		if s == "Rx" {
+			break
+		}
+	}
+	switch typ {
+	case "ST":
+		if len(f) < 4 {
+			return false, info, errors.New("incomplete status message")
+		}
+		st, err := parseStatus(f[len(f)-4:])
+		if err != nil {
+			return false, info, err
+		}
+		m.Flags = statusFlags(st)
+		return true, info, nil
+	case "ER":
+		m.Flags = can.StatusMsg
+		return true, info, nil
+	}
+	return false, info, nil
+}
+
+func parseID(m *can.Msg, s string) error {
+	id, err := strconv.ParseUint(s, 16, 29)
+	if err != nil {
+		return err
+	}
+	m.Id = uint32(id)
+	if len(s) > 4 {
+		m.Flags |= can.ExtFrame
+	} else if id > 0x7FF {
+		return fmt.Errorf("invalid identifier %q", s)
+	}
+	return nil
+}
+
+func parseStatus(f []string) (uint32, error) {
+	var st uint32
+	for _, s := range f {
+		v, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return 0, err
+		}
+		st = st<<8 | uint32(v)
+	}
+	return st, nil
+}
+
+func setData(m *can.Msg, f []string, n int) error {
+	if len(f) < n {
+		return errors.New("missing data bytes")
+	}
+	data := make([]byte, n)
+	for i := range data {
+		v, err := strconv.ParseUint(f[i], 16, 8)
+		if err != nil {
+			return err
+		}
+		data[i] = byte(v)
+	}
+	m.SetData(data)
+	return nil
+}
@@ -0,0 +1,205 @@
+package trc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/canlog"
+)
+
+const testTRC11 = `;$FILEVERSION=1.1
+;$STARTTIME=45301.3987847222
+;
+;   Start time: 1/10/2024 9:34:14.000.0
+;---+--   ----+----  --+--  ----+---  +  -+ -- -- -- -- -- -- --
+     1)      1059.9  Rx         0300  8  00 00 00 00 04 00 00 00
+     2)      1283.2  Tx     18EFC0D0  2  0A 0B
+     3)      1500.0  Rx         0100  4  RTR
+     4)      1600.0  Warng  FFFFFFFF  4  00 00 00 08  BUSHEAVY
+`
+
+const testTRC20 = `;$FILEVERSION=2.0
+;$STARTTIME=45301.3987847222
+;---+-- ------+------ +- --+----- +- +- +- -- -- -- -- -- -- --
+      1      1059.900 DT     0300 Rx 8  00 00 00 00 04 00 00 00
+      2      1283.231 FB 18EFC0D0 Tx 12 01 02 03 04 05 06 07 08 09 0A 0B 0C
+      3      1300.000 RR     0100 Rx 4
+      4      1400.000 ST          Rx    00 00 00 10
+      5      1500.000 EV          User-defined text
+`
+
+const testTRC21 = `;$FILEVERSION=2.1
+;$STARTTIME=45301.3987847222
+;$COLUMNS=N,O,T,B,I,d,R,L,D
+;---+-- ------+------ +- +- --+----- +- +- +--- +- -- -- -- -- -- -- --
+      1      1059.900 DT 1      0300 Rx -  8    00 00 00 00 04 00 00 00
+      2      1283.231 BI 2  18EFC0D0 Tx -  9    01 02 03 04 05 06 07 08 09 0A 0B 0C
+      3      1300.000 FD 1      0123 Rx -  2    AA BB
+      4      1400.000 ST 3          Rx    00 04 00 00
+`
+
+type record struct {
+	id    uint32
+	flags can.Flags
+	data  string
+	t     can.Time
+	ch    int
+	dir   canlog.Dir
+}
+
+func readAll(t *testing.T, r *Reader) []record {
+	t.Helper()
+	var recs []record
+	for {
+		var m can.Msg
+		info, err := r.ReadMsg(&m)
+		if err == io.EOF {
+			return recs
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, record{m.Id, m.Flags, string(m.Data()), m.Rx.Time - can.Time(r.Start.UnixMicro()), info.Channel, info.Dir})
+	}
+}
+
+func TestRead(t *testing.T) {
+	data12 := "\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c"
+	tests := []struct {
+		name    string
+		trc     string
+		version Version
+		want    []record
+	}{
+		{"1.1", testTRC11, Version11, []record{
+			{0x300, 0, "\x00\x00\x00\x00\x04\x00\x00\x00", 1059900, 1, canlog.Rx},
+			{0x18EFC0D0, can.ExtFrame, "\x0a\x0b", 1283200, 1, canlog.Tx},
+			{0x100, can.RTRMsg, "", 1500000, 1, canlog.Rx},
+			{0, can.StatusMsg | can.ErrorWarning, "", 1600000, 1, canlog.Rx},
+		}},
+		{"2.0", testTRC20, Version20, []record{
+			{0x300, 0, "\x00\x00\x00\x00\x04\x00\x00\x00", 1059900, 1, canlog.Rx},
+			{0x18EFC0D0, can.ExtFrame | can.ForceFD | can.FDSwitchBitrate, data12, 1283231, 1, canlog.Tx},
+			{0x100, can.RTRMsg, "", 1300000, 1, canlog.Rx},
+			{0, can.StatusMsg | can.BusOff, "", 1400000, 1, canlog.Rx},
+		}},
+		{"2.1", testTRC21, Version21, []record{
+			{0x300, 0, "\x00\x00\x00\x00\x04\x00\x00\x00", 1059900, 1, canlog.Rx},
+			{0x18EFC0D0, can.ExtFrame | can.ForceFD | can.FDSwitchBitrate | can.FDErrorStateInd, data12, 1283231, 2, canlog.Tx},
+			{0x123, can.ForceFD, "\xaa\xbb", 1300000, 1, canlog.Rx},
+			{0, can.StatusMsg | can.ErrorPassive, "", 1400000, 3, canlog.Rx},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReader(strings.NewReader(tt.trc))
+			got := readAll(t, r)
+			if r.Version != tt.version {
+				t.Errorf("got version %v, want %v", r.Version, tt.version)
+			}
+			if r.Start.Year() != 2024 || r.Start.Hour() != 9 || r.Start.Minute() != 34 {
+				t.Errorf("got start time %v", r.Start)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d", len(got), len(tt.want))
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("message %d: got %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	const t0 = can.Time(1700000000123400)
+	classic := []record{
+		{0x7FF, 0, "\x01\x02\x03", t0, 1, canlog.Rx},
+		{0x1FFFFFFF, can.ExtFrame | can.RTRMsg, "", t0 + 100, 1, canlog.Tx},
+		{0, can.StatusMsg | can.BusOff, "", t0 + 2e6, 1, canlog.Rx},
+	}
+	fd := []record{
+		{0x10, can.ForceFD | can.FDErrorStateInd, "", t0 + 20, 2, canlog.Rx},
+		{0x10, can.ForceFD | can.FDSwitchBitrate, strings.Repeat("\x55", 64), t0 + 1e6 + 1, 1, canlog.Tx},
+		{0x11, can.ExtFrame | can.ForceFD, strings.Repeat("\xaa", 20), t0 + 1e6 + 2, 1, canlog.Tx},
+	}
+	tests := []struct {
+		version Version
+		in      []record
+	}{
+		{Version11, classic},
+		{Version20, append(classic, fd...)},
+		{Version21, append(classic, fd...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version.String(), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rec := range tt.in {
+				var m can.Msg
+				m.Id = rec.id
+				m.Flags = rec.flags
+				m.SetData([]byte(rec.data))
+				m.Rx.Time = rec.t
+				if err := w.WriteMsg(&m, canlog.Info{Channel: rec.ch, Dir: rec.dir}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := NewReader(&buf)
+			for i, rec := range tt.in {
+				var m can.Msg
+				info, err := r.ReadMsg(&m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.version != Version21 {
+					rec.ch = 1
+				}
+				got := record{m.Id, m.Flags, string(m.Data()), m.Rx.Time, info.Channel, info.Dir}
+				if got != rec {
+					t.Errorf("message %d: got %+v, want %+v", i, got, rec)
+				}
+			}
+			if _, err := r.ReadMsg(new(can.Msg)); err != io.EOF {
+				t.Errorf("got %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestWriteFD11(t *testing.T) {
+	w, _ := NewWriter(io.Discard, Version11)
+	var m can.Msg
+	m.Flags = can.ForceFD
+	if err := w.WriteMsg(&m, canlog.Info{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestWriteXL(t *testing.T) {
+	for _, v := range []Version{Version11, Version20, Version21} {
+		w, err := NewWriter(io.Discard, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m can.Msg
+		m.Id = 0x123
+		m.Flags = can.XLFrame
+		m.SetData(make([]byte, 100))
+		if err := w.WriteMsg(&m, canlog.Info{}); !errors.Is(err, canlog.ErrXLNotSupported) {
+			t.Errorf("%v: got %v, want ErrXLNotSupported", v, err)
+		}
+	}
+}
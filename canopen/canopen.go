@@ -0,0 +1,182 @@
+// Package canopen implements CANopen (CiA 301) master functionality
+// on top of a can.Device.
+//
+// A [Master] sends NMT commands, transfers data using the SDO
+// protocol (expedited, segmented and block transfers), monitors
+// nodes using heartbeat or node guarding, decodes emergency messages,
+// produces SYNC messages, and sends and receives PDOs according to
+// a mapping. An object dictionary can be read from EDS or DCF files
+// using [ParseEDS].
+package canopen
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+// Function codes of predefined COB-IDs
+const (
+	cobNMT       = 0x000
+	cobSync      = 0x080
+	cobEmcy      = 0x080
+	cobSDOTx     = 0x580 // server to client
+	cobSDORx     = 0x600 // client to server
+	cobHeartbeat = 0x700
+)
+
+// Config contains the parameters of a Master. The callback functions
+// are called from the goroutine receiving messages; they must not block.
+type Config struct {
+	// SDOTimeout is the time to wait for an SDO response.
+	// A zero value means 1 s.
+	SDOTimeout time.Duration
+
+	// BlockSize is the number of segments per block requested
+	// in SDO block uploads. A zero value means 127.
+	BlockSize uint8
+
+	// StateFunc, if not nil, is called when the NMT state of a node
+	// changes, as reported by heartbeat, boot-up, or node guarding
+	// messages.
+	StateFunc func(node uint8, s NMTState)
+
+	// TimeoutFunc, if not nil, is called when a monitored node did not
+	// send a heartbeat message in time, or did not answer node guarding
+	// requests within the life time.
+	TimeoutFunc func(node uint8)
+
+	// EmergencyFunc, if not nil, is called when
+	// an emergency message has been received.
+	EmergencyFunc func(node uint8, e *Emergency)
+}
+
+var errClosed = errors.New("canopen: use of closed master")
+
+// A Master controls CANopen nodes attached to a can.Device.
+//
+// The Master takes over the device: it starts a goroutine reading
+// from the device, which dispatches received messages. Closing the
+// Master closes the device.
+type Master struct {
+	dev can.Device
+	cfg Config
+
+	done     chan struct{}
+	readDone chan struct{}
+	readErr  error
+
+	wmu sync.Mutex
+
+	mu        sync.Mutex
+	sdo       map[uint8]*sdoChannel
+	nodes     map[uint8]*nodeState
+	pdo       map[uint32]func(data []byte, t can.Time)
+	closeOnce sync.Once
+}
+
+// NewMaster creates a master on dev.
+func NewMaster(dev can.Device, cfg *Config) *Master {
+	m := new(Master)
+	m.dev = dev
+	if cfg != nil {
+		m.cfg = *cfg
+	}
+	m.sdo = make(map[uint8]*sdoChannel)
+	m.nodes = make(map[uint8]*nodeState)
+	m.pdo = make(map[uint32]func([]byte, can.Time))
+	m.done = make(chan struct{})
+	m.readDone = make(chan struct{})
+	go m.readLoop()
+	return m
+}
+
+func (m *Master) readLoop() {
+	buf := make([]can.Msg, 8)
+	for {
+		n, err := m.dev.Read(buf)
+		if err != nil {
+			m.readErr = err
+			close(m.readDone)
+			return
+		}
+		for i := range buf[:n] {
+			msg := &buf[i]
+			if msg.IsStatus() || msg.ExtFrame() {
+				continue
+			}
+			m.dispatch(msg)
+		}
+	}
+}
+
+func (m *Master) dispatch(msg *can.Msg) {
+	cob := msg.Id
+	node := uint8(cob & 0x7F)
+	if msg.Test(can.RTRMsg) {
+		return
+	}
+	data := slices.Clone(msg.Data())
+	switch {
+	case cob > cobEmcy && cob <= cobEmcy+0x7F:
+		if e, err := decodeEmergency(data); err == nil && m.cfg.EmergencyFunc != nil {
+			m.cfg.EmergencyFunc(node, e)
+		}
+		return
+	case cob > cobSDOTx && cob <= cobSDOTx+0x7F:
+		m.mu.Lock()
+		ch := m.sdo[node]
+		m.mu.Unlock()
+		if ch != nil {
+			ch.put(data)
+		}
+		return
+	case cob > cobHeartbeat && cob <= cobHeartbeat+0x7F:
+		m.handleNodeState(node, data)
+		return
+	}
+	m.mu.Lock()
+	f := m.pdo[cob]
+	m.mu.Unlock()
+	if f != nil {
+		f(data, msg.Rx.Time)
+	}
+}
+
+func (m *Master) send(cob uint32, data []byte, rtr bool) error {
+	var msg can.Msg
+	msg.Id = cob
+	if rtr {
+		msg.Flags |= can.RTRMsg
+	}
+	msg.SetData(data)
+
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	start := time.Now()
+	for {
+		err := m.dev.WriteMsg(&msg)
+		if !errors.Is(err, can.ErrTxQueueFull) || time.Since(start) > time.Second {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Close closes the master and the underlying device.
+func (m *Master) Close() error {
+	err := errClosed
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		for _, ns := range m.nodes {
+			ns.stop()
+		}
+		m.mu.Unlock()
+		err = m.dev.Close()
+	})
+	return err
+}
@@ -0,0 +1,325 @@
+package canopen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+// sdoServer is a minimal SDO server used for testing the client.
+type sdoServer struct {
+	dev     can.Device
+	node    uint8
+	od      map[uint32][]byte
+	blksize byte
+
+	key    uint32
+	toggle byte
+	buf    []byte
+
+	blkDown bool
+	seq     byte
+	blkUp   []byte
+	pos     int
+}
+
+func (s *sdoServer) run() {
+	buf := make([]can.Msg, 8)
+	for {
+		n, err := s.dev.Read(buf)
+		if err != nil {
+			return
+		}
+		for i := range buf[:n] {
+			m := &buf[i]
+			if m.Id != cobSDORx+uint32(s.node) || len(m.Data()) != 8 {
+				continue
+			}
+			for _, r := range s.handle(m.Data()) {
+				var resp can.Msg
+				resp.Id = cobSDOTx + uint32(s.node)
+				b := make([]byte, 8)
+				copy(b, r)
+				resp.SetData(b)
+				s.dev.WriteMsg(&resp)
+			}
+		}
+	}
+}
+
+func (s *sdoServer) abort(mux []byte, code AbortCode) [][]byte {
+	s.blkDown = false
+	s.blkUp = nil
+	return [][]byte{binary.LittleEndian.AppendUint32(append([]byte{0x80}, mux...), uint32(code))}
+}
+
+func (s *sdoServer) handle(r []byte) [][]byte {
+	mux := r[1:4]
+	if s.blkDown {
+		seq := r[0] & 0x7F
+		if seq == s.seq+1 {
+			s.buf = append(s.buf, r[1:8]...)
+			s.seq++
+		}
+		if r[0]&0x80 == 0 && seq != s.blksize {
+			return nil
+		}
+		ack := []byte{0xA2, s.seq, s.blksize}
+		s.seq = 0
+		s.blkDown = r[0]&0x80 == 0
+		return [][]byte{ack}
+	}
+	key := uint32(binary.LittleEndian.Uint16(mux))<<8 | uint32(mux[2])
+	switch r[0] >> 5 {
+	case 2: // initiate upload
+		v, ok := s.od[key]
+		if !ok {
+			return s.abort(mux, AbortNoObject)
+		}
+		if len(v) <= 4 {
+			return [][]byte{append([]byte{0x43 | byte(4-len(v))<<2, mux[0], mux[1], mux[2]}, v...)}
+		}
+		s.buf = v
+		s.toggle = 0
+		return [][]byte{binary.LittleEndian.AppendUint32([]byte{0x41, mux[0], mux[1], mux[2]}, uint32(len(v)))}
+	case 3: // upload segment
+		n := min(7, len(s.buf))
+		cmd := s.toggle<<4 | byte(7-n)<<1
+		if n == len(s.buf) {
+			cmd |= 1
+		}
+		resp := append([]byte{cmd}, s.buf[:n]...)
+		s.buf = s.buf[n:]
+		s.toggle ^= 1
+		return [][]byte{resp}
+	case 1: // initiate download
+		s.key = key
+		if r[0]&2 != 0 {
+			s.od[key] = bytes.Clone(r[4 : 8-int(r[0]>>2&3)])
+		} else {
+			s.buf = nil
+			s.toggle = 0
+		}
+		return [][]byte{{0x60, mux[0], mux[1], mux[2]}}
+	case 0: // download segment
+		s.buf = append(s.buf, r[1:8-int(r[0]>>1&7)]...)
+		resp := []byte{0x20 | s.toggle<<4}
+		s.toggle ^= 1
+		if r[0]&1 != 0 {
+			s.od[s.key] = s.buf
+		}
+		return [][]byte{resp}
+	case 6: // block download
+		if r[0]&1 == 0 {
+			s.key = key
+			s.buf = nil
+			s.blkDown = true
+			s.seq = 0
+			return [][]byte{{0xA4, mux[0], mux[1], mux[2], s.blksize}}
+		}
+		data := s.buf[:len(s.buf)-int(r[0]>>2&7)]
+		if binary.LittleEndian.Uint16(r[1:]) != crc16(0, data) {
+			return s.abort(mux, AbortCRC)
+		}
+		s.od[s.key] = data
+		return [][]byte{{0xA1}}
+	case 5: // block upload
+		switch r[0] & 3 {
+		case 0:
+			v, ok := s.od[key]
+			if !ok {
+				return s.abort(mux, AbortNoObject)
+			}
+			s.blkUp = v
+			s.pos = 0
+			s.blksize = r[4]
+			return [][]byte{binary.LittleEndian.AppendUint32([]byte{0xC6, mux[0], mux[1], mux[2]}, uint32(len(v)))}
+		case 2:
+			s.pos += int(r[1]) * 7
+			s.blksize = r[2]
+			if s.pos >= len(s.blkUp) {
+				nseg := max((len(s.blkUp)+6)/7, 1)
+				crc := crc16(0, s.blkUp)
+				return [][]byte{{0xC1 | byte(nseg*7-len(s.blkUp))<<2, byte(crc), byte(crc >> 8)}}
+			}
+			fallthrough
+		case 3:
+			var segs [][]byte
+			for seq := 1; seq <= int(s.blksize); seq++ {
+				off := s.pos + (seq-1)*7
+				seg := append([]byte{byte(seq)}, s.blkUp[off:min(off+7, len(s.blkUp))]...)
+				if off+7 >= len(s.blkUp) {
+					seg[0] |= 0x80
+					segs = append(segs, seg)
+					break
+				}
+				segs = append(segs, seg)
+			}
+			return segs
+		}
+		return nil
+	}
+	return s.abort(mux, AbortCommand)
+}
+
+func openMaster(t *testing.T, bus string, cfg *Config) (*Master, can.Device) {
+	t.Helper()
+	dev, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMaster(dev, cfg)
+	t.Cleanup(func() {
+		m.Close()
+		peer.Close()
+	})
+	return m, peer
+}
+
+func TestSDO(t *testing.T) {
+	m, peer := openMaster(t, "canopen-sdo", nil)
+	s := &sdoServer{dev: peer, node: 5, od: make(map[uint32][]byte), blksize: 4}
+	go s.run()
+
+	tests := []struct {
+		name  string
+		size  int
+		block bool
+	}{
+		{"expedited", 3, false},
+		{"segmented", 20, false},
+		{"segmented, multiple of 7", 21, false},
+		{"block", 200, true},
+		{"block, short", 5, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := make([]byte, tt.size)
+			for j := range want {
+				want[j] = byte(j + i)
+			}
+			sub := uint8(i + 1)
+			var got []byte
+			var err error
+			if tt.block {
+				err = m.BlockDownload(5, 0x2000, sub, want)
+				if err == nil {
+					got, err = m.BlockUpload(5, 0x2000, sub)
+				}
+			} else {
+				err = m.Download(5, 0x2000, sub, want)
+				if err == nil {
+					got, err = m.Upload(5, 0x2000, sub)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("got % x, want % x", got, want)
+			}
+		})
+	}
+
+	_, err := m.Upload(5, 0x3000, 0)
+	var ae *SDOAbortError
+	if !errors.As(err, &ae) || ae.Code != AbortNoObject || ae.Index != 0x3000 {
+		t.Errorf("unknown object: got %v", err)
+	}
+}
+
+func TestPDO(t *testing.T) {
+	p := &PDO{COBID: 0x185, Mapping: []MappedObject{
+		{0x6041, 0, 16},
+		{0x6061, 0, 8},
+		{0x2000, 1, 1},
+		{0x2000, 2, 7},
+		{0x6064, 0, 32},
+	}}
+	values := []uint64{0x1237, 0xFE, 1, 0x55, 0xDEADBEEF}
+	data, err := p.Encode(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x37, 0x12, 0xFE, 0xAB, 0xEF, 0xBE, 0xAD, 0xDE}
+	if !bytes.Equal(data, want) {
+		t.Errorf("Encode: got % x, want % x", data, want)
+	}
+	got, err := p.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range values {
+		if got[i] != values[i] {
+			t.Errorf("Decode: value %d: got %#x, want %#x", i, got[i], values[i])
+		}
+	}
+}
+
+func TestMonitoring(t *testing.T) {
+	states := make(chan NMTState, 4)
+	timeouts := make(chan uint8, 1)
+	emcy := make(chan *Emergency, 1)
+	m, peer := openMaster(t, "canopen-monitor", &Config{
+		StateFunc:     func(node uint8, s NMTState) { states <- s },
+		TimeoutFunc:   func(node uint8) { timeouts <- node },
+		EmergencyFunc: func(node uint8, e *Emergency) { emcy <- e },
+	})
+	m.Monitor(7, 50*time.Millisecond)
+
+	send := func(id uint32, data ...byte) {
+		var msg can.Msg
+		msg.Id = id
+		msg.SetData(data)
+		if err := peer.WriteMsg(&msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(0x707, 0x00)
+	send(0x707, 0x7F)
+	send(0x707, 0x7F)
+	for _, want := range []NMTState{BootUp, PreOperational} {
+		select {
+		case s := <-states:
+			if s != want {
+				t.Errorf("got state %v, want %v", s, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("state change missing")
+		}
+	}
+	if s, ok := m.NodeState(7); !ok || s != PreOperational {
+		t.Errorf("NodeState: got %v, %v", s, ok)
+	}
+
+	select {
+	case node := <-timeouts:
+		if node != 7 {
+			t.Errorf("timeout of node %d", node)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("heartbeat timeout missing")
+	}
+	if _, ok := m.NodeState(7); ok {
+		t.Error("state still known after timeout")
+	}
+
+	send(0x087, 0x30, 0x81, 0x11, 1, 2, 3, 4, 5)
+	select {
+	case e := <-emcy:
+		if e.Code != 0x8130 || e.Register != 0x11 || e.Class() != "life guard error or heartbeat error" {
+			t.Errorf("got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("emergency missing")
+	}
+}
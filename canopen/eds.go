@@ -0,0 +1,184 @@
+package canopen
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// iniFile contains the sections of an EDS or DCF file. Section
+// names and keys are converted to lower case.
+type iniFile map[string]map[string]string
+
+func parseINI(r io.Reader) (iniFile, error) {
+	f := make(iniFile)
+	var sec map[string]string
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		l := strings.TrimSpace(s.Text())
+		if l == "" || l[0] == ';' || l[0] == '#' {
+			continue
+		}
+		if l[0] == '[' {
+			end := strings.IndexByte(l, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("canopen: line %d: malformed section header", line)
+			}
+			name := strings.ToLower(strings.TrimSpace(l[1:end]))
+			sec = f[name]
+			if sec == nil {
+				sec = make(map[string]string)
+				f[name] = sec
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(l, "=")
+		if !ok || sec == nil {
+			return nil, fmt.Errorf("canopen: line %d: syntax error", line)
+		}
+		sec[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ParseEDS reads an electronic data sheet (EDS), or a device
+// configuration file (DCF), as specified in CiA 306. Objects
+// using the CompactSubObj notation are expanded.
+func ParseEDS(r io.Reader) (*ObjectDictionary, error) {
+	f, err := parseINI(r)
+	if err != nil {
+		return nil, err
+	}
+	od := new(ObjectDictionary)
+	od.FileInfo = f["fileinfo"]
+	od.DeviceInfo = f["deviceinfo"]
+	od.objects = make(map[uint16]*Object)
+	if dc := f["devicecomissioning"]; dc != nil {
+		if v, err := strconv.ParseUint(dc["nodeid"], 0, 8); err == nil {
+			od.NodeID = uint8(v)
+		}
+		if v, err := strconv.ParseUint(dc["baudrate"], 0, 32); err == nil {
+			od.Bitrate = uint32(v) * 1000
+		}
+	}
+
+	for name, sec := range f {
+		if len(name) != 4 {
+			continue
+		}
+		index, err := strconv.ParseUint(name, 16, 16)
+		if err != nil {
+			continue
+		}
+		o, err := parseObject(sec)
+		if err != nil {
+			return nil, fmt.Errorf("canopen: object %04X: %w", index, err)
+		}
+		o.Index = uint16(index)
+		if o.ObjectType == ObjArray || o.ObjectType == ObjRecord {
+			if err := parseSubs(f, o, sec); err != nil {
+				return nil, fmt.Errorf("canopen: object %04X: %w", index, err)
+			}
+		}
+		od.objects[o.Index] = o
+	}
+	return od, nil
+}
+
+func parseObject(sec map[string]string) (*Object, error) {
+	o := new(Object)
+	o.Name = sec["parametername"]
+	o.ObjectType = ObjVar
+	if s := sec["objecttype"]; s != "" {
+		v, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			return nil, err
+		}
+		o.ObjectType = ObjectType(v)
+	}
+	if s := sec["datatype"]; s != "" {
+		v, err := strconv.ParseUint(s, 0, 16)
+		if err != nil {
+			return nil, err
+		}
+		o.DataType = DataType(v)
+	}
+	o.Access = strings.ToLower(sec["accesstype"])
+	o.PDOMapping = sec["pdomapping"] == "1"
+	o.DefaultValue = sec["defaultvalue"]
+	o.ParameterValue = sec["parametervalue"]
+	o.LowLimit = sec["lowlimit"]
+	o.HighLimit = sec["highlimit"]
+	return o, nil
+}
+
+// parseSubs reads the sub-entries of an array or record.
+func parseSubs(f iniFile, o *Object, sec map[string]string) error {
+	prefix := fmt.Sprintf("%04xsub", o.Index)
+	for name, ssec := range f {
+		s, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		sub, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return err
+		}
+		so, err := parseObject(ssec)
+		if err != nil {
+			return fmt.Errorf("sub-index %d: %w", sub, err)
+		}
+		so.Index = o.Index
+		so.Sub = uint8(sub)
+		o.Subs = append(o.Subs, so)
+	}
+
+	if s := sec["compactsubobj"]; s != "" && len(o.Subs) == 0 {
+		n, err := strconv.ParseUint(s, 0, 8)
+		if err != nil {
+			return err
+		}
+		names := f[fmt.Sprintf("%04xname", o.Index)]
+		values := f[fmt.Sprintf("%04xvalue", o.Index)]
+		o.Subs = append(o.Subs, &Object{
+			Index:        o.Index,
+			Name:         "NrOfObjects",
+			ObjectType:   ObjVar,
+			DataType:     Unsigned8,
+			Access:       "ro",
+			DefaultValue: s,
+		})
+		for i := 1; i <= int(n); i++ {
+			key := strconv.Itoa(i)
+			name := names[key]
+			if name == "" {
+				name = fmt.Sprintf("%s%d", o.Name, i)
+			}
+			o.Subs = append(o.Subs, &Object{
+				Index:          o.Index,
+				Sub:            uint8(i),
+				Name:           name,
+				ObjectType:     ObjVar,
+				DataType:       o.DataType,
+				Access:         o.Access,
+				PDOMapping:     o.PDOMapping,
+				DefaultValue:   o.DefaultValue,
+				ParameterValue: values[key],
+				LowLimit:       o.LowLimit,
+				HighLimit:      o.HighLimit,
+			})
+		}
+	}
+	slices.SortFunc(o.Subs, func(a, b *Object) int {
+		return int(a.Sub) - int(b.Sub)
+	})
+	return nil
+}
@@ -0,0 +1,133 @@
+package canopen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const testDCF = `
+[FileInfo]
+FileName=test.dcf
+; comment
+
+[DeviceInfo]
+VendorName=Example
+ProductName=IO module
+
+[DeviceComissioning]
+NodeID=0x0A
+Baudrate=250
+
+[1000]
+ParameterName=Device type
+ObjectType=0x7
+DataType=0x0007
+AccessType=ro
+DefaultValue=0x00020191
+PDOMapping=0
+
+[1017]
+ParameterName=Producer heartbeat time
+DataType=0x0006
+AccessType=rw
+DefaultValue=0
+ParameterValue=500
+
+[1800]
+ParameterName=TPDO1 communication parameter
+ObjectType=0x9
+SubNumber=2
+
+[1800sub0]
+ParameterName=Highest sub-index supported
+DataType=0x0005
+AccessType=const
+DefaultValue=2
+
+[1800sub1]
+ParameterName=COB-ID
+DataType=0x0007
+AccessType=rw
+DefaultValue=$NODEID+0x180
+
+[1A00]
+ParameterName=TPDO1 mapping parameter
+ObjectType=0x8
+DataType=0x0007
+AccessType=rw
+CompactSubObj=2
+
+[1A00Value]
+NrOfEntries=2
+1=0x60000108
+2=0x64010210
+
+[6000]
+ParameterName=Temperature
+DataType=0x0003
+AccessType=ro
+DefaultValue=-40
+`
+
+func TestParseEDS(t *testing.T) {
+	od, err := ParseEDS(strings.NewReader(testDCF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if od.NodeID != 10 || od.Bitrate != 250000 {
+		t.Errorf("commissioning: got node %d, bitrate %d", od.NodeID, od.Bitrate)
+	}
+	if od.DeviceInfo["productname"] != "IO module" {
+		t.Errorf("device info: got %v", od.DeviceInfo)
+	}
+	if got := len(od.Indices()); got != 5 {
+		t.Errorf("got %d objects, want 5", got)
+	}
+
+	tests := []struct {
+		index uint16
+		sub   uint8
+		name  string
+		value []byte
+	}{
+		{0x1000, 0, "Device type", []byte{0x91, 0x01, 0x02, 0x00}},
+		{0x1017, 0, "Producer heartbeat time", []byte{0xF4, 0x01}},
+		{0x1800, 1, "COB-ID", []byte{0x8A, 0x01, 0, 0}},
+		{0x1A00, 0, "NrOfObjects", []byte{2}},
+		{0x1A00, 2, "TPDO1 mapping parameter2", []byte{0x10, 0x02, 0x01, 0x64}},
+		{0x6000, 0, "Temperature", []byte{0xD8, 0xFF}},
+	}
+	for _, tt := range tests {
+		o := od.Find(tt.index, tt.sub)
+		if o == nil {
+			t.Errorf("%04X:%02X not found", tt.index, tt.sub)
+			continue
+		}
+		if o.Name != tt.name {
+			t.Errorf("%04X:%02X: got name %q, want %q", tt.index, tt.sub, o.Name, tt.name)
+		}
+		v, err := o.Value(od.NodeID)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !bytes.Equal(v, tt.value) {
+			t.Errorf("%04X:%02X: got % x, want % x", tt.index, tt.sub, v, tt.value)
+		}
+	}
+
+	p, err := od.PDO(TPDO, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MappedObject{{0x6000, 1, 8}, {0x6401, 2, 16}}
+	if p.COBID != 0x18A || len(p.Mapping) != 2 || p.Mapping[0] != want[0] || p.Mapping[1] != want[1] {
+		t.Errorf("got PDO %+v", p)
+	}
+
+	v, err := Integer16.Decode([]byte{0xD8, 0xFF})
+	if err != nil || v != int64(-40) {
+		t.Errorf("Decode: got %v, %v", v, err)
+	}
+}
@@ -0,0 +1,74 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Emergency is the content of an emergency (EMCY) message.
+type Emergency struct {
+	// Code is the emergency error code;
+	// 0 indicates that an error has been reset.
+	Code uint16
+
+	// Register is the value of the node's error register (1001h).
+	Register byte
+
+	// Data contains manufacturer-specific error information.
+	Data [5]byte
+}
+
+func decodeEmergency(data []byte) (*Emergency, error) {
+	if len(data) != 8 {
+		return nil, errors.New("canopen: invalid emergency message length")
+	}
+	e := new(Emergency)
+	e.Code = binary.LittleEndian.Uint16(data)
+	e.Register = data[2]
+	copy(e.Data[:], data[3:])
+	return e, nil
+}
+
+// emcyClasses maps the upper byte, or the upper nibble,
+// of emergency error codes to a description.
+var emcyClasses = []struct {
+	code, mask uint16
+	text       string
+}{
+	{0x0000, 0xFF00, "error reset or no error"},
+	{0x1000, 0xFF00, "generic error"},
+	{0x2000, 0xF000, "current"},
+	{0x3000, 0xF000, "voltage"},
+	{0x4000, 0xF000, "temperature"},
+	{0x5000, 0xFF00, "device hardware"},
+	{0x6000, 0xF000, "device software"},
+	{0x7000, 0xFF00, "additional modules"},
+	{0x8110, 0xFFFF, "CAN overrun"},
+	{0x8120, 0xFFFF, "CAN in error passive mode"},
+	{0x8130, 0xFFFF, "life guard error or heartbeat error"},
+	{0x8140, 0xFFFF, "recovered from bus off"},
+	{0x8150, 0xFFFF, "CAN-ID collision"},
+	{0x8210, 0xFFFF, "PDO not processed due to length error"},
+	{0x8220, 0xFFFF, "PDO length exceeded"},
+	{0x8240, 0xFFFF, "unexpected SYNC data length"},
+	{0x8250, 0xFFFF, "RPDO timeout"},
+	{0x8000, 0xF000, "monitoring"},
+	{0x9000, 0xFF00, "external error"},
+	{0xF000, 0xFF00, "additional functions"},
+	{0xFF00, 0xFF00, "device specific"},
+}
+
+// Class returns a description of the error class of e.Code.
+func (e *Emergency) Class() string {
+	for _, c := range emcyClasses {
+		if e.Code&c.mask == c.code {
+			return c.text
+		}
+	}
+	return "unknown"
+}
+
+func (e *Emergency) String() string {
+	return fmt.Sprintf("EMCY %04X (%s) register %02X data % X", e.Code, e.Class(), e.Register, e.Data[:])
+}
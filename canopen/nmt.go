@@ -0,0 +1,218 @@
+package canopen
+
+import (
+	"fmt"
+	"time"
+)
+
+// NMTCommand is a network management command.
+type NMTCommand byte
+
+const (
+	StartNode           NMTCommand = 0x01
+	StopNode            NMTCommand = 0x02
+	EnterPreOperational NMTCommand = 0x80
+	ResetNode           NMTCommand = 0x81
+	ResetCommunication  NMTCommand = 0x82
+)
+
+// NMTState is the network management state of a node.
+type NMTState byte
+
+const (
+	BootUp         NMTState = 0x00
+	Stopped        NMTState = 0x04
+	Operational    NMTState = 0x05
+	PreOperational NMTState = 0x7F
+)
+
+func (s NMTState) String() string {
+	switch s {
+	case BootUp:
+		return "boot-up"
+	case Stopped:
+		return "stopped"
+	case Operational:
+		return "operational"
+	case PreOperational:
+		return "pre-operational"
+	}
+	return fmt.Sprintf("state %#02x", byte(s))
+}
+
+// SendNMT sends an NMT command to a node,
+// or to all nodes, if node is 0.
+func (m *Master) SendNMT(cmd NMTCommand, node uint8) error {
+	return m.send(cobNMT, []byte{byte(cmd), node}, false)
+}
+
+// nodeState contains the monitoring state of a node.
+type nodeState struct {
+	state NMTState
+	known bool
+
+	hbTimeout time.Duration
+	hbTimer   *time.Timer
+
+	guarding  bool
+	toggle    byte
+	lastGuard time.Time
+	guardStop chan struct{}
+}
+
+func (ns *nodeState) stop() {
+	if ns.hbTimer != nil {
+		ns.hbTimer.Stop()
+	}
+	if ns.guardStop != nil {
+		close(ns.guardStop)
+		ns.guardStop = nil
+	}
+}
+
+// nodeState returns the state of node, creating it if needed.
+// It must be called with m.mu held.
+func (m *Master) nodeState(node uint8) *nodeState {
+	ns := m.nodes[node]
+	if ns == nil {
+		ns = new(nodeState)
+		m.nodes[node] = ns
+	}
+	return ns
+}
+
+// NodeState returns the NMT state of a node, as last reported by
+// heartbeat, boot-up, or node guarding messages. If the state is not
+// known, or a monitoring timeout occurred, ok is false.
+func (m *Master) NodeState(node uint8) (s NMTState, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns := m.nodes[node]
+	if ns == nil {
+		return 0, false
+	}
+	return ns.state, ns.known
+}
+
+// handleNodeState processes heartbeat, boot-up,
+// and node guarding response messages.
+func (m *Master) handleNodeState(node uint8, data []byte) {
+	if len(data) < 1 {
+		return
+	}
+	m.mu.Lock()
+	ns := m.nodeState(node)
+	b := data[0]
+	state := NMTState(b & 0x7F)
+	if ns.guarding && b != 0 {
+		if b&0x80 != ns.toggle {
+			m.mu.Unlock()
+			return
+		}
+		ns.toggle ^= 0x80
+		ns.lastGuard = time.Now()
+	} else if b == 0 {
+		// boot-up; a new guarding sequence starts with toggle bit 0
+		ns.toggle = 0
+		ns.lastGuard = time.Now()
+	}
+	if ns.hbTimer != nil && ns.hbTimeout != 0 {
+		ns.hbTimer.Reset(ns.hbTimeout)
+	}
+	changed := !ns.known || ns.state != state
+	ns.state = state
+	ns.known = true
+	m.mu.Unlock()
+
+	if changed && m.cfg.StateFunc != nil {
+		m.cfg.StateFunc(node, state)
+	}
+}
+
+func (m *Master) nodeTimeout(node uint8) {
+	m.mu.Lock()
+	ns := m.nodeState(node)
+	wasKnown := ns.known
+	ns.known = false
+	m.mu.Unlock()
+	if wasKnown && m.cfg.TimeoutFunc != nil {
+		m.cfg.TimeoutFunc(node)
+	}
+}
+
+// Monitor starts consuming the heartbeat messages of a node: if no
+// heartbeat is received within timeout, TimeoutFunc is called.
+// A zero timeout stops monitoring.
+func (m *Master) Monitor(node uint8, timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns := m.nodeState(node)
+	ns.hbTimeout = timeout
+	if timeout == 0 {
+		if ns.hbTimer != nil {
+			ns.hbTimer.Stop()
+		}
+		return
+	}
+	if ns.hbTimer == nil {
+		ns.hbTimer = time.AfterFunc(timeout, func() { m.nodeTimeout(node) })
+	} else {
+		ns.hbTimer.Reset(timeout)
+	}
+}
+
+// Guard starts node guarding: the state of the node is requested
+// every guardTime using a remote frame. If the node does not answer
+// with a correct toggle bit within the life time, that is guardTime
+// multiplied by lifeFactor, TimeoutFunc is called. The returned
+// function stops node guarding.
+func (m *Master) Guard(node uint8, guardTime time.Duration, lifeFactor int) (stop func()) {
+	m.mu.Lock()
+	ns := m.nodeState(node)
+	if ns.guardStop != nil {
+		close(ns.guardStop)
+	}
+	ns.guarding = true
+	ns.toggle = 0
+	ns.lastGuard = time.Now()
+	stopc := make(chan struct{})
+	ns.guardStop = stopc
+	m.mu.Unlock()
+
+	lifeTime := guardTime * time.Duration(lifeFactor)
+	go func() {
+		t := time.NewTicker(guardTime)
+		defer t.Stop()
+		expired := false
+		for {
+			select {
+			case <-t.C:
+			case <-stopc:
+				return
+			case <-m.done:
+				return
+			}
+			m.mu.Lock()
+			since := time.Since(ns.lastGuard)
+			m.mu.Unlock()
+			if since > lifeTime {
+				if !expired {
+					expired = true
+					m.nodeTimeout(node)
+				}
+			} else {
+				expired = false
+			}
+			m.send(cobHeartbeat+uint32(node), nil, true)
+		}
+	}()
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if ns.guardStop == stopc {
+			close(stopc)
+			ns.guardStop = nil
+			ns.guarding = false
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ObjectType is the type of an object dictionary entry.
+type ObjectType uint8
+
+const (
+	ObjDomain    ObjectType = 2
+	ObjDefType   ObjectType = 5
+	ObjDefStruct ObjectType = 6
+	ObjVar       ObjectType = 7
+	ObjArray     ObjectType = 8
+	ObjRecord    ObjectType = 9
+)
+
+// DataType is a CANopen data type index.
+type DataType uint16
+
+const (
+	Boolean        DataType = 0x01
+	Integer8       DataType = 0x02
+	Integer16      DataType = 0x03
+	Integer32      DataType = 0x04
+	Unsigned8      DataType = 0x05
+	Unsigned16     DataType = 0x06
+	Unsigned32     DataType = 0x07
+	Real32         DataType = 0x08
+	VisibleString  DataType = 0x09
+	OctetString    DataType = 0x0A
+	UnicodeString  DataType = 0x0B
+	TimeOfDay      DataType = 0x0C
+	TimeDifference DataType = 0x0D
+	Domain         DataType = 0x0F
+	Integer24      DataType = 0x10
+	Real64         DataType = 0x11
+	Integer40      DataType = 0x12
+	Integer48      DataType = 0x13
+	Integer56      DataType = 0x14
+	Integer64      DataType = 0x15
+	Unsigned24     DataType = 0x16
+	Unsigned40     DataType = 0x18
+	Unsigned48     DataType = 0x19
+	Unsigned56     DataType = 0x1A
+	Unsigned64     DataType = 0x1B
+)
+
+// Size returns the encoded size of values of the data type in bytes,
+// or 0 for types of variable length.
+func (dt DataType) Size() int {
+	switch dt {
+	case Boolean, Integer8, Unsigned8:
+		return 1
+	case Integer16, Unsigned16:
+		return 2
+	case Integer24, Unsigned24:
+		return 3
+	case Integer32, Unsigned32, Real32:
+		return 4
+	case Integer40, Unsigned40:
+		return 5
+	case Integer48, Unsigned48, TimeOfDay, TimeDifference:
+		return 6
+	case Integer56, Unsigned56:
+		return 7
+	case Integer64, Unsigned64, Real64:
+		return 8
+	}
+	return 0
+}
+
+func (dt DataType) signed() bool {
+	switch dt {
+	case Integer8, Integer16, Integer24, Integer32, Integer40, Integer48, Integer56, Integer64:
+		return true
+	}
+	return false
+}
+
+func (dt DataType) unsigned() bool {
+	switch dt {
+	case Boolean, Unsigned8, Unsigned16, Unsigned24, Unsigned32, Unsigned40, Unsigned48, Unsigned56, Unsigned64:
+		return true
+	}
+	return false
+}
+
+// Decode converts an encoded value into a Go value: bool for Boolean,
+// int64 for signed, uint64 for unsigned integer types, float64 for
+// Real32 and Real64, string for visible and unicode strings, and
+// []byte for all other types.
+func (dt DataType) Decode(b []byte) (any, error) {
+	if n := dt.Size(); n != 0 && len(b) != n {
+		return nil, fmt.Errorf("canopen: data type %#02x: got %d bytes, want %d", uint16(dt), len(b), n)
+	}
+	switch {
+	case dt == Boolean:
+		return b[0] != 0, nil
+	case dt == Real32:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case dt == Real64:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case dt.unsigned():
+		return leUint(b), nil
+	case dt.signed():
+		shift := 64 - 8*len(b)
+		return int64(leUint(b)<<shift) >> shift, nil
+	case dt == VisibleString:
+		return string(b), nil
+	case dt == UnicodeString:
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+		return string(utf16.Decode(u)), nil
+	}
+	return b, nil
+}
+
+func leUint(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// ParseValue converts a value as written in EDS and DCF files into its
+// encoded form. Integer values may be specified in decimal, hexadecimal
+// (0x prefix), or octal (leading 0) notation, and may refer to the
+// node-ID using $NODEID, as in "$NODEID+0x180".
+func (dt DataType) ParseValue(s string, nodeID uint8) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case dt == VisibleString:
+		return []byte(s), nil
+	case dt == UnicodeString:
+		var b []byte
+		for _, u := range utf16.Encode([]rune(s)) {
+			b = binary.LittleEndian.AppendUint16(b, u)
+		}
+		return b, nil
+	case dt == Real32 || dt == Real64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		if dt == Real32 {
+			return binary.LittleEndian.AppendUint32(nil, math.Float32bits(float32(f))), nil
+		}
+		return binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)), nil
+	case dt.signed() || dt.unsigned():
+		v, err := parseInt(s, nodeID, dt.signed())
+		if err != nil {
+			return nil, err
+		}
+		n := dt.Size()
+		bits := 8 * n
+		if dt.signed() && bits < 64 && (int64(v) < -1<<(bits-1) || int64(v) >= 1<<(bits-1)) ||
+			!dt.signed() && bits < 64 && v >= 1<<bits {
+			return nil, fmt.Errorf("canopen: value %q out of range", s)
+		}
+		b := binary.LittleEndian.AppendUint64(nil, v)
+		return b[:n], nil
+	}
+	return hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+}
+
+// parseInt evaluates an integer expression,
+// which may be a sum containing $NODEID.
+func parseInt(s string, nodeID uint8, signed bool) (uint64, error) {
+	var sum uint64
+	for _, term := range strings.Split(s, "+") {
+		term = strings.TrimSpace(term)
+		if strings.EqualFold(term, "$NODEID") {
+			sum += uint64(nodeID)
+			continue
+		}
+		if signed {
+			v, err := strconv.ParseInt(term, 0, 64)
+			if err != nil {
+				return 0, err
+			}
+			sum += uint64(v)
+			continue
+		}
+		v, err := strconv.ParseUint(term, 0, 64)
+		if err != nil {
+			return 0, err
+		}
+		sum += v
+	}
+	return sum, nil
+}
+
+// Object is an entry of an object dictionary. Arrays and records
+// contain their sub-entries in Subs, ordered by sub-index.
+type Object struct {
+	Index      uint16
+	Sub        uint8
+	Name       string
+	ObjectType ObjectType
+	DataType   DataType
+
+	// Access is the access type: "ro", "wo", "rw", "rwr", "rww", or "const".
+	Access     string
+	PDOMapping bool
+
+	// DefaultValue and ParameterValue contain the values
+	// as specified in the EDS or DCF file.
+	DefaultValue   string
+	ParameterValue string
+	LowLimit       string
+	HighLimit      string
+
+	Subs []*Object
+}
+
+// Writable reports whether the object may be written by an SDO client.
+func (o *Object) Writable() bool {
+	return strings.HasPrefix(o.Access, "rw") || o.Access == "wo"
+}
+
+// Value returns the encoded value of the object: the parameter
+// value, if present, or the default value otherwise. If neither
+// is present, nil is returned.
+func (o *Object) Value(nodeID uint8) ([]byte, error) {
+	s := o.ParameterValue
+	if s == "" {
+		s = o.DefaultValue
+	}
+	if s == "" {
+		return nil, nil
+	}
+	b, err := o.DataType.ParseValue(s, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("canopen: object %04X:%02X: %w", o.Index, o.Sub, err)
+	}
+	return b, nil
+}
+
+// ObjectDictionary contains the objects read from an EDS or DCF file.
+type ObjectDictionary struct {
+	FileInfo   map[string]string
+	DeviceInfo map[string]string
+
+	// NodeID and Bitrate are read from the DeviceComissioning
+	// section of a DCF file; they are zero otherwise.
+	NodeID  uint8
+	Bitrate uint32
+
+	objects map[uint16]*Object
+}
+
+// Object returns the object at the specified index, or nil.
+func (od *ObjectDictionary) Object(index uint16) *Object {
+	return od.objects[index]
+}
+
+// Find returns the variable at the specified index and sub-index,
+// which is either a sub-entry of an array or record, or, if sub is 0,
+// a simple variable. If there is no such variable, nil is returned.
+func (od *ObjectDictionary) Find(index uint16, sub uint8) *Object {
+	o := od.objects[index]
+	if o == nil {
+		return nil
+	}
+	if len(o.Subs) == 0 {
+		if sub == 0 {
+			return o
+		}
+		return nil
+	}
+	for _, s := range o.Subs {
+		if s.Sub == sub {
+			return s
+		}
+	}
+	return nil
+}
+
+// Indices returns the indices of all objects in ascending order.
+func (od *ObjectDictionary) Indices() []uint16 {
+	idx := make([]uint16, 0, len(od.objects))
+	for i := range od.objects {
+		idx = append(idx, i)
+	}
+	slices.Sort(idx)
+	return idx
+}
+
+// PDO returns the receive or transmit PDO with number n (starting at 1),
+// as defined by the communication and mapping parameters in od.
+func (od *ObjectDictionary) PDO(kind PDOKind, n int) (*PDO, error) {
+	cob := od.Find(kind.commIndex(n), 1)
+	if cob == nil {
+		return nil, fmt.Errorf("canopen: PDO %d not defined", n)
+	}
+	v, err := od.uint(cob)
+	if err != nil {
+		return nil, err
+	}
+	p := new(PDO)
+	p.COBID = uint32(v) & 0x1FFFFFFF
+	p.Disabled = v&pdoInvalid != 0
+
+	num := od.Find(kind.mapIndex(n), 0)
+	if num == nil {
+		return nil, fmt.Errorf("canopen: mapping of PDO %d not defined", n)
+	}
+	nmap, err := od.uint(num)
+	if err != nil {
+		return nil, err
+	}
+	for i := 1; i <= int(nmap); i++ {
+		e := od.Find(kind.mapIndex(n), uint8(i))
+		if e == nil {
+			return nil, fmt.Errorf("canopen: mapping entry %d of PDO %d not defined", i, n)
+		}
+		v, err := od.uint(e)
+		if err != nil {
+			return nil, err
+		}
+		p.Mapping = append(p.Mapping, parseMappedObject(uint32(v)))
+	}
+	return p, nil
+}
+
+func (od *ObjectDictionary) uint(o *Object) (uint64, error) {
+	b, err := o.Value(od.NodeID)
+	if err != nil {
+		return 0, err
+	}
+	return leUint(b), nil
+}
+
+// Configure downloads the parameter values of all writable
+// variables in od, as contained in a DCF file, to a node.
+func (m *Master) Configure(node uint8, od *ObjectDictionary) error {
+	for _, i := range od.Indices() {
+		o := od.objects[i]
+		vars := o.Subs
+		if len(vars) == 0 {
+			vars = []*Object{o}
+		}
+		for _, v := range vars {
+			if v.ParameterValue == "" || !v.Writable() {
+				continue
+			}
+			b, err := v.Value(node)
+			if err != nil {
+				return err
+			}
+			if err := m.Download(node, v.Index, v.Sub, b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
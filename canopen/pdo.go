@@ -0,0 +1,217 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/knieriem/can"
+)
+
+// MappedObject is an entry of a PDO mapping.
+type MappedObject struct {
+	Index uint16
+	Sub   uint8
+	Bits  uint8
+}
+
+func parseMappedObject(v uint32) MappedObject {
+	return MappedObject{Index: uint16(v >> 16), Sub: uint8(v >> 8), Bits: uint8(v)}
+}
+
+func (o MappedObject) value() uint32 {
+	return uint32(o.Index)<<16 | uint32(o.Sub)<<8 | uint32(o.Bits)
+}
+
+// PDOKind distinguishes receive and transmit PDOs,
+// as seen from the node.
+type PDOKind int
+
+const (
+	RPDO PDOKind = iota
+	TPDO
+)
+
+// commIndex returns the index of the communication
+// parameter object of the PDO with number n.
+func (k PDOKind) commIndex(n int) uint16 {
+	if k == TPDO {
+		return 0x1800 + uint16(n-1)
+	}
+	return 0x1400 + uint16(n-1)
+}
+
+func (k PDOKind) mapIndex(n int) uint16 {
+	return k.commIndex(n) + 0x200
+}
+
+// PDO defines the identifier and the mapping of a process data object.
+type PDO struct {
+	COBID    uint32
+	Disabled bool
+	Mapping  []MappedObject
+}
+
+// pdoInvalid is the flag of the COB-ID in the PDO
+// communication parameters that disables the PDO.
+const pdoInvalid = 1 << 31
+
+var ErrPDOMapping = errors.New("canopen: PDO mapping exceeds 64 bits")
+
+func (p *PDO) bits() (int, error) {
+	n := 0
+	for _, o := range p.Mapping {
+		n += int(o.Bits)
+	}
+	if n > 64 {
+		return 0, ErrPDOMapping
+	}
+	return n, nil
+}
+
+// Encode packs values, one for each mapped object, into PDO data.
+func (p *PDO) Encode(values []uint64) ([]byte, error) {
+	n, err := p.bits()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(p.Mapping) {
+		return nil, fmt.Errorf("canopen: got %d PDO values, want %d", len(values), len(p.Mapping))
+	}
+	var acc uint64
+	off := 0
+	for i, o := range p.Mapping {
+		acc |= (values[i] & mask(o.Bits)) << off
+		off += int(o.Bits)
+	}
+	b := binary.LittleEndian.AppendUint64(nil, acc)
+	return b[:(n+7)/8], nil
+}
+
+// Decode extracts the values of the mapped objects from PDO data.
+// Values of signed objects must be sign-extended by the caller.
+func (p *PDO) Decode(data []byte) ([]uint64, error) {
+	n, err := p.bits()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < (n+7)/8 {
+		return nil, fmt.Errorf("canopen: PDO data too short (%d bytes)", len(data))
+	}
+	var b [8]byte
+	copy(b[:], data)
+	acc := binary.LittleEndian.Uint64(b[:])
+	values := make([]uint64, len(p.Mapping))
+	for i, o := range p.Mapping {
+		values[i] = acc & mask(o.Bits)
+		acc >>= o.Bits
+	}
+	return values, nil
+}
+
+func mask(bits uint8) uint64 {
+	if bits >= 64 {
+		return ^uint64(0)
+	}
+	return 1<<bits - 1
+}
+
+// ReadPDO reads the communication and mapping parameters of
+// the receive or transmit PDO with number n (starting at 1)
+// from a node.
+func (m *Master) ReadPDO(node uint8, kind PDOKind, n int) (*PDO, error) {
+	cob, err := m.uploadU32(node, kind.commIndex(n), 1)
+	if err != nil {
+		return nil, err
+	}
+	p := new(PDO)
+	p.COBID = cob & 0x1FFFFFFF
+	p.Disabled = cob&pdoInvalid != 0
+
+	num, err := m.Upload(node, kind.mapIndex(n), 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(num) != 1 {
+		return nil, ErrSDOProtocol
+	}
+	for i := range num[0] {
+		v, err := m.uploadU32(node, kind.mapIndex(n), i+1)
+		if err != nil {
+			return nil, err
+		}
+		p.Mapping = append(p.Mapping, parseMappedObject(v))
+	}
+	return p, nil
+}
+
+// ConfigurePDO writes the communication and mapping parameters
+// of a PDO to a node, following the procedure defined in CiA 301:
+// the PDO is disabled while the mapping is changed.
+func (m *Master) ConfigurePDO(node uint8, kind PDOKind, n int, p *PDO) error {
+	if _, err := p.bits(); err != nil {
+		return err
+	}
+	comm, mapIdx := kind.commIndex(n), kind.mapIndex(n)
+	cob := p.COBID | pdoInvalid
+	if err := m.downloadU32(node, comm, 1, cob); err != nil {
+		return err
+	}
+	if err := m.Download(node, mapIdx, 0, []byte{0}); err != nil {
+		return err
+	}
+	for i, o := range p.Mapping {
+		if err := m.downloadU32(node, mapIdx, uint8(i+1), o.value()); err != nil {
+			return err
+		}
+	}
+	if err := m.Download(node, mapIdx, 0, []byte{byte(len(p.Mapping))}); err != nil {
+		return err
+	}
+	if p.Disabled {
+		return nil
+	}
+	return m.downloadU32(node, comm, 1, p.COBID)
+}
+
+func (m *Master) uploadU32(node uint8, index uint16, sub uint8) (uint32, error) {
+	b, err := m.Upload(node, index, sub)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 4 {
+		return 0, ErrSDOProtocol
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (m *Master) downloadU32(node uint8, index uint16, sub uint8, v uint32) error {
+	return m.Download(node, index, sub, binary.LittleEndian.AppendUint32(nil, v))
+}
+
+// SendPDO encodes values according to the mapping of p,
+// and sends them using the PDO's COB-ID.
+func (m *Master) SendPDO(p *PDO, values []uint64) error {
+	data, err := p.Encode(values)
+	if err != nil {
+		return err
+	}
+	return m.send(p.COBID, data, false)
+}
+
+// HandlePDO registers a function that is called with the decoded
+// values whenever the PDO is received. If f is nil, a previously
+// registered function is removed.
+func (m *Master) HandlePDO(p *PDO, f func(values []uint64, t can.Time)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f == nil {
+		delete(m.pdo, p.COBID)
+		return
+	}
+	m.pdo[p.COBID] = func(data []byte, t can.Time) {
+		if values, err := p.Decode(data); err == nil {
+			f(values, t)
+		}
+	}
+}
@@ -0,0 +1,479 @@
+package canopen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AbortCode is an SDO abort code.
+type AbortCode uint32
+
+const (
+	AbortToggle        AbortCode = 0x05030000
+	AbortTimeout       AbortCode = 0x05040000
+	AbortCommand       AbortCode = 0x05040001
+	AbortBlockSize     AbortCode = 0x05040002
+	AbortSeqNo         AbortCode = 0x05040003
+	AbortCRC           AbortCode = 0x05040004
+	AbortOutOfMemory   AbortCode = 0x05040005
+	AbortAccess        AbortCode = 0x06010000
+	AbortWriteOnly     AbortCode = 0x06010001
+	AbortReadOnly      AbortCode = 0x06010002
+	AbortNoObject      AbortCode = 0x06020000
+	AbortNoMap         AbortCode = 0x06040041
+	AbortMapLength     AbortCode = 0x06040042
+	AbortParamIncompat AbortCode = 0x06040043
+	AbortDevIncompat   AbortCode = 0x06040047
+	AbortHardware      AbortCode = 0x06060000
+	AbortLength        AbortCode = 0x06070010
+	AbortLengthHigh    AbortCode = 0x06070012
+	AbortLengthLow     AbortCode = 0x06070013
+	AbortNoSubIndex    AbortCode = 0x06090011
+	AbortValue         AbortCode = 0x06090030
+	AbortValueHigh     AbortCode = 0x06090031
+	AbortValueLow      AbortCode = 0x06090032
+	AbortMaxLessMin    AbortCode = 0x06090036
+	AbortNoResource    AbortCode = 0x060A0023
+	AbortGeneral       AbortCode = 0x08000000
+	AbortDataStore     AbortCode = 0x08000020
+	AbortDataLocal     AbortCode = 0x08000021
+	AbortDataState     AbortCode = 0x08000022
+	AbortNoOD          AbortCode = 0x08000023
+	AbortNoData        AbortCode = 0x08000024
+)
+
+var abortText = map[AbortCode]string{
+	AbortToggle:        "toggle bit not alternated",
+	AbortTimeout:       "SDO protocol timed out",
+	AbortCommand:       "command specifier not valid or unknown",
+	AbortBlockSize:     "invalid block size",
+	AbortSeqNo:         "invalid sequence number",
+	AbortCRC:           "CRC error",
+	AbortOutOfMemory:   "out of memory",
+	AbortAccess:        "unsupported access to an object",
+	AbortWriteOnly:     "attempt to read a write only object",
+	AbortReadOnly:      "attempt to write a read only object",
+	AbortNoObject:      "object does not exist",
+	AbortNoMap:         "object cannot be mapped to the PDO",
+	AbortMapLength:     "mapped objects would exceed PDO length",
+	AbortParamIncompat: "general parameter incompatibility",
+	AbortDevIncompat:   "general internal incompatibility in the device",
+	AbortHardware:      "access failed due to a hardware error",
+	AbortLength:        "data type does not match, length of service parameter does not match",
+	AbortLengthHigh:    "data type does not match, length of service parameter too high",
+	AbortLengthLow:     "data type does not match, length of service parameter too low",
+	AbortNoSubIndex:    "sub-index does not exist",
+	AbortValue:         "invalid value for parameter",
+	AbortValueHigh:     "value of parameter written too high",
+	AbortValueLow:      "value of parameter written too low",
+	AbortMaxLessMin:    "maximum value is less than minimum value",
+	AbortNoResource:    "resource not available: SDO connection",
+	AbortGeneral:       "general error",
+	AbortDataStore:     "data cannot be transferred or stored to the application",
+	AbortDataLocal:     "data cannot be transferred or stored because of local control",
+	AbortDataState:     "data cannot be transferred or stored because of the present device state",
+	AbortNoOD:          "no object dictionary present",
+	AbortNoData:        "no data available",
+}
+
+func (c AbortCode) String() string {
+	if s, ok := abortText[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("abort code %08X", uint32(c))
+}
+
+// SDOAbortError is returned if an SDO transfer has been aborted by the server.
+type SDOAbortError struct {
+	Index uint16
+	Sub   uint8
+	Code  AbortCode
+}
+
+func (e *SDOAbortError) Error() string {
+	return fmt.Sprintf("canopen: SDO %04X:%02X: %v", e.Index, e.Sub, e.Code)
+}
+
+var (
+	ErrSDOTimeout  = errors.New("canopen: SDO timeout")
+	ErrSDOProtocol = errors.New("canopen: SDO protocol error")
+)
+
+// sdoChannel receives the SDO responses of a node.
+type sdoChannel struct {
+	mu sync.Mutex // serializes transfers
+	c  chan []byte
+}
+
+func (ch *sdoChannel) put(data []byte) {
+	select {
+	case ch.c <- data:
+	default:
+	}
+}
+
+// sdoTransfer is an SDO transfer in progress.
+type sdoTransfer struct {
+	m     *Master
+	node  uint8
+	index uint16
+	sub   uint8
+	ch    *sdoChannel
+}
+
+// startSDO prepares an SDO transfer; the returned function must be
+// called when the transfer is finished.
+func (m *Master) startSDO(node uint8, index uint16, sub uint8) (*sdoTransfer, func()) {
+	m.mu.Lock()
+	ch := m.sdo[node]
+	if ch == nil {
+		ch = &sdoChannel{c: make(chan []byte, 256)}
+		m.sdo[node] = ch
+	}
+	m.mu.Unlock()
+
+	ch.mu.Lock()
+	for len(ch.c) != 0 {
+		<-ch.c
+	}
+	t := &sdoTransfer{m: m, node: node, index: index, sub: sub, ch: ch}
+	return t, ch.mu.Unlock
+}
+
+func (t *sdoTransfer) send(data []byte) error {
+	b := make([]byte, 8)
+	copy(b, data)
+	return t.m.send(cobSDORx+uint32(t.node), b, false)
+}
+
+// initiate sends an initiate request containing the multiplexer.
+func (t *sdoTransfer) initiate(cmd byte, data []byte) error {
+	b := []byte{cmd, byte(t.index), byte(t.index >> 8), t.sub}
+	return t.send(append(b, data...))
+}
+
+func (t *sdoTransfer) abort(code AbortCode) {
+	b := []byte{0x80, byte(t.index), byte(t.index >> 8), t.sub}
+	t.send(binary.LittleEndian.AppendUint32(b, uint32(code)))
+}
+
+// protocolError aborts the transfer and returns ErrSDOProtocol.
+func (t *sdoTransfer) protocolError(code AbortCode) error {
+	t.abort(code)
+	return ErrSDOProtocol
+}
+
+// resp waits for the next response of the server.
+func (t *sdoTransfer) resp() ([]byte, error) {
+	timeout := t.m.cfg.SDOTimeout
+	if timeout == 0 {
+		timeout = time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	var r []byte
+	select {
+	case r = <-t.ch.c:
+	case <-timer.C:
+		t.abort(AbortTimeout)
+		return nil, ErrSDOTimeout
+	case <-t.m.done:
+		return nil, errClosed
+	case <-t.m.readDone:
+		return nil, t.m.readErr
+	}
+	if len(r) < 8 {
+		return nil, t.protocolError(AbortCommand)
+	}
+	if r[0] == 0x80 {
+		return nil, &SDOAbortError{Index: t.index, Sub: t.sub, Code: AbortCode(binary.LittleEndian.Uint32(r[4:]))}
+	}
+	return r, nil
+}
+
+// initResp waits for an initiate response, which must match
+// the command specifier bits selected by mask, and the multiplexer.
+func (t *sdoTransfer) initResp(mask, cmd byte) ([]byte, error) {
+	r, err := t.resp()
+	if err != nil {
+		return nil, err
+	}
+	if r[0]&mask != cmd {
+		return nil, t.protocolError(AbortCommand)
+	}
+	if binary.LittleEndian.Uint16(r[1:]) != t.index || r[3] != t.sub {
+		return nil, t.protocolError(AbortParamIncompat)
+	}
+	return r, nil
+}
+
+// Upload reads the value of an object from a node, using an
+// expedited or segmented transfer, as selected by the server.
+func (m *Master) Upload(node uint8, index uint16, sub uint8) ([]byte, error) {
+	t, done := m.startSDO(node, index, sub)
+	defer done()
+
+	if err := t.initiate(0x40, nil); err != nil {
+		return nil, err
+	}
+	r, err := t.initResp(0xE0, 0x40)
+	if err != nil {
+		return nil, err
+	}
+	if r[0]&2 != 0 {
+		// expedited
+		n := 4
+		if r[0]&1 != 0 {
+			n -= int(r[0] >> 2 & 3)
+		}
+		return r[4 : 4+n], nil
+	}
+	size := -1
+	if r[0]&1 != 0 {
+		size = int(binary.LittleEndian.Uint32(r[4:]))
+	}
+
+	var data []byte
+	toggle := byte(0)
+	for {
+		if err := t.send([]byte{0x60 | toggle<<4}); err != nil {
+			return nil, err
+		}
+		r, err := t.resp()
+		if err != nil {
+			return nil, err
+		}
+		if r[0]&0xE0 != 0x00 {
+			return nil, t.protocolError(AbortCommand)
+		}
+		if r[0]>>4&1 != toggle {
+			return nil, t.protocolError(AbortToggle)
+		}
+		n := 7 - int(r[0]>>1&7)
+		data = append(data, r[1:1+n]...)
+		if r[0]&1 != 0 {
+			break
+		}
+		toggle ^= 1
+	}
+	if size >= 0 && len(data) != size {
+		return nil, t.protocolError(AbortLength)
+	}
+	return data, nil
+}
+
+// Download writes the value of an object of a node, using an
+// expedited transfer for up to four bytes, and a segmented transfer
+// otherwise.
+func (m *Master) Download(node uint8, index uint16, sub uint8, data []byte) error {
+	t, done := m.startSDO(node, index, sub)
+	defer done()
+
+	if len(data) <= 4 && len(data) != 0 {
+		if err := t.initiate(0x23|byte(4-len(data))<<2, data); err != nil {
+			return err
+		}
+		_, err := t.initResp(0xE0, 0x60)
+		return err
+	}
+	err := t.initiate(0x21, binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	if err != nil {
+		return err
+	}
+	if _, err := t.initResp(0xE0, 0x60); err != nil {
+		return err
+	}
+	toggle := byte(0)
+	for {
+		n := min(len(data), 7)
+		cmd := toggle<<4 | byte(7-n)<<1
+		if n == len(data) {
+			cmd |= 1
+		}
+		if err := t.send(append([]byte{cmd}, data[:n]...)); err != nil {
+			return err
+		}
+		r, err := t.resp()
+		if err != nil {
+			return err
+		}
+		if r[0]&0xE0 != 0x20 {
+			return t.protocolError(AbortCommand)
+		}
+		if r[0]>>4&1 != toggle {
+			return t.protocolError(AbortToggle)
+		}
+		data = data[n:]
+		if cmd&1 != 0 {
+			return nil
+		}
+		toggle ^= 1
+	}
+}
+
+// BlockDownload writes the value of an object of a node using
+// an SDO block transfer, which is efficient for large amounts
+// of data. The data is protected by a CRC, if supported by the
+// server.
+func (m *Master) BlockDownload(node uint8, index uint16, sub uint8, data []byte) error {
+	t, done := m.startSDO(node, index, sub)
+	defer done()
+
+	err := t.initiate(0xC6, binary.LittleEndian.AppendUint32(nil, uint32(len(data))))
+	if err != nil {
+		return err
+	}
+	r, err := t.initResp(0xE3, 0xA0)
+	if err != nil {
+		return err
+	}
+	serverCRC := r[0]&4 != 0
+	blksize := int(r[4])
+	if blksize < 1 || blksize > 127 {
+		return t.protocolError(AbortBlockSize)
+	}
+
+	nseg := max((len(data)+6)/7, 1)
+	start := 0 // index of the first segment of the current block
+	for start < nseg {
+		seq := 0
+		for seq < blksize && start+seq < nseg {
+			i := start + seq
+			seg := data[i*7 : min(i*7+7, len(data))]
+			seq++
+			cmd := byte(seq)
+			if i == nseg-1 {
+				cmd |= 0x80
+			}
+			if err := t.send(append([]byte{cmd}, seg...)); err != nil {
+				return err
+			}
+		}
+		r, err := t.resp()
+		if err != nil {
+			return err
+		}
+		if r[0]&0xE3 != 0xA2 {
+			return t.protocolError(AbortCommand)
+		}
+		ackseq := int(r[1])
+		if ackseq > seq {
+			return t.protocolError(AbortSeqNo)
+		}
+		start += ackseq
+		blksize = int(r[2])
+		if blksize < 1 || blksize > 127 {
+			return t.protocolError(AbortBlockSize)
+		}
+	}
+
+	unused := nseg*7 - len(data)
+	var crc uint16
+	if serverCRC {
+		crc = crc16(0, data)
+	}
+	cmd := 0xC1 | byte(unused)<<2
+	if err := t.send([]byte{cmd, byte(crc), byte(crc >> 8)}); err != nil {
+		return err
+	}
+	r, err = t.resp()
+	if err != nil {
+		return err
+	}
+	if r[0]&0xE3 != 0xA1 {
+		return t.protocolError(AbortCommand)
+	}
+	return nil
+}
+
+// BlockUpload reads the value of an object from a node using
+// an SDO block transfer.
+func (m *Master) BlockUpload(node uint8, index uint16, sub uint8) ([]byte, error) {
+	t, done := m.startSDO(node, index, sub)
+	defer done()
+
+	blksize := m.cfg.BlockSize
+	if blksize == 0 || blksize > 127 {
+		blksize = 127
+	}
+	if err := t.initiate(0xA4, []byte{blksize, 0}); err != nil {
+		return nil, err
+	}
+	r, err := t.initResp(0xE1, 0xC0)
+	if err != nil {
+		return nil, err
+	}
+	serverCRC := r[0]&4 != 0
+	size := -1
+	if r[0]&2 != 0 {
+		size = int(binary.LittleEndian.Uint32(r[4:]))
+	}
+	if err := t.send([]byte{0xA3}); err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for last := false; !last; {
+		expected := 1
+		for {
+			r, err := t.resp()
+			if err != nil {
+				return nil, err
+			}
+			seq := int(r[0] & 0x7F)
+			if seq == expected {
+				data = append(data, r[1:8]...)
+				expected++
+				last = r[0]&0x80 != 0
+			}
+			if seq == int(blksize) || r[0]&0x80 != 0 {
+				break
+			}
+		}
+		if err := t.send([]byte{0xA2, byte(expected - 1), blksize}); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err = t.resp()
+	if err != nil {
+		return nil, err
+	}
+	if r[0]&0xE1 != 0xC1 {
+		return nil, t.protocolError(AbortCommand)
+	}
+	unused := int(r[0] >> 2 & 7)
+	if unused > len(data) {
+		return nil, t.protocolError(AbortLength)
+	}
+	data = data[:len(data)-unused]
+	if size >= 0 && len(data) != size {
+		return nil, t.protocolError(AbortLength)
+	}
+	if serverCRC && binary.LittleEndian.Uint16(r[1:]) != crc16(0, data) {
+		return nil, t.protocolError(AbortCRC)
+	}
+	if err := t.send([]byte{0xA1}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// crc16 computes the CRC used by SDO block transfers
+// (CCITT polynomial 0x1021, initial value 0).
+func crc16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,43 @@
+package canopen
+
+import (
+	"sync"
+	"time"
+)
+
+// StartSync starts a goroutine that sends SYNC messages periodically.
+// If counterOverflow is greater than 1, the messages contain a
+// counter that runs from 1 to counterOverflow. The returned function
+// stops the goroutine.
+func (m *Master) StartSync(period time.Duration, counterOverflow int) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		t := time.NewTicker(period)
+		defer t.Stop()
+		counter := 0
+		for {
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			case <-m.done:
+				return
+			}
+			var data []byte
+			if counterOverflow > 1 {
+				counter = counter%counterOverflow + 1
+				data = []byte{byte(counter)}
+			}
+			m.send(cobSync, data, false)
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
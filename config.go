@@ -14,9 +14,24 @@ import (
 type Config struct {
 	Nominal BitTimingConfig
 	Data    Optional[BitTimingConfig]
+	XLData  Optional[BitTimingConfig]
 
 	Termination Optional[bool]
 	FDMode      Optional[bool]
+	XLMode      Optional[bool]
+
+	// Controller mode options, see [CtrlMode].
+	ListenOnly     Optional[bool]
+	Loopback       Optional[bool]
+	OneShot        Optional[bool]
+	TripleSampling Optional[bool]
+	PresumeAck     Optional[bool]
+	FDNonISO       Optional[bool]
+
+	// RestartDelay is the delay after which the controller
+	// is restarted automatically after a bus-off condition;
+	// zero disables automatic restarts.
+	RestartDelay Optional[time.Duration]
 
 	MsgFilter []MsgFilter
 }
@@ -99,6 +114,95 @@ func (conf *Config) ResolveFDMode(fdCapable bool) (isFD bool, err error) {
 
 var ErrFDNotSupported = Error("FD mode not supported")
 
+// ResolveXLMode determines whether a Config requests CAN XL mode,
+// factoring in the hardware's XL capability, like ResolveFDMode
+// does for FD mode, based on the XLMode and XLData options.
+func (conf *Config) ResolveXLMode(xlCapable bool) (isXL bool, err error) {
+	if conf.XLMode.Valid && conf.XLMode.Value || conf.XLData.Valid {
+		if !xlCapable {
+			if conf.XLMode.Soft || !conf.XLMode.Valid && conf.XLData.Soft {
+				return false, nil
+			}
+			return false, ErrXLNotSupported
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+var ErrXLNotSupported = Error("XL mode not supported")
+
+// CtrlMode identifies a controller mode option of a Config.
+type CtrlMode int
+
+const (
+	ModeListenOnly     CtrlMode = iota // listen-only (silent) mode, no ACKs or error frames sent
+	ModeLoopback                       // internal loopback
+	ModeOneShot                        // no retransmission of frames
+	ModeTripleSampling                 // triple sampling of bits
+	ModePresumeAck                     // ignore missing ACKs
+	ModeFDNonISO                       // non-ISO CAN FD (Bosch FD 1.0)
+	numCtrlModes
+)
+
+var ctrlModeKeys = [numCtrlModes]string{
+	ModeListenOnly:     "listen-only",
+	ModeLoopback:       "loopback",
+	ModeOneShot:        "one-shot",
+	ModeTripleSampling: "triple-sampling",
+	ModePresumeAck:     "presume-ack",
+	ModeFDNonISO:       "fd-non-iso",
+}
+
+func (m CtrlMode) String() string {
+	if m < 0 || m >= numCtrlModes {
+		return "mode(" + strconv.Itoa(int(m)) + ")"
+	}
+	return ctrlModeKeys[m]
+}
+
+// CtrlMode returns a pointer to the option of the Config
+// corresponding to controller mode m.
+func (conf *Config) CtrlMode(m CtrlMode) *Optional[bool] {
+	switch m {
+	case ModeListenOnly:
+		return &conf.ListenOnly
+	case ModeLoopback:
+		return &conf.Loopback
+	case ModeOneShot:
+		return &conf.OneShot
+	case ModeTripleSampling:
+		return &conf.TripleSampling
+	case ModePresumeAck:
+		return &conf.PresumeAck
+	case ModeFDNonISO:
+		return &conf.FDNonISO
+	}
+	return nil
+}
+
+// ResolveCtrlModes checks the enabled controller mode options of a Config
+// against the modes supported by the hardware, as reported by the supported
+// function. Like in ResolveFDMode, a "soft" request of an unsupported mode
+// is not an error; the option is reset in this case, updating the Config
+// in-place. A strict request of an unsupported mode results in an
+// ErrCtrlModeNotSupported.
+func (conf *Config) ResolveCtrlModes(supported func(CtrlMode) bool) error {
+	for m := range numCtrlModes {
+		opt := conf.CtrlMode(m)
+		if !opt.Valid || !opt.Value || supported(m) {
+			continue
+		}
+		if !opt.Soft {
+			return fmt.Errorf("%s: %w", m, ErrCtrlModeNotSupported)
+		}
+		*opt = Optional[bool]{}
+	}
+	return nil
+}
+
+var ErrCtrlModeNotSupported = Error("controller mode not supported")
+
 // ParseConfSpecs parses CAN adapter configuration specifications.
 // The strings may contain space separated parameter settings.
 //
@@ -153,6 +257,27 @@ var ErrFDNotSupported = Error("FD mode not supported")
 //		A boolean parameter deciding whether the CAN adapter should be run
 //		in CAN 2.0 mode or FD mode.
 //
+//	xdb - CAN XL data bit timing, optionally with a sample point, and SJW
+//
+//		A bit timing expr, like the value of "db".
+//
+//	xl - CAN XL mode
+//
+//		A boolean parameter deciding whether the CAN adapter should be run
+//		in CAN XL mode; it is implied if a XL data bit timing is specified.
+//
+//	restart - automatic restart delay after bus-off, in milliseconds
+//
+//		A value of 0 disables automatic restarts, requiring the
+//		controller to be restarted manually (see [Restarter]).
+//		Example: restart:100
+//
+//	listen-only, loopback, one-shot, triple-sampling, presume-ack, fd-non-iso
+//
+//		Boolean parameters enabling controller modes, see [CtrlMode].
+//		Like "fd", they may be suffixed by "?", in which case the
+//		mode is not enabled if it is not supported by the adapter.
+//
 //	f - CAN message filter
 //
 //		A value has the form:  id ":" mask,
@@ -204,6 +329,12 @@ func ParseConfig(specs ...string) (*Config, error) {
 			c.FDMode.Soft = c.Data.Soft
 		}
 	}
+	if c.XLData.Valid {
+		if !c.XLMode.Valid {
+			c.XLMode.Set(true)
+			c.XLMode.Soft = c.XLData.Soft
+		}
+	}
 	if c.Nominal.isUnset() {
 		return nil, errors.New("missing nominal bitrate")
 	}
@@ -260,6 +391,29 @@ func (c *Config) fromSpec(s string) error {
 		}
 		c.FDMode.Soft = soft
 		allowSoft = true
+	case "xdb":
+		err := c.XLData.Value.fromString(value)
+		if err != nil {
+			return err
+		}
+		c.XLData.Valid = true
+		c.XLData.Soft = soft
+		allowSoft = true
+	case "xl":
+		err := parseBoolInt(&c.XLMode, value)
+		if err != nil {
+			return err
+		}
+		c.XLMode.Soft = soft
+		allowSoft = true
+	case "restart":
+		ms, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("parsing %q: %w", key, err)
+		}
+		c.RestartDelay.Set(time.Duration(ms) * time.Millisecond)
+		c.RestartDelay.Soft = soft
+		allowSoft = true
 	case "f":
 		f, err := parseMsgFilter(value)
 		if err != nil {
@@ -273,6 +427,18 @@ func (c *Config) fromSpec(s string) error {
 		}
 		c.Termination.Soft = soft
 		allowSoft = true
+	default:
+		i := slices.Index(ctrlModeKeys[:], key)
+		if i == -1 {
+			break
+		}
+		opt := c.CtrlMode(CtrlMode(i))
+		err := parseBoolInt(opt, value)
+		if err != nil {
+			return err
+		}
+		opt.Soft = soft
+		allowSoft = true
 	}
 	if soft && !allowSoft {
 		return fmt.Errorf("key %q may not be used with '?'", key)
@@ -293,7 +459,7 @@ func indexColon(s string) int {
 	return iColon
 }
 
-var boolKeys = []string{"fd", "T"}
+var boolKeys = append([]string{"fd", "xl", "T"}, ctrlModeKeys[:]...)
 
 func parseBoolInt(dest *Optional[bool], s string) error {
 	if s == "1" {
@@ -496,6 +662,22 @@ func (c *Config) Format(sep string) string {
 	if !skipFD {
 		enc.addOptBool("fd", c.FDMode)
 	}
+	skipXL := false
+	if c.XLData.Valid {
+		enc.addValue("xdb", c.XLData.Value.String())
+		if c.XLMode.Valid && c.XLMode.Value {
+			skipXL = true
+		}
+	}
+	if !skipXL {
+		enc.addOptBool("xl", c.XLMode)
+	}
+	for m := range numCtrlModes {
+		enc.addOptBool(ctrlModeKeys[m], *c.CtrlMode(m))
+	}
+	if c.RestartDelay.Valid {
+		enc.addValue("restart", strconv.FormatInt(c.RestartDelay.Value.Milliseconds(), 10))
+	}
 	enc.addOptBool("T", c.Termination)
 	return strings.Join(enc.buf, sep)
 }
@@ -562,7 +744,7 @@ func (enc *configEncoder) addOptBool(key string, opt Optional[bool]) {
 }
 
 // ResolveBittiming calls Resolve on the nominal and, if requested and
-// supported, the data [BitTimingConfig] fields, updating the
+// supported, the data and XL data [BitTimingConfig] fields, updating the
 // Config in-place. The function returns any error received from any
 // of the Resolve calls.
 func (conf *Config) ResolveBitTiming(ctl *timing.Controller) error {
@@ -587,6 +769,17 @@ func (conf *Config) ResolveBitTiming(ctl *timing.Controller) error {
 			return err
 		}
 	}
+	wantXL := (conf.XLMode.Valid && conf.XLMode.Value) || conf.XLData.Valid
+	if wantXL && ctl.XLData != nil {
+		if !conf.XLData.Valid {
+			conf.XLData.Value = conf.Nominal
+			conf.XLData.Valid = true
+		}
+		err := conf.XLData.Value.Resolve(nil, ctl.Clock, ctl.XLData)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
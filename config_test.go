@@ -1,6 +1,7 @@
 package can
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 	"time"
@@ -54,6 +55,19 @@ func deepEqualConfig(a, b *Config) bool {
 		}
 	}
 
+	// XL data BitTimingConfig comparison
+	if a.XLData.Valid != b.XLData.Valid {
+		return false
+	}
+	if a.XLData.Valid {
+		if a.XLData.Value.Bitrate != b.XLData.Value.Bitrate ||
+			a.XLData.Value.SamplePoint != b.XLData.Value.SamplePoint ||
+			a.XLData.Value.Tq != b.XLData.Value.Tq ||
+			!reflect.DeepEqual(a.XLData.Value.BitTiming, b.XLData.Value.BitTiming) {
+			return false
+		}
+	}
+
 	// Optional[bool] fields
 	if a.Termination.Valid != b.Termination.Valid ||
 		(a.Termination.Valid && a.Termination.Value != b.Termination.Value) {
@@ -63,6 +77,18 @@ func deepEqualConfig(a, b *Config) bool {
 		(a.FDMode.Valid && a.FDMode.Value != b.FDMode.Value) {
 		return false
 	}
+	if a.XLMode.Valid != b.XLMode.Valid ||
+		(a.XLMode.Valid && a.XLMode.Value != b.XLMode.Value) {
+		return false
+	}
+	for m := range numCtrlModes {
+		if *a.CtrlMode(m) != *b.CtrlMode(m) {
+			return false
+		}
+	}
+	if a.RestartDelay != b.RestartDelay {
+		return false
+	}
 
 	return true
 }
@@ -171,6 +197,71 @@ func TestParseConfig(t *testing.T) {
 			},
 			wantFmt: "500k db:2M@.75:s4 T",
 		},
+		{
+			name:  "xl data bitrate",
+			input: "500k db:2M xdb:10M@.7",
+			want: &Config{
+				Nominal: newBitTimingConfig(500e3, 0, 0, 0, 0, 0, 0, 0),
+				Data:    Optional[BitTimingConfig]{Valid: true, Value: newBitTimingConfig(2e6, 0, 0, 0, 0, 0, 0, 0)},
+				XLData:  Optional[BitTimingConfig]{Valid: true, Value: newBitTimingConfig(10e6, 700, 0, 0, 0, 0, 0, 0)},
+				FDMode:  newOptionalBool(true),
+				XLMode:  newOptionalBool(true),
+			},
+			wantFmt: "500k db:2M xdb:10M@.7",
+		},
+		{
+			name:  "soft xl mode",
+			input: "1M xl?",
+			want: &Config{
+				Nominal: newBitTimingConfig(1e6, 0, 0, 0, 0, 0, 0, 0),
+				XLMode:  Optional[bool]{Valid: true, Soft: true, Value: true},
+			},
+			wantFmt: "1M xl",
+		},
+		{
+			name:  "xl disabled",
+			input: "1M xl:0",
+			want: &Config{
+				Nominal: newBitTimingConfig(1e6, 0, 0, 0, 0, 0, 0, 0),
+				XLMode:  newOptionalBool(false),
+			},
+			wantFmt: "1M xl:0",
+		},
+		{
+			name:  "restart delay",
+			input: "250k restart:100",
+			want: &Config{
+				Nominal:      newBitTimingConfig(250e3, 0, 0, 0, 0, 0, 0, 0),
+				RestartDelay: Optional[time.Duration]{Valid: true, Value: 100 * time.Millisecond},
+			},
+			wantFmt: "250k restart:100",
+		},
+		{
+			name:  "soft restart delay without colon",
+			input: "250k restart?0",
+			want: &Config{
+				Nominal:      newBitTimingConfig(250e3, 0, 0, 0, 0, 0, 0, 0),
+				RestartDelay: Optional[time.Duration]{Valid: true, Soft: true},
+			},
+			wantFmt: "250k restart:0",
+		},
+		{
+			name:    "invalid restart delay",
+			input:   "250k restart:1s",
+			wantErr: true,
+		},
+		{
+			name:  "controller modes",
+			input: "500k listen-only? one-shot triple-sampling:0 restart:0",
+			want: &Config{
+				Nominal:        newBitTimingConfig(500e3, 0, 0, 0, 0, 0, 0, 0),
+				ListenOnly:     Optional[bool]{Valid: true, Soft: true, Value: true},
+				OneShot:        newOptionalBool(true),
+				TripleSampling: newOptionalBool(false),
+				RestartDelay:   Optional[time.Duration]{Valid: true},
+			},
+			wantFmt: "500k listen-only one-shot triple-sampling:0 restart:0",
+		},
 		{
 			name:    "invalid key",
 			input:   "invalid",
@@ -210,3 +301,33 @@ func TestParseConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveCtrlModes(t *testing.T) {
+	onlyLoopback := func(m CtrlMode) bool { return m == ModeLoopback }
+	for _, tc := range []struct {
+		input string
+		want  *Config
+		err   error
+	}{
+		{input: "500k loopback listen-only?", want: &Config{Loopback: newOptionalBool(true)}},
+		{input: "500k listen-only:0", want: &Config{ListenOnly: newOptionalBool(false)}},
+		{input: "500k listen-only", err: ErrCtrlModeNotSupported},
+	} {
+		conf, err := ParseConfig(tc.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = conf.ResolveCtrlModes(onlyLoopback)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: got error %v", tc.input, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		tc.want.Nominal = conf.Nominal
+		if !deepEqualConfig(conf, tc.want) {
+			t.Errorf("%s: got %+v", tc.input, conf)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Package dbc reads CAN database files in the Vector DBC format,
+// and decodes and encodes the signals of CAN messages.
+package dbc
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/knieriem/can"
+)
+
+var (
+	ErrUnknownMsg    = errors.New("dbc: unknown message")
+	ErrUnknownSignal = errors.New("dbc: unknown signal")
+)
+
+// Database contains the definitions read from a DBC file.
+type Database struct {
+	Version string
+	Nodes   []*Node
+
+	// Messages are stored in the order of their definition.
+	Messages []*Message
+
+	// ValueTables contains the global value tables (VAL_TABLE_).
+	ValueTables map[string]ValueTable
+
+	// AttrDefs contains the attribute definitions (BA_DEF_),
+	// including their defaults (BA_DEF_DEF_).
+	AttrDefs map[string]*AttrDef
+
+	// Comment and Attributes refer to the network as a whole.
+	Comment    string
+	Attributes map[string]any
+
+	byID map[uint32]*Message
+}
+
+// Node is a network node (ECU), as listed in the BU_ section.
+type Node struct {
+	Name       string
+	Comment    string
+	Attributes map[string]any
+}
+
+// Message is a CAN message definition.
+type Message struct {
+	// ID is the CAN identifier, without the bit DBC files
+	// use to mark extended frames.
+	ID       uint32
+	Extended bool
+	Name     string
+
+	// Size is the length of the data field in bytes. Lengths
+	// larger than 8 are valid if they are listed in [can.ValidFDSizes].
+	Size        int
+	Transmitter string
+
+	// Transmitters contains additional transmitters (BO_TX_BU_).
+	Transmitters []string
+
+	Signals    []*Signal
+	Comment    string
+	Attributes map[string]any
+}
+
+// ValueTable maps raw signal values to descriptions.
+type ValueTable map[int64]string
+
+// AttrDef is an attribute definition. Values of INT, HEX and FLOAT
+// attributes are stored as float64, values of STRING and ENUM
+// attributes as string.
+type AttrDef struct {
+	Name string
+
+	// Object is the kind of object the attribute applies to:
+	// "" for the network, or "BU_", "BO_", "SG_", or "EV_".
+	Object string
+
+	// Type is one of "INT", "HEX", "FLOAT", "STRING", or "ENUM".
+	Type     string
+	Min, Max float64
+	Enum     []string
+	Default  any
+}
+
+// Message returns the definition of the message with the specified
+// identifier, or nil.
+func (db *Database) Message(id uint32, extended bool) *Message {
+	if extended {
+		id |= extFlag
+	}
+	return db.byID[id]
+}
+
+// MessageByName returns the definition of the message
+// with the specified name, or nil.
+func (db *Database) MessageByName(name string) *Message {
+	for _, m := range db.Messages {
+		if m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// Node returns the node with the specified name, or nil.
+func (db *Database) Node(name string) *Node {
+	for _, n := range db.Nodes {
+		if n.Name == name {
+			return n
+		}
+	}
+	return nil
+}
+
+// Attr looks up an attribute in attrs, which is the Attributes map of
+// the database, a node, message, or signal. If the attribute is not
+// set, its default value is returned.
+func (db *Database) Attr(attrs map[string]any, name string) (any, bool) {
+	if v, ok := attrs[name]; ok {
+		return v, true
+	}
+	if d := db.AttrDefs[name]; d != nil && d.Default != nil {
+		return d.Default, true
+	}
+	return nil, false
+}
+
+// Decode looks up the definition of m and decodes its active signals.
+func (db *Database) Decode(m *can.Msg) (*Message, []Value, error) {
+	msg := db.Message(m.Id, m.Flags.ExtFrame())
+	if msg == nil {
+		return nil, nil, fmt.Errorf("%w: %#x", ErrUnknownMsg, m.Id)
+	}
+	v, err := msg.Decode(m.Data())
+	if err != nil {
+		return msg, nil, err
+	}
+	return msg, v, nil
+}
+
+// Signal returns the signal with the specified name, or nil.
+func (msg *Message) Signal(name string) *Signal {
+	for _, s := range msg.Signals {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Multiplexor returns the signal that selects the multiplexed
+// signals of msg, if it is using simple multiplexing.
+// If there is no multiplexor, nil is returned.
+func (msg *Message) Multiplexor() *Signal {
+	for _, s := range msg.Signals {
+		if s.IsMultiplexor && s.MultiplexedBy == nil {
+			return s
+		}
+	}
+	return nil
+}
+
+func (msg *Message) String() string {
+	return msg.Name
+}
+
+// frameID returns the identifier as used in the DBC file.
+func (msg *Message) frameID() uint32 {
+	if msg.Extended {
+		return msg.ID | extFlag
+	}
+	return msg.ID
+}
+
+const extFlag = 1 << 31
@@ -0,0 +1,280 @@
+package dbc
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/knieriem/can"
+)
+
+const testDBC = `VERSION "1.0"
+
+NS_ :
+	NS_DESC_
+	CM_
+	BA_DEF_
+	SG_MUL_VAL_
+
+BS_:
+
+BU_: ECU Tester
+VAL_TABLE_ OnOff 1 "On" 0 "Off" ;
+
+// engine data
+BO_ 256 Engine: 8 ECU
+ SG_ Speed : 0|16@1+ (0.25,0) [0|16383.75] "rpm" Tester
+ SG_ Temp : 16|8@1- (1,-40) [-168|87] "degC" Tester,ECU
+ SG_ Pressure : 31|12@0+ (0.5,0) [0|2047.5] "kPa" Tester
+ SG_ Level : 35|4@0- (1,0) [-8|7] "" Vector__XXX
+ SG_ Switch : 48|1@1+ (1,0) [0|1] "" Tester
+
+BO_ 512 Mux: 8 ECU
+ SG_ Page M : 0|8@1+ (1,0) [0|255] "" Tester
+ SG_ A m0 : 8|16@1+ (1,0) [0|65535] "" Tester
+ SG_ B m1 : 8|8@1+ (1,0) [0|255] "" Tester
+ SG_ Sub m2M : 8|8@1+ (1,0) [0|255] "" Tester
+ SG_ C m0 : 24|8@1+ (1,0) [0|255] "" Tester
+ SG_ D : 56|8@1+ (1,0) [0|255] "" Tester
+
+BO_ 2147487744 FDData: 64 ECU
+ SG_ Value : 0|32@1- (1,0) [0|0] "" Tester
+ SG_ Real : 64|32@1- (1,0) [0|0] "V" Tester
+ SG_ Last : 511|8@0+ (1,0) [0|255] "" Tester
+
+BO_TX_BU_ 512 : Tester;
+
+CM_ "test network";
+CM_ BU_ ECU "engine control unit";
+CM_ BO_ 256 "engine
+data";
+CM_ SG_ 256 Speed "engine speed";
+BA_DEF_ BO_ "GenMsgCycleTime" INT 0 65535;
+BA_DEF_ "BusType" STRING ;
+BA_DEF_ SG_ "GenSigSendType" ENUM "Cyclic","OnChange";
+BA_DEF_DEF_ "GenMsgCycleTime" 100;
+BA_DEF_DEF_ "BusType" "CAN FD";
+BA_DEF_DEF_ "GenSigSendType" "Cyclic";
+BA_ "GenMsgCycleTime" BO_ 256 10;
+BA_ "GenSigSendType" SG_ 256 Switch 1;
+VAL_ 256 Switch 1 "On" 0 "Off" ;
+SIG_VALTYPE_ 2147487744 Real : 1;
+SG_MUL_VAL_ 512 C Page 0-0;
+SG_MUL_VAL_ 512 Sub Page 2-2;
+SG_MUL_VAL_ 512 D Sub 1-3, 10-10;
+`
+
+func parseTest(t *testing.T) *Database {
+	t.Helper()
+	db, err := Parse(strings.NewReader(testDBC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestParse(t *testing.T) {
+	db := parseTest(t)
+	if db.Version != "1.0" || len(db.Nodes) != 2 || len(db.Messages) != 3 {
+		t.Fatalf("got version %q, %d nodes, %d messages", db.Version, len(db.Nodes), len(db.Messages))
+	}
+	if db.ValueTables["OnOff"][1] != "On" {
+		t.Errorf("value table: got %v", db.ValueTables)
+	}
+	if db.Comment != "test network" || db.Node("ECU").Comment != "engine control unit" {
+		t.Error("comments not set")
+	}
+
+	msg := db.Message(256, false)
+	if msg == nil || msg.Name != "Engine" || msg.Comment != "engine\ndata" {
+		t.Fatalf("got message %+v", msg)
+	}
+	if v, _ := db.Attr(msg.Attributes, "GenMsgCycleTime"); v != 10.0 {
+		t.Errorf("GenMsgCycleTime: got %v", v)
+	}
+	if v, _ := db.Attr(db.MessageByName("Mux").Attributes, "GenMsgCycleTime"); v != 100.0 {
+		t.Errorf("GenMsgCycleTime default: got %v", v)
+	}
+	if v, _ := db.Attr(db.Attributes, "BusType"); v != "CAN FD" {
+		t.Errorf("BusType: got %v", v)
+	}
+	sw := msg.Signal("Switch")
+	if v, _ := db.Attr(sw.Attributes, "GenSigSendType"); v != "OnChange" {
+		t.Errorf("GenSigSendType: got %v", v)
+	}
+	temp := msg.Signal("Temp")
+	if !temp.Signed || temp.Offset != -40 || len(temp.Receivers) != 2 {
+		t.Errorf("got signal %+v", temp)
+	}
+	if s := msg.Signal("Speed"); s.Comment != "engine speed" || s.Unit != "rpm" {
+		t.Errorf("got signal %+v", s)
+	}
+	if s := msg.Signal("Level"); s.Receivers != nil {
+		t.Errorf("Level: got receivers %v", s.Receivers)
+	}
+	if m := db.MessageByName("Mux"); len(m.Transmitters) != 1 || m.Multiplexor().Name != "Page" {
+		t.Errorf("got message %+v", m)
+	}
+
+	fd := db.Message(0x1000, true)
+	if fd == nil || fd.Size != 64 || fd.Signal("Real").ValueType != Float32 {
+		t.Errorf("got FD message %+v", fd)
+	}
+	if db.Message(0x1000, false) != nil {
+		t.Error("extended message found using standard ID")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		dbc  string
+	}{
+		{"invalid FD size", "BO_ 1 M: 10 X\n"},
+		{"signal exceeds message", "BO_ 1 M: 2 X\n SG_ S : 8|16@1+ (1,0) [0|0] \"\" X\n"},
+		{"motorola exceeds message", "BO_ 1 M: 2 X\n SG_ S : 0|16@0+ (1,0) [0|0] \"\" X\n"},
+		{"undefined message", "CM_ BO_ 1 \"x\";\n"},
+		{"missing multiplexor", "BO_ 1 M: 8 X\n SG_ S m1 : 0|8@1+ (1,0) [0|0] \"\" X\n"},
+		{"unterminated string", "VERSION \"1.0\n"},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(strings.NewReader(tt.dbc)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+func TestSignals(t *testing.T) {
+	db := parseTest(t)
+	msg := db.MessageByName("Engine")
+
+	data := []byte{0x10, 0x27, 0xEC, 0x12, 0x3A, 0x50, 0x01, 0x00}
+	tests := []struct {
+		signal string
+		raw    uint64
+		phys   float64
+		str    string
+	}{
+		{"Speed", 0x2710, 2500, "2500 rpm"},
+		{"Temp", 0xEC, -60, "-60 degC"},
+		{"Pressure", 0x123, 145.5, "145.5 kPa"},
+		{"Level", 0xA, -6, "-6"},
+		{"Switch", 1, 1, "On"},
+	}
+	values, err := msg.Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != len(tests) {
+		t.Fatalf("got %d values", len(values))
+	}
+	for i, tt := range tests {
+		v := values[i]
+		if v.Signal.Name != tt.signal || v.Raw != tt.raw || v.Physical != tt.phys || v.String() != tt.str {
+			t.Errorf("%s: got %v (raw %#x, physical %v), want %v", tt.signal, v, v.Raw, v.Physical, tt.str)
+		}
+	}
+
+	phys := make(map[string]float64)
+	for _, tt := range tests {
+		phys[tt.signal] = tt.phys
+	}
+	enc, err := msg.Encode(phys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Clone(data)
+	want[5] = 0 // not covered by any signal
+	if !bytes.Equal(enc, want) {
+		t.Errorf("Encode: got % x, want % x", enc, want)
+	}
+
+	if _, err := msg.Encode(map[string]float64{"Temp": 100}); !errors.Is(err, ErrRange) {
+		t.Errorf("out of range: got %v", err)
+	}
+	if _, err := msg.Encode(map[string]float64{"Foo": 1}); !errors.Is(err, ErrUnknownSignal) {
+		t.Errorf("unknown signal: got %v", err)
+	}
+	if _, err := msg.Decode(data[:4]); !errors.Is(err, ErrShortData) {
+		t.Errorf("short data: got %v", err)
+	}
+}
+
+func TestMultiplexing(t *testing.T) {
+	db := parseTest(t)
+	msg := db.MessageByName("Mux")
+
+	tests := []struct {
+		values map[string]float64
+		data   []byte
+	}{
+		{map[string]float64{"Page": 0, "A": 0x1234, "C": 5},
+			[]byte{0, 0x34, 0x12, 5, 0, 0, 0, 0}},
+		{map[string]float64{"Page": 1, "B": 7},
+			[]byte{1, 7, 0, 0, 0, 0, 0, 0}},
+		{map[string]float64{"Page": 2, "Sub": 3, "D": 9},
+			[]byte{2, 3, 0, 0, 0, 0, 0, 9}},
+		{map[string]float64{"Page": 2, "Sub": 10, "D": 1},
+			[]byte{2, 10, 0, 0, 0, 0, 0, 1}},
+		{map[string]float64{"Page": 2, "Sub": 4},
+			[]byte{2, 4, 0, 0, 0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		data, err := msg.Encode(tt.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, tt.data) {
+			t.Errorf("%v: got % x, want % x", tt.values, data, tt.data)
+		}
+		got, err := msg.DecodeMap(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tt.values) {
+			t.Errorf("%v: decoded %v", tt.values, got)
+		}
+		for name, v := range tt.values {
+			if got[name] != v {
+				t.Errorf("%v: decoded %v", tt.values, got)
+			}
+		}
+	}
+
+	_, err := msg.Encode(map[string]float64{"Page": 1, "A": 1})
+	if !errors.Is(err, ErrInactiveSig) {
+		t.Errorf("inactive signal: got %v", err)
+	}
+}
+
+func TestFD(t *testing.T) {
+	db := parseTest(t)
+	msg := db.MessageByName("FDData")
+
+	var m can.Msg
+	err := msg.EncodeMsg(&m, map[string]float64{"Value": -2, "Real": 1.5, "Last": 0xAB})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Id != 0x1000 || !m.Flags.ExtFrame() || m.Flags&can.ForceFD == 0 || len(m.Data()) != 64 {
+		t.Fatalf("got %v", &m)
+	}
+	if m.Data()[63] != 0xAB || m.Data()[0] != 0xFE || m.Data()[3] != 0xFF {
+		t.Errorf("got data % x", m.Data())
+	}
+
+	dec, values, err := db.Decode(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dec != msg || values[0].Physical != -2 || values[1].Physical != 1.5 || values[1].Raw != uint64(math.Float32bits(1.5)) {
+		t.Errorf("got %v", values)
+	}
+
+	m.Flags &^= can.ExtFrame
+	if _, _, err := db.Decode(&m); !errors.Is(err, ErrUnknownMsg) {
+		t.Errorf("unknown message: got %v", err)
+	}
+}
@@ -0,0 +1,1051 @@
+package dbc
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/knieriem/can"
+)
+
+type tokKind int
+
+const (
+	tEOF tokKind = iota
+	tIdent
+	tNumber
+	tString
+	tPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+	line int
+
+	// bol is set if the token is the first on its line,
+	// col0 if it also starts in the first column.
+	bol  bool
+	col0 bool
+}
+
+func (t token) String() string {
+	if t.kind == tEOF {
+		return "end of file"
+	}
+	return strconv.Quote(t.text)
+}
+
+type lexer struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (l *lexer) next() (token, error) {
+	bol := l.pos == 0
+	col0 := bol
+	for l.pos < len(l.s) {
+		c := l.s[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+			bol = true
+			col0 = true
+			continue
+		case isSpace(c):
+			col0 = false
+			l.pos++
+			continue
+		case strings.HasPrefix(l.s[l.pos:], "//"):
+			i := strings.IndexByte(l.s[l.pos:], '\n')
+			if i == -1 {
+				l.pos = len(l.s)
+			} else {
+				l.pos += i
+			}
+			continue
+		}
+		break
+	}
+	t := token{line: l.line, bol: bol, col0: col0}
+	if l.pos == len(l.s) {
+		return t, nil
+	}
+	start := l.pos
+	c := l.s[l.pos]
+	switch {
+	case c == '"':
+		var b strings.Builder
+		l.pos++
+		for {
+			if l.pos == len(l.s) {
+				return t, fmt.Errorf("dbc: line %d: unterminated string", t.line)
+			}
+			c := l.s[l.pos]
+			l.pos++
+			if c == '"' {
+				break
+			}
+			if c == '\\' && l.pos < len(l.s) {
+				c = l.s[l.pos]
+				l.pos++
+			}
+			if c == '\n' {
+				l.line++
+			}
+			b.WriteByte(c)
+		}
+		t.kind = tString
+		t.text = b.String()
+		return t, nil
+	case isDigit(c) || (c == '-' || c == '+' || c == '.') && l.pos+1 < len(l.s) && (isDigit(l.s[l.pos+1]) || l.s[l.pos+1] == '.'):
+		l.pos++
+		for l.pos < len(l.s) {
+			c := l.s[l.pos]
+			if (c == '-' || c == '+') && (l.s[l.pos-1] == 'e' || l.s[l.pos-1] == 'E') ||
+				isDigit(c) || c == '.' || c == 'e' || c == 'E' || c == 'x' || c == 'X' ||
+				c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' {
+				l.pos++
+				continue
+			}
+			break
+		}
+		t.kind = tNumber
+	case isIdentChar(c):
+		for l.pos < len(l.s) && isIdentChar(l.s[l.pos]) {
+			l.pos++
+		}
+		t.kind = tIdent
+	default:
+		l.pos++
+		t.kind = tPunct
+	}
+	t.text = l.s[start:l.pos]
+	return t, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+type parser struct {
+	lex  lexer
+	tok  token
+	peek *token
+	db   *Database
+	msg  *Message
+
+	// mux contains the multiplexor values of
+	// signals declared using the "m<value>" notation.
+	mux map[*Signal]uint64
+
+	// extMux is set for signals listed in SG_MUL_VAL_.
+	extMux map[*Signal]bool
+}
+
+// Parse reads a database in DBC format.
+func Parse(r io.Reader) (*Database, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{
+		lex: lexer{s: string(b), line: 1},
+		db: &Database{
+			ValueTables: make(map[string]ValueTable),
+			AttrDefs:    make(map[string]*AttrDef),
+			Attributes:  make(map[string]any),
+			byID:        make(map[uint32]*Message),
+		},
+		mux:    make(map[*Signal]uint64),
+		extMux: make(map[*Signal]bool),
+	}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return p.db, nil
+}
+
+func (p *parser) next() (token, error) {
+	if p.peek != nil {
+		p.tok = *p.peek
+		p.peek = nil
+		return p.tok, nil
+	}
+	t, err := p.lex.next()
+	p.tok = t
+	return t, err
+}
+
+func (p *parser) lookahead() (token, error) {
+	if p.peek == nil {
+		t, err := p.lex.next()
+		if err != nil {
+			return t, err
+		}
+		p.peek = &t
+	}
+	return *p.peek, nil
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("dbc: line %d: %s", p.tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) expect(kind tokKind, what string) (string, error) {
+	t, err := p.next()
+	if err != nil {
+		return "", err
+	}
+	if t.kind != kind {
+		return "", p.errorf("expected %s, found %v", what, t)
+	}
+	return t.text, nil
+}
+
+func (p *parser) expectPunct(s string) error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.kind != tPunct || t.text != s {
+		return p.errorf("expected %q, found %v", s, t)
+	}
+	return nil
+}
+
+// acceptPunct consumes the next token if it is the punctuation s.
+func (p *parser) acceptPunct(s string) (bool, error) {
+	t, err := p.lookahead()
+	if err != nil {
+		return false, err
+	}
+	if t.kind == tPunct && t.text == s {
+		p.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *parser) float() (float64, error) {
+	s, err := p.expect(tNumber, "number")
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, p.errorf("invalid number %q", s)
+	}
+	return f, nil
+}
+
+func (p *parser) int() (int64, error) {
+	s, err := p.expect(tNumber, "integer")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		u, uerr := strconv.ParseUint(s, 0, 64)
+		if uerr != nil {
+			return 0, p.errorf("invalid integer %q", s)
+		}
+		v = int64(u)
+	}
+	return v, nil
+}
+
+func (p *parser) uint(bits int) (uint64, error) {
+	s, err := p.expect(tNumber, "unsigned integer")
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(s, 0, bits)
+	if err != nil {
+		return 0, p.errorf("invalid unsigned integer %q", s)
+	}
+	return v, nil
+}
+
+// skipLine skips all tokens up to the next
+// token at the beginning of a line.
+func (p *parser) skipLine() error {
+	for {
+		t, err := p.lookahead()
+		if err != nil {
+			return err
+		}
+		if t.kind == tEOF || t.col0 {
+			return nil
+		}
+		p.next()
+	}
+}
+
+// skipStatement skips all tokens up to and including the next ';'.
+func (p *parser) skipStatement() error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.kind == tEOF:
+			return nil
+		case t.kind == tPunct && t.text == ";":
+			return nil
+		}
+	}
+}
+
+func (p *parser) parse() error {
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch t.kind {
+		case tEOF:
+			return nil
+		case tIdent:
+		default:
+			return p.errorf("unexpected %v", t)
+		}
+		switch t.text {
+		case "VERSION":
+			p.db.Version, err = p.expect(tString, "version string")
+		case "NS_", "BS_":
+			err = p.skipLine()
+		case "BU_":
+			err = p.parseNodes()
+		case "BO_":
+			err = p.parseMessage()
+		case "SG_":
+			err = p.parseSignal()
+		case "BO_TX_BU_":
+			err = p.parseTransmitters()
+		case "CM_":
+			err = p.parseComment()
+		case "BA_DEF_":
+			err = p.parseAttrDef()
+		case "BA_DEF_DEF_":
+			err = p.parseAttrDefault()
+		case "BA_":
+			err = p.parseAttr()
+		case "VAL_TABLE_":
+			err = p.parseValueTable()
+		case "VAL_":
+			err = p.parseValues()
+		case "SIG_VALTYPE_":
+			err = p.parseValueType()
+		case "SG_MUL_VAL_":
+			err = p.parseExtMux()
+		default:
+			err = p.skipStatement()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (p *parser) parseNodes() error {
+	if err := p.expectPunct(":"); err != nil {
+		return err
+	}
+	for {
+		t, err := p.lookahead()
+		if err != nil {
+			return err
+		}
+		if t.kind != tIdent || t.col0 {
+			return nil
+		}
+		p.next()
+		p.db.Nodes = append(p.db.Nodes, &Node{Name: t.text, Attributes: make(map[string]any)})
+	}
+}
+
+// BO_ <id> <name>: <size> <transmitter>
+func (p *parser) parseMessage() error {
+	id, err := p.uint(32)
+	if err != nil {
+		return err
+	}
+	msg := &Message{Attributes: make(map[string]any)}
+	msg.Name, err = p.expect(tIdent, "message name")
+	if err != nil {
+		return err
+	}
+	if err := p.expectPunct(":"); err != nil {
+		return err
+	}
+	size, err := p.uint(8)
+	if err != nil {
+		return err
+	}
+	msg.Size = int(size)
+	msg.Transmitter, err = p.expect(tIdent, "transmitter")
+	if err != nil {
+		return err
+	}
+	p.msg = msg
+
+	// The pseudo message VECTOR__INDEPENDENT_SIG_MSG
+	// collects signals not assigned to any message.
+	if id == 0xC0000000 {
+		return nil
+	}
+	if _, _, err := can.VerifyDataLenFD(msg.Size); err != nil {
+		return p.errorf("message %s: invalid size %d", msg.Name, msg.Size)
+	}
+	msg.Extended = id&extFlag != 0
+	msg.ID = uint32(id) &^ extFlag
+	if _, dup := p.db.byID[msg.frameID()]; dup {
+		return p.errorf("message %s: duplicate ID %#x", msg.Name, msg.ID)
+	}
+	p.db.byID[msg.frameID()] = msg
+	p.db.Messages = append(p.db.Messages, msg)
+	return nil
+}
+
+// SG_ <name> [M|m<n>|m<n>M] : <start>|<length>@<order><sign> (<factor>,<offset>) [<min>|<max>] "<unit>" <receivers>
+func (p *parser) parseSignal() error {
+	if p.msg == nil {
+		return p.errorf("signal outside of message")
+	}
+	s := &Signal{Attributes: make(map[string]any)}
+	var err error
+	s.Name, err = p.expect(tIdent, "signal name")
+	if err != nil {
+		return err
+	}
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.kind == tIdent {
+		if err := p.parseMuxIndicator(s, t.text); err != nil {
+			return err
+		}
+		t, err = p.next()
+		if err != nil {
+			return err
+		}
+	}
+	if t.kind != tPunct || t.text != ":" {
+		return p.errorf("expected \":\", found %v", t)
+	}
+	start, err := p.uint(16)
+	if err != nil {
+		return err
+	}
+	if err := p.expectPunct("|"); err != nil {
+		return err
+	}
+	length, err := p.uint(8)
+	if err != nil {
+		return err
+	}
+	if length == 0 || length > 64 {
+		return p.errorf("signal %s: invalid length %d", s.Name, length)
+	}
+	s.StartBit = int(start)
+	s.Length = int(length)
+	if err := p.expectPunct("@"); err != nil {
+		return err
+	}
+	order, err := p.expect(tNumber, "byte order")
+	if err != nil {
+		return err
+	}
+	switch order {
+	case "0":
+		s.ByteOrder = BigEndian
+	case "1":
+		s.ByteOrder = LittleEndian
+	default:
+		return p.errorf("signal %s: invalid byte order %q", s.Name, order)
+	}
+	sign, err := p.expect(tPunct, "sign")
+	if err != nil {
+		return err
+	}
+	switch sign {
+	case "+":
+	case "-":
+		s.Signed = true
+	default:
+		return p.errorf("signal %s: invalid sign %q", s.Name, sign)
+	}
+	if err := p.expectPunct("("); err != nil {
+		return err
+	}
+	if s.Factor, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expectPunct(","); err != nil {
+		return err
+	}
+	if s.Offset, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return err
+	}
+	if err := p.expectPunct("["); err != nil {
+		return err
+	}
+	if s.Min, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expectPunct("|"); err != nil {
+		return err
+	}
+	if s.Max, err = p.float(); err != nil {
+		return err
+	}
+	if err := p.expectPunct("]"); err != nil {
+		return err
+	}
+	if s.Unit, err = p.expect(tString, "unit"); err != nil {
+		return err
+	}
+	for {
+		t, err := p.lookahead()
+		if err != nil {
+			return err
+		}
+		if t.bol || t.kind == tEOF {
+			break
+		}
+		p.next()
+		switch {
+		case t.kind == tIdent:
+			if t.text != "Vector__XXX" {
+				s.Receivers = append(s.Receivers, t.text)
+			}
+		case t.kind == tPunct && t.text == ",":
+		default:
+			return p.errorf("signal %s: unexpected %v", s.Name, t)
+		}
+	}
+	return p.addSignal(s)
+}
+
+func (p *parser) parseMuxIndicator(s *Signal, ind string) error {
+	if ind == "M" {
+		s.IsMultiplexor = true
+		return nil
+	}
+	v, ok := strings.CutPrefix(ind, "m")
+	if !ok {
+		return p.errorf("signal %s: invalid multiplexer indicator %q", s.Name, ind)
+	}
+	if v, ok = strings.CutSuffix(v, "M"); ok {
+		s.IsMultiplexor = true
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return p.errorf("signal %s: invalid multiplexer indicator %q", s.Name, ind)
+	}
+	p.mux[s] = n
+	return nil
+}
+
+func (p *parser) addSignal(s *Signal) error {
+	if p.msg.Signal(s.Name) != nil {
+		return p.errorf("message %s: duplicate signal %s", p.msg.Name, s.Name)
+	}
+	if p.db.byID[p.msg.frameID()] == p.msg {
+		if lo, hi := s.byteSpan(); lo < 0 || hi >= p.msg.Size {
+			return p.errorf("signal %s exceeds length of message %s", s.Name, p.msg.Name)
+		}
+	}
+	p.msg.Signals = append(p.msg.Signals, s)
+	return nil
+}
+
+// lookupMsg parses a message ID, and returns the message.
+func (p *parser) lookupMsg() (*Message, error) {
+	id, err := p.uint(32)
+	if err != nil {
+		return nil, err
+	}
+	msg := p.db.byID[uint32(id)]
+	if msg == nil && id != 0xC0000000 {
+		return nil, p.errorf("undefined message ID %d", id)
+	}
+	return msg, nil
+}
+
+// lookupSignal parses a message ID and a signal name, and returns the
+// signal. For signals of VECTOR__INDEPENDENT_SIG_MSG, nil is returned.
+func (p *parser) lookupSignal() (*Signal, error) {
+	msg, err := p.lookupMsg()
+	if err != nil {
+		return nil, err
+	}
+	name, err := p.expect(tIdent, "signal name")
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, nil
+	}
+	s := msg.Signal(name)
+	if s == nil {
+		return nil, p.errorf("message %s: undefined signal %s", msg.Name, name)
+	}
+	return s, nil
+}
+
+func (p *parser) lookupNode() (*Node, error) {
+	name, err := p.expect(tIdent, "node name")
+	if err != nil {
+		return nil, err
+	}
+	n := p.db.Node(name)
+	if n == nil {
+		return nil, p.errorf("undefined node %s", name)
+	}
+	return n, nil
+}
+
+// BO_TX_BU_ <id> : <transmitter>,... ;
+func (p *parser) parseTransmitters() error {
+	msg, err := p.lookupMsg()
+	if err != nil {
+		return err
+	}
+	if err := p.expectPunct(":"); err != nil {
+		return err
+	}
+	for {
+		t, err := p.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.kind == tPunct && t.text == ";":
+			return nil
+		case t.kind == tPunct && t.text == ",":
+		case t.kind == tIdent:
+			if msg != nil {
+				msg.Transmitters = append(msg.Transmitters, t.text)
+			}
+		default:
+			return p.errorf("unexpected %v", t)
+		}
+	}
+}
+
+// CM_ [BU_ <node>|BO_ <id>|SG_ <id> <signal>|EV_ <var>] "<text>" ;
+func (p *parser) parseComment() error {
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	var dst *string
+	if t.kind == tIdent {
+		switch t.text {
+		case "BU_":
+			n, err := p.lookupNode()
+			if err != nil {
+				return err
+			}
+			dst = &n.Comment
+		case "BO_":
+			msg, err := p.lookupMsg()
+			if err != nil {
+				return err
+			}
+			if msg != nil {
+				dst = &msg.Comment
+			}
+		case "SG_":
+			s, err := p.lookupSignal()
+			if err != nil {
+				return err
+			}
+			if s != nil {
+				dst = &s.Comment
+			}
+		case "EV_":
+			if _, err := p.expect(tIdent, "variable name"); err != nil {
+				return err
+			}
+		default:
+			return p.errorf("invalid comment object %v", t)
+		}
+		if t, err = p.next(); err != nil {
+			return err
+		}
+	} else {
+		dst = &p.db.Comment
+	}
+	if t.kind != tString {
+		return p.errorf("expected comment string, found %v", t)
+	}
+	if dst != nil {
+		*dst = t.text
+	}
+	return p.expectPunct(";")
+}
+
+// BA_DEF_ [BU_|BO_|SG_|EV_] "<name>" <type> [<args>] ;
+func (p *parser) parseAttrDef() error {
+	d := new(AttrDef)
+	t, err := p.next()
+	if err != nil {
+		return err
+	}
+	if t.kind == tIdent {
+		d.Object = t.text
+		if t, err = p.next(); err != nil {
+			return err
+		}
+	}
+	if t.kind != tString {
+		return p.errorf("expected attribute name, found %v", t)
+	}
+	d.Name = t.text
+	d.Type, err = p.expect(tIdent, "attribute type")
+	if err != nil {
+		return err
+	}
+	switch d.Type {
+	case "INT", "HEX", "FLOAT":
+		if d.Min, err = p.float(); err != nil {
+			return err
+		}
+		if d.Max, err = p.float(); err != nil {
+			return err
+		}
+	case "STRING":
+	case "ENUM":
+		for {
+			t, err := p.lookahead()
+			if err != nil {
+				return err
+			}
+			if t.kind != tString {
+				break
+			}
+			p.next()
+			d.Enum = append(d.Enum, t.text)
+			if ok, err := p.acceptPunct(","); err != nil {
+				return err
+			} else if !ok {
+				break
+			}
+		}
+	default:
+		return p.errorf("attribute %s: invalid type %s", d.Name, d.Type)
+	}
+	p.db.AttrDefs[d.Name] = d
+	return p.expectPunct(";")
+}
+
+// attrValue parses an attribute value according to its definition.
+func (p *parser) attrValue(d *AttrDef) (any, error) {
+	t, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	switch t.kind {
+	case tString:
+		return t.text, nil
+	case tNumber:
+	default:
+		return nil, p.errorf("attribute %s: invalid value %v", d.Name, t)
+	}
+	f, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return nil, p.errorf("attribute %s: invalid value %v", d.Name, t)
+	}
+	switch d.Type {
+	case "ENUM":
+		i := int(f)
+		if i < 0 || i >= len(d.Enum) {
+			return nil, p.errorf("attribute %s: enum value %v out of range", d.Name, t)
+		}
+		return d.Enum[i], nil
+	case "STRING":
+		return t.text, nil
+	}
+	return f, nil
+}
+
+// BA_DEF_DEF_ "<name>" <value> ;
+func (p *parser) parseAttrDefault() error {
+	name, err := p.expect(tString, "attribute name")
+	if err != nil {
+		return err
+	}
+	d := p.db.AttrDefs[name]
+	if d == nil {
+		return p.errorf("undefined attribute %s", name)
+	}
+	if d.Default, err = p.attrValue(d); err != nil {
+		return err
+	}
+	return p.expectPunct(";")
+}
+
+// BA_ "<name>" [BU_ <node>|BO_ <id>|SG_ <id> <signal>|EV_ <var>] <value> ;
+func (p *parser) parseAttr() error {
+	name, err := p.expect(tString, "attribute name")
+	if err != nil {
+		return err
+	}
+	d := p.db.AttrDefs[name]
+	if d == nil {
+		return p.errorf("undefined attribute %s", name)
+	}
+	t, err := p.lookahead()
+	if err != nil {
+		return err
+	}
+	attrs := p.db.Attributes
+	if t.kind == tIdent {
+		p.next()
+		switch t.text {
+		case "BU_":
+			n, err := p.lookupNode()
+			if err != nil {
+				return err
+			}
+			attrs = n.Attributes
+		case "BO_":
+			msg, err := p.lookupMsg()
+			if err != nil {
+				return err
+			}
+			attrs = nil
+			if msg != nil {
+				attrs = msg.Attributes
+			}
+		case "SG_":
+			s, err := p.lookupSignal()
+			if err != nil {
+				return err
+			}
+			attrs = nil
+			if s != nil {
+				attrs = s.Attributes
+			}
+		case "EV_":
+			if _, err := p.expect(tIdent, "variable name"); err != nil {
+				return err
+			}
+			attrs = nil
+		default:
+			return p.errorf("invalid attribute object %v", t)
+		}
+	}
+	v, err := p.attrValue(d)
+	if err != nil {
+		return err
+	}
+	if attrs != nil {
+		attrs[name] = v
+	}
+	return p.expectPunct(";")
+}
+
+// valueDescriptions parses pairs of values and descriptions up to ';'.
+func (p *parser) valueDescriptions() (ValueTable, error) {
+	vt := make(ValueTable)
+	for {
+		if ok, err := p.acceptPunct(";"); err != nil || ok {
+			return vt, err
+		}
+		v, err := p.int()
+		if err != nil {
+			return nil, err
+		}
+		desc, err := p.expect(tString, "value description")
+		if err != nil {
+			return nil, err
+		}
+		vt[v] = desc
+	}
+}
+
+// VAL_TABLE_ <name> {<value> "<description>"} ;
+func (p *parser) parseValueTable() error {
+	name, err := p.expect(tIdent, "value table name")
+	if err != nil {
+		return err
+	}
+	vt, err := p.valueDescriptions()
+	if err != nil {
+		return err
+	}
+	p.db.ValueTables[name] = vt
+	return nil
+}
+
+// VAL_ <id> <signal> {<value> "<description>"} ;
+func (p *parser) parseValues() error {
+	t, err := p.lookahead()
+	if err != nil {
+		return err
+	}
+	if t.kind == tIdent {
+		// value descriptions of an environment variable
+		return p.skipStatement()
+	}
+	s, err := p.lookupSignal()
+	if err != nil {
+		return err
+	}
+	vt, err := p.valueDescriptions()
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		s.Values = vt
+	}
+	return nil
+}
+
+// SIG_VALTYPE_ <id> <signal> : <type> ;
+func (p *parser) parseValueType() error {
+	s, err := p.lookupSignal()
+	if err != nil {
+		return err
+	}
+	if _, err := p.acceptPunct(":"); err != nil {
+		return err
+	}
+	typ, err := p.uint(8)
+	if err != nil {
+		return err
+	}
+	if s != nil {
+		switch typ {
+		case 0:
+			s.ValueType = Integer
+		case 1:
+			s.ValueType = Float32
+		case 2:
+			s.ValueType = Float64
+		default:
+			return p.errorf("signal %s: invalid value type %d", s.Name, typ)
+		}
+		if s.ValueType == Float32 && s.Length != 32 || s.ValueType == Float64 && s.Length != 64 {
+			return p.errorf("signal %s: length does not match value type", s.Name)
+		}
+	}
+	return p.expectPunct(";")
+}
+
+// SG_MUL_VAL_ <id> <signal> <multiplexor> <min>-<max>, ... ;
+func (p *parser) parseExtMux() error {
+	msg, err := p.lookupMsg()
+	if err != nil {
+		return err
+	}
+	name, err := p.expect(tIdent, "signal name")
+	if err != nil {
+		return err
+	}
+	muxName, err := p.expect(tIdent, "multiplexor name")
+	if err != nil {
+		return err
+	}
+	var ranges []MuxRange
+	for {
+		if ok, err := p.acceptPunct(";"); err != nil || ok {
+			if err != nil {
+				return err
+			}
+			break
+		}
+		lo, err := p.uint(64)
+		if err != nil {
+			return err
+		}
+		// The range separator may have been read as the sign of
+		// the upper bound.
+		if _, err := p.acceptPunct("-"); err != nil {
+			return err
+		}
+		hs, err := p.expect(tNumber, "range")
+		if err != nil {
+			return err
+		}
+		hi, err := strconv.ParseUint(strings.TrimPrefix(hs, "-"), 10, 64)
+		if err != nil || hi < lo {
+			return p.errorf("invalid multiplexor range %d-%s", lo, hs)
+		}
+		ranges = append(ranges, MuxRange{lo, hi})
+		if _, err := p.acceptPunct(","); err != nil {
+			return err
+		}
+	}
+	if msg == nil {
+		return nil
+	}
+	s := msg.Signal(name)
+	mux := msg.Signal(muxName)
+	if s == nil || mux == nil {
+		return p.errorf("message %s: undefined signal in multiplexor definition", msg.Name)
+	}
+	if !mux.IsMultiplexor {
+		return p.errorf("message %s: %s is not a multiplexor", msg.Name, muxName)
+	}
+	s.MultiplexedBy = mux
+	s.MuxRanges = append(s.MuxRanges, ranges...)
+	p.extMux[s] = true
+	return nil
+}
+
+// resolve links multiplexed signals declared using the simple
+// multiplexing notation to the multiplexor of their message.
+func (p *parser) resolve() error {
+	for _, msg := range p.db.Messages {
+		var mux *Signal
+		for _, s := range msg.Signals {
+			if _, ok := p.mux[s]; !ok && s.IsMultiplexor {
+				if mux != nil {
+					return fmt.Errorf("dbc: message %s: more than one top-level multiplexor", msg.Name)
+				}
+				mux = s
+			}
+		}
+		for _, s := range msg.Signals {
+			v, ok := p.mux[s]
+			if !ok || p.extMux[s] {
+				continue
+			}
+			if mux == nil {
+				return fmt.Errorf("dbc: message %s: signal %s: missing multiplexor", msg.Name, s.Name)
+			}
+			s.MultiplexedBy = mux
+			s.MuxRanges = []MuxRange{{v, v}}
+		}
+		for _, s := range msg.Signals {
+			if s.muxDepth() >= 64 {
+				return fmt.Errorf("dbc: message %s: signal %s: circular multiplexing", msg.Name, s.Name)
+			}
+		}
+	}
+	return nil
+}
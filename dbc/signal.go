@@ -0,0 +1,351 @@
+package dbc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/knieriem/can"
+)
+
+var (
+	ErrRange       = errors.New("dbc: value out of range")
+	ErrShortData   = errors.New("dbc: data too short")
+	ErrInactiveSig = errors.New("dbc: signal not active in multiplexed message")
+)
+
+// ByteOrder is the byte order of a signal.
+type ByteOrder uint8
+
+const (
+	// BigEndian (Motorola, "@0"): the start bit is the most significant bit.
+	BigEndian ByteOrder = 0
+
+	// LittleEndian (Intel, "@1"): the start bit is the least significant bit.
+	LittleEndian ByteOrder = 1
+)
+
+// ValueType is the encoding of raw signal values, as
+// specified using SIG_VALTYPE_.
+type ValueType uint8
+
+const (
+	Integer ValueType = iota
+	Float32
+	Float64
+)
+
+// Signal is the definition of a signal within a message.
+type Signal struct {
+	Name      string
+	StartBit  int
+	Length    int
+	ByteOrder ByteOrder
+	Signed    bool
+	ValueType ValueType
+
+	// The physical value is calculated as raw*Factor + Offset.
+	Factor, Offset float64
+	Min, Max       float64
+	Unit           string
+	Receivers      []string
+
+	// IsMultiplexor is set if the signal selects which of the
+	// multiplexed signals of the message are present.
+	IsMultiplexor bool
+
+	// MultiplexedBy is the multiplexor signal selecting this signal,
+	// or nil, if the signal is always present. Using extended
+	// multiplexing (SG_MUL_VAL_), a multiplexor may itself be
+	// multiplexed by another signal.
+	MultiplexedBy *Signal
+
+	// MuxRanges contains the values of the multiplexor for which
+	// the signal is present.
+	MuxRanges []MuxRange
+
+	Values     ValueTable
+	Comment    string
+	Attributes map[string]any
+}
+
+// MuxRange is an inclusive range of multiplexor values.
+type MuxRange struct {
+	Min, Max uint64
+}
+
+// Value is a decoded signal value.
+type Value struct {
+	Signal   *Signal
+	Raw      uint64
+	Physical float64
+}
+
+// Label returns the description of the raw value
+// from the signal's value table, if there is one.
+func (v Value) Label() string {
+	if v.Signal.Values == nil {
+		return ""
+	}
+	return v.Signal.Values[v.Signal.rawInt(v.Raw)]
+}
+
+func (v Value) String() string {
+	if l := v.Label(); l != "" {
+		return l
+	}
+	s := strconv.FormatFloat(v.Physical, 'g', -1, 64)
+	if v.Signal.Unit != "" {
+		s += " " + v.Signal.Unit
+	}
+	return s
+}
+
+// Decode returns the values of all signals of the message
+// that are present in data, considering multiplexing.
+func (msg *Message) Decode(data []byte) ([]Value, error) {
+	if len(data) < msg.Size {
+		return nil, fmt.Errorf("%w: %s: got %d bytes, want %d", ErrShortData, msg.Name, len(data), msg.Size)
+	}
+	values := make([]Value, 0, len(msg.Signals))
+	for _, s := range msg.Signals {
+		if !s.Active(data) {
+			continue
+		}
+		raw := s.Raw(data)
+		values = append(values, Value{Signal: s, Raw: raw, Physical: s.Physical(raw)})
+	}
+	return values, nil
+}
+
+// DecodeMap is like Decode, but returns the physical values by signal name.
+func (msg *Message) DecodeMap(data []byte) (map[string]float64, error) {
+	values, err := msg.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]float64, len(values))
+	for _, v := range values {
+		m[v.Signal.Name] = v.Physical
+	}
+	return m, nil
+}
+
+// Encode creates the data field of the message from the physical
+// values of signals. Signals that are not contained in values are
+// encoded with a raw value of zero. Multiplexor signals are encoded
+// first; values of signals that are not active for the resulting
+// multiplexor values are rejected.
+func (msg *Message) Encode(values map[string]float64) ([]byte, error) {
+	for name := range values {
+		if msg.Signal(name) == nil {
+			return nil, fmt.Errorf("%w: %s.%s", ErrUnknownSignal, msg.Name, name)
+		}
+	}
+	sigs := slices.Clone(msg.Signals)
+	slices.SortStableFunc(sigs, func(a, b *Signal) int {
+		return a.muxDepth() - b.muxDepth()
+	})
+	data := make([]byte, msg.Size)
+	for _, s := range sigs {
+		v, ok := values[s.Name]
+		if !s.Active(data) {
+			if ok {
+				return nil, fmt.Errorf("%w: %s.%s", ErrInactiveSig, msg.Name, s.Name)
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		raw, err := s.RawValue(v)
+		if err != nil {
+			return nil, err
+		}
+		s.SetRaw(data, raw)
+	}
+	return data, nil
+}
+
+// EncodeMsg sets the identifier, flags and data of m according to
+// the message definition and the physical values of signals.
+// Messages longer than 8 bytes are sent as CAN FD frames.
+func (msg *Message) EncodeMsg(m *can.Msg, values map[string]float64) error {
+	data, err := msg.Encode(values)
+	if err != nil {
+		return err
+	}
+	m.Id = msg.ID
+	m.Flags &^= can.ExtFrame | can.RTRMsg | can.ForceFD
+	if msg.Extended {
+		m.Flags |= can.ExtFrame
+	}
+	if msg.Size > 8 {
+		m.Flags |= can.ForceFD
+	}
+	m.SetData(data)
+	return nil
+}
+
+// Active reports whether the signal is present in data, i.e. it
+// is not multiplexed, or the multiplexors selecting the signal have
+// matching values.
+func (s *Signal) Active(data []byte) bool {
+	if s.MultiplexedBy == nil {
+		return true
+	}
+	if !s.MultiplexedBy.Active(data) || !s.MultiplexedBy.fits(data) {
+		return false
+	}
+	sel := s.MultiplexedBy.Raw(data)
+	for _, r := range s.MuxRanges {
+		if sel >= r.Min && sel <= r.Max {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Signal) muxDepth() int {
+	n := 0
+	for m := s.MultiplexedBy; m != nil && n < 64; m = m.MultiplexedBy {
+		n++
+	}
+	return n
+}
+
+// Raw extracts the raw value of the signal from data. The result
+// contains the bits of the signal without sign extension.
+func (s *Signal) Raw(data []byte) uint64 {
+	var v uint64
+	p := s.StartBit
+	for i := range s.Length {
+		if p/8 >= len(data) {
+			break
+		}
+		bit := uint64(data[p/8]>>(p%8)) & 1
+		if s.ByteOrder == LittleEndian {
+			v |= bit << i
+			p++
+			continue
+		}
+		v = v<<1 | bit
+		p = nextMotorolaBit(p)
+	}
+	return v
+}
+
+// SetRaw stores the raw value of the signal into data.
+func (s *Signal) SetRaw(data []byte, raw uint64) {
+	p := s.StartBit
+	for i := range s.Length {
+		var bit uint64
+		if s.ByteOrder == LittleEndian {
+			bit = raw >> i & 1
+		} else {
+			bit = raw >> (s.Length - 1 - i) & 1
+		}
+		if p/8 < len(data) {
+			data[p/8] = data[p/8]&^(1<<(p%8)) | byte(bit)<<(p%8)
+		}
+		if s.ByteOrder == LittleEndian {
+			p++
+		} else {
+			p = nextMotorolaBit(p)
+		}
+	}
+}
+
+// nextMotorolaBit returns the position of the next less significant
+// bit of a big-endian signal, using the bit numbering of DBC files,
+// where bit 7 is the most significant bit of byte 0.
+func nextMotorolaBit(p int) int {
+	if p%8 == 0 {
+		return p + 15
+	}
+	return p - 1
+}
+
+// fits reports whether all bits of the signal are within data.
+func (s *Signal) fits(data []byte) bool {
+	lo, hi := s.byteSpan()
+	return lo >= 0 && hi < len(data)
+}
+
+// byteSpan returns the indices of the first and last byte
+// occupied by the signal.
+func (s *Signal) byteSpan() (lo, hi int) {
+	if s.Length == 0 {
+		return s.StartBit / 8, s.StartBit / 8
+	}
+	if s.ByteOrder == LittleEndian {
+		return s.StartBit / 8, (s.StartBit + s.Length - 1) / 8
+	}
+	p := s.StartBit
+	for range s.Length - 1 {
+		p = nextMotorolaBit(p)
+	}
+	return s.StartBit / 8, p / 8
+}
+
+// rawInt interprets raw as signed integer, if the signal is signed.
+func (s *Signal) rawInt(raw uint64) int64 {
+	if s.Signed && s.Length > 0 && s.Length < 64 {
+		shift := 64 - s.Length
+		return int64(raw<<shift) >> shift
+	}
+	return int64(raw)
+}
+
+// Physical converts a raw value into the physical value.
+func (s *Signal) Physical(raw uint64) float64 {
+	var v float64
+	switch s.ValueType {
+	case Float32:
+		v = float64(math.Float32frombits(uint32(raw)))
+	case Float64:
+		v = math.Float64frombits(raw)
+	default:
+		if s.Signed {
+			v = float64(s.rawInt(raw))
+		} else {
+			v = float64(raw)
+		}
+	}
+	return v*s.Factor + s.Offset
+}
+
+// RawValue converts a physical value into the raw value, rounding
+// to the nearest integer. An error is returned if the result does
+// not fit into the signal.
+func (s *Signal) RawValue(phys float64) (uint64, error) {
+	v := phys - s.Offset
+	if s.Factor != 0 {
+		v /= s.Factor
+	}
+	switch s.ValueType {
+	case Float32:
+		return uint64(math.Float32bits(float32(v))), nil
+	case Float64:
+		return math.Float64bits(v), nil
+	}
+	v = math.Round(v)
+	n := s.Length
+	if s.Signed {
+		lim := math.Ldexp(1, n-1)
+		if v < -lim || v >= lim {
+			return 0, fmt.Errorf("%w: %s: %v", ErrRange, s.Name, phys)
+		}
+		raw := uint64(int64(v))
+		if n < 64 {
+			raw &= 1<<n - 1
+		}
+		return raw, nil
+	}
+	if v < 0 || v >= math.Ldexp(1, n) {
+		return 0, fmt.Errorf("%w: %s: %v", ErrRange, s.Name, phys)
+	}
+	return uint64(v), nil
+}
@@ -2,6 +2,10 @@
 package all
 
 import (
+	_ "github.com/knieriem/can/drv/cannelloni"
 	_ "github.com/knieriem/can/drv/canrpc"
 	_ "github.com/knieriem/can/drv/pcan"
+	_ "github.com/knieriem/can/drv/slcan"
+	_ "github.com/knieriem/can/drv/socketcand"
+	_ "github.com/knieriem/can/drv/virtual"
 )
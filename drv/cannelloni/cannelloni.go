@@ -0,0 +1,370 @@
+// Package cannelloni implements the protocol of cannelloni, a tool
+// tunnelling CAN frames between SocketCAN interfaces over UDP or TCP,
+// both as a driver, and as a server exporting a can.Device.
+//
+// The package registers a driver named "cannelloni" with the can
+// package. The device name specifies the address of the remote peer,
+// optionally prefixed by the transport, which may be "udp" (the
+// default), or "tcp":
+//
+//	cannelloni:192.168.1.2:20000
+//	cannelloni:tcp:192.168.1.2:20000
+//
+// If the port is omitted, the default port 20000 is used. Since a
+// cannelloni peer using UDP sends its frames to a configured address,
+// the local UDP port must be known to the peer. It defaults to the port
+// of the remote address, as cannelloni's defaults are symmetric, but
+// may be specified after '@':
+//
+//	cannelloni:192.168.1.2:20000@:20001
+//
+// Using TCP, the driver acts as client, i.e. the peer must be running
+// in server mode.
+//
+// The protocol does not transport timestamps, received messages
+// are timestamped on reception. SocketCAN error frames are not
+// forwarded by cannelloni on default; status messages are neither
+// sent by the driver, nor by the server. SCTP is not supported.
+package cannelloni
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/knieriem/can"
+)
+
+// DefaultPort is the default port used by cannelloni.
+const DefaultPort = "20000"
+
+const (
+	version = 2
+
+	opData = 0
+
+	headerSize    = 5
+	frameBaseSize = 5
+
+	// maximum size of UDP packets sent; larger packets
+	// might be fragmented on Ethernet
+	maxPacketSize = 1472
+	rxPacketSize  = 1600
+
+	// flag in the length field of FD frames
+	fdFrame = 0x80
+
+	// flags of SocketCAN identifiers
+	effFlag = 0x80000000
+	rtrFlag = 0x40000000
+	errFlag = 0x20000000
+
+	effMask = 0x1FFFFFFF
+	sffMask = 0x7FF
+
+	// flags of SocketCAN FD frames
+	fdBRS = 0x01
+	fdESI = 0x02
+	fdFDF = 0x04
+)
+
+// handshake is exchanged at the beginning of a TCP connection.
+const handshake = "CANNELLONIv1"
+
+var (
+	ErrHandshake = errors.New("cannelloni: handshake failed")
+
+	errFrame   = errors.New("cannelloni: invalid frame")
+	errSkipped = errors.New("cannelloni: error frame skipped")
+)
+
+var be = binary.BigEndian
+
+// appendFrame encodes m, and appends it to b.
+func appendFrame(b []byte, m *can.Msg) []byte {
+	data := m.Data()
+	id := m.Id & sffMask
+	if m.ExtFrame() {
+		id = m.Id&effMask | effFlag
+	}
+	rtr := m.Test(can.RTRMsg)
+	if rtr {
+		id |= rtrFlag
+	}
+	b = be.AppendUint32(b, id)
+	_, needsFD, _ := can.VerifyDataLenFD(len(data))
+	if needsFD || m.Test(can.ForceFD) {
+		flags := byte(fdFDF)
+		if m.Test(can.FDSwitchBitrate) {
+			flags |= fdBRS
+		}
+		if m.Test(can.FDErrorStateInd) {
+			flags |= fdESI
+		}
+		b = append(b, byte(len(data))|fdFrame, flags)
+	} else {
+		b = append(b, byte(len(data)))
+	}
+	if rtr {
+		return b
+	}
+	return append(b, data...)
+}
+
+// decodeFrame decodes the frame at the beginning of b into m,
+// and returns the number of bytes consumed. The data is stored
+// into m using setData.
+func decodeFrame(b []byte, m *can.Msg, setData func(*can.Msg, []byte) error) (int, error) {
+	if len(b) < frameBaseSize {
+		return 0, errFrame
+	}
+	id := be.Uint32(b)
+	n := int(b[4] &^ fdFrame)
+	i := frameBaseSize
+	m.Reset()
+	if b[4]&fdFrame != 0 {
+		if len(b) < i+1 || n > 64 {
+			return 0, errFrame
+		}
+		m.Flags |= can.ForceFD
+		if b[i]&fdBRS != 0 {
+			m.Flags |= can.FDSwitchBitrate
+		}
+		if b[i]&fdESI != 0 {
+			m.Flags |= can.FDErrorStateInd
+		}
+		i++
+	} else if n > 8 {
+		return 0, errFrame
+	}
+	if id&errFlag != 0 {
+		if len(b) < i+n {
+			return 0, errFrame
+		}
+		return i + n, errSkipped
+	}
+	if id&effFlag != 0 {
+		m.Flags |= can.ExtFrame
+		m.Id = id & effMask
+	} else {
+		m.Id = id & sffMask
+	}
+	if id&rtrFlag != 0 {
+		m.Flags |= can.RTRMsg
+		return i, nil
+	}
+	if len(b) < i+n {
+		return 0, errFrame
+	}
+	if err := setData(m, b[i:i+n]); err != nil {
+		return 0, err
+	}
+	return i + n, nil
+}
+
+// conn transfers messages over a network connection, either using
+// packets containing multiple frames (UDP), or as a stream of
+// frames (TCP).
+type conn struct {
+	nc     net.Conn
+	stream bool
+
+	// receiving side
+	r     *bufio.Reader // stream
+	pkt   []byte        // packet
+	rest  []byte        // not yet decoded part of pkt
+	count int           // number of frames left in rest
+	frame []byte
+
+	wmu sync.Mutex
+	seq uint8
+}
+
+// newConn wraps nc. Connections implementing net.PacketConn
+// are packet based, others are considered stream connections,
+// which require a handshake.
+func newConn(nc net.Conn) (*conn, error) {
+	c := &conn{nc: nc}
+	if _, ok := nc.(net.PacketConn); ok {
+		c.pkt = make([]byte, rxPacketSize)
+		return c, nil
+	}
+	c.stream = true
+	c.r = bufio.NewReader(nc)
+	c.frame = make([]byte, frameBaseSize+1+64)
+	if _, err := io.WriteString(nc, handshake); err != nil {
+		return nil, err
+	}
+	b := make([]byte, len(handshake))
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, err
+	}
+	if string(b) != handshake {
+		return nil, ErrHandshake
+	}
+	return c, nil
+}
+
+// encode returns msgs encoded as one or more
+// chunks of data to be written to the connection.
+// Status messages, and messages that cannot be
+// represented, like CAN XL frames, are skipped.
+func (c *conn) encode(msgs []can.Msg) [][]byte {
+	var chunks [][]byte
+	var b []byte
+	count := 0
+	for i := range msgs {
+		m := &msgs[i]
+		if m.IsStatus() || verifyMsg(m) != nil {
+			continue
+		}
+		if c.stream {
+			b = appendFrame(b, m)
+			continue
+		}
+		if b != nil && len(b)+frameBaseSize+1+len(m.Data()) > maxPacketSize {
+			chunks = append(chunks, c.finishPacket(b, count))
+			b = nil
+		}
+		if b == nil {
+			b = make([]byte, headerSize, maxPacketSize)
+			count = 0
+		}
+		b = appendFrame(b, m)
+		count++
+	}
+	if len(b) == 0 {
+		return chunks
+	}
+	if !c.stream {
+		b = c.finishPacket(b, count)
+	}
+	return append(chunks, b)
+}
+
+func (c *conn) finishPacket(b []byte, count int) []byte {
+	c.wmu.Lock()
+	seq := c.seq
+	c.seq++
+	c.wmu.Unlock()
+	b[0] = version
+	b[1] = opData
+	b[2] = seq
+	be.PutUint16(b[3:], uint16(count))
+	return b
+}
+
+func (c *conn) write(msgs []can.Msg) error {
+	for _, b := range c.encode(msgs) {
+		c.wmu.Lock()
+		_, err := c.nc.Write(b)
+		c.wmu.Unlock()
+		if err != nil && (c.stream || !errors.Is(err, syscall.ECONNREFUSED)) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readMsg blocks until the next message has been received.
+func (c *conn) readMsg(m *can.Msg, setData func(*can.Msg, []byte) error) error {
+	if c.stream {
+		return c.readFrame(m, setData)
+	}
+	for {
+		for c.count == 0 {
+			n, err := c.nc.Read(c.pkt)
+			if err != nil {
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					// the peer has not been started yet
+					continue
+				}
+				return err
+			}
+			p := c.pkt[:n]
+			if n < headerSize || p[0] != version || p[1] != opData {
+				continue
+			}
+			c.count = int(be.Uint16(p[3:]))
+			c.rest = p[headerSize:]
+		}
+		n, err := decodeFrame(c.rest, m, setData)
+		if err == errSkipped {
+			c.rest = c.rest[n:]
+			c.count--
+			continue
+		}
+		if err != nil {
+			// skip the remaining frames of the packet
+			c.count = 0
+			if err == errFrame {
+				continue
+			}
+			return err
+		}
+		c.rest = c.rest[n:]
+		c.count--
+		return nil
+	}
+}
+
+func (c *conn) readFrame(m *can.Msg, setData func(*can.Msg, []byte) error) error {
+	for {
+		b := c.frame[:frameBaseSize]
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return err
+		}
+		n := int(b[4] &^ fdFrame)
+		if b[4]&fdFrame != 0 {
+			b = b[:len(b)+1]
+			if _, err := io.ReadFull(c.r, b[frameBaseSize:]); err != nil {
+				return err
+			}
+		}
+		if be.Uint32(b)&rtrFlag == 0 || be.Uint32(b)&errFlag != 0 {
+			if n > 64 {
+				return errFrame
+			}
+			b = b[:len(b)+n]
+			if _, err := io.ReadFull(c.r, b[len(b)-n:]); err != nil {
+				return err
+			}
+		}
+		_, err := decodeFrame(b, m, setData)
+		if err == errSkipped {
+			continue
+		}
+		return err
+	}
+}
+
+// buffered reports whether received data is available
+// that can be read without blocking.
+func (c *conn) buffered() bool {
+	if c.stream {
+		return c.r.Buffered() != 0
+	}
+	return c.count != 0
+}
+
+// importData returns a function storing data into a message,
+// using pool, if not nil, for data exceeding the standard payload.
+func importData(pool can.DataBufPool) func(*can.Msg, []byte) error {
+	if pool == nil {
+		return func(m *can.Msg, data []byte) error {
+			if len(data) > 8 {
+				data = slices.Clone(data)
+			}
+			m.SetData(data)
+			return nil
+		}
+	}
+	return func(m *can.Msg, data []byte) error {
+		return m.Import(data, pool)
+	}
+}
@@ -0,0 +1,203 @@
+package cannelloni
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+func TestFrame(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		wire string
+	}{
+		{"123#", "\x00\x00\x01\x23\x00"},
+		{"7FF#0102", "\x00\x00\x07\xFF\x02\x01\x02"},
+		{"12345678#AA", "\x92\x34\x56\x78\x01\xAA"},
+		{"123#R", "\x40\x00\x01\x23\x00"},
+		{"00000123#R", "\xC0\x00\x01\x23\x00"},
+		{"123##1" + "000102030405060708090A0B", "\x00\x00\x01\x23\x8C\x05" + "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0A\x0B"},
+		{"123##2" + "11", "\x00\x00\x01\x23\x81\x06\x11"},
+	} {
+		var m can.Msg
+		if err := m.FromExpr(tc.expr); err != nil {
+			t.Fatal(err)
+		}
+		b := appendFrame(nil, &m)
+		if string(b) != tc.wire {
+			t.Errorf("%s: got % x, want % x", tc.expr, b, tc.wire)
+			continue
+		}
+		var m2 can.Msg
+		n, err := decodeFrame(b, &m2, importData(nil))
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if n != len(b) || m2.Id != m.Id || m2.Flags != m.Flags || !bytes.Equal(m2.Data(), m.Data()) {
+			t.Errorf("%s: decoded %x %v % x (%d bytes)", tc.expr, m2.Id, m2.Flags, m2.Data(), n)
+		}
+	}
+}
+
+func TestPacket(t *testing.T) {
+	c := &conn{}
+	msgs := make([]can.Msg, 200)
+	for i := range msgs {
+		msgs[i].FromExpr(strconv.FormatInt(int64(i), 16) + "#0102030405060708")
+	}
+	msgs[10].Flags = can.StatusMsg | can.ErrorPassive
+	msgs[20].Flags = can.XLFrame
+	msgs[20].SetData(make([]byte, 100))
+	chunks := c.encode(msgs)
+	if len(chunks) != 2 {
+		t.Fatalf("got %d packets, want 2", len(chunks))
+	}
+	n := 0
+	for i, p := range chunks {
+		if len(p) > maxPacketSize || p[0] != version || p[1] != opData || int(p[2]) != i {
+			t.Errorf("invalid header: % x", p[:headerSize])
+		}
+		n += int(be.Uint16(p[3:]))
+	}
+	if n != len(msgs)-2 {
+		t.Errorf("got %d frames, want %d", n, len(msgs)-2)
+	}
+}
+
+func TestWriteXL(t *testing.T) {
+	var m can.Msg
+	m.Id = 0x123
+	m.Flags = can.XLFrame
+	m.SetData([]byte{1, 2, 3})
+	d := new(dev)
+	if err := d.WriteMsg(&m); err != can.ErrXLNotSupported {
+		t.Errorf("got %v, want %v", err, can.ErrXLNotSupported)
+	}
+}
+
+// testServer exports one device of a virtual bus,
+// and returns another device connected to that bus.
+func testServer(t *testing.T, bus string) (*Server, can.Device) {
+	t.Helper()
+	exported, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		exported.Close()
+		local.Close()
+	})
+	return NewServer(exported), local
+}
+
+func exchange(t *testing.T, local, remote can.Device) {
+	t.Helper()
+	var m can.Msg
+	m.FromExpr("12345678##1" + strings.Repeat("55", 16))
+	if err := remote.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	expectMsg(t, local, &m)
+
+	m.Reset()
+	m.FromExpr("321#R")
+	if err := local.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	expectMsg(t, remote, &m)
+}
+
+func expectMsg(t *testing.T, d can.Device, want *can.Msg) {
+	t.Helper()
+	buf := make([]can.Msg, 4)
+	timer := time.AfterFunc(2*time.Second, func() {
+		t.Error("timeout")
+		d.Close()
+	})
+	defer timer.Stop()
+	n, err := d.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	m := &buf[0]
+	if m.Id != want.Id || m.Flags != want.Flags || !bytes.Equal(m.Data(), want.Data()) {
+		t.Errorf("got %x %v % x, want %x %v % x", m.Id, m.Flags, m.Data(), want.Id, want.Flags, want.Data())
+	}
+}
+
+func TestUDP(t *testing.T) {
+	s, local := testServer(t, "cannelloni-udp")
+
+	sc, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	saddr := sc.LocalAddr().String()
+	sc.Close()
+
+	remote, err := can.Open("cannelloni:" + saddr + "@127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	// connect the server to the client's local address
+	raddr := remote.(*dev).c.nc.LocalAddr().(*net.UDPAddr)
+	laddr, _ := net.ResolveUDPAddr("udp", saddr)
+	nc, err := net.DialUDP("udp", laddr, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.ServeConn(nc)
+	defer nc.Close()
+
+	waitPeer(s)
+	exchange(t, local, remote)
+}
+
+func TestTCP(t *testing.T) {
+	s, local := testServer(t, "cannelloni-tcp")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	remote, err := can.Open("cannelloni:tcp:" + l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	waitPeer(s)
+	exchange(t, local, remote)
+}
+
+// waitPeer waits until a peer has been registered with the server.
+func waitPeer(s *Server) {
+	for {
+		s.mu.Lock()
+		n := len(s.peers)
+		s.mu.Unlock()
+		if n != 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,227 @@
+package cannelloni
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv"
+)
+
+func init() {
+	can.RegisterDriver(new(driver))
+}
+
+type driver struct{}
+
+func (*driver) Name() string {
+	return "cannelloni"
+}
+
+func (*driver) Scan() []can.DeviceInfo {
+	return nil
+}
+
+// Open connects to the peer specified by name, which has the
+// form [network:]host[:port][@laddr], where network is "udp"
+// or "tcp", and laddr is the local UDP address.
+func (*driver) Open(env *can.Env, name string, conf *can.Config) (can.Device, error) {
+	if name == "" {
+		return nil, errors.New("cannelloni: missing peer address")
+	}
+	network := "udp"
+	addr := name
+	if s, ok := strings.CutPrefix(addr, "tcp:"); ok {
+		network = "tcp"
+		addr = s
+	} else if s, ok := strings.CutPrefix(addr, "udp:"); ok {
+		addr = s
+	}
+	addr, laddr, hasLocal := strings.Cut(addr, "@")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+
+	var nc net.Conn
+	var err error
+	if network == "tcp" {
+		if hasLocal {
+			return nil, errors.New("cannelloni: local address not supported with tcp")
+		}
+		nc, err = net.Dial(network, addr)
+	} else {
+		nc, err = dialUDP(addr, laddr, hasLocal)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d, err := newDevice(nc, conf)
+	if err != nil {
+		return nil, err
+	}
+	d.info.ID = name
+	if env != nil {
+		d.setData = importData(env.BufPool)
+	}
+	return d, nil
+}
+
+func dialUDP(addr, laddr string, hasLocal bool) (net.Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	la := &net.UDPAddr{Port: raddr.Port}
+	if hasLocal {
+		la, err = net.ResolveUDPAddr("udp", laddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return net.DialUDP("udp", la, raddr)
+}
+
+// NewDevice returns a device exchanging messages with a cannelloni peer
+// through nc, which is closed when the device is closed, or if NewDevice
+// fails. If nc implements
+// net.PacketConn, like a connected *net.UDPConn, it is used in packet
+// mode; otherwise it is considered a stream, as used with TCP.
+// Message filters of conf, which may be nil, are applied in software.
+func NewDevice(nc net.Conn, conf *can.Config) (can.Device, error) {
+	d, err := newDevice(nc, conf)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func newDevice(nc net.Conn, conf *can.Config) (*dev, error) {
+	if conf != nil {
+		if err := conf.ResolveCtrlModes(drv.NoCtrlModes); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("cannelloni: %w", err)
+		}
+	}
+	c, err := newConn(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	d := new(dev)
+	d.c = c
+	d.setData = importData(nil)
+	d.info = can.DeviceInfo{
+		ID:     nc.RemoteAddr().String(),
+		Device: nc.RemoteAddr().String(),
+		Driver: "cannelloni",
+		Model:  "cannelloni peer",
+	}
+	if conf != nil {
+		d.filters = slices.Clone(conf.MsgFilter)
+	}
+	return d, nil
+}
+
+type dev struct {
+	c       *conn
+	info    can.DeviceInfo
+	filters []can.MsgFilter
+	setData func(*can.Msg, []byte) error
+
+	mu      sync.Mutex
+	closing bool
+}
+
+func (d *dev) ID() string {
+	return "cannelloni:" + d.info.ID
+}
+
+func (d *dev) Info() *can.DeviceInfo {
+	return &d.info
+}
+
+// Read blocks until at least one message has been received, and
+// returns as many messages as are available without blocking.
+func (d *dev) Read(buf []can.Msg) (n int, err error) {
+	for n < len(buf) {
+		if n > 0 && !d.c.buffered() {
+			break
+		}
+		m := &buf[n]
+		if err := d.c.readMsg(m, d.setData); err != nil {
+			if d.isClosed() {
+				err = io.EOF
+			}
+			if n > 0 && err == io.EOF {
+				break
+			}
+			return n, err
+		}
+		m.Rx.Time = can.Now()
+		if drv.AcceptMsg(d.filters, m) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (d *dev) WriteMsg(m *can.Msg) error {
+	if m.IsStatus() {
+		return nil
+	}
+	if err := verifyMsg(m); err != nil {
+		return err
+	}
+	return d.c.write([]can.Msg{*m})
+}
+
+func (d *dev) Write(msgs []can.Msg) (n int, err error) {
+	for i := range msgs {
+		if err = verifyMsg(&msgs[i]); err != nil {
+			msgs = msgs[:i]
+			break
+		}
+	}
+	if err1 := d.c.write(msgs); err1 != nil {
+		return 0, err1
+	}
+	return len(msgs), err
+}
+
+// verifyMsg checks whether m can be represented in the protocol,
+// which does not support CAN XL frames.
+func verifyMsg(m *can.Msg) error {
+	if m.XLFrame() {
+		return can.ErrXLNotSupported
+	}
+	n := len(m.Data())
+	if _, _, err := can.VerifyDataLenFD(n); err != nil {
+		return err
+	}
+	if m.Test(can.RTRMsg) && n != 0 {
+		return can.ErrInvalidMsgLen
+	}
+	return nil
+}
+
+func (d *dev) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+func (d *dev) Close() error {
+	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closing = true
+	d.mu.Unlock()
+	return d.c.nc.Close()
+}
@@ -0,0 +1,151 @@
+package cannelloni
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/knieriem/can"
+)
+
+// number of chunks of encoded messages that may be queued for a peer
+const peerQueueLen = 64
+
+// A Server exports a can.Device to cannelloni peers. Messages read
+// from the device are forwarded to all peers connected at that time,
+// messages received from any peer are written to the device.
+type Server struct {
+	dev can.Device
+
+	mu    sync.Mutex
+	peers map[*peer]struct{}
+	err   error
+}
+
+type peer struct {
+	c    *conn
+	out  chan []byte
+	quit chan struct{}
+}
+
+// NewServer returns a server exporting dev, and starts reading
+// messages from dev. Messages received while no peer is connected
+// are dropped. The server stops, and disconnects all peers, when
+// reading from the device fails, e.g. after it has been closed.
+func NewServer(dev can.Device) *Server {
+	s := new(Server)
+	s.dev = dev
+	s.peers = make(map[*peer]struct{})
+	go s.readLoop()
+	return s
+}
+
+func (s *Server) readLoop() {
+	buf := make([]can.Msg, 32)
+	for i := range buf {
+		pd := make(can.PlainData, 0, 64)
+		buf[i].Attach(&pd)
+	}
+	for {
+		n, err := s.dev.Read(buf)
+		s.mu.Lock()
+		if err != nil {
+			s.err = err
+			for p := range s.peers {
+				p.c.nc.Close()
+			}
+			s.mu.Unlock()
+			return
+		}
+		for p := range s.peers {
+			for _, b := range p.c.encode(buf[:n]) {
+				select {
+				case p.out <- b:
+				default:
+					// the peer is not able to keep up
+				}
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Serve accepts TCP connections on l, and serves each of them
+// in a separate goroutine using ServeConn. It returns the error
+// returned by l's Accept method.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(nc)
+	}
+}
+
+// ServeConn serves a single peer connected through nc, which may be
+// a TCP connection, or a connected *net.UDPConn (see NewDevice). It
+// returns when the connection fails, or the server has been stopped,
+// and closes nc. A connection closed by a TCP peer is not reported as
+// an error.
+func (s *Server) ServeConn(nc net.Conn) error {
+	defer nc.Close()
+	c, err := newConn(nc)
+	if err != nil {
+		return err
+	}
+	p := &peer{c: c, out: make(chan []byte, peerQueueLen), quit: make(chan struct{})}
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return s.err
+	}
+	s.peers[p] = struct{}{}
+	s.mu.Unlock()
+	go p.writeLoop()
+	defer func() {
+		s.mu.Lock()
+		delete(s.peers, p)
+		s.mu.Unlock()
+		close(p.quit)
+	}()
+
+	var m can.Msg
+	setData := importData(nil)
+	for {
+		if err := c.readMsg(&m, setData); err != nil {
+			s.mu.Lock()
+			if s.err != nil {
+				err = s.err
+			}
+			s.mu.Unlock()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		err := s.dev.WriteMsg(&m)
+		if err != nil && !errors.Is(err, can.ErrTxQueueFull) {
+			return err
+		}
+	}
+}
+
+// writeLoop writes queued messages to the peer. Write errors on
+// packet connections are ignored, as they may be caused by a peer
+// that has not been started yet.
+func (p *peer) writeLoop() {
+	for {
+		select {
+		case b := <-p.out:
+			_, err := p.c.nc.Write(b)
+			if err != nil && p.c.stream {
+				p.c.nc.Close()
+				return
+			}
+		case <-p.quit:
+			return
+		}
+	}
+}
@@ -12,6 +12,12 @@ type FlagsMap []struct {
 	Drv int       // a flag as defined by the driver
 }
 
+// NoCtrlModes may be passed to can.Config.ResolveCtrlModes by
+// drivers that do not support any controller modes.
+func NoCtrlModes(can.CtrlMode) bool {
+	return false
+}
+
 func (m FlagsMap) Decode(v int) (f can.Flags) {
 	for i := range m {
 		if v&m[i].Drv != 0 {
@@ -0,0 +1,42 @@
+package drv
+
+import "github.com/knieriem/can"
+
+// AcceptMsg reports whether a message passes the list of message
+// filters, for drivers that have to implement filtering in software.
+//
+// The filters are evaluated in order, considering only those matching
+// the frame type (standard or extended) of m. A non-inverted filter adds
+// the IDs it covers to the set of accepted IDs, an inverted filter
+// removes them. If the first applicable filter is inverted, evaluation
+// starts from a set containing all IDs. This is the same logic as used
+// by the pcan driver, when it translates filters into ID ranges.
+//
+// An empty list of filters accepts all messages, as do status messages.
+func AcceptMsg(filters []can.MsgFilter, m *can.Msg) bool {
+	if len(filters) == 0 || m.IsStatus() {
+		return true
+	}
+	ext := m.ExtFrame()
+	accept := false
+	first := true
+	for i := range filters {
+		f := &filters[i]
+		if f.ExtFrame != ext {
+			continue
+		}
+		match := m.Id&f.IDMask == f.ID&f.IDMask
+		if f.Invert {
+			if first {
+				accept = true
+			}
+			if match {
+				accept = false
+			}
+		} else if match {
+			accept = true
+		}
+		first = false
+	}
+	return accept
+}
@@ -0,0 +1,121 @@
+// Package gvret implements a server for the binary protocol of GVRET,
+// the firmware of several CAN adapters, allowing SavvyCAN to access a
+// can.Device via TCP, or a pseudo terminal.
+//
+// The device is accessed through a share.Hub, so that other users,
+// like a test automation, may use the device at the same time. Frames
+// written by these users are visible to GVRET clients, and vice versa.
+//
+// The device is exported as the first bus of a GVRET adapter;
+// frames that clients send to other buses are dropped. Clients cannot
+// change the bitrate; requests to set up a bus are ignored.
+// Remote frames are not forwarded, since the protocol has no
+// representation for them.
+package gvret
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/knieriem/can"
+)
+
+// DefaultPort is the TCP port GVRET adapters listen on.
+const DefaultPort = "23"
+
+var ErrProtocol = errors.New("gvret: protocol error")
+
+// startCmd is the first byte of each command, and of frames sent to the client.
+const startCmd = 0xF1
+
+// command codes
+const (
+	cmdBuildFrame    = 0
+	cmdTimeSync      = 1
+	cmdDigInputs     = 2
+	cmdAnaInputs     = 3
+	cmdSetDigOutputs = 4
+	cmdSetupBus      = 5
+	cmdGetBusParams  = 6
+	cmdGetDevInfo    = 7
+	cmdSetSingleWire = 8
+	cmdKeepAlive     = 9
+	cmdSetSysType    = 10
+	cmdEchoFrame     = 11
+	cmdGetNumBuses   = 12
+	cmdGetExtBuses   = 13
+	cmdSetExtBuses   = 14
+	cmdBuildFDFrame  = 20
+)
+
+const (
+	extFrameBit   = 1 << 31
+	buildNum      = 618 // reported in the device info
+	eepromVersion = 0x20
+)
+
+// argLen contains the number of argument bytes of commands
+// with a fixed length; commands not listed have no arguments.
+var argLen = map[byte]int{
+	cmdSetDigOutputs: 1,
+	cmdSetupBus:      8,
+	cmdSetSingleWire: 1,
+	cmdSetSysType:    1,
+	cmdSetExtBuses:   12,
+}
+
+// appendFrame appends a frame received from the bus, as sent
+// to the client. It reports false if the message cannot be
+// represented, like status messages, remote, and CAN XL frames.
+func appendFrame(b []byte, m *can.Msg) ([]byte, bool) {
+	if m.IsStatus() || m.Test(can.RTRMsg) || m.XLFrame() {
+		return b, false
+	}
+	data := m.Data()
+	if _, _, err := can.VerifyDataLenFD(len(data)); err != nil {
+		return b, false
+	}
+	id := m.Id
+	if m.ExtFrame() {
+		id |= extFrameBit
+	}
+	fd := len(data) > 8 || m.Test(can.ForceFD) || m.Test(can.FDSwitchBitrate)
+	if fd {
+		b = append(b, startCmd, cmdBuildFDFrame)
+	} else {
+		b = append(b, startCmd, cmdBuildFrame)
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(m.Rx.Time))
+	b = binary.LittleEndian.AppendUint32(b, id)
+	if fd {
+		b = append(b, byte(len(data)), 0)
+	} else {
+		b = append(b, byte(len(data)))
+	}
+	b = append(b, data...)
+	return append(b, 0), true
+}
+
+// parseFrame decodes the header of a frame sent by the client, i.e.
+// identifier, bus, and length, and returns the bus number, and the
+// data length.
+func parseFrame(m *can.Msg, hdr []byte, fd bool) (bus int, n int, err error) {
+	id := binary.LittleEndian.Uint32(hdr)
+	m.Reset()
+	if id&extFrameBit != 0 {
+		m.Flags |= can.ExtFrame
+		m.Id = id &^ extFrameBit & 0x1FFFFFFF
+	} else {
+		m.Id = id & 0x7FF
+	}
+	bus = int(hdr[4])
+	n = int(hdr[5])
+	if !fd {
+		return bus, min(n&0x0F, 8), nil
+	}
+	if n > 64 {
+		return 0, 0, ErrProtocol
+	}
+	m.Flags |= can.ForceFD
+	return bus, n, nil
+}
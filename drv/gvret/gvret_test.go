@@ -0,0 +1,213 @@
+package gvret
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/share"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+func TestFrame(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		enc  string
+	}{
+		{"123#0102", "f1 00 78 56 34 12 23 01 00 00 02 01 02 00"},
+		{"12345678#", "f1 00 78 56 34 12 78 56 34 92 00 00"},
+		{"7FF##0AA", "f1 14 78 56 34 12 ff 07 00 00 01 00 aa 00"},
+		{"100##1" + strings.Repeat("55", 12), "f1 14 78 56 34 12 00 01 00 00 0c 00" + strings.Repeat(" 55", 12) + " 00"},
+	} {
+		var m can.Msg
+		if err := m.FromExpr(tc.expr); err != nil {
+			t.Fatal(err)
+		}
+		m.Rx.Time = 0x512345678
+		b, ok := appendFrame(nil, &m)
+		if !ok {
+			t.Fatalf("%s: not encoded", tc.expr)
+		}
+		if enc := fmt.Sprintf("% x", b); enc != tc.enc {
+			t.Errorf("%s:\ngot  %s\nwant %s", tc.expr, enc, tc.enc)
+		}
+
+		// frames sent by the client have no timestamp,
+		// and bus and length in separate bytes
+		fd := b[1] == cmdBuildFDFrame
+		bus, n := b[10]>>4, b[10]&0x0F
+		if fd {
+			bus, n = b[11], b[10]
+		}
+		hdr := append(slices.Clone(b[6:10]), bus, n)
+		var m2 can.Msg
+		bus1, n1, err := parseFrame(&m2, hdr, fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bus1 != 0 || n1 != len(m.Data()) || m2.Id != m.Id || m2.ExtFrame() != m.ExtFrame() {
+			t.Errorf("%s: decoded %d %d %x %v", tc.expr, bus1, n1, m2.Id, m2.Flags)
+		}
+	}
+
+	var m can.Msg
+	m.FromExpr("123#R")
+	if _, ok := appendFrame(nil, &m); ok {
+		t.Error("remote frame encoded")
+	}
+
+	m.Reset()
+	m.Id = 0x123
+	m.Flags = can.XLFrame
+	m.SetData(make([]byte, 100))
+	if _, ok := appendFrame(nil, &m); ok {
+		t.Error("XL frame encoded")
+	}
+	m.Flags = can.ForceFD
+	m.SetData(make([]byte, 300))
+	if _, ok := appendFrame(nil, &m); ok {
+		t.Error("oversized frame encoded")
+	}
+}
+
+func readMsg(t *testing.T, d can.Device) *can.Msg {
+	t.Helper()
+	timer := time.AfterFunc(2*time.Second, func() {
+		t.Error("timeout")
+		d.Close()
+	})
+	defer timer.Stop()
+	buf := make([]can.Msg, 1)
+	if _, err := d.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return &buf[0]
+}
+
+// client is a raw client connection.
+type client struct {
+	t  *testing.T
+	rw io.ReadWriter
+}
+
+func (c *client) send(b ...byte) {
+	c.t.Helper()
+	if _, err := c.rw.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) expect(want ...byte) []byte {
+	c.t.Helper()
+	if d, ok := c.rw.(interface{ SetReadDeadline(time.Time) error }); ok {
+		d.SetReadDeadline(time.Now().Add(2 * time.Second))
+	}
+	b := make([]byte, len(want))
+	if _, err := io.ReadFull(c.rw, b); err != nil {
+		c.t.Fatal(err)
+	}
+	for i := range want {
+		// 0xFF matches any value
+		if want[i] != 0xFF && b[i] != want[i] {
+			c.t.Fatalf("got % x, want % x", b, want)
+		}
+	}
+	return b
+}
+
+// session runs a sequence of commands like SavvyCAN
+// does, and tests the forwarding of frames.
+func (c *client) session(local, automation can.Device) {
+	t := c.t
+	t.Helper()
+	c.send(0xE7, 0xE7, 0xF1, cmdGetNumBuses)
+	c.expect(0xF1, cmdGetNumBuses, 1)
+	c.send(0xF1, cmdGetBusParams)
+	b := c.expect(0xF1, cmdGetBusParams, 1, 0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0, 0)
+	if br := binary.LittleEndian.Uint32(b[3:]); br != 500000 {
+		t.Errorf("bitrate: %d", br)
+	}
+	c.send(0xF1, cmdSetupBus, 0, 0, 0, 0, 0, 0, 0, 0, 0xF1, cmdKeepAlive)
+	c.expect(0xF1, cmdKeepAlive, 0xDE, 0xAD)
+	c.send(0xF1, cmdGetDevInfo)
+	c.expect(0xF1, cmdGetDevInfo, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+	c.send(0xF1, cmdTimeSync)
+	b = c.expect(0xF1, cmdTimeSync, 0xFF, 0xFF, 0xFF, 0xFF)
+	now := uint32(can.Now())
+	if dt := now - binary.LittleEndian.Uint32(b[2:]); dt > 1e6 {
+		t.Errorf("time sync: off by %d µs", dt)
+	}
+
+	// frames from the bus, and from other users of the hub
+	var m can.Msg
+	m.FromExpr("123#0102")
+	if err := local.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(0xF1, cmdBuildFrame, 0xFF, 0xFF, 0xFF, 0xFF, 0x23, 0x01, 0, 0, 2, 1, 2, 0)
+	readMsg(t, automation)
+	m.FromExpr("12345678##1" + strings.Repeat("AA", 12))
+	if err := automation.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	c.expect(append([]byte{0xF1, cmdBuildFDFrame, 0xFF, 0xFF, 0xFF, 0xFF, 0x78, 0x56, 0x34, 0x92, 12, 0},
+		append(bytes.Repeat([]byte{0xAA}, 12), 0)...)...)
+	readMsg(t, local)
+
+	// frames from the client
+	c.send(0xF1, cmdBuildFrame, 0x21, 0x03, 0, 0, 0, 1, 0x55, 0)
+	for _, d := range []can.Device{local, automation} {
+		if r := readMsg(t, d); r.Id != 0x321 || r.ExtFrame() || !bytes.Equal(r.Data(), []byte{0x55}) {
+			t.Errorf("got %x %v % x", r.Id, r.Flags, r.Data())
+		}
+	}
+	c.send(0xF1, cmdBuildFrame, 0x21, 0x03, 0, 0, 1, 1, 0x66, 0) // bus 1
+	c.send(append([]byte{0xF1, cmdBuildFDFrame, 0x00, 0x01, 0, 0x80, 0, 16}, make([]byte, 17)...)...)
+	for _, d := range []can.Device{local, automation} {
+		if r := readMsg(t, d); r.Id != 0x100 || !r.ExtFrame() || len(r.Data()) != 16 {
+			t.Errorf("got %x %v % x", r.Id, r.Flags, r.Data())
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	exported, err := can.Open("virtual:gvret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := can.Open("virtual:gvret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	h := share.New(exported)
+	defer h.Close()
+	automation, err := h.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(h)
+	s.Bitrate = 500000
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+
+	nc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c := &client{t: t, rw: nc}
+	c.session(local, automation)
+}
@@ -0,0 +1,53 @@
+package gvret
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// interval at which Read checks whether a client has opened the pty
+var ptyPollInterval = 200 * time.Millisecond
+
+// A Pty is the master side of a pseudo terminal. Clients like
+// SavvyCAN connect to the slave side, as if it was the serial
+// port of a GVRET adapter.
+type Pty struct {
+	// Name is the path of the slave side.
+	Name string
+
+	f         *os.File
+	connected bool
+}
+
+// Read waits until a client has opened the slave side, and reads
+// data sent by the client. After the client has closed the slave
+// side, Read returns io.EOF once, and discards any pending output.
+func (p *Pty) Read(b []byte) (int, error) {
+	for {
+		n, err := p.f.Read(b)
+		if !errors.Is(err, syscall.EIO) {
+			if n > 0 {
+				p.connected = true
+			}
+			return n, err
+		}
+		// EIO denotes that the slave side is not open
+		if p.connected {
+			p.connected = false
+			p.flush()
+			return 0, io.EOF
+		}
+		time.Sleep(ptyPollInterval)
+	}
+}
+
+func (p *Pty) Write(b []byte) (int, error) {
+	return p.f.Write(b)
+}
+
+func (p *Pty) Close() error {
+	return p.f.Close()
+}
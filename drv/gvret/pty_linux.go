@@ -0,0 +1,67 @@
+package gvret
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPty creates a pseudo terminal in raw mode.
+func OpenPty() (*Pty, error) {
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.SyscallConn()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	var n int
+	var err1 error
+	err = rc.Control(func(fd uintptr) {
+		err1 = unix.IoctlSetPointerInt(int(fd), unix.TIOCSPTLCK, 0)
+		if err1 == nil {
+			n, err1 = unix.IoctlGetInt(int(fd), unix.TIOCGPTN)
+		}
+		if err1 == nil {
+			err1 = setRaw(int(fd))
+		}
+	})
+	if err == nil {
+		err = err1
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Pty{Name: "/dev/pts/" + strconv.Itoa(n), f: f}, nil
+}
+
+func setRaw(fd int) error {
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON | unix.IXOFF
+	t.Oflag &^= unix.OPOST
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(fd, unix.TCSETS, t)
+}
+
+// flush discards data not yet read by the client.
+func (p *Pty) flush() {
+	rc, err := p.f.SyscallConn()
+	if err != nil {
+		return
+	}
+	rc.Control(func(fd uintptr) {
+		unix.IoctlSetInt(int(fd), unix.TCFLSH, unix.TCIOFLUSH)
+	})
+}
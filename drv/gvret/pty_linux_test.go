@@ -0,0 +1,56 @@
+package gvret
+
+import (
+	"os"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/share"
+	"golang.org/x/sys/unix"
+)
+
+func TestPty(t *testing.T) {
+	exported, err := can.Open("virtual:gvret-pty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := can.Open("virtual:gvret-pty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	h := share.New(exported)
+	defer h.Close()
+	automation, err := h.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := OpenPty()
+	if err != nil {
+		t.Skip(err)
+	}
+	s := NewServer(h)
+	s.Bitrate = 500000
+	done := make(chan error)
+	go func() {
+		done <- s.ServePty(p)
+	}()
+
+	// connect twice, to see that the server
+	// handles the client closing the slave side
+	for range 2 {
+		f, err := os.OpenFile(p.Name, os.O_RDWR|unix.O_NOCTTY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &client{t: t, rw: f}
+		c.session(local, automation)
+		f.Close()
+	}
+
+	p.Close()
+	if err := <-done; err == nil {
+		t.Error("ServePty: no error after close")
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package gvret
+
+import (
+	"errors"
+	"runtime"
+)
+
+// OpenPty creates a pseudo terminal in raw mode.
+func OpenPty() (*Pty, error) {
+	return nil, errors.New("gvret: pseudo terminals not supported on " + runtime.GOOS)
+}
+
+func (p *Pty) flush() {
+}
@@ -0,0 +1,222 @@
+package gvret
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/share"
+)
+
+// A Server exports the device of a share.Hub to GVRET clients.
+// Multiple clients may be connected at the same time.
+type Server struct {
+	// Bitrate is reported to clients as the bitrate of the bus.
+	Bitrate int
+
+	hub *share.Hub
+}
+
+// NewServer returns a server exporting the device of h.
+func NewServer(h *share.Hub) *Server {
+	return &Server{hub: h}
+}
+
+// Serve accepts connections on l, and serves each of them
+// in a separate goroutine using ServeConn. It returns the error
+// returned by l's Accept method.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			s.ServeConn(nc)
+			nc.Close()
+		}()
+	}
+}
+
+// ServePty serves clients connecting through the slave side of p,
+// one after another, until p is closed.
+func (s *Server) ServePty(p *Pty) error {
+	for {
+		if err := s.ServeConn(p); err != nil {
+			return err
+		}
+	}
+}
+
+// ServeConn serves a single client connected through rw, until reading
+// from rw fails, or the hub's device cannot be read anymore. In the
+// latter case, rw is closed, if it implements io.Closer. A connection
+// closed by the client is not reported as an error.
+func (s *Server) ServeConn(rw io.ReadWriter) error {
+	d, err := s.hub.Open()
+	if err != nil {
+		return err
+	}
+	c := &session{s: s, rw: rw, dev: d}
+	done := make(chan struct{})
+	go func() {
+		c.forward()
+		close(done)
+	}()
+	err = c.run(bufio.NewReader(rw))
+	d.Close()
+	<-done
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// session holds the state of a client connection.
+type session struct {
+	s   *Server
+	rw  io.ReadWriter
+	dev can.Device
+
+	// set as soon as the client has sent a command;
+	// frames are not forwarded before
+	active atomic.Bool
+
+	wmu sync.Mutex
+}
+
+func (c *session) write(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.rw.Write(b)
+	return err
+}
+
+// forward sends frames read from the device to the client.
+func (c *session) forward() {
+	buf := make([]can.Msg, 32)
+	for i := range buf {
+		pd := make(can.PlainData, 0, 64)
+		buf[i].Attach(&pd)
+	}
+	var b []byte
+	for {
+		n, err := c.dev.Read(buf)
+		if err != nil {
+			if err != io.EOF {
+				if cl, ok := c.rw.(io.Closer); ok {
+					cl.Close()
+				}
+			}
+			return
+		}
+		if !c.active.Load() {
+			continue
+		}
+		b = b[:0]
+		for i := range buf[:n] {
+			b, _ = appendFrame(b, &buf[i])
+		}
+		if len(b) != 0 {
+			c.write(b)
+		}
+	}
+}
+
+func (c *session) run(r *bufio.Reader) error {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != startCmd {
+			// 0xE7, sent by clients to enter binary mode, or
+			// characters of the ASCII protocol, which is not supported
+			continue
+		}
+		cmd, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		c.active.Store(true)
+		if err := c.command(r, cmd); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *session) command(r *bufio.Reader, cmd byte) error {
+	var b []byte
+	switch cmd {
+	case cmdBuildFrame, cmdBuildFDFrame, cmdEchoFrame:
+		var m can.Msg
+		hdr := make([]byte, 6)
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return err
+		}
+		bus, n, err := parseFrame(&m, hdr, cmd == cmdBuildFDFrame)
+		if err != nil {
+			// resynchronize at the next command
+			return nil
+		}
+		data := make([]byte, n+1) // including checksum
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		m.SetData(data[:n])
+		if cmd == cmdEchoFrame {
+			m.Rx.Time = can.Now()
+			if b, ok := appendFrame(nil, &m); ok {
+				return c.write(b)
+			}
+			return nil
+		}
+		if bus == 0 {
+			c.dev.WriteMsg(&m)
+		}
+		return nil
+
+	case cmdTimeSync:
+		b = append(b, startCmd, cmd)
+		b = binary.LittleEndian.AppendUint32(b, uint32(can.Now()))
+
+	case cmdDigInputs:
+		b = append(b, startCmd, cmd, 0, 0)
+
+	case cmdAnaInputs:
+		b = append(b, startCmd, cmd)
+		b = append(b, make([]byte, 2*7+1)...)
+
+	case cmdGetBusParams:
+		b = append(b, startCmd, cmd, 1)
+		b = binary.LittleEndian.AppendUint32(b, uint32(c.s.Bitrate))
+		b = append(b, 0, 0, 0, 0, 0)
+
+	case cmdGetDevInfo:
+		b = append(b, startCmd, cmd)
+		b = binary.LittleEndian.AppendUint16(b, buildNum)
+		b = append(b, eepromVersion, 0, 0, 0)
+
+	case cmdKeepAlive:
+		b = append(b, startCmd, cmd, 0xDE, 0xAD)
+
+	case cmdGetNumBuses:
+		b = append(b, startCmd, cmd, 1)
+
+	case cmdGetExtBuses:
+		b = append(b, startCmd, cmd)
+		b = append(b, make([]byte, 3*5)...)
+
+	default:
+		if n := argLen[cmd]; n != 0 {
+			_, err := r.Discard(n)
+			return err
+		}
+		return nil
+	}
+	return c.write(b)
+}
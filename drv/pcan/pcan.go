@@ -2,6 +2,17 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Package pcan implements a driver for PEAK-System's PCAN adapters,
+// using the PCAN-Basic library on Windows, and the character device
+// interface of the pcan driver on Linux.
+//
+// The delay after which the controller is restarted after a bus-off
+// condition cannot be adjusted. A restart delay of zero disables
+// automatic restarts, so that the device must be restarted using
+// can.Restarter. A non-zero delay enables automatic restarts, as far
+// as supported, but is rejected unless it is a soft request. On
+// Windows, automatic restarts are enabled by default; the character
+// device driver on Linux does not restart the controller automatically.
 package pcan
 
 import (
@@ -66,6 +77,26 @@ var DevSpecFD = timing.Controller{
 	},
 }
 
+var errRestartDelay = errors.New("restart delay cannot be adjusted")
+
+// autoreset reports whether conf requests automatic restarts
+// after bus-off, returning def if conf does not specify a restart
+// delay. As the delay cannot be adjusted, a non-zero delay must be
+// a soft request.
+func autoreset(conf *can.Config, def bool) (bool, error) {
+	if conf == nil || !conf.RestartDelay.Valid {
+		return def, nil
+	}
+	d := &conf.RestartDelay
+	if d.Value == 0 {
+		return false, nil
+	}
+	if !d.Soft {
+		return false, errRestartDelay
+	}
+	return true, nil
+}
+
 func timingConf(c *can.Config) (tc uint16, err error) {
 	if c == nil {
 		return defaultBitrate, nil
@@ -80,6 +111,12 @@ func timingConf(c *can.Config) (tc uint16, err error) {
 	return defaultBitrate, nil
 }
 
+// supportsCtrlMode reports whether controller mode m
+// is supported by PCAN adapters.
+func supportsCtrlMode(m can.CtrlMode) bool {
+	return m == can.ModeListenOnly
+}
+
 type busList []*bus
 
 func (buses busList) lookup(name string) *bus {
@@ -161,6 +198,60 @@ var errFlagsMap = drv.FlagsMap{
 	{can.DataOverrun, int(api.ErrOVERRUN)},
 }
 
+// busError returns a can.BusError describing the controller
+// state, and overrun conditions contained in st.
+func busError(st api.Status) *can.BusError {
+	e := new(can.BusError)
+	switch {
+	case st.Test(api.ErrBUSOFF):
+		e.Class |= can.ClassBusOff
+	case st.Test(busPassive):
+		e.Class |= can.ClassCtrl
+		e.Ctrl |= can.CtrlRxPassive | can.CtrlTxPassive
+	case st.Test(busWarning):
+		e.Class |= can.ClassCtrl
+		e.Ctrl |= can.CtrlRxWarning | can.CtrlTxWarning
+	}
+	if st.Test(api.ErrOVERRUN | api.ErrQOVERRUN) {
+		e.Class |= can.ClassCtrl
+		e.Ctrl |= can.CtrlRxOverflow
+	}
+	return e
+}
+
+// setStatus turns dst into a status message reporting st. The
+// flags are decoded using errFlagsMap; the attached can.BusError
+// provides the controller state, and overrun conditions. If st
+// does not map to any error class, no data is attached.
+func setStatus(dst *can.Msg, st api.Status) {
+	if e := busError(st); e.Class != 0 {
+		dst.SetBusError(e)
+	} else {
+		dst.Reset()
+	}
+	dst.Flags = errFlagsMap.Decode(int(st)) | can.StatusMsg
+}
+
+// Status bits that do not indicate a failure of the status request.
+const busStatus = api.ErrANYBUSERR | api.ErrOVERRUN | api.ErrQOVERRUN |
+	api.ErrXMTFULL | api.ErrQXMTFULL | api.ErrQRCVEMPTY
+
+// ControllerState returns the controller state derived from the
+// status reported by the PCAN driver. Neither error counters,
+// nor the number of restarts are provided by the driver.
+func (d *dev) ControllerState() (*can.ControllerState, error) {
+	st := d.h.Status()
+	if st&^busStatus != 0 {
+		return nil, st
+	}
+	cs := new(can.ControllerState)
+	cs.State = busError(st).State()
+	if cs.State == can.StateUnknown {
+		cs.State = can.StateErrorActive
+	}
+	return cs, nil
+}
+
 func encode(dst *api.Msg, src *can.Msg) {
 	dst.ID = src.Id
 	data := src.Data()
@@ -71,6 +71,7 @@ type dev struct {
 	file    io.Closer
 	h       api.Fd
 	info    can.DeviceInfo
+	init    api.Init
 	receive struct {
 		epoll  *epoll.Pollster
 		status api.Status
@@ -116,11 +117,26 @@ func (*driver) Open(_ *can.Env, devName string, conf *can.Config) (cd can.Device
 	if err != nil {
 		return nil, err
 	}
+	// Automatic restarts are not supported by the character
+	// device driver, so a soft request is ignored.
+	if _, err = autoreset(conf, false); err != nil {
+		return nil, err
+	}
+	listenOnly := false
+	if conf != nil {
+		if err = conf.ResolveCtrlModes(supportsCtrlMode); err != nil {
+			return nil, err
+		}
+		listenOnly = conf.ListenOnly.Valid && conf.ListenOnly.Value
+	}
 
-	var i api.Init
+	i := &d.init
 	i.WBTR0BTR1 = bitrate
 	i.UcCANMsgType = api.MsgExtended
-	err = d.h.Init(&i)
+	if listenOnly {
+		i.UcListenOnly = 1
+	}
+	err = d.h.Init(i)
 	if err != nil {
 		if runtime.GOARCH == "386" && err == syscall.EINVAL {
 			err = errors.New("32-bit program / 64-bit driver mismatch")
@@ -188,6 +204,9 @@ func (d *dev) Read(buf []can.Msg) (n int, err error) {
 	return
 }
 
+// Status bits indicating the error warning, and error passive states.
+var busWarning, busPassive = api.ErrBUSLIGHT, api.ErrBUSHEAVY
+
 var msgFlagsMap = drv.FlagsMap{
 	{can.RTRMsg, api.MsgRtr},
 	{can.ExtFrame, api.MsgExtended},
@@ -204,10 +223,7 @@ func (d *dev) decode(dst *can.Msg, m *api.Msg, µs int64) (st api.Status) {
 
 	if m.MSGTYPE&api.MsgStatus != 0 {
 		st = api.Status(binary.BigEndian.Uint32(m.DATA[0:4]))
-		dst.Flags = errFlagsMap.Decode(int(st))
-		dst.Flags |= can.StatusMsg
-		dst.Id = 0
-		dst.SetData(nil)
+		setStatus(dst, st)
 		return
 	}
 	dst.Id = m.ID
@@ -233,6 +249,14 @@ func (d *dev) WriteMsg(cm *can.Msg) (err error) {
 	return
 }
 
+// Restart restarts the CAN controller after a bus-off
+// condition by initializing the channel again.
+func (d *dev) Restart() (err error) {
+	err = d.h.Init(&d.init)
+	wrapErr("restart", &err)
+	return
+}
+
 func (d *dev) Close() (err error) {
 	d.receive.epoll.Close()
 	err = d.file.Close()
@@ -0,0 +1,82 @@
+package pcan
+
+import (
+	"testing"
+
+	"github.com/knieriem/can"
+	api "github.com/knieriem/can/drv/pcan/internal/api"
+)
+
+func TestBusError(t *testing.T) {
+	tests := []struct {
+		st       api.Status
+		state    can.State
+		overflow bool
+	}{
+		{api.OK, can.StateUnknown, false},
+		{busWarning, can.StateErrorWarning, false},
+		{busPassive, can.StateErrorPassive, false},
+		{api.ErrBUSOFF, can.StateBusOff, false},
+		{api.ErrBUSOFF | busPassive, can.StateBusOff, false},
+		{api.ErrOVERRUN, can.StateUnknown, true},
+		{busPassive | api.ErrQOVERRUN, can.StateErrorPassive, true},
+	}
+	for _, tt := range tests {
+		e := busError(tt.st)
+		if s := e.State(); s != tt.state {
+			t.Errorf("%#x: got state %v, want %v", uint32(tt.st), s, tt.state)
+		}
+		if overflow := e.Ctrl&can.CtrlRxOverflow != 0; overflow != tt.overflow {
+			t.Errorf("%#x: overflow: got %v, want %v", uint32(tt.st), overflow, tt.overflow)
+		}
+
+		var m can.Msg
+		m.SetData([]byte{1, 2, 3})
+		setStatus(&m, tt.st)
+		if !m.IsStatus() {
+			t.Errorf("%#x: not a status message", uint32(tt.st))
+		}
+		if tt.st.Test(api.ErrOVERRUN) != m.Test(can.DataOverrun) {
+			t.Errorf("%#x: unexpected flags %#x", uint32(tt.st), m.Flags)
+		}
+		got, ok := m.BusError()
+		if e.Class == 0 {
+			if ok || m.Id != 0 || len(m.Data()) != 0 {
+				t.Errorf("%#x: unexpected bus error %+v: id %#x, data % x", uint32(tt.st), got, m.Id, m.Data())
+			}
+			continue
+		}
+		if !ok || got != *e {
+			t.Errorf("%#x: got bus error %+v, want %+v", uint32(tt.st), got, *e)
+		}
+	}
+}
+
+func TestAutoreset(t *testing.T) {
+	tests := []struct {
+		spec  string
+		def   bool
+		reset bool
+		err   error
+	}{
+		{"500k", true, true, nil},
+		{"500k", false, false, nil},
+		{"500k restart:0", true, false, nil},
+		{"500k restart:0", false, false, nil},
+		{"500k restart?:100", false, true, nil},
+		{"500k restart:100", true, false, errRestartDelay},
+	}
+	for _, tt := range tests {
+		conf, err := can.ParseConfig(tt.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reset, err := autoreset(conf, tt.def)
+		if reset != tt.reset || err != tt.err {
+			t.Errorf("%q, %v: got %v, %v", tt.spec, tt.def, reset, err)
+		}
+	}
+	if reset, _ := autoreset(nil, true); !reset {
+		t.Error("nil config: autoreset expected")
+	}
+}
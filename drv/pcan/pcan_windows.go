@@ -115,6 +115,18 @@ type dev struct {
 	msg    api.Msg
 	fdMsg  api.MsgFD
 	fdMode bool
+
+	init initParams // kept for Restart
+}
+
+// initParams contains the settings applied when
+// the channel is initialized.
+type initParams struct {
+	btr0btr1   uint16
+	btStr      string // bit timing in FD mode
+	autoreset  bool
+	listenOnly bool
+	filters    []can.MsgFilter
 }
 
 func (*driver) Scan() (list []can.DeviceInfo) {
@@ -167,38 +179,32 @@ func (*driver) Open(_ *can.Env, devName string, conf *can.Config) (cd can.Device
 	if err != nil {
 		return nil, err
 	}
-	if btStr != "" {
-		if err = h.InitializeFD(btStr); err != nil {
-			return nil, err
-		}
-	} else if err = h.Initialize(api.Baudrate(btr0btr1), 0, 0, 0).Err(); err != nil {
+	reset, err := autoreset(conf, true)
+	if err != nil {
 		return nil, err
 	}
-
-	if err = h.SetValue(api.BusoffAutoreset, true).Err(); err != nil {
-		h.Uninitialize()
-		return
+	d.init = initParams{
+		btr0btr1:  btr0btr1,
+		btStr:     btStr,
+		autoreset: reset,
 	}
-
-	if d.receive.ev, err = windows.CreateEvent(nil, 0, 0, nil); err != nil {
-		h.Uninitialize()
-		return
+	if conf != nil {
+		if err = conf.ResolveCtrlModes(supportsCtrlMode); err != nil {
+			return nil, err
+		}
+		d.init.listenOnly = conf.ListenOnly.Valid && conf.ListenOnly.Value
+		d.init.filters = slices.Clone(conf.MsgFilter)
 	}
 
-	if err = h.SetValue(api.ReceiveEvent, d.receive.ev).Err(); err != nil {
-		h.Uninitialize()
+	if d.receive.ev, err = windows.CreateEvent(nil, 0, 0, nil); err != nil {
 		return
 	}
-
-	if len(conf.MsgFilter) != 0 {
-		err = h.FilterMsgs(conf.MsgFilter)
-		if err != nil {
-			h.Uninitialize()
-			return nil, err
-		}
+	d.h = h
+	if err = d.initialize(); err != nil {
+		windows.CloseHandle(d.receive.ev)
+		return nil, err
 	}
 
-	d.h = h
 	d.info = can.DeviceInfo{
 		ID:     b.name + strconv.Itoa(i+1),
 		Driver: "pcan",
@@ -211,6 +217,42 @@ func (*driver) Open(_ *can.Env, devName string, conf *can.Config) (cd can.Device
 	return
 }
 
+// initialize initializes the channel using the parameters
+// in d.init. If it fails, the channel is uninitialized.
+func (d *dev) initialize() (err error) {
+	h := d.h
+	p := &d.init
+	if p.btStr != "" {
+		err = h.InitializeFD(p.btStr)
+	} else {
+		err = h.Initialize(api.Baudrate(p.btr0btr1), 0, 0, 0).Err()
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			h.Uninitialize()
+		}
+	}()
+
+	if err = h.SetValue(api.BusoffAutoreset, p.autoreset).Err(); err != nil {
+		return err
+	}
+	if p.listenOnly {
+		if err = h.SetValue(api.ListenOnly, true).Err(); err != nil {
+			return err
+		}
+	}
+	if err = h.SetValue(api.ReceiveEvent, d.receive.ev).Err(); err != nil {
+		return err
+	}
+	if len(p.filters) != 0 {
+		return h.FilterMsgs(p.filters)
+	}
+	return nil
+}
+
 func prepareBittiming(conf *can.Config, fdCapable bool) (tc uint16, btStr string, err error) {
 	if conf == nil {
 		return defaultBitrate, "", nil
@@ -297,9 +339,7 @@ func (d *dev) Read(buf []can.Msg) (n int, err error) {
 		case st.Test(api.ErrBUSHEAVY | api.ErrBUSOFF):
 			st &= api.ErrBUSHEAVY | api.ErrBUSOFF
 			if st != prevSt {
-				buf[n] = can.Msg{
-					Flags: errFlagsMap.Decode(int(st)) | can.StatusMsg,
-				}
+				setStatus(&buf[n], st)
 				n++
 				prevSt = st
 				return
@@ -368,6 +408,9 @@ func (d *dev) readMsg(m *can.Msg) error {
 	return d.decode(m, am.ID, am.MSGTYPE, am.Data(), µs)
 }
 
+// Status bits indicating the error warning, and error passive states.
+var busWarning, busPassive = api.ErrBUSLIGHT | api.ErrBUSWARNING, api.ErrBUSPASSIVE
+
 var msgFlagsMap = drv.FlagsMap{
 	{can.RTRMsg, api.MsgRtr},
 	{can.ExtFrame, api.MsgExtended},
@@ -385,10 +428,7 @@ func (d *dev) decode(dst *can.Msg, id uint32, msgType uint8, apiData []byte, µs
 
 	if msgType&api.MsgStatus != 0 {
 		st := api.Status(binary.BigEndian.Uint32(apiData))
-		dst.Flags = errFlagsMap.Decode(int(st))
-		dst.Flags |= can.StatusMsg
-		dst.Id = 0
-		dst.SetData(nil)
+		setStatus(dst, st)
 		return st.Err()
 	}
 	dst.Id = id
@@ -440,6 +480,17 @@ func encodeFD(dst *api.MsgFD, src *can.Msg) error {
 	return nil
 }
 
+// Restart restarts the CAN controller after a bus-off condition
+// by initializing the channel again. CAN_Reset is not sufficient,
+// as it only clears the receive and transmit queues.
+func (d *dev) Restart() (err error) {
+	defer wrapErr("restart", &err)
+	if err = d.h.Uninitialize().Err(); err != nil {
+		return err
+	}
+	return d.initialize()
+}
+
 func (d *dev) Close() (err error) {
 	err = d.h.Uninitialize().Err()
 	windows.SetEvent(d.receive.ev)
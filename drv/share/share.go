@@ -0,0 +1,277 @@
+// Package share allows multiple users within a process to access a
+// can.Device at the same time, like a test automation and a server
+// exporting the device to an analysis tool.
+//
+// A Hub reads messages from the device, and delivers each of them to
+// all handles opened on the hub. Messages written through a handle are
+// passed to the device, and, once written successfully, delivered to the
+// other handles as well, similar to the local loopback of SocketCAN;
+// their receive time is the time they were written.
+package share
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/knieriem/can"
+)
+
+const rxQueueLen = 1024
+
+var ErrClosed = errors.New("share: hub closed")
+
+// A Hub distributes messages read from a device to its handles.
+type Hub struct {
+	dev can.Device
+	wmu sync.Mutex // serializes writes to dev
+
+	mu      sync.Mutex
+	handles map[*handle]struct{}
+	err     error
+	closing bool
+}
+
+// New returns a hub for dev, and starts reading messages from dev.
+// Messages read while no handle is open are dropped.
+func New(dev can.Device) *Hub {
+	h := new(Hub)
+	h.dev = dev
+	h.handles = make(map[*handle]struct{})
+	go h.readLoop()
+	return h
+}
+
+func (h *Hub) readLoop() {
+	buf := make([]can.Msg, 32)
+	for i := range buf {
+		pd := make(can.PlainData, 0, 64)
+		buf[i].Attach(&pd)
+	}
+	for {
+		n, err := h.dev.Read(buf)
+		h.mu.Lock()
+		if err != nil {
+			if h.closing {
+				err = io.EOF
+			}
+			h.err = err
+			for d := range h.handles {
+				d.wake()
+			}
+			h.mu.Unlock()
+			return
+		}
+		for i := range buf[:n] {
+			f := newFrame(&buf[i], buf[i].Rx.Time)
+			for d := range h.handles {
+				d.receive(f)
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// Open returns a new handle. Closing the handle does not
+// affect the device, or other handles.
+func (h *Hub) Open() (can.Device, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.err != nil {
+		return nil, h.err
+	}
+	if h.closing {
+		return nil, ErrClosed
+	}
+	d := &handle{hub: h, notify: make(chan struct{}, 1)}
+	h.handles[d] = struct{}{}
+	return d, nil
+}
+
+// Close closes the device. Reading from handles results in io.EOF
+// after all messages received before have been read.
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	if h.closing {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closing = true
+	h.mu.Unlock()
+	return h.dev.Close()
+}
+
+// loopback delivers messages written by src to the other handles.
+func (h *Hub) loopback(src *handle, msgs []can.Msg) {
+	t := can.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i := range msgs {
+		if msgs[i].IsStatus() {
+			continue
+		}
+		f := newFrame(&msgs[i], t)
+		for d := range h.handles {
+			if d != src {
+				d.receive(f)
+			}
+		}
+	}
+}
+
+// frame is a copy of a message, shared by all handles.
+type frame struct {
+	id    uint32
+	flags can.Flags
+	xl    can.XLHeader
+	data  []byte
+	t     can.Time
+}
+
+func newFrame(m *can.Msg, t can.Time) *frame {
+	return &frame{
+		id:    m.Id,
+		flags: m.Flags,
+		xl:    m.XL,
+		data:  slices.Clone(m.Data()),
+		t:     t,
+	}
+}
+
+type handle struct {
+	hub *Hub
+
+	// protected by hub.mu
+	rxq      []*frame
+	overflow bool
+	closing  bool
+
+	notify chan struct{}
+}
+
+// receive must be called with the hub locked.
+func (d *handle) receive(f *frame) {
+	if len(d.rxq) >= rxQueueLen {
+		d.overflow = true
+	} else {
+		d.rxq = append(d.rxq, f)
+	}
+	d.wake()
+}
+
+func (d *handle) wake() {
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (d *handle) ID() string {
+	return d.hub.dev.ID()
+}
+
+func (d *handle) Info() *can.DeviceInfo {
+	return d.hub.dev.Info()
+}
+
+// Read blocks until at least one message is available, and returns
+// as many messages as fit into buf. If the handle's receive queue
+// overflowed, a status message with flag ReceiveBufferOverflow is
+// returned first.
+func (d *handle) Read(buf []can.Msg) (n int, err error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	h := d.hub
+	for {
+		h.mu.Lock()
+		if d.closing {
+			h.mu.Unlock()
+			return 0, io.EOF
+		}
+		if d.overflow {
+			d.overflow = false
+			h.mu.Unlock()
+			m := &buf[0]
+			m.Reset()
+			m.Flags = can.StatusMsg | can.ReceiveBufferOverflow
+			m.Rx.Time = can.Now()
+			return 1, nil
+		}
+		for n < len(buf) && len(d.rxq) != 0 {
+			f := d.rxq[0]
+			m := &buf[n]
+			m.Reset()
+			m.Id = f.id
+			m.Flags = f.flags
+			m.XL = f.xl
+			m.Rx.Time = f.t
+			if m.Import(f.data, nil) != nil {
+				// frames are shared between handles, so the
+				// data must not be attached to the message
+				m.SetData(slices.Clone(f.data))
+			}
+			d.rxq[0] = nil
+			d.rxq = d.rxq[1:]
+			n++
+		}
+		err := h.err
+		h.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		<-d.notify
+	}
+}
+
+func (d *handle) WriteMsg(m *can.Msg) error {
+	if d.isClosed() {
+		return ErrClosed
+	}
+	h := d.hub
+	h.wmu.Lock()
+	err := h.dev.WriteMsg(m)
+	h.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+	h.loopback(d, []can.Msg{*m})
+	return nil
+}
+
+func (d *handle) Write(msgs []can.Msg) (n int, err error) {
+	if d.isClosed() {
+		return 0, ErrClosed
+	}
+	h := d.hub
+	h.wmu.Lock()
+	n, err = h.dev.Write(msgs)
+	h.wmu.Unlock()
+	h.loopback(d, msgs[:n])
+	return n, err
+}
+
+func (d *handle) isClosed() bool {
+	d.hub.mu.Lock()
+	defer d.hub.mu.Unlock()
+	return d.closing
+}
+
+// Close removes the handle from the hub.
+func (d *handle) Close() error {
+	h := d.hub
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d.closing {
+		return nil
+	}
+	d.closing = true
+	d.rxq = nil
+	delete(h.handles, d)
+	d.wake()
+	return nil
+}
@@ -0,0 +1,117 @@
+package share
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+func readMsg(t *testing.T, d can.Device) *can.Msg {
+	t.Helper()
+	timer := time.AfterFunc(2*time.Second, func() {
+		t.Error("timeout")
+		d.Close()
+	})
+	defer timer.Stop()
+	buf := make([]can.Msg, 1)
+	if _, err := d.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return &buf[0]
+}
+
+func TestHub(t *testing.T) {
+	shared, err := can.Open("virtual:share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote, err := can.Open("virtual:share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	h := New(shared)
+	var handles []can.Device
+	for range 3 {
+		d, err := h.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		handles = append(handles, d)
+	}
+
+	for _, expr := range []string{
+		"123#0102",
+		"12345678#",
+		"100##1" + strings.Repeat("55", 20),
+	} {
+		var m can.Msg
+		if err := m.FromExpr(expr); err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.WriteMsg(&m); err != nil {
+			t.Fatal(err)
+		}
+		for i, d := range handles {
+			r := readMsg(t, d)
+			if r.Id != m.Id || r.Flags != m.Flags || !bytes.Equal(r.Data(), m.Data()) {
+				t.Errorf("%s: handle %d: got %x %v % x", expr, i, r.Id, r.Flags, r.Data())
+			}
+		}
+	}
+
+	var xl can.Msg
+	xl.Id = 0x123
+	xl.Flags = can.XLFrame | can.XLSecure
+	xl.XL = can.XLHeader{SDT: 3, VCID: 0x42, AF: 0x12345678}
+	xl.SetData(bytes.Repeat([]byte{0x5a}, 100))
+	if err := remote.WriteMsg(&xl); err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range handles {
+		r := readMsg(t, d)
+		if r.Id != xl.Id || r.Flags != xl.Flags || r.XL != xl.XL || !bytes.Equal(r.Data(), xl.Data()) {
+			t.Errorf("XL frame: handle %d: got %x %v %+v % x", i, r.Id, r.Flags, r.XL, r.Data())
+		}
+	}
+
+	// a message written through one handle reaches the bus,
+	// and the other handles, but not the writer itself
+	handles[1].Close()
+	var m can.Msg
+	m.FromExpr("321#AA")
+	if err := handles[0].WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	if r := readMsg(t, remote); r.Id != 0x321 {
+		t.Errorf("remote: got %x", r.Id)
+	}
+	if r := readMsg(t, handles[2]); r.Id != 0x321 {
+		t.Errorf("loopback: got %x", r.Id)
+	}
+	m.FromExpr("322#BB")
+	remote.WriteMsg(&m)
+	if r := readMsg(t, handles[0]); r.Id != 0x322 {
+		t.Errorf("got %x, own message looped back", r.Id)
+	}
+	if _, err := handles[1].Read(make([]can.Msg, 1)); err != io.EOF {
+		t.Errorf("closed handle: got %v", err)
+	}
+
+	h.Close()
+	if r := readMsg(t, handles[2]); r.Id != 0x322 {
+		t.Errorf("got %x", r.Id)
+	}
+	if _, err := handles[2].Read(make([]can.Msg, 1)); err != io.EOF {
+		t.Errorf("closed hub: got %v", err)
+	}
+	if _, err := h.Open(); err == nil {
+		t.Error("open after close succeeded")
+	}
+}
@@ -0,0 +1,59 @@
+package slcan
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/knieriem/can"
+	"golang.org/x/sys/unix"
+)
+
+// openPty returns the master side of a pseudo terminal,
+// and the path of its slave side.
+func openPty(t *testing.T) (*os.File, string) {
+	t.Helper()
+	f, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	fd := int(f.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		f.Close()
+		t.Skip(err)
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		f.Close()
+		t.Skip(err)
+	}
+	return f, "/dev/pts/" + strconv.Itoa(n)
+}
+
+func TestSerial(t *testing.T) {
+	master, path := openPty(t)
+	defer master.Close()
+	e := newEmulator(master)
+
+	d, err := can.Open("slcan:" + path + "@921600,125k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	e.expect(t, "", "C", "S4", "Z1", "V", "N", "O")
+	if id := d.ID(); id != "slcan:"+path+"@921600" {
+		t.Errorf("unexpected ID: %q", id)
+	}
+
+	var m can.Msg
+	m.FromExpr("7FF#11")
+	if err := d.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	e.expect(t, "t7FF111")
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	e.expect(t, "C")
+}
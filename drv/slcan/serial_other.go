@@ -0,0 +1,13 @@
+//go:build !linux
+
+package slcan
+
+import (
+	"errors"
+	"io"
+	"runtime"
+)
+
+func openSerial(path string, baud int) (io.ReadWriteCloser, error) {
+	return nil, errors.New("serial ports not supported on " + runtime.GOOS + "; use NewDevice")
+}
@@ -0,0 +1,764 @@
+// Package slcan implements a driver for CAN adapters speaking the
+// Lawicel ASCII protocol ("slcan") over a serial line, like the
+// CANUSB, CANable, and various USB-to-CAN adapters using a USB CDC
+// serial port.
+//
+// The package registers a driver named "slcan" with the can package.
+// The device name is the path of the serial port, optionally followed
+// by '@' and the baud rate of the serial line, which defaults to 115200:
+//
+//	dev, err := can.Open("slcan:/dev/ttyACM0@1000000,250k")
+//
+// Since slcan adapters cannot be detected reliably, the driver does
+// not report any devices on Scan, and an empty name is rejected.
+// Using NewDevice, the protocol may also be run on any
+// io.ReadWriteCloser, e.g. a serial port opened by a different
+// package, or a TCP connection to a serial server.
+//
+// Standard bitrates are configured using the Sn command. Other
+// bitrates, explicit sample points, or bit timing specifications
+// are translated into register values of an SJA1000 running at
+// 16 MHz, and configured using the sxxyy command. A data bitrate
+// for CAN FD is configured using the Yn command of CANable 2.0
+// adapters, which supports 2 and 5 Mbit/s. Listen-only mode, the
+// only controller mode supported, is selected using the L command.
+//
+// If the adapter supports timestamps (Z1), received messages are
+// timestamped using the adapter's millisecond counter, which is
+// extended beyond its one minute wrap around using the host's clock.
+// If the adapter supports the F command, the status flags are
+// polled periodically; changes are reported as status messages.
+// Message filters are applied in software.
+package slcan
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv"
+	timingdev "github.com/knieriem/can/timing/dev"
+)
+
+const (
+	rxQueueLen = 1024
+
+	defaultBaudRate = 115200
+	defaultBitrate  = 500000
+
+	cmdTimeout   = time.Second
+	resetTimeout = 100 * time.Millisecond
+
+	// clock frequency of an SJA1000 using a 16 MHz crystal,
+	// as used for register values of the sxxyy command
+	sjaClock = 8e6
+
+	// period of the adapter's timestamp counter, in milliseconds
+	timestampPeriod = 60000
+)
+
+// defaultStatusPollInterval specifies how often the status flags are polled.
+const defaultStatusPollInterval = time.Second
+
+var (
+	errCmdFailed = errors.New("slcan: command failed")
+	errTimeout   = errors.New("slcan: command timed out")
+	errClosed    = errors.New("slcan: device closed")
+	errSyntax    = errors.New("slcan: invalid frame")
+)
+
+// stdBitrates lists the bitrates that
+// may be selected using the commands S0 ... S8.
+var stdBitrates = []uint32{
+	10000,
+	20000,
+	50000,
+	100000,
+	125000,
+	250000,
+	500000,
+	800000,
+	1000000,
+}
+
+// dataBitrates maps CAN FD data bitrates to arguments of the Y command.
+var dataBitrates = map[uint32]byte{
+	2000000: '2',
+	5000000: '5',
+}
+
+// statusMap maps the bits of the F command's reply.
+var statusMap = drv.FlagsMap{
+	{can.ReceiveBufferOverflow, 1 << 0},
+	{can.ErrorWarning, 1 << 2},
+	{can.DataOverrun, 1 << 3},
+	{can.ErrorPassive, 1 << 5},
+}
+
+func init() {
+	can.RegisterDriver(new(driver))
+}
+
+type driver struct{}
+
+func (*driver) Name() string {
+	return "slcan"
+}
+
+func (*driver) Scan() []can.DeviceInfo {
+	return nil
+}
+
+// Open opens the serial port specified by name, which may contain
+// the baud rate as a suffix separated by '@', like "/dev/ttyUSB0@921600".
+func (*driver) Open(env *can.Env, name string, conf *can.Config) (can.Device, error) {
+	if name == "" {
+		return nil, errors.New("slcan: missing serial port name")
+	}
+	path := name
+	baud := defaultBaudRate
+	if i := strings.LastIndexByte(name, '@'); i != -1 {
+		b, err := strconv.Atoi(name[i+1:])
+		if err != nil || b <= 0 {
+			return nil, errors.New("slcan: invalid baud rate: " + name[i+1:])
+		}
+		path = name[:i]
+		baud = b
+	}
+	port, err := openSerial(path, baud)
+	if err != nil {
+		return nil, fmt.Errorf("slcan: %w", err)
+	}
+	var pool can.DataBufPool
+	if env != nil {
+		pool = env.BufPool
+	}
+	d, err := newDevice(port, name, pool, conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	d.info.Device = path
+	return d, nil
+}
+
+// Option modifies the behaviour of a device created by NewDevice.
+type Option func(*options)
+
+type options struct {
+	statusPollInterval time.Duration
+}
+
+// NewDevice configures the adapter connected to port according
+// to conf, which may be nil, and opens the CAN channel. The port
+// will be closed when the device is closed, or if NewDevice fails.
+func NewDevice(port io.ReadWriteCloser, conf *can.Config, opts ...Option) (can.Device, error) {
+	return newDevice(port, "", nil, conf, opts)
+}
+
+type dev struct {
+	port    io.ReadWriteCloser
+	info    can.DeviceInfo
+	filters []can.MsgFilter
+	bufPool can.DataBufPool
+
+	wmu     sync.Mutex // serializes writes to port
+	cmdMu   sync.Mutex // serializes commands
+	pending atomic.Bool
+	resp    chan string
+
+	mu       sync.Mutex
+	rxq      []rxFrame
+	overflow bool
+	rxErr    error
+	notify   chan struct{}
+	closed   chan struct{}
+	rxDone   chan struct{}
+	closing  bool
+
+	pollInterval time.Duration
+	polling      sync.WaitGroup
+
+	clock clock // accessed by the receiving goroutine only
+}
+
+type rxFrame struct {
+	id    uint32
+	flags can.Flags
+	data  []byte
+	t     can.Time
+}
+
+func newDevice(port io.ReadWriteCloser, name string, pool can.DataBufPool, conf *can.Config, opts []Option) (*dev, error) {
+	o := options{statusPollInterval: defaultStatusPollInterval}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	d := new(dev)
+	d.port = port
+	d.bufPool = pool
+	d.pollInterval = o.statusPollInterval
+	d.info = can.DeviceInfo{
+		ID:     name,
+		Device: name,
+		Driver: "slcan",
+		Model:  "slcan adapter",
+	}
+	d.resp = make(chan string, 1)
+	d.notify = make(chan struct{}, 1)
+	d.closed = make(chan struct{})
+	d.rxDone = make(chan struct{})
+	go d.receive(bufio.NewReader(port))
+
+	if err := d.setup(conf); err != nil {
+		d.close()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dev) setup(conf *can.Config) error {
+	// Clear any partial command that might have remained
+	// in the adapter's buffer, and close the channel, in case
+	// it is still open.
+	d.exec("", 0, resetTimeout)
+	d.exec("C", 0, resetTimeout)
+
+	if conf == nil {
+		conf = new(can.Config)
+	}
+	if err := conf.ResolveCtrlModes(supportsCtrlMode); err != nil {
+		return err
+	}
+	cmd, err := bitrateCmd(&conf.Nominal)
+	if err != nil {
+		return fmt.Errorf("slcan: %w", err)
+	}
+	if _, err := d.exec(cmd, 0, cmdTimeout); err != nil {
+		return err
+	}
+	fd, err := conf.ResolveFDMode(true)
+	if err != nil {
+		return err
+	}
+	if fd && conf.Data.Valid {
+		if err := d.setDataBitrate(&conf.Data); err != nil {
+			return err
+		}
+	}
+	d.filters = slices.Clone(conf.MsgFilter)
+
+	// The following commands are optional.
+	d.exec("Z1", 0, cmdTimeout)
+	if s, err := d.exec("V", 'V', cmdTimeout); err == nil {
+		d.info.Firmware = s[1:]
+	}
+	if s, err := d.exec("N", 'N', cmdTimeout); err == nil {
+		d.info.SerialNum = s[1:]
+	}
+	_, errStatus := d.exec("F", 'F', cmdTimeout)
+
+	cmd = "O"
+	if conf.ListenOnly.Valid && conf.ListenOnly.Value {
+		cmd = "L"
+	}
+	if _, err := d.exec(cmd, 0, cmdTimeout); err != nil {
+		return err
+	}
+	if errStatus == nil {
+		d.polling.Add(1)
+		go d.pollStatus()
+	}
+	return nil
+}
+
+// supportsCtrlMode reports whether controller mode m is supported;
+// listen-only mode is provided by the L command.
+func supportsCtrlMode(m can.CtrlMode) bool {
+	return m == can.ModeListenOnly
+}
+
+// bitrateCmd returns the command configuring the nominal bitrate.
+func bitrateCmd(c *can.BitTimingConfig) (string, error) {
+	if c.PhaseSeg1 == 0 && c.Tq == 0 {
+		b := c.Bitrate
+		if b == 0 {
+			b = defaultBitrate
+		}
+		if c.SamplePoint == 0 {
+			if i := slices.Index(stdBitrates, b); i != -1 {
+				return "S" + strconv.Itoa(i), nil
+			}
+		}
+	}
+	bt := *c
+	if bt.Bitrate == 0 && bt.PhaseSeg1 == 0 && bt.Tq == 0 {
+		bt.Bitrate = defaultBitrate
+	}
+	cstr := &timingdev.SJA1000.Nominal
+	if err := bt.Resolve(nil, sjaClock, cstr); err != nil {
+		return "", err
+	}
+	r := cstr.EncodeToReg(&bt.BitTiming)
+	return fmt.Sprintf("s%02X%02X", r.Reg8[0], r.Reg8[1]), nil
+}
+
+func (d *dev) setDataBitrate(c *can.Optional[can.BitTimingConfig]) error {
+	arg, ok := dataBitrates[c.Value.Bitrate]
+	if !ok || c.Value.PhaseSeg1 != 0 || c.Value.Tq != 0 || c.Value.SamplePoint != 0 {
+		if c.Soft {
+			return nil
+		}
+		return errors.New("slcan: data bitrate not supported")
+	}
+	_, err := d.exec("Y"+string(arg), 0, cmdTimeout)
+	if err != nil && c.Soft {
+		return nil
+	}
+	return err
+}
+
+// exec sends a command to the adapter, and waits for the response.
+// If want is not zero, responses not starting with this character are
+// skipped, as they might belong to previously transmitted frames. An
+// error response (BEL) results in errCmdFailed.
+func (d *dev) exec(cmd string, want byte, timeout time.Duration) (string, error) {
+	d.cmdMu.Lock()
+	defer d.cmdMu.Unlock()
+
+	select {
+	case <-d.resp:
+	default:
+	}
+	d.pending.Store(true)
+	defer d.pending.Store(false)
+	if err := d.writeLine([]byte(cmd + "\r")); err != nil {
+		return "", err
+	}
+	tmo := time.NewTimer(timeout)
+	defer tmo.Stop()
+	for {
+		select {
+		case s := <-d.resp:
+			if s == "\a" {
+				return "", errCmdFailed
+			}
+			if want != 0 && (s == "" || s[0] != want) {
+				continue
+			}
+			return s, nil
+		case <-tmo.C:
+			return "", errTimeout
+		case <-d.rxDone:
+			return "", errClosed
+		}
+	}
+}
+
+func (d *dev) writeLine(b []byte) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	_, err := d.port.Write(b)
+	return err
+}
+
+// receive reads lines from the adapter, until the port
+// is closed. Frames are added to the receive queue,
+// other lines are passed to a pending command.
+func (d *dev) receive(r *bufio.Reader) {
+	var line []byte
+	var err error
+	for {
+		var c byte
+		c, err = r.ReadByte()
+		if err != nil {
+			break
+		}
+		switch c {
+		case '\r':
+		case '\a':
+			d.response("\a")
+			line = line[:0]
+			continue
+		case '\n':
+			continue
+		default:
+			line = append(line, c)
+			continue
+		}
+		if len(line) != 0 && strings.IndexByte("tTrRdDbB", line[0]) != -1 {
+			d.receiveFrame(line)
+		} else {
+			d.response(string(line))
+		}
+		line = line[:0]
+	}
+	d.mu.Lock()
+	d.rxErr = err
+	d.mu.Unlock()
+	close(d.rxDone)
+}
+
+func (d *dev) response(s string) {
+	if !d.pending.Load() {
+		return
+	}
+	select {
+	case d.resp <- s:
+	default:
+	}
+}
+
+func (d *dev) receiveFrame(line []byte) {
+	f, ts, err := parseFrame(line)
+	if err != nil {
+		return
+	}
+	now := can.Now()
+	if ts >= 0 {
+		f.t = d.clock.time(ts, now)
+	} else {
+		f.t = now
+	}
+	var m can.Msg
+	m.Id = f.id
+	m.Flags = f.flags
+	if !drv.AcceptMsg(d.filters, &m) {
+		return
+	}
+	d.enqueue(f)
+}
+
+func (d *dev) enqueue(f *rxFrame) {
+	d.mu.Lock()
+	if len(d.rxq) >= rxQueueLen {
+		d.overflow = true
+	} else {
+		d.rxq = append(d.rxq, *f)
+	}
+	d.mu.Unlock()
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pollStatus periodically reads the status flags, and
+// reports changes as status messages.
+func (d *dev) pollStatus() {
+	defer d.polling.Done()
+	tick := time.NewTicker(d.pollInterval)
+	defer tick.Stop()
+	prev := can.ErrorActive
+	for {
+		select {
+		case <-tick.C:
+		case <-d.closed:
+			return
+		case <-d.rxDone:
+			return
+		}
+		s, err := d.exec("F", 'F', cmdTimeout)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseUint(s[1:], 16, 8)
+		if err != nil {
+			continue
+		}
+		f := statusFlags(int(v))
+		if f == prev {
+			continue
+		}
+		prev = f
+		d.enqueue(&rxFrame{flags: can.StatusMsg | f, t: can.Now()})
+	}
+}
+
+func statusFlags(v int) can.Flags {
+	f := statusMap.Decode(v)
+	if f&(can.ErrorWarning|can.ErrorPassive) == 0 {
+		f |= can.ErrorActive
+	}
+	return f
+}
+
+// dlcLen maps DLC codes to data lengths.
+var dlcLen = [16]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 12, 16, 20, 24, 32, 48, 64}
+
+// parseFrame decodes a frame line. The timestamp,
+// if present, is returned as ts; otherwise ts is -1.
+func parseFrame(line []byte) (f *rxFrame, ts int, err error) {
+	f = new(rxFrame)
+	idLen := 3
+	switch line[0] {
+	case 'T', 'R', 'D', 'B':
+		idLen = 8
+		f.flags |= can.ExtFrame
+	}
+	switch line[0] {
+	case 'r', 'R':
+		f.flags |= can.RTRMsg
+	case 'b', 'B':
+		f.flags |= can.ForceFD | can.FDSwitchBitrate
+	case 'd', 'D':
+		f.flags |= can.ForceFD
+	}
+	s := line[1:]
+	if len(s) < idLen+1 {
+		return nil, 0, errSyntax
+	}
+	id, err := strconv.ParseUint(string(s[:idLen]), 16, 32)
+	if err != nil {
+		return nil, 0, errSyntax
+	}
+	f.id = uint32(id)
+	dlc, err := strconv.ParseUint(string(s[idLen:idLen+1]), 16, 8)
+	if err != nil {
+		return nil, 0, errSyntax
+	}
+	s = s[idLen+1:]
+	n := dlcLen[dlc]
+	if f.flags&can.ForceFD == 0 {
+		n = min(int(dlc), 8)
+	}
+	if f.flags&can.RTRMsg != 0 {
+		n = 0
+	}
+	if len(s) < 2*n {
+		return nil, 0, errSyntax
+	}
+	f.data = make([]byte, n)
+	for i := range f.data {
+		b, err := strconv.ParseUint(string(s[2*i:2*i+2]), 16, 8)
+		if err != nil {
+			return nil, 0, errSyntax
+		}
+		f.data[i] = byte(b)
+	}
+	s = s[2*n:]
+	switch len(s) {
+	case 0:
+		return f, -1, nil
+	case 4:
+		v, err := strconv.ParseUint(string(s), 16, 16)
+		if err != nil || v >= timestampPeriod {
+			return nil, 0, errSyntax
+		}
+		return f, int(v), nil
+	}
+	return nil, 0, errSyntax
+}
+
+// appendFrame encodes m as a transmit command. FD frames are padded
+// with zeros to the next length that can be expressed as a DLC.
+func appendFrame(b []byte, m *can.Msg) ([]byte, error) {
+	data := m.Data()
+	n := len(data)
+	fd := n > 8 || m.Test(can.ForceFD) || m.Test(can.FDSwitchBitrate)
+	rtr := m.Test(can.RTRMsg)
+	if rtr && (fd || n != 0) {
+		return nil, can.ErrInvalidMsgLen
+	}
+	dlc := slices.IndexFunc(dlcLen[:], func(l int) bool { return l >= n })
+	if dlc == -1 {
+		return nil, can.ErrInvalidMsgLen
+	}
+	var c byte
+	switch {
+	case rtr:
+		c = 'r'
+	case m.Test(can.FDSwitchBitrate):
+		c = 'b'
+	case fd:
+		c = 'd'
+	default:
+		c = 't'
+	}
+	if m.ExtFrame() {
+		c -= 'a' - 'A'
+		b = append(b, c)
+		b = fmt.Appendf(b, "%08X", m.Id&0x1FFFFFFF)
+	} else {
+		b = append(b, c)
+		b = fmt.Appendf(b, "%03X", m.Id&0x7FF)
+	}
+	b = append(b, "0123456789ABCDEF"[dlc])
+	b = fmt.Appendf(b, "%X", data)
+	for range dlcLen[dlc] - n {
+		b = append(b, '0', '0')
+	}
+	return append(b, '\r'), nil
+}
+
+// clock converts the adapter's timestamps into can.Time values.
+// As the adapter's counter wraps around every minute, the time
+// elapsed on the host is used to detect skipped periods.
+type clock struct {
+	valid bool
+	last  int      // last timestamp, in ms
+	ms    int64    // ms elapsed since the first timestamp
+	t0    can.Time // host time of the first timestamp
+	host  can.Time // host time of the last timestamp
+}
+
+func (c *clock) time(ts int, now can.Time) can.Time {
+	if !c.valid {
+		c.valid = true
+		c.last = ts
+		c.t0 = now
+		c.host = now
+		return now
+	}
+	delta := int64((ts - c.last + timestampPeriod) % timestampPeriod)
+	elapsed := int64(now-c.host) / 1000
+	if k := (elapsed - delta + timestampPeriod/2) / timestampPeriod; k > 0 {
+		delta += k * timestampPeriod
+	}
+	c.ms += delta
+	c.last = ts
+	c.host = now
+	return c.t0 + can.Time(c.ms*1000)
+}
+
+func (d *dev) ID() string {
+	return "slcan:" + d.info.ID
+}
+
+func (d *dev) Info() *can.DeviceInfo {
+	return &d.info
+}
+
+// Read blocks until at least one message is available, and returns
+// as many messages as fit into buf. If the receive queue overflowed,
+// a status message with flag ReceiveBufferOverflow is returned first.
+func (d *dev) Read(buf []can.Msg) (n int, err error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for {
+		d.mu.Lock()
+		if d.closing {
+			d.mu.Unlock()
+			return 0, io.EOF
+		}
+		if d.overflow {
+			d.overflow = false
+			d.mu.Unlock()
+			m := &buf[0]
+			m.Reset()
+			m.Flags = can.StatusMsg | can.ReceiveBufferOverflow
+			m.Rx.Time = can.Now()
+			return 1, nil
+		}
+		for n < len(buf) && len(d.rxq) != 0 {
+			f := &d.rxq[0]
+			m := &buf[n]
+			m.Reset()
+			m.Id = f.id
+			m.Flags = f.flags
+			m.Rx.Time = f.t
+			if err := d.setData(m, f.data); err != nil {
+				if n == 0 {
+					d.rxq = d.rxq[1:]
+					d.mu.Unlock()
+					return 0, err
+				}
+				break
+			}
+			d.rxq[0] = rxFrame{}
+			d.rxq = d.rxq[1:]
+			n++
+		}
+		rxErr := d.rxErr
+		d.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+		if rxErr != nil {
+			return 0, rxErr
+		}
+		select {
+		case <-d.notify:
+		case <-d.rxDone:
+		case <-d.closed:
+		}
+	}
+}
+
+// setData stores data into m. Without a buffer pool, data
+// exceeding the standard payload is attached to m, which is
+// possible since each received frame has its own data slice.
+func (d *dev) setData(m *can.Msg, data []byte) error {
+	if d.bufPool == nil {
+		m.SetData(data)
+		return nil
+	}
+	return m.Import(data, d.bufPool)
+}
+
+// WriteMsg sends a message to the adapter. The adapter's
+// response is not awaited.
+func (d *dev) WriteMsg(m *can.Msg) error {
+	if m.IsStatus() {
+		return nil
+	}
+	b, err := appendFrame(make([]byte, 0, 1+8+1+128+1), m)
+	if err != nil {
+		return err
+	}
+	if d.isClosed() {
+		return errClosed
+	}
+	return d.writeLine(b)
+}
+
+func (d *dev) Write(msgs []can.Msg) (n int, err error) {
+	for i := range msgs {
+		if err = d.WriteMsg(&msgs[i]); err != nil {
+			break
+		}
+		n++
+	}
+	return
+}
+
+func (d *dev) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+// Close closes the CAN channel, and the underlying port.
+func (d *dev) Close() error {
+	if d.isClosed() {
+		return nil
+	}
+	d.exec("C", 0, resetTimeout)
+	return d.close()
+}
+
+func (d *dev) close() error {
+	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closing = true
+	d.rxq = nil
+	d.mu.Unlock()
+	close(d.closed)
+	err := d.port.Close()
+
+	// Closing the port terminates the receiving goroutine,
+	// which lets a pending status request of pollStatus fail.
+	d.polling.Wait()
+	return err
+}
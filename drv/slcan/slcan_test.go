@@ -0,0 +1,332 @@
+package slcan
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+func TestBitrateCmd(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want string
+	}{
+		{"", "S6"},
+		{"10k", "S0"},
+		{"125k", "S4"},
+		{"1M", "S8"},
+		{"500k@.75", "sC03A"},
+		{"400k", "s802F"},
+		{"*125:5-10-4", "sC03E"},
+	} {
+		var c can.BitTimingConfig
+		if tc.spec != "" {
+			conf, err := can.ParseConfig(tc.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c = conf.Nominal
+		}
+		got, err := bitrateCmd(&c)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.spec, got, tc.want)
+		}
+	}
+}
+
+var frameTests = []struct {
+	line string
+	expr string
+	ts   int
+}{
+	{line: "t1230", expr: "123#", ts: -1},
+	{line: "t7FF8" + "0102030405060708", expr: "7FF#0102030405060708", ts: -1},
+	{line: "T1ABCDEF02" + "AA55" + "EA5F", expr: "1ABCDEF0#AA55", ts: 59999},
+	{line: "r1230", expr: "123#R", ts: -1},
+	{line: "R000001000" + "0010", expr: "00000100#R", ts: 16},
+	{line: "d1000", expr: "100##0", ts: -1},
+	{line: "b1009" + "000102030405060708090A0B", expr: "100##1000102030405060708090A0B", ts: -1},
+	{line: "D1234567FD" + strings.Repeat("11", 32), expr: "1234567F##0" + strings.Repeat("11", 32), ts: -1},
+	{line: "B00000001F" + strings.Repeat("00", 64) + "1234", expr: "00000001##1" + strings.Repeat("00", 64), ts: 0x1234},
+}
+
+func TestParseFrame(t *testing.T) {
+	for _, tc := range frameTests {
+		f, ts, err := parseFrame([]byte(tc.line))
+		if err != nil {
+			t.Errorf("%q: %v", tc.line, err)
+			continue
+		}
+		var m can.Msg
+		if err := m.FromExpr(tc.expr); err != nil {
+			t.Fatal(err)
+		}
+		if f.id != m.Id || f.flags != m.Flags || !bytes.Equal(f.data, m.Data()) || ts != tc.ts {
+			t.Errorf("%q: got %x %v % x ts %d, want %x %v % x ts %d", tc.line,
+				f.id, f.flags, f.data, ts, m.Id, m.Flags, m.Data(), tc.ts)
+		}
+	}
+
+	for _, line := range []string{"t12", "t12X0", "t1232AA", "t1231AA1", "t1230EA60", "T1230"} {
+		if _, _, err := parseFrame([]byte(line)); err == nil {
+			t.Errorf("%q: error expected", line)
+		}
+	}
+}
+
+func TestAppendFrame(t *testing.T) {
+	for _, tc := range frameTests {
+		if tc.ts != -1 {
+			continue
+		}
+		var m can.Msg
+		if err := m.FromExpr(tc.expr); err != nil {
+			t.Fatal(err)
+		}
+		b, err := appendFrame(nil, &m)
+		if err != nil {
+			t.Errorf("%q: %v", tc.expr, err)
+			continue
+		}
+		if want := tc.line + "\r"; string(b) != want {
+			t.Errorf("%q: got %q, want %q", tc.expr, b, want)
+		}
+	}
+
+	// padding to the next valid FD length
+	var m can.Msg
+	m.FromExpr("100##0" + strings.Repeat("ab", 25))
+	b, err := appendFrame(nil, &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "d100D" + strings.Repeat("AB", 25) + strings.Repeat("00", 7) + "\r"; string(b) != want {
+		t.Errorf("got %q, want %q", b, want)
+	}
+}
+
+func TestClock(t *testing.T) {
+	var c clock
+	const s = 1000000
+	for i, tc := range []struct {
+		ts   int
+		now  can.Time
+		want can.Time
+	}{
+		{100, 1000 * s, 1000 * s},
+		{350, 1000*s + 300000, 1000*s + 250000},
+		{59900, 1059 * s, 1000*s + 59800000},
+		{100, 1060 * s, 1000*s + 60000000},
+		{200, 1180*s + 500000, 1000*s + 180100000},
+	} {
+		if got := c.time(tc.ts, tc.now); got != tc.want {
+			t.Errorf("#%d: got %d, want %d", i, got, tc.want)
+		}
+	}
+}
+
+// emulator simulates an slcan adapter.
+type emulator struct {
+	conn io.ReadWriter
+	cmds chan string
+
+	mu     sync.Mutex
+	status string
+}
+
+func newEmulator(conn io.ReadWriter) *emulator {
+	e := &emulator{conn: conn, cmds: make(chan string, 64), status: "00"}
+	go e.run()
+	return e
+}
+
+func (e *emulator) run() {
+	r := bufio.NewReader(e.conn)
+	for {
+		line, err := r.ReadString('\r')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSuffix(line, "\r")
+		var resp string
+		switch {
+		case cmd == "":
+			resp = "\r"
+		case cmd == "V":
+			resp = "V1013\r"
+		case cmd == "N":
+			resp = "NA1B2\r"
+		case cmd == "F":
+			e.mu.Lock()
+			resp = "F" + e.status + "\r"
+			e.mu.Unlock()
+		case cmd[0] == 't' || cmd[0] == 'r':
+			resp = "z\r"
+		case strings.IndexByte("TRdDbB", cmd[0]) != -1:
+			resp = "Z\r"
+		case cmd[0] == 'Y':
+			resp = "\a"
+		default:
+			resp = "\r"
+		}
+		if cmd != "F" {
+			e.cmds <- cmd
+		}
+		if e.send(resp) != nil {
+			return
+		}
+	}
+}
+
+func (e *emulator) send(s string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := io.WriteString(e.conn, s)
+	return err
+}
+
+func (e *emulator) setStatus(s string) {
+	e.mu.Lock()
+	e.status = s
+	e.mu.Unlock()
+}
+
+func (e *emulator) expect(t *testing.T, cmds ...string) {
+	t.Helper()
+	for _, want := range cmds {
+		select {
+		case got := <-e.cmds:
+			if got != want {
+				t.Errorf("got command %q, want %q", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for command %q", want)
+		}
+	}
+}
+
+func TestDevice(t *testing.T) {
+	c1, c2 := net.Pipe()
+	e := newEmulator(c2)
+	defer c2.Close()
+
+	conf, err := can.ParseConfig("250k db?:5M listen-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDevice(c1, conf, withStatusPollInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	e.expect(t, "", "C", "S5", "Y5", "Z1", "V", "N", "L")
+	if info := d.Info(); info.Firmware != "1013" || info.SerialNum != "A1B2" {
+		t.Errorf("unexpected info: %+v", info)
+	}
+
+	msgs := make([]can.Msg, 2)
+	msgs[0].FromExpr("123#0102")
+	msgs[1].FromExpr("12345678##1" + strings.Repeat("ff", 12))
+	if n, err := d.Write(msgs); n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	e.expect(t, "t12320102", "B123456789"+strings.Repeat("FF", 12))
+
+	e.send("t321300AABB0100\r" + "T000000FF0" + "0200\r")
+	buf := make([]can.Msg, 4)
+	var got []can.Msg
+	for len(got) < 2 {
+		n, err := d.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range buf[:n] {
+			m := &buf[i]
+			if m.IsStatus() {
+				continue
+			}
+			got = append(got, *m)
+			got[len(got)-1].SetData(slices.Clone(m.Data()))
+		}
+	}
+	if m := &got[0]; m.Id != 0x321 || m.Flags != 0 || !bytes.Equal(m.Data(), []byte{0, 0xAA, 0xBB}) {
+		t.Errorf("unexpected message: %x %v % x", m.Id, m.Flags, m.Data())
+	}
+	if m := &got[1]; m.Id != 0xFF || m.Flags != can.ExtFrame || len(m.Data()) != 0 {
+		t.Errorf("unexpected message: %x %v % x", m.Id, m.Flags, m.Data())
+	}
+	if dt := got[1].Rx.Time - got[0].Rx.Time; dt != 0x100*1000 {
+		t.Errorf("time difference: got %d, want %d", dt, 0x100*1000)
+	}
+
+	e.setStatus("24")
+	for {
+		n, err := d.Read(buf[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 1 && buf[0].IsStatus() {
+			break
+		}
+	}
+	if want := can.StatusMsg | can.ErrorWarning | can.ErrorPassive; buf[0].Flags != want {
+		t.Errorf("status: got %v, want %v", buf[0].Flags, want)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Read(buf); err != io.EOF {
+		t.Errorf("read after close: got %v, want EOF", err)
+	}
+}
+
+func TestDataBitrateNotSupported(t *testing.T) {
+	c1, c2 := net.Pipe()
+	newEmulator(c2)
+	defer c2.Close()
+
+	conf, err := can.ParseConfig("1M db:5M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDevice(c1, conf)
+	if err != errCmdFailed {
+		t.Errorf("got %v, want %v", err, errCmdFailed)
+	}
+}
+
+func TestCtrlModeNotSupported(t *testing.T) {
+	c1, c2 := net.Pipe()
+	newEmulator(c2)
+	defer c2.Close()
+
+	conf, err := can.ParseConfig("500k one-shot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDevice(c1, conf)
+	if !errors.Is(err, can.ErrCtrlModeNotSupported) {
+		t.Errorf("got %v, want %v", err, can.ErrCtrlModeNotSupported)
+	}
+}
+
+// withStatusPollInterval overrides the interval at which status flags are polled.
+func withStatusPollInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.statusPollInterval = d
+	}
+}
@@ -0,0 +1,354 @@
+package socketcan
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+)
+
+// layout of struct bcm_msg_head, which depends on the size of long
+const (
+	longSize        = bits.UintSize / 8
+	bcmOpcodeOffset = 0
+	bcmFlagsOffset  = 4
+	bcmIval1Offset  = (12 + longSize - 1) &^ (longSize - 1)
+	bcmIval2Offset  = bcmIval1Offset + 2*longSize
+	bcmIDOffset     = bcmIval2Offset + 2*longSize
+	bcmNFramesOff   = bcmIDOffset + 4
+	bcmFramesOffset = (bcmNFramesOff + 4 + 7) &^ 7 // frames are 8-byte aligned
+)
+
+var ErrBCMNoMsgs = errors.New("socketcan: bcm: no messages")
+
+// A BCM provides access to the broadcast manager of SocketCAN on
+// a single interface. The broadcast manager transmits messages
+// periodically using kernel timers, and filters received messages
+// by content, optionally detecting the absence of cyclic messages.
+type BCM struct {
+	file io.ReadWriteCloser
+
+	wmu  sync.Mutex
+	rbuf [bcmFramesOffset + linux.CANFD_MTU]byte
+}
+
+// OpenBCM opens a broadcast manager socket on the network interface ifName.
+func OpenBCM(ifName string) (*BCM, error) {
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_DGRAM, unix.CAN_BCM)
+	if err != nil {
+		return nil, wrapErr("bcm", err)
+	}
+	ifindex, err := ifIndex(fd, ifName)
+	if err == nil {
+		err = unix.Connect(fd, &unix.SockaddrCAN{Ifindex: ifindex})
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, wrapErr("bcm", err)
+	}
+	file, err := pollableFile(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, wrapErr("bcm", err)
+	}
+	return &BCM{file: file}, nil
+}
+
+// Close closes the socket. The kernel removes all
+// jobs and subscriptions set up through it.
+func (b *BCM) Close() error {
+	err := b.file.Close()
+	if err != nil {
+		return wrapErr("close", err)
+	}
+	return nil
+}
+
+// bcmKey identifies a job or subscription; the kernel
+// distinguishes jobs by identifier, and by frame type.
+type bcmKey struct {
+	id uint32 // including CAN_EFF_FLAG
+	fd bool
+}
+
+// bcmMsg is a request to, or a notification from the broadcast manager.
+type bcmMsg struct {
+	op      uint32
+	flags   uint32
+	ival1   time.Duration
+	ival2   time.Duration
+	key     bcmKey
+	nFrames int
+	frames  []byte
+}
+
+func (m *bcmMsg) encode() []byte {
+	b := make([]byte, bcmFramesOffset, bcmFramesOffset+len(m.frames))
+	flags := m.flags
+	if m.key.fd {
+		flags |= linux.CAN_FD_FRAME
+	}
+	native.PutUint32(b[bcmOpcodeOffset:], m.op)
+	native.PutUint32(b[bcmFlagsOffset:], flags)
+	putTimeval(b[bcmIval1Offset:], m.ival1)
+	putTimeval(b[bcmIval2Offset:], m.ival2)
+	native.PutUint32(b[bcmIDOffset:], m.key.id)
+	native.PutUint32(b[bcmNFramesOff:], uint32(m.nFrames))
+	return append(b, m.frames...)
+}
+
+func putTimeval(b []byte, d time.Duration) {
+	sec := uint64(d / time.Second)
+	usec := uint64(d % time.Second / time.Microsecond)
+	if longSize == 8 {
+		native.PutUint64(b, sec)
+		native.PutUint64(b[8:], usec)
+		return
+	}
+	native.PutUint32(b, uint32(sec))
+	native.PutUint32(b[4:], uint32(usec))
+}
+
+func (b *BCM) write(m *bcmMsg) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	_, err := b.file.Write(m.encode())
+	if err != nil {
+		return wrapErr("bcm", err)
+	}
+	return nil
+}
+
+// encodeFrames returns the messages encoded as an array of
+// struct can_frame, or, if any message is an FD frame, as an
+// array of struct canfd_frame.
+func encodeFrames(msgs []can.Msg) (frames []byte, fd bool, err error) {
+	if len(msgs) == 0 {
+		return nil, false, ErrBCMNoMsgs
+	}
+	var f frame
+	for i := range msgs {
+		n, err := f.encode(&msgs[i], linux.CANFD_MTU)
+		if err != nil {
+			return nil, false, wrapErr("bcm", err)
+		}
+		if n > linux.CAN_MTU {
+			fd = true
+		}
+	}
+	size := linux.CAN_MTU
+	if fd {
+		size = linux.CANFD_MTU
+	}
+	for i := range msgs {
+		f.encode(&msgs[i], linux.CANFD_MTU)
+		frames = append(frames, f.b[:size]...)
+	}
+	return frames, fd, nil
+}
+
+func msgKey(m *can.Msg, fd bool) bcmKey {
+	id := m.Id
+	if m.ExtFrame() {
+		id |= linux.CAN_EFF_FLAG
+	}
+	return bcmKey{id: id, fd: fd}
+}
+
+// A TxJob is a cyclic transmission performed by the kernel.
+type TxJob struct {
+	bcm *BCM
+	key bcmKey
+}
+
+// StartCyclic sets up a job transmitting one message each interval,
+// starting immediately. If more than one message is specified, the
+// messages are transmitted one after another, starting again with the
+// first message after the last one. The job is identified by the
+// identifier of the first message; setting up a job with the identifier
+// of an existing job replaces the existing job.
+func (b *BCM) StartCyclic(interval time.Duration, msgs ...can.Msg) (*TxJob, error) {
+	if interval <= 0 {
+		return nil, wrapErr("bcm", errors.New("invalid interval"))
+	}
+	frames, fd, err := encodeFrames(msgs)
+	if err != nil {
+		return nil, err
+	}
+	j := &TxJob{bcm: b, key: msgKey(&msgs[0], fd)}
+	err = b.write(&bcmMsg{
+		op:      linux.TX_SETUP,
+		flags:   linux.SETTIMER | linux.STARTTIMER | linux.TX_ANNOUNCE,
+		ival2:   interval,
+		key:     j.key,
+		nFrames: len(msgs),
+		frames:  frames,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Update replaces the messages of the job. The timer is not
+// restarted; the new content is transmitted at the next cycle,
+// starting with the first message. The number of messages must
+// not exceed the number the job has been set up with, and the
+// frame type, i.e. CAN 2.0 or FD, must not change.
+func (j *TxJob) Update(msgs ...can.Msg) error {
+	frames, fd, err := encodeFrames(msgs)
+	if err != nil {
+		return err
+	}
+	if fd != j.key.fd {
+		return wrapErr("bcm", errors.New("frame type of job cannot be changed"))
+	}
+	return j.bcm.write(&bcmMsg{
+		op:      linux.TX_SETUP,
+		flags:   linux.TX_RESET_MULTI_IDX,
+		key:     j.key,
+		nFrames: len(msgs),
+		frames:  frames,
+	})
+}
+
+// Stop removes the job.
+func (j *TxJob) Stop() error {
+	return j.bcm.write(&bcmMsg{op: linux.TX_DELETE, key: j.key})
+}
+
+// RxFilter describes a content filter subscription.
+type RxFilter struct {
+	ID       uint32
+	ExtFrame bool
+	FD       bool
+
+	// Mask selects the data bits that are relevant for detecting
+	// a change of a message's content. A received message is reported,
+	// if its masked data, or its length, differs from the message
+	// received before. If Mask is nil, every received message
+	// with the identifier is reported.
+	Mask []byte
+
+	// If Timeout is not zero, a BCMTimeout event is reported if no
+	// message has been received within Timeout. The timer is started
+	// immediately.
+	Timeout time.Duration
+
+	// If Throttle is not zero, changes are reported at most
+	// once per Throttle interval, delivering the latest message.
+	Throttle time.Duration
+}
+
+// A RxSub is a content filter subscription set up using Subscribe.
+type RxSub struct {
+	bcm *BCM
+	key bcmKey
+}
+
+// Subscribe sets up a content filter for messages received on the
+// interface. Matching messages are reported as BCMChanged events. A
+// filter replaces an existing one for the same identifier.
+func (b *BCM) Subscribe(f *RxFilter) (*RxSub, error) {
+	var m can.Msg
+	m.Id = f.ID
+	if f.ExtFrame {
+		m.Flags |= can.ExtFrame
+	}
+	s := &RxSub{bcm: b, key: msgKey(&m, f.FD)}
+	req := &bcmMsg{
+		op:    linux.RX_SETUP,
+		ival1: f.Timeout,
+		ival2: f.Throttle,
+		key:   s.key,
+	}
+	if f.Timeout != 0 || f.Throttle != 0 {
+		req.flags |= linux.SETTIMER | linux.STARTTIMER
+	}
+	if f.Mask == nil {
+		req.flags |= linux.RX_FILTER_ID
+	} else {
+		size, maxData := linux.CAN_MTU, 8
+		if f.FD {
+			size, maxData = linux.CANFD_MTU, 64
+		}
+		if len(f.Mask) > maxData {
+			return nil, wrapErr("bcm", errors.New("mask too long"))
+		}
+		req.flags |= linux.RX_CHECK_DLC
+		req.nFrames = 1
+		req.frames = make([]byte, size)
+		copy(req.frames[dataOffset:], f.Mask)
+	}
+	if err := b.write(req); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Stop removes the subscription.
+func (s *RxSub) Stop() error {
+	return s.bcm.write(&bcmMsg{op: linux.RX_DELETE, key: s.key})
+}
+
+// BCMEventType denotes the type of a notification
+// received from the broadcast manager.
+type BCMEventType int
+
+const (
+	_          BCMEventType = iota
+	BCMChanged              // a message matching a subscription has been received
+	BCMTimeout              // a cyclic message has not been received in time
+)
+
+// A BCMEvent is a notification received from the broadcast manager.
+type BCMEvent struct {
+	Type     BCMEventType
+	ID       uint32
+	ExtFrame bool
+
+	// Msg is the received message, in case of BCMChanged.
+	Msg can.Msg
+}
+
+// ReadEvent waits for the next notification. Notifications
+// of other types than listed by BCMEventType are skipped.
+func (b *BCM) ReadEvent(ev *BCMEvent) error {
+	for {
+		n, err := b.file.Read(b.rbuf[:])
+		if err != nil {
+			return wrapErr("read", err)
+		}
+		if n < bcmFramesOffset {
+			return wrapErr("read", errors.New("short bcm message"))
+		}
+		buf := b.rbuf[:n]
+		id := native.Uint32(buf[bcmIDOffset:])
+		ev.ID = id & linux.CAN_EFF_MASK
+		ev.ExtFrame = id&linux.CAN_EFF_FLAG != 0
+		if !ev.ExtFrame {
+			ev.ID &= linux.CAN_SFF_MASK
+		}
+		ev.Msg.Reset()
+		switch native.Uint32(buf[bcmOpcodeOffset:]) {
+		case linux.RX_TIMEOUT:
+			ev.Type = BCMTimeout
+			return nil
+		case linux.RX_CHANGED:
+			var f frame
+			if copy(f.b[:], buf[bcmFramesOffset:]) < dataOffset || dataOffset+f.len() > n-bcmFramesOffset {
+				return wrapErr("read", errors.New("short bcm frame"))
+			}
+			ev.Type = BCMChanged
+			// FD frames may carry more than eight bytes,
+			// which do not fit into the message itself.
+			return f.decode(&ev.Msg, plainBufPool{})
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package socketcan
+
+import (
+	"bytes"
+	"math/bits"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+)
+
+func TestBCMEncode(t *testing.T) {
+	if bits.UintSize == 64 && bcmFramesOffset != 56 || bits.UintSize == 32 && bcmFramesOffset != 40 {
+		t.Fatalf("unexpected size of bcm_msg_head: %d", bcmFramesOffset)
+	}
+	var m1, m2 can.Msg
+	m1.FromExpr("12345678#0102")
+	m2.FromExpr("12345678##1" + "00112233445566778899AABB")
+	for _, tc := range []struct {
+		msgs  []can.Msg
+		fd    bool
+		size  int
+		frame []byte
+	}{
+		{[]can.Msg{m1}, false, linux.CAN_MTU, []byte{0x78, 0x56, 0x34, 0x92, 2, 0, 0, 0, 1, 2, 0, 0, 0, 0, 0, 0}},
+		{[]can.Msg{m1, m2}, true, 2 * linux.CANFD_MTU, []byte{0x78, 0x56, 0x34, 0x92, 2, 0, 0, 0, 1, 2}},
+	} {
+		frames, fd, err := encodeFrames(tc.msgs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fd != tc.fd || len(frames) != tc.size || !bytes.HasPrefix(frames, tc.frame) {
+			t.Errorf("got %v %d % x", fd, len(frames), frames)
+		}
+		req := &bcmMsg{
+			op:      linux.TX_SETUP,
+			flags:   linux.SETTIMER,
+			ival2:   1500 * time.Millisecond,
+			key:     msgKey(&tc.msgs[0], fd),
+			nFrames: len(tc.msgs),
+			frames:  frames,
+		}
+		b := req.encode()
+		flags := native.Uint32(b[bcmFlagsOffset:])
+		if len(b) != bcmFramesOffset+len(frames) ||
+			native.Uint32(b[bcmOpcodeOffset:]) != linux.TX_SETUP ||
+			(flags&linux.CAN_FD_FRAME != 0) != fd ||
+			native.Uint32(b[bcmIDOffset:]) != 0x92345678 ||
+			native.Uint32(b[bcmNFramesOff:]) != uint32(len(tc.msgs)) ||
+			native.Uint32(b[bcmIval2Offset:]) != 1 ||
+			native.Uint32(b[bcmIval2Offset+bits.UintSize/8:]) != 500000 {
+			t.Errorf("unexpected encoding: % x", b)
+		}
+	}
+	if _, _, err := encodeFrames(nil); err != ErrBCMNoMsgs {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestBCMReadEvent(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer unix.Close(fds[1])
+	file, err := pollableFile(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &BCM{file: file}
+	defer b.Close()
+
+	var m can.Msg
+	m.FromExpr("12345678##1" + "00112233445566778899AABB")
+	frames, fd, err := encodeFrames([]can.Msg{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &bcmMsg{op: linux.RX_CHANGED, key: msgKey(&m, fd), nFrames: 1, frames: frames}
+	unix.Write(fds[1], req.encode())
+	req = &bcmMsg{op: linux.RX_TIMEOUT, key: msgKey(&m, fd)}
+	unix.Write(fds[1], req.encode())
+
+	var ev BCMEvent
+	if err := b.ReadEvent(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != BCMChanged || ev.ID != 0x12345678 || !ev.ExtFrame ||
+		ev.Msg.Id != m.Id || !ev.Msg.Test(can.ExtFrame) || !bytes.Equal(ev.Msg.Data(), m.Data()) {
+		t.Errorf("got %v %x %v: %x %x % x", ev.Type, ev.ID, ev.ExtFrame, ev.Msg.Id, ev.Msg.Flags, ev.Msg.Data())
+	}
+	if err := b.ReadEvent(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != BCMTimeout || ev.ID != 0x12345678 || len(ev.Msg.Data()) != 0 {
+		t.Errorf("got %v %x % x", ev.Type, ev.ID, ev.Msg.Data())
+	}
+}
+
+// TestBCM needs a vcan interface named vcan0.
+func TestBCM(t *testing.T) {
+	b, err := OpenBCM("vcan0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer b.Close()
+	raw, err := Driver.Open(nil, "vcan0", nil)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer raw.Close()
+
+	var m can.Msg
+	m.FromExpr("123#01")
+	j, err := b.StartCyclic(10*time.Millisecond, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]can.Msg, 1)
+	for i := 0; i < 3; i++ {
+		if _, err := raw.Read(buf); err != nil || buf[0].Id != 0x123 {
+			t.Fatal(err, buf[0].Id)
+		}
+	}
+	m.FromExpr("123#02")
+	if err := j.Update(m); err != nil {
+		t.Fatal(err)
+	}
+	for buf[0].Data()[0] != 2 {
+		if _, err := raw.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := j.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = b.Subscribe(&RxFilter{ID: 0x321, Mask: []byte{0xFF}, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.FromExpr("321#0102")
+	raw.WriteMsg(&m)
+	m.FromExpr("321#0103") // masked byte unchanged
+	raw.WriteMsg(&m)
+	var ev BCMEvent
+	if err := b.ReadEvent(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != BCMChanged || ev.ID != 0x321 || !bytes.Equal(ev.Msg.Data(), []byte{1, 2}) {
+		t.Errorf("got %v %x % x", ev.Type, ev.ID, ev.Msg.Data())
+	}
+	if err := b.ReadEvent(&ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Type != BCMTimeout || ev.ID != 0x321 {
+		t.Errorf("got %v %x", ev.Type, ev.ID)
+	}
+}
@@ -74,6 +74,10 @@ var funcs = map[string]command{
 	".up":   updown,
 	".down": updown,
 
+	".restart": func(link *inet.Interface, _ string, _ ...string) error {
+		return link.Restart()
+	},
+
 	"list": func(_ *inet.Interface, _ string, args ...string) error {
 		list, err := inet.List()
 		if err != nil {
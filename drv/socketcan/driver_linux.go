@@ -52,17 +52,22 @@ func (driver) Name() string {
 
 type dev struct {
 	file io.ReadWriteCloser
+	rc   syscall.RawConn
 	mtu  int
 	info can.DeviceInfo
 
+	privilegedCmd string
+
 	sendBufMu sync.Mutex
 	sendBuf   frame
+	sendBatch *mmsgBuf
+
+	recvMu    sync.Mutex // protects the fields below
+	recvBatch *mmsgBuf
+	recvErr   error // error to be returned by the next Read
+	ts        rxTimestamps
+	clock     rxClock
 
-	recvBuf frame
-	receive struct {
-		t0    can.Time
-		t0val int64
-	}
 	bufPool can.DataBufPool
 }
 
@@ -134,6 +139,21 @@ func (drv *driver) Open(env *can.Env, devName string, conf *can.Config) (can.Dev
 			conf.FDMode.Valid = false
 			conf.Data.Valid = false
 		}
+		xl, err := conf.ResolveXLMode(ctl.XLData != nil)
+		if err != nil {
+			return nil, err
+		}
+		if !xl {
+			// keep an explicit request to disable XL mode
+			if conf.XLMode.Value {
+				conf.XLMode.Valid = false
+			}
+			conf.XLData.Valid = false
+		}
+		err = conf.ResolveCtrlModes(info.Can.SupportsCtrlMode)
+		if err != nil {
+			return nil, err
+		}
 		err = conf.ResolveBitTiming(ctl)
 		if err != nil {
 			return nil, err
@@ -142,6 +162,7 @@ func (drv *driver) Open(env *can.Env, devName string, conf *can.Config) (can.Dev
 		// avoid bitrates being printed when formatting bit timings during config
 		conf.Nominal.Bitrate = 0
 		conf.Data.Value.Bitrate = 0
+		conf.XLData.Value.Bitrate = 0
 
 		needUpdate, err := info.NeedUpdate(conf)
 		if err != nil {
@@ -192,6 +213,7 @@ skipConfig:
 
 	d := new(dev)
 	d.mtu = int(info.Attr.MTU)
+	d.privilegedCmd = drv.privilegedCmd
 	err = unix.SetsockoptInt(fd, unix.SOL_CAN_RAW, unix.CAN_RAW_FD_FRAMES, 1)
 	if err != nil {
 		if d.mtu > linux.CAN_MTU {
@@ -199,6 +221,21 @@ skipConfig:
 		}
 		return nil, wrapErr("open", fmt.Errorf("cannot enter FD mode: %w", err))
 	}
+	if d.mtu >= linux.CANXL_MIN_MTU {
+		err = unix.SetsockoptInt(fd, unix.SOL_CAN_RAW, linux.CAN_RAW_XL_FRAMES, 1)
+		if err != nil {
+			return nil, wrapErr("open", fmt.Errorf("cannot enter XL mode: %w", err))
+		}
+
+		// Let the VCID of CAN XL frames be passed through. As this
+		// option is not supported by older kernels, an error is only
+		// reported if XL mode has been requested strictly.
+		vcidOpts := []byte{unix.CAN_RAW_XL_VCID_TX_PASS, 0, 0, 0}
+		err = unix.SetsockoptString(fd, unix.SOL_CAN_RAW, linux.CAN_RAW_XL_VCID_OPTS, string(vcidOpts))
+		if err != nil && conf != nil && conf.XLMode.Valid && conf.XLMode.Value && !conf.XLMode.Soft {
+			return nil, wrapErr("open", fmt.Errorf("cannot pass VCIDs of XL frames: %w", err))
+		}
+	}
 
 	if conf != nil {
 		if filters := conf.MsgFilter; len(filters) > 0 {
@@ -226,20 +263,33 @@ skipConfig:
 	}
 
 	errMask := linux.CAN_ERR_CRTL |
+		linux.CAN_ERR_PROT |
+		linux.CAN_ERR_TRX |
 		linux.CAN_ERR_BUSOFF |
 		linux.CAN_ERR_ACK |
 		linux.CAN_ERR_BUSERROR |
 		linux.CAN_ERR_RESTARTED |
-		linux.CAN_ERR_TX_TIMEOUT
+		linux.CAN_ERR_TX_TIMEOUT |
+		linux.CAN_ERR_CNT
 	err = unix.SetsockoptInt(fd, unix.SOL_CAN_RAW, unix.CAN_RAW_ERR_FILTER, errMask)
 	if err != nil {
 		return nil, wrapErr("open", err)
 	}
+	oobSize, err := setupTimestamps(fd)
+	if err != nil {
+		return nil, wrapErr("open", err)
+	}
+	d.recvBatch = newMmsgBuf(maxBatch, oobSize)
 	file, err := pollableFile(fd)
 	if err != nil {
 		return nil, wrapErr("open", err)
 	}
 	d.file = file
+	d.rc, err = file.(syscall.Conn).SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, wrapErr("open", err)
+	}
 	setupInfo(&d.info, info)
 	cleanupPriv = nil
 	if env != nil {
@@ -256,20 +306,29 @@ func setupSocket(dev string) (fd int, err error) {
 		return -1, err
 	}
 
-	ifr, err := unix.NewIfreq(dev)
+	ifindex, err := ifIndex(fd, dev)
 	if err != nil {
 		return -1, err
 	}
-	err = unix.IoctlIfreq(fd, unix.SIOCGIFINDEX, ifr)
+
+	err = unix.Bind(fd, &unix.SockaddrCAN{Ifindex: ifindex})
 	if err != nil {
 		return -1, err
 	}
+	return fd, nil
+}
 
-	err = unix.Bind(fd, &unix.SockaddrCAN{Ifindex: int(ifr.Uint32())})
+// ifIndex returns the index of the network interface dev.
+func ifIndex(fd int, dev string) (int, error) {
+	ifr, err := unix.NewIfreq(dev)
 	if err != nil {
-		return -1, err
+		return 0, err
 	}
-	return fd, nil
+	err = unix.IoctlIfreq(fd, unix.SIOCGIFINDEX, ifr)
+	if err != nil {
+		return 0, err
+	}
+	return int(ifr.Uint32()), nil
 }
 
 // pollableFile turns fd into an *os.File, that is managed by Go's runtime poller
@@ -289,17 +348,147 @@ func setupInfo(info *can.DeviceInfo, link *netlink.Link) {
 	}
 }
 
+// ControllerState returns the state, and the error counters of the
+// CAN controller, as reported by netlink. Counters are only available
+// if the device driver supports them.
+func (d *dev) ControllerState() (*can.ControllerState, error) {
+	conn, err := netlink.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	link, err := conn.OpenInterface(d.info.ID)
+	if err != nil {
+		return nil, err
+	}
+	info, err := link.Info()
+	if err != nil {
+		return nil, err
+	}
+	if info.Can == nil {
+		// vcan interfaces have no controller state
+		return new(can.ControllerState), nil
+	}
+	stats, err := link.DeviceStats()
+	if err != nil {
+		return nil, err
+	}
+	return controllerState(info.Can, stats), nil
+}
+
+// controllerState converts the CAN attributes of a link, and
+// the optional device statistics into a can.ControllerState.
+func controllerState(c *netlink.CanAttributes, stats *unix.CANDeviceStats) *can.ControllerState {
+	cs := new(can.ControllerState)
+	if int(c.State) < len(canStates) {
+		cs.State = canStates[c.State]
+	}
+	if cnt := c.BusErrCounters; cnt != nil {
+		cs.TxErrCount = int(cnt.Txerr)
+		cs.RxErrCount = int(cnt.Rxerr)
+		cs.HasCounters = true
+	}
+	if stats != nil {
+		cs.Restarts = int(stats.Restarts)
+	}
+	return cs
+}
+
+// Restart restarts the CAN controller after a bus-off condition,
+// using the privileged utility, if configured.
+func (d *dev) Restart() error {
+	conn, err := netlink.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	link, err := conn.OpenInterface(d.info.ID)
+	if err != nil {
+		return err
+	}
+	priv := privilegedAccess(&privilegedDirect{Interface: link})
+	if d.privilegedCmd != "" {
+		priv, err = startPrivilegedUtil(d.privilegedCmd, link.Name)
+		if err != nil {
+			return err
+		}
+		defer priv.Close()
+	}
+	return priv.Restart()
+}
+
+// canStates maps unix.CAN_STATE_* values to can.State.
+var canStates = []can.State{
+	unix.CAN_STATE_ERROR_ACTIVE:  can.StateErrorActive,
+	unix.CAN_STATE_ERROR_WARNING: can.StateErrorWarning,
+	unix.CAN_STATE_ERROR_PASSIVE: can.StateErrorPassive,
+	unix.CAN_STATE_BUS_OFF:       can.StateBusOff,
+	unix.CAN_STATE_STOPPED:       can.StateStopped,
+}
+
+// Read receives as many frames as are available, up to the size
+// of buf, using a single recvmmsg call. It blocks until at least
+// one frame has been received. Frames that cannot be decoded are
+// skipped; the error is returned along with the frames decoded
+// so far, if there are none, or else by the next call.
 func (d *dev) Read(buf []can.Msg) (n int, err error) {
-	f := d.recvBuf
-	err = f.readFromN(d.file, d.mtu)
+	d.recvMu.Lock()
+	defer d.recvMu.Unlock()
+
+	if err = d.recvErr; err != nil {
+		d.recvErr = nil
+		return 0, err
+	}
+	b := d.recvBatch
+	vlen := min(len(buf), maxBatch)
+	if vlen == 0 {
+		return 0, nil
+	}
+	b.prepare(vlen, d.mtu)
+	var nr int
+	err1 := d.rc.Read(func(fd uintptr) bool {
+		nr, err = recvmmsg(fd, b.hdrs[:vlen], unix.MSG_WAITFORONE)
+		return err != unix.EAGAIN
+	})
+	if err1 != nil {
+		err = err1
+	}
 	if err != nil {
 		return 0, wrapErr("read", err)
 	}
-	err = f.decode(&buf[0], d.bufPool)
+	for i := range nr {
+		ferr := d.decodeFrame(&buf[n], b, i)
+		if ferr != nil {
+			if err == nil {
+				err = ferr
+			}
+			continue
+		}
+		n++
+	}
+	if err != nil && n != 0 {
+		d.recvErr = err
+		err = nil
+	}
+	return n, err
+}
+
+// decodeFrame decodes the i-th frame of a received batch into m.
+func (d *dev) decodeFrame(m *can.Msg, b *mmsgBuf, i int) error {
+	f := &b.frames[i]
+	err := f.checkLen(int(b.hdrs[i].n))
+	if err == nil {
+		err = parseTimestamps(&d.ts, b.control(i))
+	}
 	if err != nil {
-		return 0, err
+		return wrapErr("read", err)
 	}
-	return 1, nil
+	err = f.decode(m, d.bufPool)
+	if err != nil {
+		return err
+	}
+	m.Rx.Time = d.clock.time(&d.ts)
+	return nil
 }
 
 func (d *dev) WriteMsg(msg *can.Msg) error {
@@ -310,7 +499,7 @@ func (d *dev) WriteMsg(msg *can.Msg) error {
 
 	d.sendBufMu.Lock()
 	defer d.sendBufMu.Unlock()
-	f := d.sendBuf
+	f := &d.sendBuf
 
 	nf, err := f.encode(msg, d.mtu)
 	if err != nil {
@@ -326,15 +515,60 @@ func (d *dev) WriteMsg(msg *can.Msg) error {
 	return nil
 }
 
+// Write transmits msgs in batches using sendmmsg.
+// Status messages are skipped.
 func (d *dev) Write(msgs []can.Msg) (n int, err error) {
-	for i := range msgs {
-		err = d.WriteMsg(&msgs[i])
-		if err != nil {
-			break
+	d.sendBufMu.Lock()
+	defer d.sendBufMu.Unlock()
+
+	if d.sendBatch == nil {
+		d.sendBatch = newMmsgBuf(maxBatch, 0)
+	}
+	b := d.sendBatch
+	for n < len(msgs) {
+		var encErr error
+		nb := 0
+		i := n
+		for ; i < len(msgs) && nb < maxBatch; i++ {
+			msg := &msgs[i]
+			if msg.IsStatus() {
+				continue
+			}
+			nf, err := b.frames[nb].encode(msg, d.mtu)
+			if err != nil {
+				encErr = err
+				break
+			}
+			b.iov[nb].SetLen(nf)
+			b.idx[nb] = i
+			nb++
+		}
+		if nb != 0 {
+			var sent int
+			err1 := d.rc.Write(func(fd uintptr) bool {
+				sent, err = sendmmsg(fd, b.hdrs[:nb], 0)
+				return err != unix.EAGAIN
+			})
+			if err1 != nil {
+				err = err1
+			}
+			if err != nil {
+				if errors.Is(err, syscall.ENOBUFS) {
+					err = can.ErrTxQueueFull
+				}
+				return n, wrapErr("write", err)
+			}
+			if sent < nb {
+				n = b.idx[sent]
+				continue
+			}
+		}
+		n = i
+		if encErr != nil {
+			return n, wrapErr("write", encErr)
 		}
-		n++
 	}
-	return
+	return n, nil
 }
 
 func (d *dev) Close() error {
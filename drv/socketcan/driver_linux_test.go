@@ -0,0 +1,53 @@
+package socketcan
+
+import (
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/netlink"
+)
+
+func TestControllerState(t *testing.T) {
+	tests := []struct {
+		attrs netlink.CanAttributes
+		stats *unix.CANDeviceStats
+		want  can.ControllerState
+	}{
+		{
+			attrs: netlink.CanAttributes{State: unix.CAN_STATE_ERROR_ACTIVE},
+			want:  can.ControllerState{State: can.StateErrorActive},
+		}, {
+			attrs: netlink.CanAttributes{
+				State:          unix.CAN_STATE_ERROR_WARNING,
+				BusErrCounters: &unix.CANBusErrorCounters{Txerr: 96, Rxerr: 3},
+			},
+			want: can.ControllerState{State: can.StateErrorWarning, TxErrCount: 96, RxErrCount: 3, HasCounters: true},
+		}, {
+			attrs: netlink.CanAttributes{
+				State:          unix.CAN_STATE_ERROR_PASSIVE,
+				BusErrCounters: &unix.CANBusErrorCounters{Txerr: 0, Rxerr: 128},
+			},
+			want: can.ControllerState{State: can.StateErrorPassive, RxErrCount: 128, HasCounters: true},
+		}, {
+			attrs: netlink.CanAttributes{State: unix.CAN_STATE_BUS_OFF},
+			stats: &unix.CANDeviceStats{Restarts: 2, Bus_off: 3},
+			want:  can.ControllerState{State: can.StateBusOff, Restarts: 2},
+		}, {
+			attrs: netlink.CanAttributes{State: unix.CAN_STATE_STOPPED},
+			want:  can.ControllerState{State: can.StateStopped},
+		}, {
+			attrs: netlink.CanAttributes{State: unix.CAN_STATE_SLEEPING},
+			want:  can.ControllerState{State: can.StateUnknown},
+		}, {
+			attrs: netlink.CanAttributes{State: 42},
+			want:  can.ControllerState{State: can.StateUnknown},
+		},
+	}
+	for i, tt := range tests {
+		if got := controllerState(&tt.attrs, tt.stats); *got != tt.want {
+			t.Errorf("%d: got %+v, want %+v", i, *got, tt.want)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 
 	"github.com/knieriem/can"
 	"github.com/knieriem/can/drv/socketcan/internal/linux"
@@ -18,13 +17,21 @@ const (
 	flagsOffset = 5
 	lenOffset   = idLen
 	dataOffset  = 8
+
+	// linux CAN XL frame
+	xlFlagsOffset = 4
+	xlSDTOffset   = 5
+	xlLenOffset   = 6
+	xlAFOffset    = 8
+	xlDataOffset  = linux.CANXL_HDR_SIZE
+	xlVCIDShift   = 16 // position of the VCID within the prio field
 )
 
 // so far we only support littleEndian architectures
 var native = binary.LittleEndian
 
 type frame struct {
-	b [linux.CANFD_MTU]byte
+	b [linux.CANXL_MTU]byte
 }
 
 func (f *frame) id() uint32 {
@@ -35,7 +42,17 @@ func (f *frame) setid(id uint32) {
 	native.PutUint32(f.b[0:idLen], id)
 }
 
+// isXL reports whether the frame is a CAN XL frame. The XLF flag
+// is at the position of the len field of CAN 2.0 and FD frames,
+// where it can't be set due to the limited length.
+func (f *frame) isXL() bool {
+	return f.b[xlFlagsOffset]&linux.CANXL_XLF != 0
+}
+
 func (f *frame) len() int {
+	if f.isXL() {
+		return int(native.Uint16(f.b[xlLenOffset:]))
+	}
 	return int(f.b[lenOffset])
 }
 
@@ -47,8 +64,15 @@ func (f *frame) setFlags(v byte) {
 	f.b[flagsOffset] = byte(v)
 }
 
+func (f *frame) dataStart() int {
+	if f.isXL() {
+		return xlDataOffset
+	}
+	return dataOffset
+}
+
 func (f *frame) data() []byte {
-	data := f.b[dataOffset:]
+	data := f.b[f.dataStart():]
 	if n := f.len(); n < len(data) {
 		data = data[:n]
 	}
@@ -56,9 +80,12 @@ func (f *frame) data() []byte {
 }
 
 func (f *frame) encode(msg *can.Msg, mtu int) (nw int, err error) {
+	if msg.XLFrame() {
+		return f.encodeXL(msg, mtu)
+	}
 	nw = linux.CAN_MTU
 
-	clear(f.b[:])
+	clear(f.b[:linux.CANFD_MTU])
 	data := msg.Data()
 	n := len(data)
 	if n > mtu-dataOffset {
@@ -84,7 +111,7 @@ func (f *frame) encode(msg *can.Msg, mtu int) (nw int, err error) {
 	f.setid(id)
 
 	if needsFD || msg.Test(can.ForceFD) {
-		nw = len(f.b)
+		nw = linux.CANFD_MTU
 	}
 	if msg.Flags.Test(can.FDSwitchBitrate) {
 		f.setFlags(linux.CANFD_BRS)
@@ -92,29 +119,62 @@ func (f *frame) encode(msg *can.Msg, mtu int) (nw int, err error) {
 	return nw, nil
 }
 
+func (f *frame) encodeXL(msg *can.Msg, mtu int) (nw int, err error) {
+	data := msg.Data()
+	n := len(data)
+	if err := can.VerifyDataLenXL(n); err != nil {
+		return 0, err
+	}
+	nw = xlDataOffset + n
+	if nw > mtu {
+		return 0, ErrMTUExceeded
+	}
+	if msg.Id&^linux.CANXL_PRIO_MASK != 0 || msg.Test(can.ExtFrame) || msg.Test(can.RTRMsg) {
+		return 0, ErrInvalidXLFrame
+	}
+	flags := byte(linux.CANXL_XLF)
+	if msg.Test(can.XLSecure) {
+		flags |= linux.CANXL_SEC
+	}
+	f.setid(msg.Id | uint32(msg.XL.VCID)<<xlVCIDShift)
+	f.b[xlFlagsOffset] = flags
+	f.b[xlSDTOffset] = msg.XL.SDT
+	native.PutUint16(f.b[xlLenOffset:], uint16(n))
+	native.PutUint32(f.b[xlAFOffset:], msg.XL.AF)
+	copy(f.b[xlDataOffset:], data)
+	return nw, nil
+}
+
 func (f *frame) decode(msg *can.Msg, pool can.DataBufPool) error {
 	id := f.id()
 	msg.Reset()
-	if id&linux.CAN_ERR_FLAG != 0 {
-		// error frame
-		msg.Flags |= can.StatusMsg
-		ctrlStatus := f.data()[1]
-
-		errClass := id & linux.CAN_ERR_MASK
-		if errClass&linux.CAN_ERR_ACK != 0 {
-			msg.Flags |= can.MissingAck
+	if f.isXL() {
+		msg.Flags = can.XLFrame
+		if f.b[xlFlagsOffset]&linux.CANXL_SEC != 0 {
+			msg.Flags |= can.XLSecure
 		}
-		if errClass&linux.CAN_ERR_BUSOFF != 0 {
-			msg.Flags |= can.BusOff
-		}
-		if errClass&linux.CAN_ERR_CRTL != 0 {
-			if ctrlStatus&linux.CAN_ERR_CRTL_RX_OVERFLOW != 0 {
-				msg.Flags |= can.ReceiveBufferOverflow
-			}
-			if ctrlStatus&linux.CAN_ERR_CRTL_TX_WARNING != 0 {
-				msg.Flags |= can.ErrorWarning
-			}
+		msg.Id = id & linux.CANXL_PRIO_MASK
+		msg.XL = can.XLHeader{
+			SDT:  f.b[xlSDTOffset],
+			VCID: uint8(id >> xlVCIDShift),
+			AF:   native.Uint32(f.b[xlAFOffset:]),
 		}
+		return msg.Import(f.data(), pool)
+	}
+	if id&linux.CAN_ERR_FLAG != 0 {
+		// error frame; its layout corresponds to can.BusError
+		var d [8]byte
+		copy(d[:], f.data())
+		msg.SetBusError(&can.BusError{
+			Class:      can.ErrorClass(id & linux.CAN_ERR_MASK),
+			LostArbBit: d[0],
+			Ctrl:       can.ControllerStatus(d[1]),
+			Prot:       can.ProtViolation(d[2]),
+			ProtLoc:    can.ProtLocation(d[3]),
+			Trx:        d[4],
+			TxErrCount: d[6],
+			RxErrCount: d[7],
+		})
 		return nil
 	}
 
@@ -132,17 +192,14 @@ func (f *frame) decode(msg *can.Msg, pool can.DataBufPool) error {
 	return msg.Import(f.data(), pool)
 }
 
-func (f *frame) readFromN(r io.Reader, n int) error {
-	n, err := r.Read(f.b[:n])
-	if err != nil {
-		return err
-	}
-
-	// check for frame integrity
-	if n < dataOffset || dataOffset+f.len() > n {
+// checkLen verifies the integrity of a frame of n bytes that has been read.
+func (f *frame) checkLen(n int) error {
+	if n < dataOffset || f.dataStart()+f.len() > n {
 		return fmt.Errorf("unexpected short read: %d bytes", n)
 	}
 	return nil
 }
 
 var ErrMTUExceeded = errors.New("frame length exceeds MTU")
+
+var ErrInvalidXLFrame = errors.New("invalid CAN XL frame")
@@ -0,0 +1,91 @@
+//go:build linux
+
+package socketcan
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+)
+
+func TestFrame(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		mtu   int
+		nw    int
+		frame []byte
+		err   error
+	}{
+		{expr: "123#0102", mtu: linux.CAN_MTU, nw: linux.CAN_MTU, frame: []byte{0x23, 1, 0, 0, 2, 0, 0, 0, 1, 2}},
+		{expr: "12345678##1" + "00112233445566778899AABB", mtu: linux.CANFD_MTU, nw: linux.CANFD_MTU,
+			frame: []byte{0x78, 0x56, 0x34, 0x92, 12, linux.CANFD_BRS, 0, 0, 0, 0x11}},
+		{expr: "45123#81:70:12345678#112233", mtu: linux.CANXL_MTU, nw: linux.CANXL_HDR_SIZE + 3,
+			frame: []byte{0x23, 1, 0x45, 0, 0x81, 0x70, 3, 0, 0x78, 0x56, 0x34, 0x12, 0x11, 0x22, 0x33}},
+		{expr: "123#80:00:00000000#" + string(bytes.Repeat([]byte("00"), 100)), mtu: linux.CANXL_MIN_MTU, err: ErrMTUExceeded},
+	} {
+		var m can.Msg
+		if err := m.FromExpr(tc.expr); err != nil {
+			t.Fatal(err)
+		}
+		var f frame
+		nw, err := f.encode(&m, tc.mtu)
+		if err != tc.err {
+			t.Errorf("%s: got error %v", tc.expr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if nw != tc.nw || !bytes.HasPrefix(f.b[:nw], tc.frame) {
+			t.Errorf("%s: got %d % x", tc.expr, nw, f.b[:len(tc.frame)])
+		}
+		if err := f.checkLen(nw); err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+		}
+		var m2 can.Msg
+		pd := make(can.PlainData, 0, can.MaxXLDataLen)
+		m2.Attach(&pd)
+		if err := f.decode(&m2, nil); err != nil {
+			t.Fatal(err)
+		}
+		m.Flags &^= can.ForceFD | can.FDSwitchBitrate
+		if m2.Id != m.Id || m2.Flags != m.Flags || m2.XL != m.XL || !bytes.Equal(m2.Data(), m.Data()) {
+			t.Errorf("%s: decoded %+v", tc.expr, m2)
+		}
+	}
+}
+
+func TestErrorFrame(t *testing.T) {
+	var f frame
+	f.setid(linux.CAN_ERR_FLAG | linux.CAN_ERR_PROT | linux.CAN_ERR_BUSERROR | linux.CAN_ERR_CRTL | linux.CAN_ERR_CNT)
+	f.setLen(8)
+	copy(f.data(), []byte{0, linux.CAN_ERR_CRTL_TX_PASSIVE, 0x82, 0x19, 0, 0, 128, 5})
+
+	var m can.Msg
+	if err := f.decode(&m, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m.Flags != can.StatusMsg|can.ErrorPassive {
+		t.Errorf("unexpected flags: %#x", m.Flags)
+	}
+	e, ok := m.BusError()
+	if !ok {
+		t.Fatal("bus error missing")
+	}
+	want := can.BusError{
+		Class:      can.ClassProt | can.ClassBusError | can.ClassCtrl | can.ClassCounters,
+		Ctrl:       can.CtrlTxPassive,
+		Prot:       can.ProtForm | can.ProtTx,
+		ProtLoc:    can.LocAck,
+		TxErrCount: 128,
+		RxErrCount: 5,
+	}
+	if e != want {
+		t.Errorf("got %+v, want %+v", e, want)
+	}
+	if s := e.State(); s != can.StateErrorPassive {
+		t.Errorf("got state %v", s)
+	}
+}
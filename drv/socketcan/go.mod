@@ -4,7 +4,7 @@ go 1.25.0
 
 require (
 	github.com/jsimonetti/rtnetlink/v2 v2.2.0
-	github.com/knieriem/can v0.3.0-beta1
+	github.com/knieriem/can v0.3.0-beta1.0.20261018043028-1cfab3cf9ed2
 	github.com/mdlayher/netlink v1.9.0
 	golang.org/x/sys v0.42.0
 )
@@ -15,3 +15,7 @@ require (
 	golang.org/x/net v0.52.0 // indirect
 	golang.org/x/sync v0.20.0 // indirect
 )
+
+// Build against the package in the parent directory, so that
+// changes to both modules can be tested together.
+replace github.com/knieriem/can => ../..
@@ -0,0 +1,6 @@
+package linux
+
+// CAN_RAW_XL_VCID_OPTS is the raw socket option taking a
+// struct can_raw_vcid_options. It is defined here, as it is
+// missing from the headers the constants have been generated from.
+const CAN_RAW_XL_VCID_OPTS = 8
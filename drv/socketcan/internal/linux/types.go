@@ -4,8 +4,11 @@ package linux
 
 /*
 #include <linux/can.h>
+#include <linux/can/j1939.h>
 */
 import "C"
 
 type CanFrame C.struct_can_frame
 type CanfdFrame C.struct_canfd_frame
+
+type J1939Filter C.struct_j1939_filter
@@ -17,6 +17,17 @@ const (
 	CAN_MTU   = 0x10
 	CANFD_MTU = 0x48
 
+	CANXL_XLF       = 0x80
+	CANXL_SEC       = 0x1
+	CANXL_MTU       = 0x80c
+	CANXL_HDR_SIZE  = 0xc
+	CANXL_MIN_MTU   = 0x4c
+	CANXL_MIN_DLEN  = 0x1
+	CANXL_MAX_DLEN  = 0x800
+	CANXL_PRIO_MASK = 0x7ff
+
+	CAN_RAW_XL_FRAMES = 0x7
+
 	CAN_ERR_TX_TIMEOUT = 0x1
 	CAN_ERR_LOSTARB    = 0x2
 	CAN_ERR_CRTL       = 0x4
@@ -26,6 +37,7 @@ const (
 	CAN_ERR_BUSOFF     = 0x40
 	CAN_ERR_BUSERROR   = 0x80
 	CAN_ERR_RESTARTED  = 0x100
+	CAN_ERR_CNT        = 0x200
 
 	CAN_ERR_CRTL_UNSPEC      = 0x0
 	CAN_ERR_CRTL_RX_OVERFLOW = 0x1
@@ -34,4 +46,52 @@ const (
 	CAN_ERR_CRTL_TX_WARNING  = 0x8
 	CAN_ERR_CRTL_RX_PASSIVE  = 0x10
 	CAN_ERR_CRTL_TX_PASSIVE  = 0x20
+
+	TX_SETUP   = 0x1
+	TX_DELETE  = 0x2
+	TX_READ    = 0x3
+	TX_SEND    = 0x4
+	RX_SETUP   = 0x5
+	RX_DELETE  = 0x6
+	RX_READ    = 0x7
+	TX_STATUS  = 0x8
+	TX_EXPIRED = 0x9
+	RX_STATUS  = 0xa
+	RX_TIMEOUT = 0xb
+	RX_CHANGED = 0xc
+
+	SETTIMER           = 0x1
+	STARTTIMER         = 0x2
+	TX_COUNTEVT        = 0x4
+	TX_ANNOUNCE        = 0x8
+	TX_CP_CAN_ID       = 0x10
+	RX_FILTER_ID       = 0x20
+	RX_CHECK_DLC       = 0x40
+	RX_NO_AUTOTIMER    = 0x80
+	RX_ANNOUNCE_RESUME = 0x100
+	TX_RESET_MULTI_IDX = 0x200
+	RX_RTR_FRAME       = 0x400
+	CAN_FD_FRAME       = 0x800
+
+	SOL_CAN_ISOTP     = 0x6a
+	CAN_ISOTP_OPTS    = 0x1
+	CAN_ISOTP_RECV_FC = 0x2
+	CAN_ISOTP_LL_OPTS = 0x5
+
+	CAN_ISOTP_EXTEND_ADDR  = 0x2
+	CAN_ISOTP_TX_PADDING   = 0x4
+	CAN_ISOTP_RX_EXT_ADDR  = 0x200
+	CAN_ISOTP_WAIT_TX_DONE = 0x400
+
+	SOL_CAN_J1939       = 0x6b
+	SO_J1939_FILTER     = 0x1
+	SO_J1939_PROMISC    = 0x2
+	SO_J1939_SEND_PRIO  = 0x3
+	SCM_J1939_DEST_ADDR = 0x1
+	SCM_J1939_PRIO      = 0x3
+
+	J1939_NO_ADDR = 0xff
+	J1939_NO_NAME = 0x0
+	J1939_NO_PGN  = 0x40000
+	J1939_PGN_MAX = 0x3ffff
 )
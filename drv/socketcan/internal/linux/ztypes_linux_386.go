@@ -19,3 +19,13 @@ type CanfdFrame struct {
 	X__res1 uint8
 	Data    [64]uint8
 }
+
+type J1939Filter struct {
+	Name      uint64
+	Name_mask uint64
+	Pgn       uint32
+	Pgn_mask  uint32
+	Addr      uint8
+	Addr_mask uint8
+	Pad_cgo_0 [2]byte
+}
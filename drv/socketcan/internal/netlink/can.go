@@ -14,10 +14,14 @@ import (
 )
 
 const (
-	ifla_CAN_TDC          = 0x10
-	ifla_CAN_CTRLMODE_EXT = 0x11
+	ifla_CAN_TDC                     = 0x10
+	ifla_CAN_CTRLMODE_EXT            = 0x11
+	ifla_CAN_XL_DATA_BITTIMING       = 0x12
+	ifla_CAN_XL_DATA_BITTIMING_CONST = 0x13
 
 	ifla_CAN_CTRLMODE_SUPPORTED = 1
+
+	can_CTRLMODE_XL = 0x1000
 )
 
 // CanAttributes contain the attributes read from a CAN network interface.
@@ -40,6 +44,10 @@ type CanAttributes struct {
 	CtrlMode          unix.CANCtrlMode
 	CtrlModeSupported uint32
 
+	// hasCtrlModeExt is set if the kernel provides
+	// IFLA_CAN_CTRLMODE_EXT, i.e. CtrlModeSupported.
+	hasCtrlModeExt bool
+
 	RestartMs uint32
 	Clock     uint32
 
@@ -54,14 +62,20 @@ type CanAttributes struct {
 	DataBitTiming      *unix.CANBitTiming
 	DataBitTimingConst *unix.CANBitTimingConst
 
+	// XLDataBitTiming and XLDataBitTimingConst
+	// are provided by CAN XL capable interfaces.
+	XLDataBitTiming      *unix.CANBitTiming
+	XLDataBitTimingConst *unix.CANBitTimingConst
+
 	BitrateMax uint32
 
 	UnknownTypes []uint16
 }
 
 type canAttrData struct {
-	tx *can.Config
-	rx *CanAttributes
+	tx      *can.Config
+	rx      *CanAttributes
+	restart bool
 }
 
 func init() {
@@ -77,6 +91,10 @@ func (*canAttrData) New() rtnetlink.LinkDriver {
 }
 
 func (d *canAttrData) Encode(ae *netlink.AttributeEncoder) error {
+	if d.restart {
+		ae.Uint32(unix.IFLA_CAN_RESTART, 1)
+		return nil
+	}
 	can := new(canAttrEncoder)
 	can.ae = ae
 	can.setConfig(d.tx)
@@ -116,6 +134,7 @@ func (d *canAttrData) Decode(netlAd *netlink.AttributeDecoder) error {
 				return fmt.Errorf("parsing CtrlModeExt: %w", err)
 			}
 			c.CtrlModeSupported = flags
+			c.hasCtrlModeExt = true
 		case unix.IFLA_CAN_RESTART_MS:
 			c.RestartMs = ad.Uint32()
 
@@ -131,6 +150,12 @@ func (d *canAttrData) Decode(netlAd *netlink.AttributeDecoder) error {
 		case unix.IFLA_CAN_DATA_BITTIMING_CONST:
 			c.DataBitTimingConst, err = ad.decodeBitTimingConst("DATA_")
 
+		case ifla_CAN_XL_DATA_BITTIMING:
+			c.XLDataBitTiming, err = ad.decodeBitTiming("XL_DATA_")
+
+		case ifla_CAN_XL_DATA_BITTIMING_CONST:
+			c.XLDataBitTimingConst, err = ad.decodeBitTimingConst("XL_DATA_")
+
 		case unix.IFLA_CAN_CLOCK:
 			c.Clock = ad.Uint32()
 
@@ -206,9 +231,38 @@ func (can *CanAttributes) Controller() *timing.Controller {
 		ctl.Data = new(timing.Constraints)
 		convertConstraints(ctl.Data, can.DataBitTimingConst)
 	}
+	if can.CtrlModeSupported&can_CTRLMODE_XL != 0 && can.XLDataBitTimingConst != nil {
+		ctl.XLData = new(timing.Constraints)
+		convertConstraints(ctl.XLData, can.XLDataBitTimingConst)
+	}
 	return ctl
 }
 
+// ctrlModeFlags maps can.CtrlMode values to unix.CAN_CTRLMODE_* flags.
+var ctrlModeFlags = []uint32{
+	can.ModeListenOnly:     unix.CAN_CTRLMODE_LISTENONLY,
+	can.ModeLoopback:       unix.CAN_CTRLMODE_LOOPBACK,
+	can.ModeOneShot:        unix.CAN_CTRLMODE_ONE_SHOT,
+	can.ModeTripleSampling: unix.CAN_CTRLMODE_3_SAMPLES,
+	can.ModePresumeAck:     unix.CAN_CTRLMODE_PRESUME_ACK,
+	can.ModeFDNonISO:       unix.CAN_CTRLMODE_FD_NON_ISO,
+}
+
+func ctrlModeOpt(conf *can.Config, m int) *can.Optional[bool] {
+	return conf.CtrlMode(can.CtrlMode(m))
+}
+
+// SupportsCtrlMode reports whether the interface supports
+// controller mode m, according to CtrlModeSupported. If the kernel
+// does not report the supported modes, true is returned, so that
+// the kernel decides when the mode is configured.
+func (can *CanAttributes) SupportsCtrlMode(m can.CtrlMode) bool {
+	if !can.hasCtrlModeExt {
+		return true
+	}
+	return can.CtrlModeSupported&ctrlModeFlags[m] != 0
+}
+
 func convertConstraints(cstr *timing.Constraints, c *unix.CANBitTimingConst) {
 	cstr.TSeg1Min = int(c.Tseg1_min)
 	cstr.TSeg1Max = int(c.Tseg1_max)
@@ -236,6 +290,25 @@ func (can *CanAttributes) needUpdate(conf *can.Config) (needUpdate bool, err err
 			return true, nil
 		}
 	}
+	for m, flag := range ctrlModeFlags {
+		opt := ctrlModeOpt(conf, m)
+		if opt.Valid && opt.Value != (can.CtrlMode.Flags&flag != 0) {
+			return true, nil
+		}
+	}
+	if d := conf.RestartDelay; d.Valid && uint32(d.Value.Milliseconds()) != can.RestartMs {
+		return true, nil
+	}
+	wantXL, maskXL := xlMode(conf)
+	haveXL := can.CtrlMode.Flags&can_CTRLMODE_XL != 0
+	if maskXL && haveXL != wantXL {
+		return true, nil
+	}
+	if wantXL {
+		if !bittimingsEqual(xlDataBitTiming(conf), can.XLDataBitTiming) {
+			return true, nil
+		}
+	}
 	return false, nil
 }
 
@@ -300,6 +373,24 @@ func (link *Interface) SetConfig(conf *can.Config) error {
 	return link.conn.Link.Set(msg)
 }
 
+// Restart restarts the CAN controller manually after a bus-off
+// condition. The kernel returns EBUSY if the interface is not
+// in bus-off state.
+func (link *Interface) Restart() error {
+	msg := &rtnetlink.LinkMessage{
+		Family: link.msgFamily,
+		Type:   link.msgType,
+		Index:  uint32(link.index),
+		Attributes: &rtnetlink.LinkAttributes{
+			Info: &rtnetlink.LinkInfo{
+				Kind: "can",
+				Data: &canAttrData{restart: true},
+			},
+		},
+	}
+	return link.conn.Link.Set(msg)
+}
+
 type canAttrEncoder struct {
 	ae *netlink.AttributeEncoder
 }
@@ -314,17 +405,52 @@ func (can *canAttrEncoder) setConfig(conf *can.Config) {
 		}
 		can.setBittiming(unix.IFLA_CAN_DATA_BITTIMING, data)
 	}
-	can.SetFDMode(fd)
-	// TODO: support conf.Termination
-}
-
-func (can *canAttrEncoder) SetFDMode(v bool) {
 	var m unix.CANCtrlMode
 	m.Mask = unix.CAN_CTRLMODE_FD
-	if v {
-		m.Flags = m.Mask
+	if fd {
+		m.Flags = unix.CAN_CTRLMODE_FD
+	}
+	xl, maskXL := xlMode(conf)
+	if xl {
+		can.setBittiming(ifla_CAN_XL_DATA_BITTIMING, xlDataBitTiming(conf))
+		m.Flags |= can_CTRLMODE_XL
+	}
+	if maskXL {
+		m.Mask |= can_CTRLMODE_XL
+	}
+	for mode, flag := range ctrlModeFlags {
+		opt := ctrlModeOpt(conf, mode)
+		if !opt.Valid {
+			continue
+		}
+		m.Mask |= flag
+		if opt.Value {
+			m.Flags |= flag
+		}
 	}
 	can.encodeData(unix.IFLA_CAN_CTRLMODE, m)
+	if conf.RestartDelay.Valid {
+		can.ae.Uint32(unix.IFLA_CAN_RESTART_MS, uint32(conf.RestartDelay.Value.Milliseconds()))
+	}
+	// TODO: support conf.Termination
+}
+
+// xlMode reports whether conf requests XL mode, and whether the XL bit of
+// the control mode is to be changed at all, which is only the case if XL
+// mode is requested, or disabled explicitly, as older kernels don't know
+// about this bit.
+func xlMode(conf *can.Config) (xl, mask bool) {
+	xl = (conf.XLMode.Valid && conf.XLMode.Value) || conf.XLData.Valid
+	return xl, xl || conf.XLMode.Valid
+}
+
+// xlDataBitTiming returns the XL data bit timing of conf,
+// which defaults to the nominal bit timing.
+func xlDataBitTiming(conf *can.Config) *can.BitTimingConfig {
+	if conf.XLData.Valid {
+		return &conf.XLData.Value
+	}
+	return &conf.Nominal
 }
 
 func (can *canAttrEncoder) setBittiming(t uint16, btc *can.BitTimingConfig) {
@@ -352,3 +478,63 @@ func (can *canAttrEncoder) encodeData(t uint16, data any) {
 		return b.Bytes(), nil
 	})
 }
+
+// DeviceStats returns the statistics maintained by the CAN
+// device driver, like the number of restarts, or nil if the
+// interface does not provide any. As these are not decoded by
+// rtnetlink, the link is requested using a plain netlink connection.
+func (link *Interface) DeviceStats() (*unix.CANDeviceStats, error) {
+	c, err := netlink.Dial(unix.NETLINK_ROUTE, nil)
+	if err != nil {
+		return nil, fmt.Errorf("netlink: %w", err)
+	}
+	defer c.Close()
+
+	ifi := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(ifi[4:], uint32(link.index))
+	msgs, err := c.Execute(netlink.Message{
+		Header: netlink.Header{Type: unix.RTM_GETLINK, Flags: netlink.Request},
+		Data:   ifi,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("netlink: %w", err)
+	}
+	for _, m := range msgs {
+		if len(m.Data) < unix.SizeofIfInfomsg {
+			continue
+		}
+		stats, err := decodeDeviceStats(m.Data[unix.SizeofIfInfomsg:])
+		if err != nil {
+			return nil, fmt.Errorf("netlink: parsing device stats: %w", err)
+		}
+		if stats != nil {
+			return stats, nil
+		}
+	}
+	return nil, nil
+}
+
+// decodeDeviceStats looks for IFLA_INFO_XSTATS within the
+// IFLA_LINKINFO attribute of a link message.
+func decodeDeviceStats(b []byte) (stats *unix.CANDeviceStats, err error) {
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		return nil, err
+	}
+	for ad.Next() {
+		if ad.Type() != unix.IFLA_LINKINFO {
+			continue
+		}
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			for nad.Next() {
+				if nad.Type() == unix.IFLA_INFO_XSTATS {
+					stats = new(unix.CANDeviceStats)
+					cad := &canAttrDecoder{nad}
+					return cad.decodeStruct(stats)
+				}
+			}
+			return nil
+		})
+	}
+	return stats, ad.Err()
+}
@@ -0,0 +1,253 @@
+package netlink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/mdlayher/netlink"
+)
+
+func TestXLCtrlMode(t *testing.T) {
+	bt500k := &unix.CANBitTiming{Bitrate: 500000}
+	bt2M := &unix.CANBitTiming{Bitrate: 2000000}
+	tests := []struct {
+		spec       string
+		attrs      CanAttributes
+		needUpdate bool
+		mask       uint32
+		flags      uint32
+	}{
+		{
+			spec:  "500k",
+			attrs: CanAttributes{BitTiming: bt500k},
+			mask:  unix.CAN_CTRLMODE_FD,
+		}, {
+			// XL mode enabled, but not mentioned in the config:
+			// keep it as it is
+			spec: "500k",
+			attrs: CanAttributes{
+				BitTiming:       bt500k,
+				XLDataBitTiming: bt500k,
+				CtrlMode:        unix.CANCtrlMode{Flags: can_CTRLMODE_XL},
+			},
+			mask: unix.CAN_CTRLMODE_FD,
+		}, {
+			spec: "500k xl:0",
+			attrs: CanAttributes{
+				BitTiming:       bt500k,
+				XLDataBitTiming: bt500k,
+				CtrlMode:        unix.CANCtrlMode{Flags: can_CTRLMODE_XL},
+			},
+			needUpdate: true,
+			mask:       unix.CAN_CTRLMODE_FD | can_CTRLMODE_XL,
+		}, {
+			spec:       "500k xl:1",
+			attrs:      CanAttributes{BitTiming: bt500k},
+			needUpdate: true,
+			mask:       unix.CAN_CTRLMODE_FD | can_CTRLMODE_XL,
+			flags:      can_CTRLMODE_XL,
+		}, {
+			spec: "500k xl:1",
+			attrs: CanAttributes{
+				BitTiming:       bt500k,
+				XLDataBitTiming: bt500k,
+				CtrlMode:        unix.CANCtrlMode{Flags: can_CTRLMODE_XL},
+			},
+			mask:  unix.CAN_CTRLMODE_FD | can_CTRLMODE_XL,
+			flags: can_CTRLMODE_XL,
+		}, {
+			spec: "500k xdb:2M",
+			attrs: CanAttributes{
+				BitTiming:       bt500k,
+				XLDataBitTiming: bt2M,
+				CtrlMode:        unix.CANCtrlMode{Flags: can_CTRLMODE_XL},
+			},
+			mask:  unix.CAN_CTRLMODE_FD | can_CTRLMODE_XL,
+			flags: can_CTRLMODE_XL,
+		}, {
+			spec: "500k xdb:2M",
+			attrs: CanAttributes{
+				BitTiming:       bt500k,
+				XLDataBitTiming: bt500k,
+				CtrlMode:        unix.CANCtrlMode{Flags: can_CTRLMODE_XL},
+			},
+			needUpdate: true,
+			mask:       unix.CAN_CTRLMODE_FD | can_CTRLMODE_XL,
+			flags:      can_CTRLMODE_XL,
+		},
+	}
+	for i, tt := range tests {
+		conf, err := can.ParseConfig(tt.spec)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		update, err := tt.attrs.needUpdate(conf)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if update != tt.needUpdate {
+			t.Errorf("%d: %q: needUpdate: got %v, want %v", i, tt.spec, update, tt.needUpdate)
+		}
+		m := encodedCtrlMode(t, conf)
+		if m.Mask != tt.mask || m.Flags != tt.flags {
+			t.Errorf("%d: %q: ctrl mode: got %+v, want mask %#x, flags %#x", i, tt.spec, m, tt.mask, tt.flags)
+		}
+	}
+}
+
+func encodedCtrlMode(t *testing.T, conf *can.Config) *unix.CANCtrlMode {
+	t.Helper()
+	ae := netlink.NewAttributeEncoder()
+	(&canAttrEncoder{ae: ae}).setConfig(conf)
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad, err := netlink.NewAttributeDecoder(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ad.Next() {
+		if ad.Type() != unix.IFLA_CAN_CTRLMODE {
+			continue
+		}
+		var m unix.CANCtrlMode
+		err := binary.Read(bytes.NewReader(ad.Bytes()), ad.ByteOrder, &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &m
+	}
+	t.Fatal("ctrl mode attribute missing")
+	return nil
+}
+
+func TestDecodeDeviceStats(t *testing.T) {
+	want := unix.CANDeviceStats{Bus_error: 7, Error_warning: 3, Error_passive: 2, Bus_off: 1, Restarts: 1}
+	var xstats bytes.Buffer
+	binary.Write(&xstats, binary.NativeEndian, &want)
+
+	tests := []struct {
+		name  string
+		attrs func(ae *netlink.AttributeEncoder)
+		want  *unix.CANDeviceStats
+	}{
+		{
+			name: "stats",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.IFLA_IFNAME, "can0")
+				ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+					nae.String(unix.IFLA_INFO_KIND, "can")
+					nae.Bytes(unix.IFLA_INFO_XSTATS, xstats.Bytes())
+					return nil
+				})
+			},
+			want: &want,
+		}, {
+			name: "no xstats",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+					nae.String(unix.IFLA_INFO_KIND, "vcan")
+					return nil
+				})
+			},
+		}, {
+			name: "no linkinfo",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.String(unix.IFLA_IFNAME, "can0")
+			},
+		},
+	}
+	for _, tt := range tests {
+		ae := netlink.NewAttributeEncoder()
+		tt.attrs(ae)
+		b, err := ae.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decodeDeviceStats(b)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		switch {
+		case got == nil && tt.want == nil:
+		case got == nil || tt.want == nil:
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		case *got != *tt.want:
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, *tt.want)
+		}
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.Bytes(unix.IFLA_INFO_XSTATS, xstats.Bytes()[:8])
+		return nil
+	})
+	b, err := ae.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeDeviceStats(b); err == nil {
+		t.Error("short stats: missing error")
+	}
+}
+
+func TestSupportsCtrlMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs func(ae *netlink.AttributeEncoder)
+		want  map[can.CtrlMode]bool
+	}{
+		{
+			name: "ctrlmode ext",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.Nested(ifla_CAN_CTRLMODE_EXT, func(nae *netlink.AttributeEncoder) error {
+					nae.Uint32(ifla_CAN_CTRLMODE_SUPPORTED, unix.CAN_CTRLMODE_LISTENONLY|unix.CAN_CTRLMODE_ONE_SHOT)
+					return nil
+				})
+			},
+			want: map[can.CtrlMode]bool{
+				can.ModeListenOnly: true,
+				can.ModeOneShot:    true,
+				can.ModeFDNonISO:   false,
+			},
+		}, {
+			// older kernels
+			name: "no ctrlmode ext",
+			attrs: func(ae *netlink.AttributeEncoder) {
+				ae.Uint32(unix.IFLA_CAN_STATE, unix.CAN_STATE_ERROR_ACTIVE)
+			},
+			want: map[can.CtrlMode]bool{
+				can.ModeListenOnly: true,
+				can.ModeOneShot:    true,
+				can.ModeFDNonISO:   true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		ae := netlink.NewAttributeEncoder()
+		tt.attrs(ae)
+		b, err := ae.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ad, err := netlink.NewAttributeDecoder(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var d canAttrData
+		if err := d.Decode(ad); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for m, want := range tt.want {
+			if got := d.rx.SupportsCtrlMode(m); got != want {
+				t.Errorf("%s: %v: got %v, want %v", tt.name, m, got, want)
+			}
+		}
+	}
+}
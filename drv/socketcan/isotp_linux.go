@@ -0,0 +1,202 @@
+package socketcan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+	"github.com/knieriem/can/isotp"
+)
+
+// ISOTPConn is an ISO-TP connection using the kernel's CAN_ISOTP
+// implementation. It implements isotp.Transport.
+type ISOTPConn struct {
+	file *os.File
+	rc   syscall.RawConn
+}
+
+// NewISOTP returns an ISO-TP connection on the network interface
+// ifName, using the kernel's implementation if it is available,
+// or else an isotp.Conn running on a raw CAN socket.
+func NewISOTP(ifName string, cfg *isotp.Config) (isotp.Transport, error) {
+	c, err := OpenISOTP(ifName, cfg)
+	if err == nil {
+		return c, nil
+	}
+	if !errors.Is(err, unix.EPROTONOSUPPORT) {
+		return nil, err
+	}
+	return openUserISOTP(ifName, cfg)
+}
+
+// openUserISOTP returns an isotp.Conn running on a raw CAN socket.
+// Since received FD frames may carry more than eight bytes,
+// the device is provided with a buffer pool.
+func openUserISOTP(ifName string, cfg *isotp.Config) (*isotp.Conn, error) {
+	dev, err := defaultDriver.Open(&can.Env{BufPool: plainBufPool{}}, ifName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return isotp.NewConn(dev, cfg), nil
+}
+
+// plainBufPool allocates a new can.PlainData buffer on each request.
+type plainBufPool struct{}
+
+func (plainBufPool) Get(n int) can.DataBuffer {
+	pd := make(can.PlainData, 0, n)
+	return &pd
+}
+
+// OpenISOTP opens a CAN_ISOTP socket on the network interface ifName,
+// configured according to cfg. Mixed addressing is handled like
+// extended addressing, i.e. TxAddr and RxAddr are put into, or expected
+// as the first data byte of each frame. The timeouts of cfg are not
+// used, since the kernel applies its own values.
+func OpenISOTP(ifName string, cfg *isotp.Config) (*ISOTPConn, error) {
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_DGRAM, unix.CAN_ISOTP)
+	if err != nil {
+		return nil, wrapErr("isotp", err)
+	}
+	err = setupISOTP(fd, ifName, cfg)
+	if err != nil {
+		unix.Close(fd)
+		return nil, wrapErr("isotp", err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, wrapErr("isotp", err)
+	}
+	c := new(ISOTPConn)
+	c.file = os.NewFile(uintptr(fd), "isotp")
+	c.rc, err = c.file.SyscallConn()
+	if err != nil {
+		c.file.Close()
+		return nil, wrapErr("isotp", err)
+	}
+	return c, nil
+}
+
+func setupISOTP(fd int, ifName string, cfg *isotp.Config) error {
+	// struct can_isotp_options
+	opts := make([]byte, 12)
+	flags := uint32(linux.CAN_ISOTP_WAIT_TX_DONE)
+	if cfg.AddrMode != isotp.NormalAddr {
+		flags |= linux.CAN_ISOTP_EXTEND_ADDR | linux.CAN_ISOTP_RX_EXT_ADDR
+		opts[8] = cfg.TxAddr
+		opts[11] = cfg.RxAddr
+	}
+	if cfg.Padding {
+		flags |= linux.CAN_ISOTP_TX_PADDING
+		opts[9] = cfg.PadByte
+	}
+	native.PutUint32(opts, flags)
+	err := setsockopt(fd, linux.CAN_ISOTP_OPTS, opts)
+	if err != nil {
+		return err
+	}
+
+	// struct can_isotp_fc_options
+	fc := []byte{cfg.BlockSize, isotp.EncodeSTmin(cfg.STmin), byte(min(cfg.WFTmax, 255))}
+	err = setsockopt(fd, linux.CAN_ISOTP_RECV_FC, fc)
+	if err != nil {
+		return err
+	}
+
+	if cfg.TxDL > 8 {
+		// struct can_isotp_ll_options
+		ll := []byte{linux.CANFD_MTU, byte(cfg.TxDL), 0}
+		if cfg.BRS {
+			ll[2] = linux.CANFD_BRS
+		}
+		err = setsockopt(fd, linux.CAN_ISOTP_LL_OPTS, ll)
+		if err != nil {
+			return err
+		}
+	}
+
+	ifindex, err := ifIndex(fd, ifName)
+	if err != nil {
+		return err
+	}
+	sa := &unix.SockaddrCAN{Ifindex: ifindex, TxID: cfg.TxID, RxID: cfg.RxID}
+	if cfg.ExtFrame {
+		sa.TxID |= linux.CAN_EFF_FLAG
+		sa.RxID |= linux.CAN_EFF_FLAG
+	}
+	return unix.Bind(fd, sa)
+}
+
+func setsockopt(fd, opt int, b []byte) error {
+	return unix.SetsockoptString(fd, linux.SOL_CAN_ISOTP, opt, string(b))
+}
+
+// isotpErrors maps error codes reported by the kernel
+// to errors of package isotp.
+var isotpErrors = map[syscall.Errno]error{
+	unix.ECOMM:     isotp.ErrTimeoutBs,
+	unix.ETIMEDOUT: isotp.ErrTimeoutCr,
+	unix.EILSEQ:    isotp.ErrWrongSN,
+	unix.EMSGSIZE:  isotp.ErrOverflow,
+	unix.EBADMSG:   isotp.ErrInvalidPCI,
+}
+
+func isotpErr(fnName string, err error) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		if e, ok := isotpErrors[errno]; ok {
+			err = fmt.Errorf("%w (%w)", e, err)
+		}
+	}
+	return wrapErr(fnName, err)
+}
+
+// Read receives a single PDU. If p is too small to hold it,
+// the PDU is truncated, and io.ErrShortBuffer is returned.
+func (c *ISOTPConn) Read(p []byte) (n int, err error) {
+	err1 := c.rc.Read(func(fd uintptr) bool {
+		n, _, _, _, err = unix.Recvmsg(int(fd), p, nil, unix.MSG_TRUNC)
+		return err != unix.EAGAIN
+	})
+	if err1 != nil {
+		return 0, wrapErr("read", err1)
+	}
+	if err != nil {
+		return 0, isotpErr("read", err)
+	}
+	if n > len(p) {
+		return len(p), io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+// Write transmits p as a single PDU. It returns
+// after the last frame has been transmitted.
+func (c *ISOTPConn) Write(p []byte) (int, error) {
+	n, err := c.file.Write(p)
+	if err != nil {
+		return n, isotpErr("write", err)
+	}
+	return n, nil
+}
+
+// SetReadDeadline sets the deadline for subsequent Read calls.
+// A zero value disables the deadline.
+func (c *ISOTPConn) SetReadDeadline(t time.Time) error {
+	return c.file.SetReadDeadline(t)
+}
+
+func (c *ISOTPConn) Close() error {
+	err := c.file.Close()
+	if err != nil {
+		return wrapErr("close", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package socketcan
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+	"github.com/knieriem/can/isotp"
+)
+
+func TestISOTPErr(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want error
+	}{
+		{unix.ECOMM, isotp.ErrTimeoutBs},
+		{unix.EILSEQ, isotp.ErrWrongSN},
+		{os.NewSyscallError("recvmsg", unix.ETIMEDOUT), isotp.ErrTimeoutCr},
+		{unix.EIO, unix.EIO},
+	} {
+		err := isotpErr("read", tc.err)
+		if !errors.Is(err, tc.want) || !errors.Is(err, tc.err) {
+			t.Errorf("%v: got %v", tc.err, err)
+		}
+	}
+}
+
+// TestISOTP needs a vcan interface named vcan0,
+// and the can-isotp kernel module.
+func TestISOTP(t *testing.T) {
+	cfg := &isotp.Config{TxID: 0x7E0, RxID: 0x7E8, Padding: true, PadByte: 0xAA}
+	c, err := OpenISOTP("vcan0", cfg)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+	peer, err := OpenISOTP("vcan0", &isotp.Config{TxID: 0x7E8, RxID: 0x7E0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	pdu := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 100)
+	go c.Write(pdu)
+	buf := make([]byte, 4096)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := peer.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], pdu) {
+		t.Errorf("got % x", buf[:n])
+	}
+
+	go c.Write(pdu)
+	if _, err := peer.Read(buf[:10]); err == nil {
+		t.Error("no error reading into short buffer")
+	}
+
+	peer.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := peer.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v", err)
+	}
+}
+
+// TestISOTPBufPool checks that FD frames received by the
+// device of the userspace fallback can be decoded.
+func TestISOTPBufPool(t *testing.T) {
+	var m can.Msg
+	m.FromExpr("7E8##0" + strings.Repeat("11", 64))
+	var f frame
+	if _, err := f.encode(&m, linux.CANFD_MTU); err != nil {
+		t.Fatal(err)
+	}
+	var m2 can.Msg
+	if err := f.decode(&m2, nil); err != can.ErrMsgCapExceeded {
+		t.Errorf("decoding without pool: got %v", err)
+	}
+	if err := f.decode(&m2, plainBufPool{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m2.Data(), m.Data()) {
+		t.Errorf("got % x", m2.Data())
+	}
+}
+
+// TestUserISOTPFD transfers a PDU using FD frames of 64 bytes
+// over the userspace fallback. It needs a vcan interface named vcan0.
+func TestUserISOTPFD(t *testing.T) {
+	c, err := openUserISOTP("vcan0", &isotp.Config{TxID: 0x7E0, RxID: 0x7E8, TxDL: 64})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer c.Close()
+	peer, err := openUserISOTP("vcan0", &isotp.Config{TxID: 0x7E8, RxID: 0x7E0, TxDL: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	pdu := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 100)
+	go c.Write(pdu)
+	buf := make([]byte, 4096)
+	peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := peer.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], pdu) {
+		t.Errorf("got % x", buf[:n])
+	}
+}
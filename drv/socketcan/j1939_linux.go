@@ -0,0 +1,246 @@
+package socketcan
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+	"github.com/knieriem/can/j1939"
+)
+
+// J1939Config defines the parameters of a J1939 socket.
+type J1939Config struct {
+	// Name and Addr are the NAME and the address of the local node.
+	// If Name is not zero, the kernel tracks address claims, and
+	// resolves the node's source address using its Name; in this case
+	// Claim must be called before messages can be sent. If Name is zero,
+	// Addr is used as static source address.
+	Name j1939.Name
+	Addr byte
+
+	// If PGNs is not empty, only messages with the listed
+	// parameter group numbers are received.
+	PGNs []j1939.PGN
+
+	// If Promiscuous is set, messages addressed
+	// to other nodes are received too.
+	Promiscuous bool
+}
+
+// J1939Conn is a J1939 socket using the kernel's CAN_J1939
+// implementation, which handles the transport protocols,
+// and address claiming. Its API corresponds to that of j1939.Node.
+type J1939Conn struct {
+	file *os.File
+	rc   syscall.RawConn
+	name j1939.Name
+
+	wmu  sync.Mutex
+	prio int // send priority currently set
+}
+
+// OpenJ1939 opens a CAN_J1939 socket on the network
+// interface ifName, configured according to cfg.
+func OpenJ1939(ifName string, cfg *J1939Config) (*J1939Conn, error) {
+	fd, err := unix.Socket(unix.AF_CAN, unix.SOCK_DGRAM, unix.CAN_J1939)
+	if err != nil {
+		return nil, wrapErr("j1939", err)
+	}
+	err = setupJ1939(fd, ifName, cfg)
+	if err == nil {
+		err = unix.SetNonblock(fd, true)
+	}
+	if err != nil {
+		unix.Close(fd)
+		return nil, wrapErr("j1939", err)
+	}
+	c := new(J1939Conn)
+	c.name = cfg.Name
+	c.prio = -1
+	c.file = os.NewFile(uintptr(fd), "j1939")
+	c.rc, err = c.file.SyscallConn()
+	if err != nil {
+		c.file.Close()
+		return nil, wrapErr("j1939", err)
+	}
+	return c, nil
+}
+
+func setupJ1939(fd int, ifName string, cfg *J1939Config) error {
+	// needed to send, and to receive broadcasts
+	err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+	if err != nil {
+		return err
+	}
+	if cfg.Promiscuous {
+		err = unix.SetsockoptInt(fd, linux.SOL_CAN_J1939, linux.SO_J1939_PROMISC, 1)
+		if err != nil {
+			return err
+		}
+	}
+	if len(cfg.PGNs) != 0 {
+		flt := make([]linux.J1939Filter, len(cfg.PGNs))
+		for i, pgn := range cfg.PGNs {
+			flt[i].Pgn = uint32(pgn)
+			flt[i].Pgn_mask = linux.J1939_PGN_MAX
+		}
+		b := unsafe.Slice((*byte)(unsafe.Pointer(&flt[0])), len(flt)*int(unsafe.Sizeof(flt[0])))
+		err = unix.SetsockoptString(fd, linux.SOL_CAN_J1939, linux.SO_J1939_FILTER, string(b))
+		if err != nil {
+			return err
+		}
+	}
+	ifindex, err := ifIndex(fd, ifName)
+	if err != nil {
+		return err
+	}
+	return unix.Bind(fd, &unix.SockaddrCANJ1939{
+		Ifindex: ifindex,
+		Name:    uint64(cfg.Name),
+		PGN:     linux.J1939_NO_PGN,
+		Addr:    cfg.Addr,
+	})
+}
+
+// claimTime is the time a node waits for contending
+// claims before it may use an address.
+const claimTime = 250 * time.Millisecond
+
+var ErrJ1939NoName = errors.New("socketcan: j1939: no name configured")
+
+// Claim sends an address claimed message for the configured address,
+// and waits until the address may be used. Contending claims of other
+// nodes are resolved by the kernel.
+func (c *J1939Conn) Claim() error {
+	if c.name == 0 {
+		return ErrJ1939NoName
+	}
+	err := c.Send(&j1939.Message{
+		ID:   j1939.ID{Priority: j1939.DefaultPriority, PGN: j1939.PGNAddressClaimed, Dst: j1939.GlobalAddr},
+		Data: c.name.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+	time.Sleep(claimTime)
+	return nil
+}
+
+// Send transmits a message. Messages longer than eight bytes
+// are sent using the transport protocol, handled by the kernel.
+// The source address is the node's address; m.Src is ignored.
+// Priorities 0 and 1 may only be used with CAP_NET_ADMIN.
+func (c *J1939Conn) Send(m *j1939.Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if prio := int(m.Priority & 7); prio != c.prio {
+		var err error
+		c.rc.Control(func(fd uintptr) {
+			err = unix.SetsockoptInt(int(fd), linux.SOL_CAN_J1939, linux.SO_J1939_SEND_PRIO, prio)
+		})
+		if err != nil {
+			return wrapErr("write", err)
+		}
+		c.prio = prio
+	}
+	dst := m.Dst
+	if !m.PGN.PDU1() {
+		dst = linux.J1939_NO_ADDR
+	}
+	sa := &unix.SockaddrCANJ1939{
+		Name: linux.J1939_NO_NAME,
+		PGN:  uint32(m.PGN),
+		Addr: dst,
+	}
+	var err error
+	err1 := c.rc.Write(func(fd uintptr) bool {
+		err = unix.Sendto(int(fd), m.Data, 0, sa)
+		return err != unix.EAGAIN
+	})
+	if err1 != nil {
+		err = err1
+	}
+	if err != nil {
+		return wrapErr("write", err)
+	}
+	return nil
+}
+
+// Receive returns the next message addressed to the node, or
+// broadcast. Messages sent using the transport protocols are
+// reassembled by the kernel.
+func (c *J1939Conn) Receive() (*j1939.Message, error) {
+	// destination address, destination name, and priority
+	oob := make([]byte, 2*unix.CmsgSpace(1)+unix.CmsgSpace(8))
+	var m *j1939.Message
+	var err error
+	err1 := c.rc.Read(func(fd uintptr) bool {
+		var n, oobn int
+		var from unix.Sockaddr
+		n, _, err = unix.Recvfrom(int(fd), nil, unix.MSG_PEEK|unix.MSG_TRUNC)
+		if err == nil {
+			data := make([]byte, n)
+			n, oobn, _, from, err = unix.Recvmsg(int(fd), data, oob, 0)
+			if err == nil {
+				m, err = decodeJ1939(data[:n], oob[:oobn], from)
+			}
+		}
+		return err != unix.EAGAIN
+	})
+	if err1 != nil {
+		return nil, wrapErr("read", err1)
+	}
+	if err != nil {
+		return nil, wrapErr("read", err)
+	}
+	return m, nil
+}
+
+func decodeJ1939(data, oob []byte, from unix.Sockaddr) (*j1939.Message, error) {
+	sa, ok := from.(*unix.SockaddrCANJ1939)
+	if !ok {
+		return nil, errors.New("unexpected source address")
+	}
+	m := &j1939.Message{Data: data, Time: can.Now()}
+	m.PGN = j1939.PGN(sa.PGN)
+	m.Src = sa.Addr
+	m.Dst = j1939.GlobalAddr
+	m.Priority = j1939.DefaultPriority
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for _, cm := range cmsgs {
+		if cm.Header.Level != linux.SOL_CAN_J1939 || len(cm.Data) == 0 {
+			continue
+		}
+		switch cm.Header.Type {
+		case linux.SCM_J1939_DEST_ADDR:
+			m.Dst = cm.Data[0]
+		case linux.SCM_J1939_PRIO:
+			m.Priority = cm.Data[0]
+		}
+	}
+	return m, nil
+}
+
+// SetReadDeadline sets the deadline for subsequent Receive calls.
+// A zero value disables the deadline.
+func (c *J1939Conn) SetReadDeadline(t time.Time) error {
+	return c.file.SetReadDeadline(t)
+}
+
+func (c *J1939Conn) Close() error {
+	err := c.file.Close()
+	if err != nil {
+		return wrapErr("close", err)
+	}
+	return nil
+}
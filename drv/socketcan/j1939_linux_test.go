@@ -0,0 +1,80 @@
+package socketcan
+
+import (
+	"bytes"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+	"github.com/knieriem/can/j1939"
+)
+
+func j1939Cmsg(typ int, data ...byte) []byte {
+	b := make([]byte, unix.CmsgSpace(len(data)))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = linux.SOL_CAN_J1939
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(len(data)))
+	copy(b[unix.CmsgLen(0):], data)
+	return b
+}
+
+func TestDecodeJ1939(t *testing.T) {
+	data := []byte{1, 2, 3}
+	from := &unix.SockaddrCANJ1939{PGN: 0xEF00, Addr: 0x21}
+	oob := append(j1939Cmsg(linux.SCM_J1939_DEST_ADDR, 0x80), j1939Cmsg(linux.SCM_J1939_PRIO, 3)...)
+	m, err := decodeJ1939(data, oob, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := j1939.ID{Priority: 3, PGN: 0xEF00, Src: 0x21, Dst: 0x80}
+	if m.ID != want || !bytes.Equal(m.Data, data) {
+		t.Errorf("got %v % x, want %v", m.ID, m.Data, want)
+	}
+
+	m, err = decodeJ1939(data, nil, &unix.SockaddrCANJ1939{PGN: 0xFECA, Addr: 0x21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Dst != j1939.GlobalAddr || m.Priority != j1939.DefaultPriority {
+		t.Errorf("got %v", m.ID)
+	}
+}
+
+// TestJ1939 needs a vcan interface named vcan0,
+// and the can-j1939 kernel module.
+func TestJ1939(t *testing.T) {
+	a, err := OpenJ1939("vcan0", &J1939Config{Addr: 0x20})
+	if err != nil {
+		t.Skip(err)
+	}
+	defer a.Close()
+	b, err := OpenJ1939("vcan0", &J1939Config{Addr: 0x30, PGNs: []j1939.PGN{0xEF00}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	data := bytes.Repeat([]byte{0x55}, 100)
+	for _, pgn := range []j1939.PGN{0xEE00, 0xEF00} {
+		err := a.Send(&j1939.Message{
+			ID:   j1939.ID{Priority: 6, PGN: pgn, Dst: 0x30},
+			Data: data,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.SetReadDeadline(time.Now().Add(2 * time.Second))
+	m, err := b.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := j1939.ID{Priority: 6, PGN: 0xEF00, Src: 0x20, Dst: 0x30}
+	if m.ID != want || !bytes.Equal(m.Data, data) {
+		t.Errorf("got %v % x", m.ID, m.Data)
+	}
+}
@@ -0,0 +1,82 @@
+package socketcan
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// maxBatch is the maximum number of frames
+// transferred by a single recvmmsg or sendmmsg call.
+const maxBatch = 64
+
+// mmsghdr corresponds to struct mmsghdr.
+type mmsghdr struct {
+	hdr unix.Msghdr
+	n   uint32
+}
+
+// mmsgBuf holds the frames, and message headers
+// of a batch transferred using recvmmsg or sendmmsg.
+type mmsgBuf struct {
+	frames  []frame
+	iov     []unix.Iovec
+	hdrs    []mmsghdr
+	oob     []byte
+	oobSize int
+	idx     []int // index of the can.Msg corresponding to a frame
+}
+
+func newMmsgBuf(n, oobSize int) *mmsgBuf {
+	b := &mmsgBuf{
+		frames:  make([]frame, n),
+		iov:     make([]unix.Iovec, n),
+		hdrs:    make([]mmsghdr, n),
+		oob:     make([]byte, n*oobSize),
+		oobSize: oobSize,
+		idx:     make([]int, n),
+	}
+	for i := range b.hdrs {
+		b.iov[i].Base = &b.frames[i].b[0]
+		h := &b.hdrs[i].hdr
+		h.Iov = &b.iov[i]
+		h.SetIovlen(1)
+	}
+	return b
+}
+
+// prepare sets the frame lengths of the first n messages to size, and,
+// for reception, resets the control message buffers, which are
+// modified by the kernel.
+func (b *mmsgBuf) prepare(n, size int) {
+	for i := range n {
+		b.iov[i].SetLen(size)
+		h := &b.hdrs[i].hdr
+		if b.oobSize != 0 {
+			h.Control = &b.oob[i*b.oobSize]
+			h.SetControllen(b.oobSize)
+		}
+		h.Flags = 0
+	}
+}
+
+// control returns the control messages received with the i-th frame.
+func (b *mmsgBuf) control(i int) []byte {
+	return b.oob[i*b.oobSize:][:b.hdrs[i].hdr.Controllen]
+}
+
+func recvmmsg(fd uintptr, hdrs []mmsghdr, flags int) (int, error) {
+	n, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), uintptr(flags), 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
+
+func sendmmsg(fd uintptr, hdrs []mmsghdr, flags int) (int, error) {
+	n, _, e := unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&hdrs[0])), uintptr(len(hdrs)), uintptr(flags), 0, 0)
+	if e != 0 {
+		return 0, e
+	}
+	return int(n), nil
+}
@@ -0,0 +1,149 @@
+package socketcan
+
+import (
+	"fmt"
+	"math/bits"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv/socketcan/internal/linux"
+)
+
+func TestMmsgBuf(t *testing.T) {
+	if size := unsafe.Sizeof(mmsghdr{}); bits.UintSize == 64 && size != 64 || bits.UintSize == 32 && size != 32 {
+		t.Fatalf("unexpected size of struct mmsghdr: %d", size)
+	}
+	b := newMmsgBuf(4, 32)
+	b.prepare(2, 72)
+	for i := range 2 {
+		h := &b.hdrs[i].hdr
+		if b.iov[i].Len != 72 || h.Controllen != 32 || h.Control != &b.oob[i*32] {
+			t.Errorf("%d: unexpected header: %+v", i, h)
+		}
+	}
+	if b.hdrs[2].hdr.Controllen != 0 {
+		t.Error("header not expected to be prepared")
+	}
+	b.hdrs[1].hdr.Controllen = 20
+	if n := len(b.control(1)); n != 20 {
+		t.Errorf("control: got %d bytes", n)
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer unix.Close(fds[1])
+	file, err := pollableFile(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	d := &dev{file: file, mtu: linux.CANFD_MTU, recvBatch: newMmsgBuf(maxBatch, 0)}
+	d.rc, err = file.(syscall.Conn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(expr string) {
+		var m can.Msg
+		m.FromExpr(expr)
+		var f frame
+		nw, err := f.encode(&m, linux.CAN_MTU)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unix.Write(fds[1], f.b[:nw])
+	}
+	send("123#01")
+	unix.Write(fds[1], []byte{1, 2, 3, 4}) // short frame
+	send("124#02")
+
+	buf := make([]can.Msg, 4)
+	n, err := d.Read(buf)
+	if n != 2 || err != nil {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if buf[0].Id != 0x123 || buf[1].Id != 0x124 {
+		t.Errorf("unexpected messages: %v, %v", &buf[0], &buf[1])
+	}
+	if n, err = d.Read(buf); n != 0 || err == nil {
+		t.Errorf("expected the error of the short frame, got %d, %v", n, err)
+	}
+}
+
+// BenchmarkReadWrite compares transferring frames one at a time,
+// using plain read and write system calls, with batched transfers.
+// It needs a vcan interface named vcan0.
+func BenchmarkReadWrite(b *testing.B) {
+	tx, err := Driver.Open(nil, "vcan0", nil)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer tx.Close()
+	rx, err := Driver.Open(nil, "vcan0", nil)
+	if err != nil {
+		b.Skip(err)
+	}
+	defer rx.Close()
+
+	msgs := make([]can.Msg, maxBatch)
+	for i := range msgs {
+		msgs[i].FromExpr(fmt.Sprintf("%03X##1%0128X", i, i))
+	}
+	buf := make([]can.Msg, maxBatch)
+	for i := range buf {
+		pd := make(can.PlainData, 0, 64)
+		buf[i].Attach(&pd)
+	}
+	b.Run("single", func(b *testing.B) {
+		for b.Loop() {
+			for i := range msgs {
+				if err := tx.WriteMsg(&msgs[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			for range msgs {
+				if err := readSingle(rx.(*dev), &buf[0]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(msgs)), "ns/frame")
+	})
+	b.Run("batch", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := tx.Write(msgs); err != nil {
+				b.Fatal(err)
+			}
+			for n := 0; n < len(msgs); {
+				nr, err := rx.Read(buf)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n += nr
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(msgs)), "ns/frame")
+	})
+}
+
+// readSingle receives a frame using a plain read system call,
+// as the driver did before batched transfers were implemented.
+func readSingle(d *dev, m *can.Msg) error {
+	var f frame
+	n, err := d.file.Read(f.b[:d.mtu])
+	if err != nil {
+		return err
+	}
+	if err := f.checkLen(n); err != nil {
+		return err
+	}
+	return f.decode(m, d.bufPool)
+}
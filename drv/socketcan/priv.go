@@ -14,6 +14,7 @@ import (
 type privilegedAccess interface {
 	UpDown(bool) error
 	SetConfig(*can.Config) error
+	Restart() error
 	Close() error
 }
 
@@ -80,6 +81,11 @@ func (util *privilegedUtil) SetConfig(c *can.Config) error {
 	return err
 }
 
+func (util *privilegedUtil) Restart() error {
+	_, err := util.cl.Call(util.intfName, "restart")
+	return err
+}
+
 func (util *privilegedUtil) Close() error {
 	err := util.closer.Close()
 	err1 := util.cmd.Wait()
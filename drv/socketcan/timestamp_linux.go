@@ -0,0 +1,110 @@
+package socketcan
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+)
+
+// maxHWClockSkew is the maximum deviation of a hardware timestamp,
+// mapped to system time, from the corresponding software timestamp.
+// If it is exceeded, e.g. because the adapter's clock has been reset,
+// the hardware clock is re-synchronized.
+const maxHWClockSkew = 1e6 // µs
+
+// setupTimestamps enables reception timestamps on a socket. Hardware
+// timestamps are requested too, they are delivered if the interface's
+// driver provides them. If SO_TIMESTAMPING is not available,
+// SO_TIMESTAMPNS is tried. The size of the control message buffer
+// needed to receive the timestamps is returned.
+func setupTimestamps(fd int) (oobSize int, err error) {
+	flags := unix.SOF_TIMESTAMPING_SOFTWARE |
+		unix.SOF_TIMESTAMPING_RX_SOFTWARE |
+		unix.SOF_TIMESTAMPING_RAW_HARDWARE |
+		unix.SOF_TIMESTAMPING_RX_HARDWARE
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags)
+	if err == nil {
+		// struct scm_timestamping
+		return unix.CmsgSpace(3 * timespecSize), nil
+	}
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TIMESTAMPNS, 1)
+	if err != nil {
+		return 0, err
+	}
+	return unix.CmsgSpace(timespecSize), nil
+}
+
+const timespecSize = int(2 * longSize)
+
+// rxTimestamps contains the timestamps of a received frame, as found
+// in the control messages; fields are zero if not available.
+type rxTimestamps struct {
+	sw can.Time
+	hw can.Time
+}
+
+func parseTimestamps(ts *rxTimestamps, oob []byte) error {
+	*ts = rxTimestamps{}
+	cmsgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return err
+	}
+	for _, cm := range cmsgs {
+		if cm.Header.Level != unix.SOL_SOCKET {
+			continue
+		}
+		switch cm.Header.Type {
+		case unix.SCM_TIMESTAMPNS:
+			ts.sw = timespecTime(cm.Data)
+		case unix.SCM_TIMESTAMPING:
+			// ts[0]: software, ts[1]: deprecated, ts[2]: raw hardware
+			ts.sw = timespecTime(cm.Data)
+			if len(cm.Data) >= 3*timespecSize {
+				ts.hw = timespecTime(cm.Data[2*timespecSize:])
+			}
+		}
+	}
+	return nil
+}
+
+func timespecTime(b []byte) can.Time {
+	var sec, nsec int64
+	switch {
+	case len(b) < timespecSize:
+		return 0
+	case longSize == 8:
+		sec = int64(native.Uint64(b))
+		nsec = int64(native.Uint64(b[8:]))
+	default:
+		sec = int64(int32(native.Uint32(b)))
+		nsec = int64(int32(native.Uint32(b[4:])))
+	}
+	return can.Time(sec*1e6 + nsec/1e3)
+}
+
+// rxClock maps the timestamps of received frames to system time.
+type rxClock struct {
+	t0    can.Time
+	t0val can.Time
+}
+
+// time returns the reception time of a frame. A hardware timestamp is
+// preferred; since the adapter's clock may not be synchronized with the
+// system clock, it is used relative to the hardware timestamp of the
+// first frame, which is aligned with that frame's software timestamp.
+func (c *rxClock) time(ts *rxTimestamps) can.Time {
+	ref := ts.sw
+	if ref == 0 {
+		ref = can.Now()
+	}
+	if ts.hw == 0 {
+		return ref
+	}
+	t := c.t0 + ts.hw - c.t0val
+	if c.t0 == 0 || t-ref > maxHWClockSkew || ref-t > maxHWClockSkew {
+		c.t0 = ref
+		c.t0val = ts.hw
+		t = ref
+	}
+	return t
+}
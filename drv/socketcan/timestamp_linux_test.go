@@ -0,0 +1,77 @@
+package socketcan
+
+import (
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/knieriem/can"
+)
+
+func putTimespec(b []byte, t can.Time) {
+	sec, nsec := uint64(t/1e6), uint64(t%1e6*1e3)
+	if longSize == 8 {
+		native.PutUint64(b, sec)
+		native.PutUint64(b[8:], nsec)
+		return
+	}
+	native.PutUint32(b, uint32(sec))
+	native.PutUint32(b[4:], uint32(nsec))
+}
+
+func cmsg(typ int, ts ...can.Time) []byte {
+	n := len(ts) * timespecSize
+	b := make([]byte, unix.CmsgSpace(n))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(n))
+	for i, t := range ts {
+		putTimespec(b[unix.CmsgLen(0)+i*timespecSize:], t)
+	}
+	return b
+}
+
+func TestTimestamps(t *testing.T) {
+	const (
+		sw = 1700000000123456
+		hw = 3600000000
+	)
+	for _, tc := range []struct {
+		oob []byte
+		ts  rxTimestamps
+	}{
+		{nil, rxTimestamps{}},
+		{cmsg(unix.SCM_TIMESTAMPNS, sw), rxTimestamps{sw: sw}},
+		{cmsg(unix.SCM_TIMESTAMPING, sw, 0, 0), rxTimestamps{sw: sw}},
+		{cmsg(unix.SCM_TIMESTAMPING, sw, 0, 5000), rxTimestamps{sw: sw, hw: 5000}},
+	} {
+		var ts rxTimestamps
+		if err := parseTimestamps(&ts, tc.oob); err != nil {
+			t.Fatal(err)
+		}
+		if ts != tc.ts {
+			t.Errorf("got %+v, want %+v", ts, tc.ts)
+		}
+	}
+
+	var c rxClock
+	for i, tc := range []struct {
+		ts   rxTimestamps
+		want can.Time
+	}{
+		{rxTimestamps{sw: sw}, sw},
+		{rxTimestamps{sw: sw + 100, hw: hw}, sw + 100},
+		{rxTimestamps{sw: sw + 300, hw: hw + 150}, sw + 250},
+		{rxTimestamps{sw: sw + 200, hw: hw + 100}, sw + 200},
+
+		// hardware clock reset
+		{rxTimestamps{sw: sw + 1000, hw: 10}, sw + 1000},
+		{rxTimestamps{sw: sw + 1500, hw: 500}, sw + 1490},
+	} {
+		if got := c.time(&tc.ts); got != tc.want {
+			t.Errorf("%d: got %d, want %d", i, got, tc.want)
+		}
+	}
+}
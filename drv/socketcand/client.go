@@ -0,0 +1,214 @@
+package socketcand
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv"
+)
+
+func init() {
+	can.RegisterDriver(new(driver))
+}
+
+type driver struct{}
+
+func (*driver) Name() string {
+	return "socketcand"
+}
+
+func (*driver) Scan() []can.DeviceInfo {
+	return nil
+}
+
+// Open connects to the server and opens the bus specified by name,
+// which has the form host[:port]/bus.
+func (*driver) Open(_ *can.Env, name string, conf *can.Config) (can.Device, error) {
+	addr, bus, ok := strings.Cut(name, "/")
+	if !ok || bus == "" {
+		return nil, errors.New("socketcand: missing bus name")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultPort)
+	}
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	d, err := NewDevice(nc, bus, conf)
+	if err != nil {
+		return nil, err
+	}
+	d.(*dev).info.ID = name
+	return d, nil
+}
+
+// NewDevice opens the specified bus on the socketcand server connected
+// through nc, and switches to raw mode. The connection is closed when
+// the device is closed, or if NewDevice fails. Message filters of conf,
+// which may be nil, are applied in software.
+func NewDevice(nc net.Conn, bus string, conf *can.Config) (can.Device, error) {
+	d := new(dev)
+	d.nc = nc
+	d.r = bufio.NewReader(nc)
+	d.info = can.DeviceInfo{
+		ID:     nc.RemoteAddr().String() + "/" + bus,
+		Device: bus,
+		Driver: "socketcand",
+		Model:  "socketcand bus",
+	}
+	if conf != nil {
+		if err := conf.ResolveCtrlModes(drv.NoCtrlModes); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("socketcand: %w", err)
+		}
+		d.filters = slices.Clone(conf.MsgFilter)
+	}
+	err := d.expect("hi")
+	if err == nil {
+		err = d.command("open "+bus, "ok")
+	}
+	if err == nil {
+		err = d.command("rawmode", "ok")
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+type dev struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	info    can.DeviceInfo
+	filters []can.MsgFilter
+
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	closing bool
+}
+
+// expect reads the next element, which must consist of the word w.
+// An error element is returned as error.
+func (d *dev) expect(w string) error {
+	f, err := readElement(d.r)
+	if err != nil {
+		return err
+	}
+	if len(f) == 1 && f[0] == w {
+		return nil
+	}
+	if len(f) > 0 && f[0] == "error" {
+		return fmt.Errorf("socketcand: %s", strings.Join(f[1:], " "))
+	}
+	return fmt.Errorf("%w: unexpected response: %q", ErrProtocol, f)
+}
+
+func (d *dev) command(cmd, resp string) error {
+	if err := d.write([]byte("< " + cmd + " >")); err != nil {
+		return err
+	}
+	return d.expect(resp)
+}
+
+func (d *dev) write(b []byte) error {
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	_, err := d.nc.Write(b)
+	return err
+}
+
+func (d *dev) ID() string {
+	return "socketcand:" + d.info.ID
+}
+
+func (d *dev) Info() *can.DeviceInfo {
+	return &d.info
+}
+
+// Read blocks until at least one frame has been received, and
+// returns as many messages as are available without blocking.
+// Elements other than frames are skipped.
+func (d *dev) Read(buf []can.Msg) (n int, err error) {
+	for n < len(buf) {
+		if n > 0 && d.r.Buffered() == 0 {
+			break
+		}
+		f, err := readElement(d.r)
+		if err != nil {
+			if d.isClosed() {
+				err = io.EOF
+			}
+			if n > 0 && err == io.EOF {
+				break
+			}
+			return n, err
+		}
+		if len(f) == 0 || f[0] != "frame" {
+			continue
+		}
+		m := &buf[n]
+		if err := parseFrame(m, f[1:]); err != nil {
+			return n, err
+		}
+		if drv.AcceptMsg(d.filters, m) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (d *dev) WriteMsg(m *can.Msg) error {
+	if m.IsStatus() {
+		return nil
+	}
+	if err := verifyMsg(m); err != nil {
+		return err
+	}
+	return d.write(appendSend(nil, m))
+}
+
+// Write sends the messages using a single write call.
+func (d *dev) Write(msgs []can.Msg) (n int, err error) {
+	var b []byte
+	for i := range msgs {
+		m := &msgs[i]
+		if m.IsStatus() {
+			continue
+		}
+		if err = verifyMsg(m); err != nil {
+			msgs = msgs[:i]
+			break
+		}
+		b = appendSend(b, m)
+	}
+	if err1 := d.write(b); err1 != nil {
+		return 0, err1
+	}
+	return len(msgs), err
+}
+
+func (d *dev) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+func (d *dev) Close() error {
+	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closing = true
+	d.mu.Unlock()
+	return d.nc.Close()
+}
@@ -0,0 +1,464 @@
+package socketcand
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+// number of elements that may be queued for a client
+const clientQueueLen = 256
+
+// A Server exports can.Device instances to socketcand clients.
+// Multiple clients may open the same bus at the same time.
+type Server struct {
+	mu    sync.Mutex
+	buses map[string]*bus
+}
+
+// bus is a registered device.
+type bus struct {
+	dev can.Device
+	wmu sync.Mutex // serializes writes to dev
+
+	mu       sync.Mutex
+	sessions map[*session]struct{}
+	err      error
+}
+
+// NewServer returns a server without any buses registered.
+func NewServer() *Server {
+	return &Server{buses: make(map[string]*bus)}
+}
+
+// Register exports dev under the specified bus name, and starts
+// reading messages from dev. Messages are dropped while no client
+// has opened the bus. Clients are disconnected when reading from
+// the device fails, e.g. after it has been closed.
+func (s *Server) Register(name string, dev can.Device) error {
+	if name == "" || strings.ContainsAny(name, " <>") {
+		return errors.New("socketcand: invalid bus name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buses[name]; ok {
+		return errors.New("socketcand: bus already registered: " + name)
+	}
+	b := &bus{dev: dev, sessions: make(map[*session]struct{})}
+	s.buses[name] = b
+	go b.readLoop()
+	return nil
+}
+
+func (b *bus) readLoop() {
+	buf := make([]can.Msg, 32)
+	for i := range buf {
+		pd := make(can.PlainData, 0, 64)
+		buf[i].Attach(&pd)
+	}
+	for {
+		n, err := b.dev.Read(buf)
+		b.mu.Lock()
+		if err != nil {
+			b.err = err
+			for c := range b.sessions {
+				c.nc.Close()
+			}
+			b.mu.Unlock()
+			return
+		}
+		for c := range b.sessions {
+			c.deliver(buf[:n])
+		}
+		b.mu.Unlock()
+	}
+}
+
+func (b *bus) writeMsg(m *can.Msg) error {
+	b.wmu.Lock()
+	defer b.wmu.Unlock()
+	return b.dev.WriteMsg(m)
+}
+
+// Serve accepts connections on l, and serves each of them
+// in a separate goroutine using ServeConn. It returns the error
+// returned by l's Accept method.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(nc)
+	}
+}
+
+// ServeConn serves a single client connected through nc, until the
+// connection fails, or the bus opened by the client cannot be read
+// anymore. It closes nc before returning. A connection closed by the
+// client is not reported as an error.
+func (s *Server) ServeConn(nc net.Conn) error {
+	c := &session{
+		nc:   nc,
+		out:  make(chan []byte, clientQueueLen),
+		quit: make(chan struct{}),
+		subs: make(map[key]*subscription),
+		jobs: make(map[key]*job),
+	}
+	defer nc.Close()
+	go c.writeLoop()
+	defer close(c.quit)
+
+	err := c.run(s)
+	if c.bus != nil {
+		b := c.bus
+		b.mu.Lock()
+		delete(b.sessions, c)
+		if b.err != nil {
+			err = b.err
+		}
+		b.mu.Unlock()
+	}
+	c.mu.Lock()
+	for _, j := range c.jobs {
+		close(j.stop)
+	}
+	c.mu.Unlock()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// session holds the state of a client connection.
+type session struct {
+	nc   net.Conn
+	out  chan []byte
+	quit chan struct{}
+	bus  *bus
+
+	mu   sync.Mutex
+	raw  bool
+	subs map[key]*subscription
+	jobs map[key]*job
+}
+
+type key struct {
+	id  uint32
+	ext bool
+}
+
+func msgKey(m *can.Msg) key {
+	return key{id: m.Id, ext: m.ExtFrame()}
+}
+
+type subscription struct {
+	interval time.Duration
+	mask     []byte // content filter, if not nil
+	last     time.Time
+	data     []byte // data of the last frame delivered
+	valid    bool
+}
+
+type job struct {
+	stop chan struct{}
+
+	mu  sync.Mutex
+	msg can.Msg
+}
+
+func (c *session) run(s *Server) error {
+	c.send("< hi >")
+	r := bufio.NewReader(c.nc)
+	for {
+		f, err := readElement(r)
+		if err != nil {
+			return err
+		}
+		if len(f) == 0 {
+			c.sendError("empty command")
+			continue
+		}
+		if c.bus == nil {
+			if f[0] != "open" || len(f) != 2 {
+				c.sendError("bus not open")
+				continue
+			}
+			s.mu.Lock()
+			b := s.buses[f[1]]
+			s.mu.Unlock()
+			if b == nil {
+				c.sendError("no such bus: " + f[1])
+				continue
+			}
+			b.mu.Lock()
+			err := b.err
+			if err == nil {
+				b.sessions[c] = struct{}{}
+				c.bus = b
+			}
+			b.mu.Unlock()
+			if err != nil {
+				c.sendError("bus not available")
+				return err
+			}
+			c.send("< ok >")
+			continue
+		}
+		if err := c.command(f[0], f[1:]); err != nil {
+			c.sendError(err.Error())
+		}
+	}
+}
+
+var errUnknownCmd = errors.New("unknown command")
+
+func (c *session) command(cmd string, args []string) error {
+	switch cmd {
+	case "rawmode", "bcmmode":
+		c.mu.Lock()
+		c.raw = cmd == "rawmode"
+		c.mu.Unlock()
+		c.send("< ok >")
+		return nil
+	case "controlmode", "isotpmode":
+		return errors.New("mode not supported")
+	case "echo":
+		c.send("< echo >")
+		return nil
+	case "send":
+		m, err := parseMsg(args)
+		if err != nil {
+			return err
+		}
+		return c.bus.writeMsg(m)
+	}
+	c.mu.Lock()
+	raw := c.raw
+	c.mu.Unlock()
+	if raw {
+		return errUnknownCmd
+	}
+
+	switch cmd {
+	case "subscribe", "filter":
+		if len(args) < 3 {
+			return ErrProtocol
+		}
+		ival, err := parseInterval(args[:2])
+		if err != nil {
+			return err
+		}
+		id, ext, err := parseID(args[2])
+		if err != nil {
+			return err
+		}
+		sub := &subscription{interval: ival}
+		if cmd == "filter" {
+			if sub.mask, err = parseData(args[3:]); err != nil {
+				return err
+			}
+		} else if len(args) != 3 {
+			return ErrProtocol
+		}
+		c.mu.Lock()
+		c.subs[key{id, ext}] = sub
+		c.mu.Unlock()
+
+	case "unsubscribe", "delete":
+		if len(args) != 1 {
+			return ErrProtocol
+		}
+		id, ext, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		k := key{id, ext}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if cmd == "unsubscribe" {
+			delete(c.subs, k)
+			return nil
+		}
+		if j, ok := c.jobs[k]; ok {
+			close(j.stop)
+			delete(c.jobs, k)
+		}
+
+	case "add":
+		if len(args) < 3 {
+			return ErrProtocol
+		}
+		ival, err := parseInterval(args[:2])
+		if err != nil {
+			return err
+		}
+		if ival <= 0 {
+			return errors.New("invalid interval")
+		}
+		m, err := parseMsg(args[2:])
+		if err != nil {
+			return err
+		}
+		j := &job{stop: make(chan struct{}), msg: *m}
+		k := msgKey(m)
+		c.mu.Lock()
+		if old, ok := c.jobs[k]; ok {
+			close(old.stop)
+		}
+		c.jobs[k] = j
+		c.mu.Unlock()
+		go c.runJob(j, ival)
+
+	case "update":
+		m, err := parseMsg(args)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		j := c.jobs[msgKey(m)]
+		c.mu.Unlock()
+		if j == nil {
+			return errors.New("no such job")
+		}
+		j.mu.Lock()
+		j.msg = *m
+		j.mu.Unlock()
+
+	default:
+		return errUnknownCmd
+	}
+	return nil
+}
+
+// runJob transmits a job's message periodically, starting immediately.
+func (c *session) runJob(j *job, ival time.Duration) {
+	t := time.NewTicker(ival)
+	defer t.Stop()
+	for {
+		j.mu.Lock()
+		m := j.msg
+		j.mu.Unlock()
+		c.bus.writeMsg(&m)
+		select {
+		case <-t.C:
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+func parseMsg(args []string) (*can.Msg, error) {
+	if len(args) < 1 {
+		return nil, ErrProtocol
+	}
+	id, ext, err := parseID(args[0])
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseData(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	m := new(can.Msg)
+	m.Id = id
+	if ext {
+		m.Flags |= can.ExtFrame
+	}
+	m.SetData(data)
+	return m, nil
+}
+
+func parseInterval(args []string) (time.Duration, error) {
+	sec, err1 := strconv.ParseUint(args[0], 10, 32)
+	usec, err2 := strconv.ParseUint(args[1], 10, 32)
+	if err1 != nil || err2 != nil {
+		return 0, ErrProtocol
+	}
+	return time.Duration(sec)*time.Second + time.Duration(usec)*time.Microsecond, nil
+}
+
+// deliver queues the messages the client is interested in.
+// It is called with the bus locked.
+func (c *session) deliver(msgs []can.Msg) {
+	var b []byte
+	now := time.Now()
+	c.mu.Lock()
+	for i := range msgs {
+		m := &msgs[i]
+		if m.IsStatus() || len(m.Data()) > 8 || m.Test(can.RTRMsg) {
+			continue
+		}
+		if !c.raw && !c.subscribed(m, now) {
+			continue
+		}
+		b = appendFrame(b, m)
+	}
+	c.mu.Unlock()
+	if b == nil {
+		return
+	}
+	select {
+	case c.out <- b:
+	default:
+		// the client is not able to keep up
+	}
+}
+
+// subscribed reports whether a message matches a subscription,
+// and updates the state of the subscription.
+func (c *session) subscribed(m *can.Msg, now time.Time) bool {
+	sub := c.subs[msgKey(m)]
+	if sub == nil {
+		return false
+	}
+	data := m.Data()
+	if sub.mask != nil && sub.valid {
+		changed := len(data) != len(sub.data)
+		for i := 0; !changed && i < len(data) && i < len(sub.mask); i++ {
+			changed = (data[i]^sub.data[i])&sub.mask[i] != 0
+		}
+		if !changed {
+			return false
+		}
+	}
+	if sub.interval > 0 && sub.valid && now.Sub(sub.last) < sub.interval {
+		return false
+	}
+	sub.valid = true
+	sub.last = now
+	sub.data = append(sub.data[:0], data...)
+	return true
+}
+
+func (c *session) send(s string) {
+	select {
+	case c.out <- []byte(s):
+	case <-c.quit:
+	}
+}
+
+func (c *session) sendError(msg string) {
+	c.send("< error " + strings.TrimPrefix(msg, "socketcand: ") + " >")
+}
+
+func (c *session) writeLoop() {
+	for {
+		select {
+		case b := <-c.out:
+			if _, err := c.nc.Write(b); err != nil {
+				c.nc.Close()
+				return
+			}
+		case <-c.quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Package socketcand implements the ASCII protocol of socketcand,
+// a daemon providing access to CAN interfaces via TCP, both as a
+// driver, and as a server exporting can.Device instances.
+//
+// The package registers a driver named "socketcand" with the can
+// package. The device name consists of the address of the server,
+// and the name of the bus, separated by a slash:
+//
+//	socketcand:192.168.1.2:29536/can0
+//
+// If the port is omitted, the default port 29536 is used. The driver
+// operates the connection in raw mode, i.e. all frames received on
+// the bus are delivered, and timestamped by the server.
+//
+// The Server supports raw mode, as well as broadcast manager (BCM)
+// mode, which is the initial mode after a bus has been opened. In BCM
+// mode, a client receives only frames it has subscribed to, and may
+// set up cyclic transmissions:
+//
+//	< subscribe sec usec id >         frames with identifier id, at most once per interval
+//	< filter sec usec id dlc mask.. > frames whose masked data has changed
+//	< unsubscribe id >
+//	< add sec usec id dlc data.. >    transmit a frame periodically
+//	< update id dlc data.. >
+//	< delete id >
+//	< send id dlc data.. >            transmit a frame once
+//	< echo >
+//
+// Identifiers consisting of more than three hex digits denote extended
+// frames. Control and ISO-TP modes are not supported, neither are
+// CAN FD frames, which socketcand does not transport.
+package socketcand
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/knieriem/can"
+)
+
+// DefaultPort is the TCP port socketcand listens on by default.
+const DefaultPort = "29536"
+
+var (
+	ErrProtocol = errors.New("socketcand: protocol error")
+	ErrFD       = errors.New("socketcand: FD frames not supported")
+)
+
+// readElement reads the next element enclosed in '<' and '>',
+// and returns its fields.
+func readElement(r *bufio.Reader) ([]string, error) {
+	for {
+		_, err := r.ReadSlice('<')
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+	s, err := r.ReadString('>')
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(s[:len(s)-1]), nil
+}
+
+// appendFrame appends a frame element, as sent
+// to clients in raw mode, or on BCM subscriptions.
+func appendFrame(b []byte, m *can.Msg) []byte {
+	b = append(b, "< frame "...)
+	b = appendID(b, m)
+	s, µs := m.Rx.Time/1e6, m.Rx.Time%1e6
+	b = fmt.Appendf(b, " %d.%06d ", s, µs)
+	b = fmt.Appendf(b, "%X", m.Data())
+	return append(b, " >"...)
+}
+
+// appendSend appends a send command.
+func appendSend(b []byte, m *can.Msg) []byte {
+	b = append(b, "< send "...)
+	b = appendID(b, m)
+	b = appendData(b, m.Data())
+	return append(b, " >"...)
+}
+
+func appendID(b []byte, m *can.Msg) []byte {
+	if m.ExtFrame() {
+		return fmt.Appendf(b, "%08X", m.Id&0x1FFFFFFF)
+	}
+	return fmt.Appendf(b, "%03X", m.Id&0x7FF)
+}
+
+// appendData appends the length, and the data bytes
+// separated by spaces.
+func appendData(b []byte, data []byte) []byte {
+	b = fmt.Appendf(b, " %d", len(data))
+	for _, c := range data {
+		b = fmt.Appendf(b, " %02X", c)
+	}
+	return b
+}
+
+// parseID parses an identifier; more than
+// three digits denote an extended frame.
+func parseID(s string) (id uint32, ext bool, err error) {
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || v > 0x1FFFFFFF {
+		return 0, false, ErrProtocol
+	}
+	ext = len(s) > 3
+	if !ext && v > 0x7FF {
+		return 0, false, ErrProtocol
+	}
+	return uint32(v), ext, nil
+}
+
+// parseFrame parses the arguments of a frame element:
+// identifier, timestamp, and data in one string.
+func parseFrame(m *can.Msg, args []string) error {
+	if len(args) < 2 {
+		return ErrProtocol
+	}
+	id, ext, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	sec, frac, _ := strings.Cut(args[1], ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return ErrProtocol
+	}
+	var µs int64
+	if frac != "" {
+		frac = (frac + "000000")[:6]
+		if µs, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return ErrProtocol
+		}
+	}
+	var hex string
+	if len(args) > 2 {
+		hex = strings.Join(args[2:], "")
+	}
+	data, err := parseHex(hex)
+	if err != nil {
+		return err
+	}
+	m.Reset()
+	m.Id = id
+	if ext {
+		m.Flags |= can.ExtFrame
+	}
+	m.SetData(data)
+	m.Rx.Time = can.Time(s*1e6 + µs)
+	return nil
+}
+
+func parseHex(s string) ([]byte, error) {
+	if len(s)%2 != 0 || len(s) > 16 {
+		return nil, ErrProtocol
+	}
+	data := make([]byte, len(s)/2)
+	for i := range data {
+		v, err := strconv.ParseUint(s[2*i:2*i+2], 16, 8)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		data[i] = byte(v)
+	}
+	return data, nil
+}
+
+// parseData parses a length, and the data bytes following it.
+func parseData(args []string) ([]byte, error) {
+	if len(args) == 0 {
+		return nil, ErrProtocol
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > 8 || len(args) != n+1 {
+		return nil, ErrProtocol
+	}
+	data := make([]byte, n)
+	for i, s := range args[1:] {
+		v, err := strconv.ParseUint(s, 16, 8)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+		data[i] = byte(v)
+	}
+	return data, nil
+}
+
+func verifyMsg(m *can.Msg) error {
+	n := len(m.Data())
+	if n > 8 || m.Test(can.ForceFD) {
+		return ErrFD
+	}
+	if m.Test(can.RTRMsg) {
+		return errors.New("socketcand: remote frames not supported")
+	}
+	return nil
+}
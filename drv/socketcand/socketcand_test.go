@@ -0,0 +1,237 @@
+package socketcand
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+func TestFrame(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		time  can.Time
+		frame string
+		send  string
+	}{
+		{"123#", 1500000000123456, "< frame 123 1500000000.123456  >", "< send 123 0 >"},
+		{"7FF#01020304", 1000002, "< frame 7FF 1.000002 01020304 >", "< send 7FF 4 01 02 03 04 >"},
+		{"00000123#AA", 0, "< frame 00000123 0.000000 AA >", "< send 00000123 1 AA >"},
+	} {
+		var m can.Msg
+		if err := m.FromExpr(tc.expr); err != nil {
+			t.Fatal(err)
+		}
+		m.Rx.Time = tc.time
+		if b := appendFrame(nil, &m); string(b) != tc.frame {
+			t.Errorf("%s: got %q, want %q", tc.expr, b, tc.frame)
+		}
+		if b := appendSend(nil, &m); string(b) != tc.send {
+			t.Errorf("%s: got %q, want %q", tc.expr, b, tc.send)
+		}
+
+		f, err := readElement(bufio.NewReader(strings.NewReader(tc.frame)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m2 can.Msg
+		if err := parseFrame(&m2, f[1:]); err != nil {
+			t.Errorf("%s: %v", tc.frame, err)
+			continue
+		}
+		if m2.Id != m.Id || m2.Flags != m.Flags || !bytes.Equal(m2.Data(), m.Data()) || m2.Rx.Time != m.Rx.Time {
+			t.Errorf("%s: decoded %x %v % x %d", tc.frame, m2.Id, m2.Flags, m2.Data(), m2.Rx.Time)
+		}
+
+		m3, err := parseMsg(strings.Fields(strings.Trim(tc.send, "<> "))[1:])
+		if err != nil {
+			t.Errorf("%s: %v", tc.send, err)
+			continue
+		}
+		if m3.Id != m.Id || m3.Flags != m.Flags || !bytes.Equal(m3.Data(), m.Data()) {
+			t.Errorf("%s: decoded %x %v % x", tc.send, m3.Id, m3.Flags, m3.Data())
+		}
+	}
+}
+
+// testServer exports a device of a virtual bus as "vcan0", and
+// returns the address of the server, and another device connected
+// to the virtual bus.
+func testServer(t *testing.T, name string) (string, can.Device) {
+	t.Helper()
+	exported, err := can.Open("virtual:" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := can.Open("virtual:" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	if err := s.Register("vcan0", exported); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() {
+		l.Close()
+		exported.Close()
+		local.Close()
+	})
+	return l.Addr().String(), local
+}
+
+func readMsg(t *testing.T, d can.Device) *can.Msg {
+	t.Helper()
+	timer := time.AfterFunc(2*time.Second, func() {
+		t.Error("timeout")
+		d.Close()
+	})
+	defer timer.Stop()
+	buf := make([]can.Msg, 1)
+	if _, err := d.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	return &buf[0]
+}
+
+func TestRawMode(t *testing.T) {
+	addr, local := testServer(t, "socketcand-raw")
+
+	if _, err := can.Open("socketcand:" + addr + "/vcan1"); err == nil {
+		t.Error("opening an unknown bus succeeded")
+	}
+	d, err := can.Open("socketcand:" + addr + "/vcan0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	var m can.Msg
+	m.FromExpr("12345678#0102")
+	if err := d.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	if r := readMsg(t, local); r.Id != m.Id || r.Flags != m.Flags || !bytes.Equal(r.Data(), m.Data()) {
+		t.Errorf("got %x %v % x", r.Id, r.Flags, r.Data())
+	}
+
+	m.Reset()
+	m.FromExpr("100#AABBCC")
+	if err := local.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+	r := readMsg(t, d)
+	if r.Id != m.Id || r.Flags != m.Flags || !bytes.Equal(r.Data(), m.Data()) {
+		t.Errorf("got %x %v % x", r.Id, r.Flags, r.Data())
+	}
+	if dt := can.Now() - r.Rx.Time; dt < 0 || dt > 1e6 {
+		t.Errorf("unexpected timestamp: %d", r.Rx.Time)
+	}
+
+	m.FromExpr("100##0" + strings.Repeat("00", 12))
+	if err := d.WriteMsg(&m); err != ErrFD {
+		t.Errorf("got %v, want %v", err, ErrFD)
+	}
+}
+
+// client is a raw client connection, used to test BCM mode.
+type client struct {
+	t  *testing.T
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func (c *client) send(s string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.nc, s); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) expect(want string) {
+	c.t.Helper()
+	c.nc.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := readElement(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if got := strings.Join(f, " "); !strings.HasPrefix(got, want) {
+		c.t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestBCMMode(t *testing.T) {
+	addr, local := testServer(t, "socketcand-bcm")
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c := &client{t: t, nc: nc, r: bufio.NewReader(nc)}
+
+	c.expect("hi")
+	c.send("< echo >")
+	c.expect("error")
+	c.send("< open vcan0 >")
+	c.expect("ok")
+	c.send("< echo >")
+	c.expect("echo")
+
+	c.send("< subscribe 0 0 123 >< filter 0 0 00000200 2 00 FF >")
+	c.send("< echo >")
+	c.expect("echo")
+	for _, expr := range []string{
+		"124#01",
+		"123#01",
+		"00000200#0102",
+		"00000200#0202", // unchanged, as far as the mask is concerned
+		"200#0103",      // standard frame
+		"00000200#0103",
+		"123#02",
+	} {
+		var m can.Msg
+		m.FromExpr(expr)
+		if err := local.WriteMsg(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.expect("frame 123")
+	c.expect("frame 00000200")
+	c.expect("frame 00000200")
+	c.expect("frame 123")
+
+	c.send("< unsubscribe 123 >< add 0 10000 321 1 55 >")
+	r := readMsg(t, local)
+	if r.Id != 0x321 || !bytes.Equal(r.Data(), []byte{0x55}) {
+		t.Errorf("got %x % x", r.Id, r.Data())
+	}
+	c.send("< update 321 1 66 >")
+	for {
+		r := readMsg(t, local)
+		if r.Data()[0] == 0x66 {
+			break
+		}
+	}
+	c.send("< delete 321 >< send 00000321 0 >")
+	for {
+		r := readMsg(t, local)
+		if r.ExtFrame() {
+			break
+		}
+	}
+
+	c.send("< rawmode >")
+	c.expect("ok")
+	c.send("< subscribe 0 0 123 >")
+	c.expect("error")
+}
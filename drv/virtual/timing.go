@@ -0,0 +1,84 @@
+package virtual
+
+import (
+	"errors"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+// busTiming contains the bitrates of a timed bus.
+type busTiming struct {
+	nominal uint32
+	data    uint32
+}
+
+func (t *busTiming) setup(conf *can.Config) error {
+	t.nominal = bitrate(&conf.Nominal)
+	if conf.Data.Valid {
+		t.data = bitrate(&conf.Data.Value)
+		if t.data == 0 {
+			return errors.New("virtual: cannot determine data bitrate")
+		}
+	}
+	if t.data != 0 && t.nominal == 0 {
+		return errors.New("virtual: missing nominal bitrate")
+	}
+	return nil
+}
+
+// bitrate returns the bitrate of btc, which is either specified
+// directly, or by a time quantum and the segment lengths.
+func bitrate(btc *can.BitTimingConfig) uint32 {
+	if btc.Bitrate != 0 {
+		return btc.Bitrate
+	}
+	if btc.Tq == 0 {
+		return 0
+	}
+	bit := btc.Tq * time.Duration(btc.Nq())
+	return uint32(time.Second / bit)
+}
+
+// frameDuration estimates the time needed to transmit a frame,
+// including the interframe space, but without stuff bits.
+func (t *busTiming) frameDuration(f *frame) time.Duration {
+	n := len(f.data)
+	if f.flags&can.RTRMsg != 0 {
+		n = 0
+	}
+	ext := f.flags&can.ExtFrame != 0
+	fd := n > 8 || f.flags&can.ForceFD != 0
+
+	// CRC delimiter, ACK slot and delimiter, EOF, IFS
+	const trailer = 1 + 2 + 7 + 3
+
+	if !fd {
+		// SOF, identifier, RTR, IDE, r0, DLC
+		header := 1 + 11 + 1 + 1 + 1 + 4
+		if ext {
+			header += 20
+		}
+		return bitsDuration(header+8*n+15+trailer, t.nominal)
+	}
+
+	// SOF, identifier, RRS, IDE, FDF, res, BRS
+	arb := 1 + 11 + 1 + 1 + 1 + 1 + 1
+	if ext {
+		arb += 19
+	}
+	// ESI, DLC, data, stuff count, CRC
+	crc := 17
+	if n > 16 {
+		crc = 21
+	}
+	data := 1 + 4 + 8*n + 4 + crc
+	if f.flags&can.FDSwitchBitrate != 0 && t.data != 0 {
+		return bitsDuration(arb+trailer, t.nominal) + bitsDuration(data, t.data)
+	}
+	return bitsDuration(arb+data+trailer, t.nominal)
+}
+
+func bitsDuration(n int, bitrate uint32) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(bitrate)
+}
@@ -0,0 +1,510 @@
+// Package virtual implements an in-memory CAN bus that may be
+// used for tests and simulations, without any hardware or kernel
+// support.
+//
+// The package registers a driver named "virtual" with the can
+// package. Devices opened using the same bus name are connected
+// to each other:
+//
+//	a, _ := can.Open("virtual:bus0")
+//	b, _ := can.Open("virtual:bus0")
+//
+// A message written to a device will be received by all other
+// devices connected to the same bus, but not by the sender itself.
+// A bus is created when the first device is opened, and removed
+// after the last one has been closed.
+//
+// Message filters provided through [can.Config] are applied
+// to received messages. If a nominal bitrate is configured,
+// like in "virtual:bus0,500k", the bus is operated in timed mode:
+// Pending messages of all devices are put on the bus one after
+// another, ordered by arbitration priority, each taking the
+// time needed for its transmission at the configured bitrates
+// (bit stuffing is not considered). All devices opened on a
+// timed bus must agree on the bitrates; devices opened without
+// a bitrate may join any bus. As long as no device has configured
+// a bitrate, messages are delivered immediately by WriteMsg.
+package virtual
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can"
+	"github.com/knieriem/can/drv"
+)
+
+const (
+	rxQueueLen = 1024
+	txQueueLen = 64
+)
+
+func init() {
+	can.RegisterDriver(new(driver))
+}
+
+type driver struct{}
+
+func (*driver) Name() string {
+	return "virtual"
+}
+
+var buses = struct {
+	sync.Mutex
+	m map[string]*bus
+}{m: make(map[string]*bus)}
+
+func (*driver) Scan() (list []can.DeviceInfo) {
+	buses.Lock()
+	defer buses.Unlock()
+	for name := range buses.m {
+		list = append(list, *newInfo(name))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Open connects a new device to the bus specified by name. An empty
+// name is rejected, so that can.Open("") will not select a virtual
+// device while looking for real adapters.
+func (*driver) Open(env *can.Env, name string, conf *can.Config) (can.Device, error) {
+	if name == "" {
+		return nil, errors.New("virtual: missing bus name")
+	}
+	var t busTiming
+	if conf != nil {
+		if err := conf.ResolveCtrlModes(drv.NoCtrlModes); err != nil {
+			return nil, fmt.Errorf("virtual: %w", err)
+		}
+		if err := t.setup(conf); err != nil {
+			return nil, err
+		}
+	}
+
+	buses.Lock()
+	defer buses.Unlock()
+	b, ok := buses.m[name]
+	if !ok {
+		b = newBus(name)
+		buses.m[name] = b
+	}
+	if err := b.setTiming(&t); err != nil {
+		return nil, err
+	}
+
+	d := new(dev)
+	d.bus = b
+	d.info = *newInfo(name)
+	d.notify = make(chan struct{}, 1)
+	d.closed = make(chan struct{})
+	if env != nil {
+		d.bufPool = env.BufPool
+	}
+	if conf != nil {
+		d.filters = slices.Clone(conf.MsgFilter)
+	}
+	b.attach(d)
+	return d, nil
+}
+
+func newInfo(name string) *can.DeviceInfo {
+	return &can.DeviceInfo{
+		ID:     name,
+		Driver: "virtual",
+		Model:  "in-memory bus",
+	}
+}
+
+// frame is a copy of a message while it is travelling on the bus.
+type frame struct {
+	id    uint32
+	flags can.Flags
+	xl    can.XLHeader
+	data  []byte
+
+	src *dev
+	seq uint64
+}
+
+func newFrame(m *can.Msg, src *dev) *frame {
+	return &frame{
+		id:    m.Id,
+		flags: m.Flags,
+		xl:    m.XL,
+		data:  slices.Clone(m.Data()),
+		src:   src,
+	}
+}
+
+type bus struct {
+	name   string
+	timing busTiming
+
+	mu      sync.Mutex
+	devs    []*dev
+	pending []*frame
+	seq     uint64
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newBus(name string) *bus {
+	b := new(bus)
+	b.name = name
+	b.done = make(chan struct{})
+	return b
+}
+
+// setTiming switches the bus into timed mode, if t contains a bitrate
+// and the bus is not timed yet. If the bus is already timed,
+// the bitrates must match.
+func (b *bus) setTiming(t *busTiming) error {
+	if t.nominal == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timing.nominal != 0 {
+		if *t != b.timing {
+			return errors.New("virtual: bitrate mismatch on bus " + b.name)
+		}
+		return nil
+	}
+	b.timing = *t
+	b.wake = make(chan struct{}, 1)
+	go b.arbitrate()
+	return nil
+}
+
+func (b *bus) attach(d *dev) {
+	b.mu.Lock()
+	b.devs = append(b.devs, d)
+	b.mu.Unlock()
+}
+
+// detach removes d from the bus; it must be called
+// with buses locked.
+func (b *bus) detach(d *dev) {
+	b.mu.Lock()
+	b.devs = slices.DeleteFunc(b.devs, func(d1 *dev) bool { return d1 == d })
+	b.pending = slices.DeleteFunc(b.pending, func(f *frame) bool { return f.src == d })
+	empty := len(b.devs) == 0
+	b.mu.Unlock()
+	if empty {
+		delete(buses.m, b.name)
+		close(b.done)
+	}
+}
+
+// deliver passes a frame to all devices except the sender.
+func (b *bus) deliver(f *frame) {
+	t := can.Now()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, d := range b.devs {
+		if d != f.src {
+			d.receive(f, t)
+		}
+	}
+}
+
+// submit passes frames to the bus. In timed mode, they are added
+// to the list of pending frames at once, so that they take part in
+// the same arbitration.
+func (b *bus) submit(src *dev, frames ...*frame) (n int, err error) {
+	b.mu.Lock()
+	if b.timing.nominal == 0 {
+		b.mu.Unlock()
+		for _, f := range frames {
+			b.deliver(f)
+		}
+		return len(frames), nil
+	}
+	nPending := 0
+	for _, p := range b.pending {
+		if p.src == src {
+			nPending++
+		}
+	}
+	for _, f := range frames {
+		if nPending >= txQueueLen {
+			err = can.ErrTxQueueFull
+			break
+		}
+		b.seq++
+		f.seq = b.seq
+		b.pending = append(b.pending, f)
+		nPending++
+		n++
+	}
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+	return n, err
+}
+
+// arbitrate is run as a goroutine in timed mode. It repeatedly
+// selects the pending frame that would win the arbitration, and
+// delivers it after the time the transmission would have taken.
+func (b *bus) arbitrate() {
+	var busFree time.Time
+	for {
+		b.mu.Lock()
+		f := b.next()
+		b.mu.Unlock()
+		if f == nil {
+			select {
+			case <-b.wake:
+				continue
+			case <-b.done:
+				return
+			}
+		}
+		if now := time.Now(); busFree.Before(now) {
+			busFree = now
+		}
+		b.mu.Lock()
+		d := b.timing.frameDuration(f)
+		b.mu.Unlock()
+		busFree = busFree.Add(d)
+		select {
+		case <-time.After(time.Until(busFree)):
+		case <-b.done:
+			return
+		}
+
+		b.mu.Lock()
+		i := slices.Index(b.pending, f)
+		if i != -1 {
+			b.pending = slices.Delete(b.pending, i, i+1)
+		}
+		b.mu.Unlock()
+		if i == -1 {
+			// sender has been closed meanwhile
+			continue
+		}
+		b.deliver(f)
+	}
+}
+
+// next returns the pending frame with the highest priority.
+func (b *bus) next() (f *frame) {
+	for _, p := range b.pending {
+		if f == nil {
+			f = p
+			continue
+		}
+		kp, kf := arbitrationKey(p), arbitrationKey(f)
+		if kp < kf || kp == kf && p.seq < f.seq {
+			f = p
+		}
+	}
+	return f
+}
+
+// arbitrationKey returns a value reflecting the sequence of bits in
+// a frame's arbitration field: a lower value wins the arbitration.
+// The bits are: base ID (11), RTR or SRR, IDE, ID extension (18), RTR.
+func arbitrationKey(f *frame) uint32 {
+	rtr := uint32(0)
+	if f.flags&can.RTRMsg != 0 {
+		rtr = 1
+	}
+	if f.flags&can.ExtFrame == 0 {
+		return (f.id&0x7FF)<<21 | rtr<<20
+	}
+	base := f.id >> 18 & 0x7FF
+	ext := f.id & 0x3FFFF
+	return base<<21 | 1<<20 | 1<<19 | ext<<1 | rtr
+}
+
+type dev struct {
+	bus     *bus
+	info    can.DeviceInfo
+	filters []can.MsgFilter
+	bufPool can.DataBufPool
+
+	mu       sync.Mutex
+	rxq      []rxFrame
+	overflow bool
+	notify   chan struct{}
+	closed   chan struct{}
+	closing  bool
+}
+
+type rxFrame struct {
+	*frame
+	t can.Time
+}
+
+func (d *dev) receive(f *frame, t can.Time) {
+	var m can.Msg
+	m.Id = f.id
+	m.Flags = f.flags
+	m.XL = f.xl
+	if !drv.AcceptMsg(d.filters, &m) {
+		return
+	}
+	d.mu.Lock()
+	if len(d.rxq) >= rxQueueLen {
+		d.overflow = true
+	} else {
+		d.rxq = append(d.rxq, rxFrame{frame: f, t: t})
+	}
+	d.mu.Unlock()
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (d *dev) ID() string {
+	return "virtual:" + d.info.ID
+}
+
+func (d *dev) Info() *can.DeviceInfo {
+	return &d.info
+}
+
+// Read blocks until at least one message is available, and returns
+// as many messages as fit into buf. If the receive queue overflowed,
+// a status message with flag ReceiveBufferOverflow is returned first.
+func (d *dev) Read(buf []can.Msg) (n int, err error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	for {
+		d.mu.Lock()
+		if d.closing {
+			d.mu.Unlock()
+			return 0, io.EOF
+		}
+		if d.overflow {
+			d.overflow = false
+			d.mu.Unlock()
+			m := &buf[0]
+			m.Reset()
+			m.Flags = can.StatusMsg | can.ReceiveBufferOverflow
+			m.Rx.Time = can.Now()
+			return 1, nil
+		}
+		for n < len(buf) && len(d.rxq) != 0 {
+			f := d.rxq[0]
+			m := &buf[n]
+			m.Reset()
+			m.Id = f.id
+			m.Flags = f.flags
+			m.XL = f.xl
+			m.Rx.Time = f.t
+			if err := m.Import(f.data, d.bufPool); err != nil {
+				if n == 0 {
+					d.rxq = d.rxq[1:]
+					d.mu.Unlock()
+					return 0, err
+				}
+				break
+			}
+			d.rxq[0] = rxFrame{}
+			d.rxq = d.rxq[1:]
+			n++
+		}
+		d.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+		select {
+		case <-d.notify:
+		case <-d.closed:
+		}
+	}
+}
+
+func (d *dev) WriteMsg(m *can.Msg) error {
+	if m.IsStatus() {
+		return nil
+	}
+	if err := verifyMsg(m); err != nil {
+		return err
+	}
+	if d.isClosed() {
+		return errClosed
+	}
+	_, err := d.bus.submit(d, newFrame(m, d))
+	return err
+}
+
+// Write hands over all messages to the bus at once, so that
+// in timed mode they take part in the same arbitration.
+func (d *dev) Write(msgs []can.Msg) (n int, err error) {
+	frames := make([]*frame, 0, len(msgs))
+	index := make([]int, 0, len(msgs))
+	for i := range msgs {
+		m := &msgs[i]
+		if m.IsStatus() {
+			continue
+		}
+		if err = verifyMsg(m); err != nil {
+			msgs = msgs[:i]
+			break
+		}
+		frames = append(frames, newFrame(m, d))
+		index = append(index, i)
+	}
+	if d.isClosed() {
+		return 0, errClosed
+	}
+	nf, err1 := d.bus.submit(d, frames...)
+	if err1 != nil {
+		return index[nf], err1
+	}
+	return len(msgs), err
+}
+
+func verifyMsg(m *can.Msg) error {
+	n := len(m.Data())
+	if m.Flags.XLFrame() {
+		return can.VerifyDataLenXL(n)
+	}
+	if _, _, err := can.VerifyDataLenFD(n); err != nil {
+		return err
+	}
+	if m.Test(can.RTRMsg) && n != 0 {
+		return can.ErrInvalidMsgLen
+	}
+	return nil
+}
+
+func (d *dev) isClosed() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.closing
+}
+
+func (d *dev) Close() error {
+	d.mu.Lock()
+	if d.closing {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closing = true
+	d.rxq = nil
+	d.mu.Unlock()
+	close(d.closed)
+
+	buses.Lock()
+	d.bus.detach(d)
+	buses.Unlock()
+	return nil
+}
+
+var errClosed = errors.New("virtual: device closed")
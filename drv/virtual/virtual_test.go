@@ -0,0 +1,156 @@
+package virtual
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/knieriem/can"
+)
+
+func openPair(t *testing.T, spec string) (a, b can.Device) {
+	t.Helper()
+	a, err := can.Open(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = can.Open(spec)
+	if err != nil {
+		a.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestDelivery(t *testing.T) {
+	a, b := openPair(t, "virtual:test-delivery")
+
+	var m can.Msg
+	if err := m.FromExpr("123##1" + "000102030405060708090a0b0c0d0e0f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]can.Msg, 4)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	r := &buf[0]
+	if r.Id != 0x123 || !r.Test(can.FDSwitchBitrate) {
+		t.Errorf("unexpected message: id %x, flags %x", r.Id, r.Flags)
+	}
+	if !bytes.Equal(r.Data(), m.Data()) {
+		t.Errorf("data: got % x, want % x", r.Data(), m.Data())
+	}
+	if r.Rx.Time == 0 {
+		t.Error("missing receive time stamp")
+	}
+}
+
+func TestDeliveryXL(t *testing.T) {
+	a, b := openPair(t, "virtual:test-delivery-xl")
+
+	var m can.Msg
+	m.Id = 0x123
+	m.Flags = can.XLFrame | can.XLSecure
+	m.XL = can.XLHeader{SDT: 3, VCID: 0x42, AF: 0x12345678}
+	m.SetData(bytes.Repeat([]byte{0x5a}, 100))
+	if err := a.WriteMsg(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]can.Msg, 4)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	r := &buf[0]
+	if r.Id != m.Id || r.Flags != m.Flags || r.XL != m.XL {
+		t.Errorf("unexpected message: id %x, flags %x, xl %+v", r.Id, r.Flags, r.XL)
+	}
+	if !bytes.Equal(r.Data(), m.Data()) {
+		t.Errorf("data: got % x, want % x", r.Data(), m.Data())
+	}
+
+	m.SetData(make([]byte, can.MaxXLDataLen+1))
+	if err := a.WriteMsg(&m); err != can.ErrInvalidMsgLen {
+		t.Errorf("oversized XL frame: got error %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	a, err := can.Open("virtual:test-filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := can.Open("virtual:test-filter,500k f:12-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for _, expr := range []string{"200#01", "125#02", "12345678#03"} {
+		var m can.Msg
+		m.FromExpr(expr)
+		if err := a.WriteMsg(&m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := make([]can.Msg, 4)
+	n, err := b.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || buf[0].Id != 0x125 {
+		t.Fatalf("got %d messages (first id %x), want only 0x125", n, buf[0].Id)
+	}
+}
+
+func TestArbitration(t *testing.T) {
+	a, b := openPair(t, "virtual:test-arb,1M")
+
+	// All messages passed to Write take part in the same arbitration.
+	var msgs [4]can.Msg
+	for i, expr := range []string{"7ff#", "400#", "12345678#", "100#"} {
+		msgs[i].FromExpr(expr)
+	}
+	n, err := a.Write(msgs[:])
+	if err != nil || n != len(msgs) {
+		t.Fatal(n, err)
+	}
+	want := []uint32{0x100, 0x400, 0x12345678, 0x7ff}
+	buf := make([]can.Msg, 1)
+	for i, id := range want {
+		if _, err := b.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf[0].Id != id {
+			t.Errorf("message %d: got id %x, want %x", i, buf[0].Id, id)
+		}
+	}
+}
+
+func TestCtrlModes(t *testing.T) {
+	if _, err := can.Open("virtual:test-modes,500k listen-only"); !errors.Is(err, can.ErrCtrlModeNotSupported) {
+		t.Errorf("got %v, want %v", err, can.ErrCtrlModeNotSupported)
+	}
+	d, err := can.Open("virtual:test-modes,500k listen-only?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+}
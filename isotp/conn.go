@@ -0,0 +1,447 @@
+package isotp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+// Conn is an ISO-TP connection running on a can.Device.
+//
+// The Conn takes over the device: it starts a goroutine that reads
+// from the device and picks up the frames addressed to it, dropping
+// all others. Closing the Conn closes the device.
+//
+// Incoming multi-frame PDUs are flow controlled by Read, so it
+// should be called in time after a PDU is expected to arrive.
+type Conn struct {
+	dev can.Device
+	cfg Config
+
+	fc       chan []byte
+	rx       chan []byte
+	done     chan struct{}
+	readDone chan struct{}
+	readErr  error
+
+	wmu sync.Mutex
+	rmu sync.Mutex
+
+	mu        sync.Mutex
+	deadline  time.Time
+	closeOnce sync.Once
+}
+
+// rxQueueLen is the number of received frames that may be queued
+// while Read is not waiting for them; further frames are dropped.
+const rxQueueLen = 4096
+
+// NewConn creates an ISO-TP connection on dev,
+// using the parameters specified in cfg.
+func NewConn(dev can.Device, cfg *Config) *Conn {
+	c := new(Conn)
+	c.dev = dev
+	c.cfg = *cfg
+	c.fc = make(chan []byte, 4)
+	c.rx = make(chan []byte, rxQueueLen)
+	c.done = make(chan struct{})
+	c.readDone = make(chan struct{})
+	go c.readLoop()
+	return c
+}
+
+// readLoop passes payloads of frames matching the configured receive
+// address to the flow control or data channels. The payloads are
+// stripped from the address byte, if any.
+func (c *Conn) readLoop() {
+	cfg := &c.cfg
+	buf := make([]can.Msg, 8)
+	for {
+		n, err := c.dev.Read(buf)
+		if err != nil {
+			c.readErr = err
+			close(c.readDone)
+			return
+		}
+		for i := range buf[:n] {
+			m := &buf[i]
+			if m.IsStatus() || m.Test(can.RTRMsg) {
+				continue
+			}
+			if m.Id != cfg.RxID || m.ExtFrame() != cfg.ExtFrame {
+				continue
+			}
+			data := m.Data()
+			if cfg.AddrMode != NormalAddr {
+				if len(data) == 0 || data[0] != cfg.RxAddr {
+					continue
+				}
+				data = data[1:]
+			}
+			if len(data) == 0 {
+				continue
+			}
+			ch := c.rx
+			if data[0]>>4 == pciFC {
+				ch = c.fc
+			}
+			select {
+			case ch <- slices.Clone(data):
+			default:
+			}
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for subsequent Read calls.
+// A zero value disables the deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) readDeadline() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline
+}
+
+// Close closes the connection and the underlying device.
+func (c *Conn) Close() error {
+	err := errClosed
+	c.closeOnce.Do(func() {
+		close(c.done)
+		err = c.dev.Close()
+	})
+	return err
+}
+
+var errClosed = errors.New("isotp: use of closed connection")
+
+// Write transmits p as a single PDU. It returns after the last frame
+// has been handed over to the device.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	select {
+	case <-c.done:
+		return 0, errClosed
+	default:
+	}
+	if uint64(len(p)) > maxPDU {
+		return 0, ErrPDUTooLarge
+	}
+	c.drainFC()
+
+	txDL := c.cfg.txDL()
+	al := c.cfg.addrLen()
+	if len(p) <= 7-al {
+		return len(p), c.send([]byte{byte(len(p))}, p)
+	}
+	if txDL > 8 && len(p) <= txDL-2-al {
+		return len(p), c.send([]byte{0, byte(len(p))}, p)
+	}
+
+	var pci []byte
+	if len(p) <= 4095 {
+		pci = []byte{pciFF<<4 | byte(len(p)>>8), byte(len(p))}
+	} else {
+		pci = binary.BigEndian.AppendUint32([]byte{pciFF << 4, 0}, uint32(len(p)))
+	}
+	nFF := txDL - al - len(pci)
+	if err := c.send(pci, p[:nFF]); err != nil {
+		return 0, err
+	}
+	rest := p[nFF:]
+	nCF := txDL - al - 1
+	sn := byte(1)
+	for len(rest) != 0 {
+		bs, stmin, err := c.waitFC()
+		if err != nil {
+			return 0, err
+		}
+		for i := 0; bs == 0 || i < bs; i++ {
+			if i != 0 && stmin != 0 {
+				time.Sleep(stmin)
+			}
+			n := min(len(rest), nCF)
+			if err := c.send([]byte{pciCF<<4 | sn}, rest[:n]); err != nil {
+				return 0, err
+			}
+			rest = rest[n:]
+			sn = (sn + 1) & 0xF
+			if len(rest) == 0 {
+				break
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// drainFC discards flow control frames that might have been
+// received before a transfer was started.
+func (c *Conn) drainFC() {
+	for {
+		select {
+		case <-c.fc:
+		default:
+			return
+		}
+	}
+}
+
+// waitFC waits for a flow control frame with status
+// "continue to send", and returns its parameters.
+func (c *Conn) waitFC() (bs int, stmin time.Duration, err error) {
+	nWait := 0
+	timer := time.NewTimer(timeout(c.cfg.NBs))
+	defer timer.Stop()
+	for {
+		var f []byte
+		select {
+		case f = <-c.fc:
+		case <-timer.C:
+			return 0, 0, ErrTimeoutBs
+		case <-c.done:
+			return 0, 0, errClosed
+		case <-c.readDone:
+			return 0, 0, c.readErr
+		}
+		if len(f) < 3 {
+			return 0, 0, ErrInvalidPCI
+		}
+		switch f[0] & 0xF {
+		case fsCTS:
+			return int(f[1]), decodeSTmin(f[2]), nil
+		case fsWait:
+			nWait++
+			if nWait > c.cfg.wftMax() {
+				return 0, 0, ErrWaitLimit
+			}
+			timer.Reset(timeout(c.cfg.NBs))
+		case fsOverflow:
+			return 0, 0, ErrOverflow
+		default:
+			return 0, 0, ErrInvalidPCI
+		}
+	}
+}
+
+// send transmits a single frame containing the address byte,
+// if needed, the protocol control information, and data.
+func (c *Conn) send(pci, data []byte) error {
+	cfg := &c.cfg
+	b := make([]byte, 0, cfg.txDL())
+	if cfg.AddrMode != NormalAddr {
+		b = append(b, cfg.TxAddr)
+	}
+	b = append(b, pci...)
+	b = append(b, data...)
+
+	n := len(b)
+	if cfg.Padding && n < 8 {
+		n = 8
+	}
+	if n > 8 {
+		n, _, _ = can.VerifyDataLenFD(n)
+	}
+	for len(b) < n {
+		b = append(b, cfg.PadByte)
+	}
+
+	var m can.Msg
+	m.Id = cfg.TxID
+	if cfg.ExtFrame {
+		m.Flags |= can.ExtFrame
+	}
+	if cfg.txDL() > 8 {
+		m.Flags |= can.ForceFD
+		if cfg.BRS {
+			m.Flags |= can.FDSwitchBitrate
+		}
+	}
+	m.SetData(b)
+
+	start := time.Now()
+	for {
+		err := c.dev.WriteMsg(&m)
+		if !errors.Is(err, can.ErrTxQueueFull) {
+			return err
+		}
+		if time.Since(start) > timeout(cfg.NAs) {
+			return ErrTimeoutAs
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (c *Conn) sendFC(status byte) error {
+	return c.send([]byte{pciFC<<4 | status, c.cfg.BlockSize, EncodeSTmin(c.cfg.STmin)}, nil)
+}
+
+// Read receives a single PDU and copies it into p.
+// If p is too small, a flow control frame signalling an overflow is
+// sent in case of a multi-frame PDU, and io.ErrShortBuffer is returned.
+func (c *Conn) Read(p []byte) (n int, err error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	deadline := c.readDeadline()
+	var f []byte
+	for {
+		if f == nil {
+			f, err = c.nextFrame(deadline, 0)
+			if err != nil {
+				return 0, err
+			}
+		}
+		switch f[0] >> 4 {
+		case pciSF:
+			data, err := parseSF(f)
+			if err != nil {
+				f = nil
+				continue
+			}
+			n = copy(p, data)
+			if n < len(data) {
+				return n, io.ErrShortBuffer
+			}
+			return n, nil
+		case pciFF:
+			n, f, err = c.receiveMulti(p, f, deadline)
+			if f != nil {
+				// reception has been interrupted by a new PDU
+				continue
+			}
+			return n, err
+		}
+		// ignore consecutive frames not preceded by a first frame
+		f = nil
+	}
+}
+
+// receiveMulti receives the remaining part of a PDU started by the first
+// frame ff. If a new single or first frame is received in between, the
+// current reception is terminated, and the new frame is returned.
+func (c *Conn) receiveMulti(p, ff []byte, deadline time.Time) (n int, next []byte, err error) {
+	dl, data, err := parseFF(ff)
+	if err != nil {
+		return 0, nil, err
+	}
+	if dl > len(p) {
+		c.sendFC(fsOverflow)
+		return 0, nil, io.ErrShortBuffer
+	}
+	n = copy(p[:dl], data)
+
+	sn := byte(1)
+	nBlock := 0
+	if err := c.sendFC(fsCTS); err != nil {
+		return 0, nil, err
+	}
+	for n < dl {
+		f, err := c.nextFrame(deadline, timeout(c.cfg.NCr))
+		if err != nil {
+			return 0, nil, err
+		}
+		switch f[0] >> 4 {
+		case pciSF, pciFF:
+			return 0, f, nil
+		case pciCF:
+		default:
+			continue
+		}
+		if f[0]&0xF != sn {
+			return 0, nil, ErrWrongSN
+		}
+		n += copy(p[n:dl], f[1:])
+		sn = (sn + 1) & 0xF
+		nBlock++
+		if n < dl && c.cfg.BlockSize != 0 && nBlock == int(c.cfg.BlockSize) {
+			nBlock = 0
+			if err := c.sendFC(fsCTS); err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+	return n, nil, nil
+}
+
+// nextFrame waits for the next frame received from the peer.
+// If the deadline is not zero, or nCr is not zero, the wait is limited
+// accordingly; in the latter case, ErrTimeoutCr is returned.
+func (c *Conn) nextFrame(deadline time.Time, nCr time.Duration) ([]byte, error) {
+	var dlc, crc <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		dlc = t.C
+	}
+	if nCr != 0 {
+		t := time.NewTimer(nCr)
+		defer t.Stop()
+		crc = t.C
+	}
+	select {
+	case f := <-c.rx:
+		return f, nil
+	case <-dlc:
+		return nil, fmt.Errorf("isotp: read: %w", os.ErrDeadlineExceeded)
+	case <-crc:
+		return nil, ErrTimeoutCr
+	case <-c.done:
+		return nil, errClosed
+	case <-c.readDone:
+		return nil, c.readErr
+	}
+}
+
+// parseSF returns the payload of a single frame.
+func parseSF(f []byte) ([]byte, error) {
+	n := int(f[0] & 0xF)
+	data := f[1:]
+	if n == 0 {
+		// escape sequence for CAN FD
+		if len(f) < 2 {
+			return nil, ErrInvalidPCI
+		}
+		n = int(f[1])
+		data = f[2:]
+	}
+	if n == 0 || n > len(data) {
+		return nil, ErrInvalidPCI
+	}
+	return data[:n], nil
+}
+
+// parseFF returns the PDU length announced in a first
+// frame, and the data contained in the frame.
+func parseFF(f []byte) (dl int, data []byte, err error) {
+	if len(f) < 2 {
+		return 0, nil, ErrInvalidPCI
+	}
+	dl = int(f[0]&0xF)<<8 | int(f[1])
+	data = f[2:]
+	if dl == 0 {
+		// escape sequence for PDUs longer than 4095 bytes
+		if len(f) < 6 {
+			return 0, nil, ErrInvalidPCI
+		}
+		dl = int(binary.BigEndian.Uint32(f[2:6]))
+		data = f[6:]
+	}
+	if dl < len(data) {
+		return 0, nil, ErrInvalidPCI
+	}
+	return dl, data, nil
+}
@@ -0,0 +1,182 @@
+// Package isotp implements the ISO-TP transport protocol
+// (ISO 15765-2) on top of a can.Device.
+//
+// ISO-TP segments protocol data units (PDUs) that do not fit into
+// a single CAN frame into a first frame and consecutive frames,
+// with the receiver controlling the data flow using flow control
+// frames. Normal, extended and mixed addressing are supported,
+// as well as CAN FD frames with up to 64 bytes.
+//
+// A [Conn] provides a connection-like API: Write sends a whole PDU,
+// Read receives one.
+package isotp
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// AddrMode selects how ISO-TP addresses are mapped to CAN frames.
+type AddrMode int
+
+const (
+	// NormalAddr maps addresses to CAN identifiers only.
+	NormalAddr AddrMode = iota
+
+	// ExtendedAddr uses the first data byte of each frame
+	// for the target address.
+	ExtendedAddr
+
+	// MixedAddr uses the first data byte of each frame
+	// for an address extension.
+	MixedAddr
+)
+
+// Config defines the parameters of an ISO-TP connection.
+type Config struct {
+	// TxID and RxID are the CAN identifiers used for transmitted
+	// and received frames. If ExtFrame is set, 29-bit identifiers
+	// are used.
+	TxID, RxID uint32
+	ExtFrame   bool
+
+	// AddrMode selects the addressing format. In extended mode,
+	// TxAddr is the target address put into transmitted frames,
+	// RxAddr is the address expected in received frames. In mixed
+	// mode both fields contain the address extension.
+	AddrMode AddrMode
+	TxAddr   byte
+	RxAddr   byte
+
+	// TxDL is the maximum payload length of transmitted frames:
+	// 8 for classic CAN, or one of can.ValidFDSizes for CAN FD.
+	// A zero value is treated as 8. If TxDL is greater than 8,
+	// frames are sent as FD frames, with the bitrate switched
+	// if BRS is set.
+	TxDL int
+	BRS  bool
+
+	// If Padding is set, frames shorter than eight bytes are padded
+	// using PadByte. CAN FD frames are always padded to the next
+	// valid frame length.
+	Padding bool
+	PadByte byte
+
+	// BlockSize and STmin are sent to the peer in flow control
+	// frames: the number of consecutive frames to be sent before
+	// waiting for the next flow control frame (0: no limit), and the
+	// minimum separation time between consecutive frames.
+	BlockSize uint8
+	STmin     time.Duration
+
+	// WFTmax is the maximum number of subsequent flow control
+	// frames of status "wait" accepted before aborting a transfer.
+	// A zero value means 10.
+	WFTmax int
+
+	// NAs is the time allowed to hand over a frame to the device,
+	// NBs the time to wait for a flow control frame, and NCr the time
+	// to wait for a consecutive frame. A zero value means 1 s.
+	NAs time.Duration
+	NBs time.Duration
+	NCr time.Duration
+}
+
+const defaultTimeout = time.Second
+
+func (c *Config) txDL() int {
+	if c.TxDL <= 8 {
+		return 8
+	}
+	return c.TxDL
+}
+
+func (c *Config) addrLen() int {
+	if c.AddrMode == NormalAddr {
+		return 0
+	}
+	return 1
+}
+
+func (c *Config) wftMax() int {
+	if c.WFTmax == 0 {
+		return 10
+	}
+	return c.WFTmax
+}
+
+func timeout(d time.Duration) time.Duration {
+	if d == 0 {
+		return defaultTimeout
+	}
+	return d
+}
+
+// Transport is the interface implemented by ISO-TP connections.
+// Write transmits p as a single PDU. Read receives a single PDU; if p
+// is too small to hold it, io.ErrShortBuffer is returned. If the read
+// deadline is exceeded, Read returns an error wrapping
+// os.ErrDeadlineExceeded.
+type Transport interface {
+	io.ReadWriteCloser
+	SetReadDeadline(t time.Time) error
+}
+
+// Protocol control information types
+const (
+	pciSF = 0
+	pciFF = 1
+	pciCF = 2
+	pciFC = 3
+)
+
+// Flow status values of flow control frames
+const (
+	fsCTS      = 0
+	fsWait     = 1
+	fsOverflow = 2
+)
+
+// maxPDU is the largest PDU length that can be announced
+// in a first frame using the escape sequence.
+const maxPDU = 1<<32 - 1
+
+var (
+	ErrTimeoutAs   = errors.New("isotp: timeout transmitting frame (N_As)")
+	ErrTimeoutBs   = errors.New("isotp: timeout waiting for flow control (N_Bs)")
+	ErrTimeoutCr   = errors.New("isotp: timeout waiting for consecutive frame (N_Cr)")
+	ErrOverflow    = errors.New("isotp: receiver reported buffer overflow")
+	ErrWaitLimit   = errors.New("isotp: too many flow control wait frames")
+	ErrWrongSN     = errors.New("isotp: wrong sequence number")
+	ErrInvalidPCI  = errors.New("isotp: invalid protocol control information")
+	ErrPDUTooLarge = errors.New("isotp: PDU too large")
+)
+
+// EncodeSTmin converts a separation time into its
+// representation within a flow control frame.
+func EncodeSTmin(d time.Duration) byte {
+	switch {
+	case d <= 0:
+		return 0
+	case d < time.Millisecond:
+		n := (d + 99*time.Microsecond) / (100 * time.Microsecond)
+		return 0xF0 + byte(n)
+	case d > 127*time.Millisecond:
+		return 127
+	}
+	return byte((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// decodeSTmin interprets a separation time value received within
+// a flow control frame. Reserved values are mapped to 127 ms,
+// as required by the standard.
+func decodeSTmin(b byte) time.Duration {
+	switch {
+	case b <= 0x7F:
+		return time.Duration(b) * time.Millisecond
+	case b >= 0xF1 && b <= 0xF9:
+		return time.Duration(b-0xF0) * 100 * time.Microsecond
+	}
+	return 127 * time.Millisecond
+}
@@ -0,0 +1,124 @@
+package isotp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+func openPair(t *testing.T, bus string, cfg *Config) (a, b *Conn) {
+	t.Helper()
+	da, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := *cfg
+	peer.TxID, peer.RxID = cfg.RxID, cfg.TxID
+	peer.TxAddr, peer.RxAddr = cfg.RxAddr, cfg.TxAddr
+	a = NewConn(da, cfg)
+	b = NewConn(db, &peer)
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func pdu(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i * 7)
+	}
+	return p
+}
+
+func TestTransfer(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		n    int
+	}{
+		{"single frame", Config{TxID: 0x7E0, RxID: 0x7E8}, 7},
+		{"padded", Config{TxID: 0x7E0, RxID: 0x7E8, Padding: true, PadByte: 0xCC}, 3},
+		{"multi frame", Config{TxID: 0x7E0, RxID: 0x7E8}, 100},
+		{"block size", Config{TxID: 0x7E0, RxID: 0x7E8, BlockSize: 3, STmin: 500 * time.Microsecond}, 200},
+		{"extended addressing", Config{TxID: 0x18DA10F1, RxID: 0x18DAF110, ExtFrame: true, AddrMode: ExtendedAddr, TxAddr: 0x10, RxAddr: 0xF1}, 50},
+		{"mixed addressing", Config{TxID: 0x18CE10F1, RxID: 0x18CEF110, ExtFrame: true, AddrMode: MixedAddr, TxAddr: 0x42, RxAddr: 0x42}, 30},
+		{"fd single frame", Config{TxID: 0x700, RxID: 0x701, TxDL: 64, BRS: true}, 62},
+		{"fd multi frame", Config{TxID: 0x700, RxID: 0x701, TxDL: 64}, 1000},
+		{"escaped first frame", Config{TxID: 0x700, RxID: 0x701, TxDL: 64}, 5000},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := openPair(t, "isotp"+string(rune('a'+i)), &tt.cfg)
+			want := pdu(tt.n)
+			errc := make(chan error, 1)
+			go func() {
+				_, err := a.Write(want)
+				errc <- err
+			}()
+			b.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 8192)
+			n, err := b.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], want) {
+				t.Errorf("got % x, want % x", buf[:n], want)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestShortBuffer(t *testing.T) {
+	a, b := openPair(t, "isotp-short", &Config{TxID: 1, RxID: 2})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := a.Write(pdu(100))
+		errc <- err
+	}()
+	_, err := b.Read(make([]byte, 50))
+	if err != io.ErrShortBuffer {
+		t.Errorf("Read: got %v, want %v", err, io.ErrShortBuffer)
+	}
+	if err := <-errc; err != ErrOverflow {
+		t.Errorf("Write: got %v, want %v", err, ErrOverflow)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	a, b := openPair(t, "isotp-timeout", &Config{TxID: 1, RxID: 2, NBs: 50 * time.Millisecond})
+
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := b.Read(make([]byte, 10))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read: got %v, want deadline exceeded", err)
+	}
+
+	// Nobody is reading on b, so no flow control will be sent.
+	_, err = a.Write(pdu(20))
+	if err != ErrTimeoutBs {
+		t.Errorf("Write: got %v, want %v", err, ErrTimeoutBs)
+	}
+}
+
+func TestSTmin(t *testing.T) {
+	for _, d := range []time.Duration{0, 100 * time.Microsecond, 900 * time.Microsecond, time.Millisecond, 127 * time.Millisecond} {
+		if got := decodeSTmin(EncodeSTmin(d)); got != d {
+			t.Errorf("STmin %v: got %v", d, got)
+		}
+	}
+}
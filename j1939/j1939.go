@@ -0,0 +1,107 @@
+// Package j1939 implements parts of the SAE J1939 protocol stack
+// on top of a can.Device.
+//
+// It maps 29-bit CAN identifiers to priority, parameter group number
+// (PGN), source and destination addresses, and provides a [Node] that
+// claims an address on the network, and sends and receives messages,
+// using the transport protocol (BAM and RTS/CTS) for messages of up
+// to 1785 bytes.
+package j1939
+
+import (
+	"fmt"
+
+	"github.com/knieriem/can"
+)
+
+// PGN is a parameter group number.
+type PGN uint32
+
+// Parameter group numbers used by the protocol stack
+const (
+	PGNAck            PGN = 0xE800
+	PGNRequest        PGN = 0xEA00
+	PGNTPDT           PGN = 0xEB00
+	PGNTPCM           PGN = 0xEC00
+	PGNAddressClaimed PGN = 0xEE00
+)
+
+// PDU1 reports whether the PGN uses the PDU1 format, in which
+// the PDU specific field contains a destination address.
+func (p PGN) PDU1() bool {
+	return p>>8&0xFF < 240
+}
+
+func (p PGN) String() string {
+	return fmt.Sprintf("%05X", uint32(p))
+}
+
+// Special addresses
+const (
+	// NullAddr is used as source address by nodes
+	// that could not claim an address.
+	NullAddr byte = 0xFE
+
+	// GlobalAddr is the destination address of broadcast messages.
+	GlobalAddr byte = 0xFF
+)
+
+// DefaultPriority is the priority used for most messages.
+const DefaultPriority = 6
+
+// ID is the decoded form of a J1939 CAN identifier.
+type ID struct {
+	Priority uint8
+	PGN      PGN
+
+	// Src is the source address. Dst is the destination address
+	// for PDU1 messages, and GlobalAddr for PDU2 messages.
+	Src byte
+	Dst byte
+}
+
+// ParseID decodes a 29-bit CAN identifier.
+func ParseID(id uint32) ID {
+	var d ID
+	d.Priority = uint8(id >> 26 & 7)
+	d.PGN = PGN(id >> 8 & 0x3FFFF)
+	d.Src = byte(id)
+	d.Dst = GlobalAddr
+	if d.PGN.PDU1() {
+		d.Dst = byte(d.PGN)
+		d.PGN &^= 0xFF
+	}
+	return d
+}
+
+// CANID encodes d into a 29-bit CAN identifier.
+func (d ID) CANID() uint32 {
+	id := uint32(d.Priority&7)<<26 | uint32(d.PGN&0x3FFFF)<<8 | uint32(d.Src)
+	if d.PGN.PDU1() {
+		id = id&^0xFF00 | uint32(d.Dst)<<8
+	}
+	return id
+}
+
+// MsgID decodes the identifier of m. It returns false
+// if m is not a data frame with an extended identifier.
+func MsgID(m *can.Msg) (ID, bool) {
+	if !m.ExtFrame() || m.IsStatus() || m.Test(can.RTRMsg) {
+		return ID{}, false
+	}
+	return ParseID(m.Id), true
+}
+
+func (d ID) String() string {
+	return fmt.Sprintf("%d %v %02X→%02X", d.Priority, d.PGN, d.Src, d.Dst)
+}
+
+// Message is a J1939 message, which may have been
+// transferred using the transport protocol.
+type Message struct {
+	ID
+	Data []byte
+
+	// Time is the receive time stamp of the message's last frame.
+	Time can.Time
+}
@@ -0,0 +1,164 @@
+package j1939
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+)
+
+func TestID(t *testing.T) {
+	tests := []struct {
+		canID uint32
+		id    ID
+	}{
+		{0x0CF00400, ID{Priority: 3, PGN: 0xF004, Src: 0x00, Dst: GlobalAddr}},
+		{0x18FEF100, ID{Priority: 6, PGN: 0xFEF1, Src: 0x00, Dst: GlobalAddr}},
+		{0x18EAFF21, ID{Priority: 6, PGN: PGNRequest, Src: 0x21, Dst: GlobalAddr}},
+		{0x1CEC2A17, ID{Priority: 7, PGN: PGNTPCM, Src: 0x17, Dst: 0x2A}},
+		{0x19DA10F1, ID{Priority: 6, PGN: 0x1DA00, Src: 0xF1, Dst: 0x10}},
+	}
+	for _, tt := range tests {
+		id := ParseID(tt.canID)
+		if id != tt.id {
+			t.Errorf("ParseID(%08X): got %v, want %v", tt.canID, id, tt.id)
+		}
+		if got := tt.id.CANID(); got != tt.canID {
+			t.Errorf("%v: got %08X, want %08X", tt.id, got, tt.canID)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	f := NameFields{
+		IdentityNumber:          0x12345,
+		ManufacturerCode:        0x7FF,
+		ECUInstance:             2,
+		FunctionInstance:        3,
+		Function:                0x81,
+		VehicleSystem:           0x7F,
+		VehicleSystemInstance:   5,
+		IndustryGroup:           1,
+		ArbitraryAddressCapable: true,
+	}
+	n := f.Name()
+	if got := n.Fields(); got != f {
+		t.Errorf("got %+v, want %+v", got, f)
+	}
+	if nameFromBytes(n.Bytes()) != n {
+		t.Error("byte encoding does not round-trip")
+	}
+}
+
+func newNode(t *testing.T, bus string, cfg *Config) *Node {
+	t.Helper()
+	dev, err := can.Open("virtual:" + bus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewNode(dev, cfg)
+	t.Cleanup(func() { n.Close() })
+	return n
+}
+
+func claimAll(t *testing.T, nodes ...*Node) []error {
+	t.Helper()
+	errs := make([]error, len(nodes))
+	done := make(chan struct{})
+	for i, n := range nodes {
+		go func() {
+			errs[i] = n.Claim()
+			done <- struct{}{}
+		}()
+	}
+	for range nodes {
+		<-done
+	}
+	return errs
+}
+
+func TestAddressClaim(t *testing.T) {
+	winner := newNode(t, "j1939-claim", &Config{Name: 0x100, Addr: 0x80})
+	arbitrary := newNode(t, "j1939-claim", &Config{Name: 1<<63 | 0x200, Addr: 0x80})
+	fixed := newNode(t, "j1939-claim", &Config{Name: 0x300, Addr: 0x80})
+
+	errs := claimAll(t, winner, arbitrary, fixed)
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	if errs[2] != ErrAddrLost {
+		t.Errorf("fixed address node: got %v, want %v", errs[2], ErrAddrLost)
+	}
+	if a, ok := winner.Addr(); !ok || a != 0x80 {
+		t.Errorf("winner: got address %#x, %v", a, ok)
+	}
+	a, ok := arbitrary.Addr()
+	if !ok || a == 0x80 || a < 128 || a > 247 {
+		t.Errorf("arbitrary address capable node: got address %#x, %v", a, ok)
+	}
+	if _, ok := fixed.Addr(); ok {
+		t.Error("fixed address node got an address")
+	}
+	if err := fixed.Send(&Message{ID: ID{PGN: 0xFEF1}, Data: []byte{1}}); err != ErrNoAddr {
+		t.Errorf("Send: got %v, want %v", err, ErrNoAddr)
+	}
+	if peers := winner.Peers(); peers[a] != 1<<63|0x200 {
+		t.Errorf("peers: got %v", peers)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	a := newNode(t, "j1939-tp", &Config{Name: 1, Addr: 0x10, BAMInterval: time.Millisecond})
+	b := newNode(t, "j1939-tp", &Config{Name: 2, Addr: 0x20})
+	for _, err := range claimAll(t, a, b) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		dst  byte
+		pgn  PGN
+		size int
+	}{
+		{"single frame", 0x20, 0xEF00, 8},
+		{"broadcast", GlobalAddr, 0xFEE3, 100},
+		{"rts/cts", 0x20, 0xDA00, 1785},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := make([]byte, tt.size)
+			for i := range want {
+				want[i] = byte(i * 3)
+			}
+			errc := make(chan error, 1)
+			go func() {
+				errc <- a.Send(&Message{ID: ID{Priority: DefaultPriority, PGN: tt.pgn, Dst: tt.dst}, Data: want})
+			}()
+			b.SetReadDeadline(time.Now().Add(5 * time.Second))
+			m, err := b.Receive()
+			for err == nil && m.PGN == PGNAddressClaimed {
+				m, err = b.Receive()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatal(err)
+			}
+			if m.PGN != tt.pgn || m.Src != 0x10 || m.Dst != tt.dst {
+				t.Errorf("got %v", m.ID)
+			}
+			if !bytes.Equal(m.Data, want) {
+				t.Errorf("got %d bytes, want %d", len(m.Data), len(want))
+			}
+		})
+	}
+
+	if err := a.Send(&Message{ID: ID{PGN: 0xDA00, Dst: 0x20}, Data: make([]byte, 1786)}); err != ErrTooLarge {
+		t.Errorf("got %v, want %v", err, ErrTooLarge)
+	}
+}
@@ -0,0 +1,75 @@
+package j1939
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Name is the 64-bit NAME of a J1939 node, which identifies it
+// uniquely, and determines its priority in address contention:
+// a lower value takes precedence.
+type Name uint64
+
+// NameFields contains the fields of a Name.
+type NameFields struct {
+	IdentityNumber          uint32 // 21 bits
+	ManufacturerCode        uint16 // 11 bits
+	ECUInstance             uint8  // 3 bits
+	FunctionInstance        uint8  // 5 bits
+	Function                uint8
+	VehicleSystem           uint8 // 7 bits
+	VehicleSystemInstance   uint8 // 4 bits
+	IndustryGroup           uint8 // 3 bits
+	ArbitraryAddressCapable bool
+}
+
+// Name assembles a Name from its fields.
+func (f *NameFields) Name() Name {
+	n := uint64(f.IdentityNumber & 0x1FFFFF)
+	n |= uint64(f.ManufacturerCode&0x7FF) << 21
+	n |= uint64(f.ECUInstance&7) << 32
+	n |= uint64(f.FunctionInstance&0x1F) << 35
+	n |= uint64(f.Function) << 40
+	n |= uint64(f.VehicleSystem&0x7F) << 49
+	n |= uint64(f.VehicleSystemInstance&0xF) << 56
+	n |= uint64(f.IndustryGroup&7) << 60
+	if f.ArbitraryAddressCapable {
+		n |= 1 << 63
+	}
+	return Name(n)
+}
+
+// Fields splits n into its fields.
+func (n Name) Fields() NameFields {
+	return NameFields{
+		IdentityNumber:          uint32(n & 0x1FFFFF),
+		ManufacturerCode:        uint16(n >> 21 & 0x7FF),
+		ECUInstance:             uint8(n >> 32 & 7),
+		FunctionInstance:        uint8(n >> 35 & 0x1F),
+		Function:                uint8(n >> 40),
+		VehicleSystem:           uint8(n >> 49 & 0x7F),
+		VehicleSystemInstance:   uint8(n >> 56 & 0xF),
+		IndustryGroup:           uint8(n >> 60 & 7),
+		ArbitraryAddressCapable: n>>63 != 0,
+	}
+}
+
+// ArbitraryAddressCapable reports whether a node with this Name
+// is able to select a different address in case of a conflict.
+func (n Name) ArbitraryAddressCapable() bool {
+	return n>>63 != 0
+}
+
+// Bytes returns n in the little-endian encoding
+// used in address claimed messages.
+func (n Name) Bytes() []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(n))
+}
+
+func (n Name) String() string {
+	return fmt.Sprintf("%016X", uint64(n))
+}
+
+func nameFromBytes(b []byte) Name {
+	return Name(binary.LittleEndian.Uint64(b))
+}
@@ -0,0 +1,401 @@
+package j1939
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+// Config defines the parameters of a Node.
+type Config struct {
+	Name Name
+
+	// Addr is the preferred address of the node. If it has already
+	// been claimed by a node with a higher priority Name, and Name is
+	// arbitrary address capable, a free address in the range 128 to 247
+	// is claimed instead.
+	Addr byte
+
+	// If Promiscuous is set, messages addressed to other nodes are
+	// received too. Transport protocol sessions between other nodes
+	// are not reassembled, though, except for broadcasts.
+	Promiscuous bool
+
+	// BAMInterval is the time between the data packets of broadcast
+	// messages sent using the transport protocol. A zero value
+	// means 50 ms.
+	BAMInterval time.Duration
+}
+
+// claimTime is the time a node waits for contending
+// claims before it may use an address.
+const claimTime = 250 * time.Millisecond
+
+type claimState int
+
+const (
+	unclaimed claimState = iota
+	claiming
+	claimed
+	cannotClaim
+)
+
+var (
+	ErrAddrLost = errors.New("j1939: address claim lost")
+	ErrNoAddr   = errors.New("j1939: no address claimed")
+	ErrTooLarge = errors.New("j1939: message too large")
+	ErrTimeout  = errors.New("j1939: transport protocol timeout")
+	errClosed   = errors.New("j1939: use of closed node")
+)
+
+// rxQueueLen is the number of received messages that may be queued
+// while Receive is not waiting for them; further messages are dropped.
+const rxQueueLen = 256
+
+// A Node is a J1939 control application attached to a can.Device.
+//
+// The Node takes over the device: it starts a goroutine reading from
+// the device, which handles address claims and transport protocol
+// sessions, and queues received messages for Receive.
+// Closing the Node closes the device.
+type Node struct {
+	dev can.Device
+	cfg Config
+
+	rx       chan *Message
+	done     chan struct{}
+	readDone chan struct{}
+	readErr  error
+
+	wmu sync.Mutex // frame writes
+	smu sync.Mutex // Send calls
+
+	mu        sync.Mutex
+	addr      byte
+	state     claimState
+	claimGen  int
+	claimc    chan error
+	peers     map[byte]Name
+	sessions  map[sessionKey]*rxSession
+	txPeer    int
+	txc       chan []byte
+	deadline  time.Time
+	closeOnce sync.Once
+}
+
+// NewNode creates a node on dev. Before sending messages,
+// an address must be claimed using Claim.
+func NewNode(dev can.Device, cfg *Config) *Node {
+	n := new(Node)
+	n.dev = dev
+	n.cfg = *cfg
+	n.addr = NullAddr
+	n.peers = make(map[byte]Name)
+	n.sessions = make(map[sessionKey]*rxSession)
+	n.txPeer = -1
+	n.txc = make(chan []byte, 4)
+	n.rx = make(chan *Message, rxQueueLen)
+	n.done = make(chan struct{})
+	n.readDone = make(chan struct{})
+	go n.readLoop()
+	return n
+}
+
+// Claim claims the preferred address of the node, or, if the node is
+// arbitrary address capable, another free address. It returns after
+// no contending claim has been received for 250 ms. If the node lost
+// the address, and no other address could be claimed, ErrAddrLost
+// is returned.
+func (n *Node) Claim() error {
+	c := make(chan error, 1)
+	n.mu.Lock()
+	n.claimc = c
+	n.startClaim(n.cfg.Addr)
+	n.mu.Unlock()
+
+	select {
+	case err := <-c:
+		return err
+	case <-n.done:
+		return errClosed
+	case <-n.readDone:
+		return n.readErr
+	}
+}
+
+// startClaim sends an address claimed message for addr, and arranges
+// for the node's state to change to claimed after claimTime, unless
+// a contending claim is received. It must be called with n.mu held.
+func (n *Node) startClaim(addr byte) {
+	n.addr = addr
+	n.state = claiming
+	n.claimGen++
+	gen := n.claimGen
+	n.sendClaim(addr)
+	time.AfterFunc(claimTime, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.claimGen == gen && n.state == claiming {
+			n.state = claimed
+			n.notifyClaim(nil)
+		}
+	})
+}
+
+func (n *Node) notifyClaim(err error) {
+	if n.claimc != nil {
+		n.claimc <- err
+		n.claimc = nil
+	}
+}
+
+func (n *Node) sendClaim(addr byte) error {
+	return n.sendFrame(ID{Priority: DefaultPriority, PGN: PGNAddressClaimed, Src: addr, Dst: GlobalAddr}, n.cfg.Name.Bytes())
+}
+
+// Addr returns the address claimed by the node. If no address
+// has been claimed (yet), ok is false.
+func (n *Node) Addr() (addr byte, ok bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.addr, n.state == claimed
+}
+
+// Peers returns the addresses and names of the nodes
+// that have claimed an address on the network.
+func (n *Node) Peers() map[byte]Name {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	m := make(map[byte]Name, len(n.peers))
+	for a, name := range n.peers {
+		m[a] = name
+	}
+	return m
+}
+
+// Request sends a request for the specified PGN to dst,
+// which may be GlobalAddr.
+func (n *Node) Request(pgn PGN, dst byte) error {
+	return n.Send(&Message{
+		ID:   ID{Priority: DefaultPriority, PGN: PGNRequest, Dst: dst},
+		Data: []byte{byte(pgn), byte(pgn >> 8), byte(pgn >> 16)},
+	})
+}
+
+// handleClaim processes an address claimed message.
+func (n *Node) handleClaim(src byte, data []byte) {
+	if len(data) < 8 {
+		return
+	}
+	name := nameFromBytes(data)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for a, pn := range n.peers {
+		if pn == name {
+			delete(n.peers, a)
+		}
+	}
+	if src != NullAddr {
+		n.peers[src] = name
+	}
+	if src != n.addr || name == n.cfg.Name {
+		return
+	}
+	if n.state != claiming && n.state != claimed {
+		return
+	}
+	if n.cfg.Name < name {
+		// our claim takes precedence
+		n.sendClaim(n.addr)
+		return
+	}
+	if n.cfg.Name.ArbitraryAddressCapable() {
+		if a, ok := n.freeAddr(); ok {
+			n.startClaim(a)
+			return
+		}
+	}
+	n.addr = NullAddr
+	n.state = cannotClaim
+	n.sendClaim(NullAddr)
+	n.notifyClaim(ErrAddrLost)
+}
+
+// freeAddr returns an address from the range for arbitrary
+// address capable nodes that has not been claimed by another node.
+func (n *Node) freeAddr() (byte, bool) {
+	for a := byte(128); a <= 247; a++ {
+		if _, used := n.peers[a]; !used {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// handleRequest answers requests for the address claimed message.
+func (n *Node) handleRequest(id ID, data []byte) {
+	if len(data) < 3 || PGN(data[0])|PGN(data[1])<<8|PGN(data[2])<<16 != PGNAddressClaimed {
+		return
+	}
+	n.mu.Lock()
+	addr, state := n.addr, n.state
+	n.mu.Unlock()
+	if id.Dst != GlobalAddr && id.Dst != addr {
+		return
+	}
+	switch state {
+	case claiming, claimed, cannotClaim:
+		n.sendClaim(addr)
+	}
+}
+
+// addressed reports whether a message with the
+// specified identifier should be received.
+func (n *Node) addressed(id ID) bool {
+	if n.cfg.Promiscuous || id.Dst == GlobalAddr {
+		return true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return id.Dst == n.addr && (n.state == claiming || n.state == claimed)
+}
+
+func (n *Node) readLoop() {
+	buf := make([]can.Msg, 8)
+	for {
+		nm, err := n.dev.Read(buf)
+		if err != nil {
+			n.readErr = err
+			close(n.readDone)
+			return
+		}
+		for i := range buf[:nm] {
+			m := &buf[i]
+			id, ok := MsgID(m)
+			if !ok {
+				continue
+			}
+			data := slices.Clone(m.Data())
+			switch id.PGN {
+			case PGNAddressClaimed:
+				n.handleClaim(id.Src, data)
+			case PGNRequest:
+				n.handleRequest(id, data)
+			case PGNTPCM:
+				n.handleCM(id, data)
+				continue
+			case PGNTPDT:
+				n.handleDT(id, data, m.Rx.Time)
+				continue
+			}
+			if n.addressed(id) {
+				n.deliver(&Message{ID: id, Data: data, Time: m.Rx.Time})
+			}
+		}
+	}
+}
+
+func (n *Node) deliver(m *Message) {
+	select {
+	case n.rx <- m:
+	default:
+	}
+}
+
+// SetReadDeadline sets the deadline for subsequent Receive calls.
+// A zero value disables the deadline.
+func (n *Node) SetReadDeadline(t time.Time) error {
+	n.mu.Lock()
+	n.deadline = t
+	n.mu.Unlock()
+	return nil
+}
+
+// Receive returns the next message addressed to the node,
+// or broadcast. Messages of up to eight bytes are received
+// as they are; longer messages are reassembled from transport
+// protocol sessions.
+func (n *Node) Receive() (*Message, error) {
+	n.mu.Lock()
+	deadline := n.deadline
+	n.mu.Unlock()
+
+	var dlc <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		dlc = t.C
+	}
+	select {
+	case m := <-n.rx:
+		return m, nil
+	case <-dlc:
+		return nil, fmt.Errorf("j1939: receive: %w", os.ErrDeadlineExceeded)
+	case <-n.done:
+		return nil, errClosed
+	case <-n.readDone:
+		return nil, n.readErr
+	}
+}
+
+// Send transmits a message, using the node's address as source address.
+// Messages longer than eight bytes are sent using the transport
+// protocol: as broadcast (BAM), if m.Dst is GlobalAddr, or using
+// an RTS/CTS session with the destination. In the latter case, Send
+// returns after the destination has acknowledged the message.
+func (n *Node) Send(m *Message) error {
+	n.smu.Lock()
+	defer n.smu.Unlock()
+
+	addr, ok := n.Addr()
+	if !ok {
+		return ErrNoAddr
+	}
+	if len(m.Data) > maxTPSize {
+		return ErrTooLarge
+	}
+	id := m.ID
+	id.Src = addr
+	if len(m.Data) <= 8 {
+		return n.sendFrame(id, m.Data)
+	}
+	if id.Dst == GlobalAddr {
+		return n.sendBAM(id, m.Data)
+	}
+	return n.sendRTS(id, m.Data)
+}
+
+// sendFrame transmits a single frame.
+func (n *Node) sendFrame(id ID, data []byte) error {
+	var m can.Msg
+	m.Id = id.CANID()
+	m.Flags = can.ExtFrame
+	m.SetData(data)
+
+	n.wmu.Lock()
+	defer n.wmu.Unlock()
+	start := time.Now()
+	for {
+		err := n.dev.WriteMsg(&m)
+		if !errors.Is(err, can.ErrTxQueueFull) || time.Since(start) > time.Second {
+			return err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Close closes the node and the underlying device.
+func (n *Node) Close() error {
+	err := errClosed
+	n.closeOnce.Do(func() {
+		close(n.done)
+		err = n.dev.Close()
+	})
+	return err
+}
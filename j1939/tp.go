@@ -0,0 +1,316 @@
+package j1939
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/knieriem/can"
+)
+
+// maxTPSize is the maximum size of a message
+// sent using the transport protocol.
+const maxTPSize = 255 * 7
+
+// Connection management control bytes
+const (
+	cmRTS   = 16
+	cmCTS   = 17
+	cmEOMA  = 19
+	cmBAM   = 32
+	cmAbort = 255
+)
+
+// Abort reasons
+const (
+	abortTimeout = 3
+	abortBadSeq  = 7
+	abortBadSize = 9
+)
+
+const tpPriority = 7
+
+// Transport protocol timeouts
+const (
+	tpT1 = 750 * time.Millisecond
+	tpT2 = 1250 * time.Millisecond
+	tpT3 = 1250 * time.Millisecond
+	tpT4 = 1050 * time.Millisecond
+)
+
+// AbortError is returned by Send if the receiver
+// aborted a transport protocol session.
+type AbortError struct {
+	Reason byte
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("j1939: transport session aborted (reason %d)", e.Reason)
+}
+
+type sessionKey struct {
+	src, dst byte
+}
+
+// rxSession is a transport protocol session
+// initiated by another node.
+type rxSession struct {
+	id       ID
+	size     int
+	packets  int
+	maxCTS   int
+	next     int
+	endBlock int
+	data     []byte
+	bam      bool
+	timer    *time.Timer
+}
+
+// cm encodes a connection management message.
+func cm(ctrl byte, b1, b2, b3, b4 byte, pgn PGN) []byte {
+	return []byte{ctrl, b1, b2, b3, b4, byte(pgn), byte(pgn >> 8), byte(pgn >> 16)}
+}
+
+func (n *Node) sendCM(src, dst byte, data []byte) error {
+	return n.sendFrame(ID{Priority: tpPriority, PGN: PGNTPCM, Src: src, Dst: dst}, data)
+}
+
+func (n *Node) sendAbort(src, dst byte, reason byte, pgn PGN) error {
+	return n.sendCM(src, dst, cm(cmAbort, reason, 0xFF, 0xFF, 0xFF, pgn))
+}
+
+// sendDT sends the data packet with sequence number seq.
+func (n *Node) sendDT(src, dst byte, seq int, data []byte) error {
+	b := make([]byte, 8)
+	b[0] = byte(seq)
+	i := copy(b[1:], data[min((seq-1)*7, len(data)):])
+	for i++; i < 8; i++ {
+		b[i] = 0xFF
+	}
+	return n.sendFrame(ID{Priority: tpPriority, PGN: PGNTPDT, Src: src, Dst: dst}, b)
+}
+
+func numPackets(size int) int {
+	return (size + 6) / 7
+}
+
+func (n *Node) sendBAM(id ID, data []byte) error {
+	np := numPackets(len(data))
+	size := uint16(len(data))
+	err := n.sendCM(id.Src, GlobalAddr, cm(cmBAM, byte(size), byte(size>>8), byte(np), 0xFF, id.PGN))
+	if err != nil {
+		return err
+	}
+	interval := n.cfg.BAMInterval
+	if interval == 0 {
+		interval = 50 * time.Millisecond
+	}
+	for seq := 1; seq <= np; seq++ {
+		time.Sleep(interval)
+		if err := n.sendDT(id.Src, GlobalAddr, seq, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *Node) sendRTS(id ID, data []byte) error {
+	n.mu.Lock()
+	n.txPeer = int(id.Dst)
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.txPeer = -1
+		n.mu.Unlock()
+	}()
+drain:
+	for {
+		select {
+		case <-n.txc:
+		default:
+			break drain
+		}
+	}
+
+	np := numPackets(len(data))
+	size := uint16(len(data))
+	err := n.sendCM(id.Src, id.Dst, cm(cmRTS, byte(size), byte(size>>8), byte(np), 0xFF, id.PGN))
+	if err != nil {
+		return err
+	}
+	timeout := tpT3
+	for {
+		var f []byte
+		select {
+		case f = <-n.txc:
+		case <-time.After(timeout):
+			n.sendAbort(id.Src, id.Dst, abortTimeout, id.PGN)
+			return ErrTimeout
+		case <-n.done:
+			return errClosed
+		}
+		switch f[0] {
+		case cmCTS:
+			count, next := int(f[1]), int(f[2])
+			if count == 0 {
+				// hold the connection open
+				timeout = tpT4
+				continue
+			}
+			if next < 1 || next+count-1 > np {
+				n.sendAbort(id.Src, id.Dst, abortBadSeq, id.PGN)
+				return &AbortError{Reason: abortBadSeq}
+			}
+			for seq := next; seq < next+count; seq++ {
+				if err := n.sendDT(id.Src, id.Dst, seq, data); err != nil {
+					return err
+				}
+			}
+			timeout = tpT3
+		case cmEOMA:
+			return nil
+		case cmAbort:
+			return &AbortError{Reason: f[1]}
+		}
+	}
+}
+
+// handleCM processes a connection management message.
+func (n *Node) handleCM(id ID, data []byte) {
+	if len(data) < 8 {
+		return
+	}
+	pgn := PGN(data[5]) | PGN(data[6])<<8 | PGN(data[7])<<16
+	key := sessionKey{id.Src, id.Dst}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ours := id.Dst == n.addr && (n.state == claiming || n.state == claimed)
+
+	switch data[0] {
+	case cmBAM:
+		if id.Dst != GlobalAddr {
+			return
+		}
+		n.startSession(key, id, pgn, data, true)
+	case cmRTS:
+		if !ours {
+			return
+		}
+		if s := n.startSession(key, id, pgn, data, false); s != nil {
+			n.sendCTS(s)
+		}
+	case cmCTS, cmEOMA:
+		if ours && int(id.Src) == n.txPeer {
+			select {
+			case n.txc <- data:
+			default:
+			}
+		}
+	case cmAbort:
+		if s := n.sessions[key]; s != nil {
+			n.endSession(key, s)
+		}
+		if ours && int(id.Src) == n.txPeer {
+			select {
+			case n.txc <- data:
+			default:
+			}
+		}
+	}
+}
+
+// startSession sets up a session for a BAM or RTS message.
+// It must be called with n.mu held.
+func (n *Node) startSession(key sessionKey, id ID, pgn PGN, data []byte, bam bool) *rxSession {
+	size := int(binary.LittleEndian.Uint16(data[1:]))
+	np := int(data[3])
+	if old := n.sessions[key]; old != nil {
+		n.endSession(key, old)
+	}
+	if size <= 8 || size > maxTPSize || np != numPackets(size) {
+		if !bam {
+			n.sendAbort(id.Dst, id.Src, abortBadSize, pgn)
+		}
+		return nil
+	}
+	s := new(rxSession)
+	s.id = ID{Priority: id.Priority, PGN: pgn, Src: id.Src, Dst: id.Dst}
+	s.size = size
+	s.packets = np
+	s.maxCTS = int(data[4])
+	s.next = 1
+	s.data = make([]byte, 0, np*7)
+	s.bam = bam
+	d := tpT1
+	if !bam {
+		d = tpT2
+	}
+	s.timer = time.AfterFunc(d, func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if n.sessions[key] != s {
+			return
+		}
+		n.endSession(key, s)
+		if !s.bam {
+			n.sendAbort(key.dst, key.src, abortTimeout, s.id.PGN)
+		}
+	})
+	n.sessions[key] = s
+	return s
+}
+
+func (n *Node) endSession(key sessionKey, s *rxSession) {
+	s.timer.Stop()
+	delete(n.sessions, key)
+}
+
+// sendCTS requests the next block of data packets.
+func (n *Node) sendCTS(s *rxSession) {
+	count := s.packets - s.next + 1
+	if s.maxCTS != 0 {
+		count = min(count, s.maxCTS)
+	}
+	s.endBlock = s.next + count - 1
+	n.sendCM(s.id.Dst, s.id.Src, cm(cmCTS, byte(count), byte(s.next), 0xFF, 0xFF, s.id.PGN))
+}
+
+// handleDT processes a data packet.
+func (n *Node) handleDT(id ID, data []byte, t can.Time) {
+	if len(data) < 2 {
+		return
+	}
+	key := sessionKey{id.Src, id.Dst}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := n.sessions[key]
+	if s == nil {
+		return
+	}
+	if int(data[0]) != s.next {
+		n.endSession(key, s)
+		if !s.bam {
+			n.sendAbort(key.dst, key.src, abortBadSeq, s.id.PGN)
+		}
+		return
+	}
+	s.data = append(s.data, data[1:]...)
+	s.next++
+	if s.next <= s.packets {
+		if !s.bam && s.next > s.endBlock {
+			n.sendCTS(s)
+			s.timer.Reset(tpT2)
+		} else {
+			s.timer.Reset(tpT1)
+		}
+		return
+	}
+	n.endSession(key, s)
+	if !s.bam {
+		size := uint16(s.size)
+		n.sendCM(key.dst, key.src, cm(cmEOMA, byte(size), byte(size>>8), byte(s.packets), 0xFF, s.id.PGN))
+	}
+	n.deliver(&Message{ID: s.id, Data: s.data[:s.size], Time: t})
+}
@@ -32,10 +32,19 @@ const (
 	BusOff
 	DataOverrun
 	ReceiveBufferOverflow
+
+	// FD error state indicator: set on received FD frames
+	// if the transmitting node was error passive
+	FDErrorStateInd
+
+	// CAN XL specific flags
+	XLFrame  // the message is a CAN XL frame, see Msg.XL
+	XLSecure // simple extended content (SEC) indicator
 )
 
 // Reports wether the message is a status message, not a data message.
-// In the first case, Msg fields Id, Len and Data should not be interpreted.
+// In the first case, Msg fields Id, Len and Data should not be interpreted
+// directly; details of the error may be available through Msg.BusError.
 func (f Flags) IsStatus() bool {
 	return f&StatusMsg != 0
 }
@@ -46,6 +55,11 @@ func (f Flags) ExtFrame() bool {
 	return f&ExtFrame != 0
 }
 
+// Reports whether the message is a CAN XL frame.
+func (f Flags) XLFrame() bool {
+	return f&XLFrame != 0
+}
+
 func (f Flags) Test(t Flags) bool {
 	return (f & t) == t
 }
@@ -55,6 +69,10 @@ type Msg struct {
 	Id uint32 // The CAN message identifier
 	Flags
 
+	// XL contains the additional header fields of CAN XL frames;
+	// for these, Id contains the 11 bit priority identifier.
+	XL XLHeader
+
 	// data contains the payload of the CAN message.
 	// It can be accessed through Data() and SetData()
 	buf        DataBuffer
@@ -65,6 +83,13 @@ type Msg struct {
 	}
 }
 
+// XLHeader contains the header fields specific to CAN XL frames.
+type XLHeader struct {
+	SDT  uint8  // SDU (service data unit) type
+	VCID uint8  // virtual CAN network ID
+	AF   uint32 // acceptance field
+}
+
 func (m *Msg) Release() {
 	if m.buf == nil {
 		return
@@ -210,7 +235,9 @@ func (pd *PlainData) Reset() {
 func (m *Msg) Reset() {
 	m.Id = 0
 	m.Flags = 0
+	m.XL = XLHeader{}
 	if m.buf == nil {
+		m.stdPayload.Reset()
 		return
 	}
 	m.buf.Reset()
@@ -235,6 +262,18 @@ func VerifyDataLenFD(n int) (next int, needsFD bool, err error) {
 	return 0, needsFD, ErrInvalidMsgLen
 }
 
+// MaxXLDataLen is the maximum payload length of a CAN XL frame.
+const MaxXLDataLen = 2048
+
+// VerifyDataLenXL checks whether n is a valid
+// payload length of a CAN XL frame.
+func VerifyDataLenXL(n int) error {
+	if n < 1 || n > MaxXLDataLen {
+		return ErrInvalidMsgLen
+	}
+	return nil
+}
+
 var ErrInvalidMsgLen = errors.New("invalid message length")
 
 var ErrMsgCapExceeded = errors.New("message capacity too small")
@@ -245,7 +284,16 @@ var ErrMsgCapExceeded = errors.New("message capacity too small")
 //
 // CAN ID and data, separated by '#' or ':', must be specified in
 // hexadecimal format. An FD frame can be forced using a double separator,
-// followed by a CAN flags hex nibble; supported FD flags: BRS = 0b0001.
+// followed by a CAN flags hex nibble; supported FD flags: BRS = 0b0001,
+// ESI = 0b0010.
+//
+// A CAN XL frame is specified like
+//
+//	<vcid><prio>#<flags>:<sdt>:<af>#<data>
+//
+// where the optional vcid consists of two hex characters, prio of three,
+// and the acceptance field af of eight characters. Of the two flags hex
+// characters, only the SEC bit, 0x01, is interpreted.
 //
 // The string may not contain white-space, but '.' can be used to
 // separate data bytes.
@@ -253,11 +301,13 @@ func (m *Msg) FromExpr(expr string) error {
 	if i := strings.IndexAny(expr, "#:"); i != -1 {
 		sep := expr[i]
 		sID := expr[:i]
+		var id uint64
 		if sID != "" {
 			if len(sID) > 3 {
 				m.Flags |= ExtFrame
 			}
-			id, err := strconv.ParseUint(sID, 16, 32)
+			var err error
+			id, err = strconv.ParseUint(sID, 16, 32)
 			if err != nil {
 				return err
 			}
@@ -268,7 +318,19 @@ func (m *Msg) FromExpr(expr string) error {
 		if len(expr) == 0 {
 			return nil
 		}
-		if expr[0] == sep {
+		if j := strings.IndexByte(expr, sep); sep == '#' && j > 0 {
+			if len(sID) > 5 {
+				return errors.New("CAN XL priority out of range")
+			}
+			m.Flags &^= ExtFrame
+			m.Flags |= XLFrame
+			m.Id = uint32(id & 0xFFF)
+			m.XL.VCID = uint8(id >> 12)
+			if err := m.XL.fromExpr(m, expr[:j]); err != nil {
+				return err
+			}
+			expr = expr[j+1:]
+		} else if expr[0] == sep {
 			m.Flags |= ForceFD
 			expr = expr[1:]
 			if expr == "" {
@@ -281,6 +343,9 @@ func (m *Msg) FromExpr(expr string) error {
 			if u&1 != 0 {
 				m.Flags |= FDSwitchBitrate
 			}
+			if u&2 != 0 {
+				m.Flags |= FDErrorStateInd
+			}
 			expr = expr[1:]
 		}
 		if expr == "R" {
@@ -304,6 +369,32 @@ func (m *Msg) FromExpr(expr string) error {
 	return nil
 }
 
+// fromExpr parses the <flags>:<sdt>:<af> part of a CAN XL expression.
+func (h *XLHeader) fromExpr(m *Msg, expr string) error {
+	f := strings.Split(expr, ":")
+	if len(f) != 3 {
+		return errors.New("CAN XL header: syntax error")
+	}
+	flags, err := strconv.ParseUint(f[0], 16, 8)
+	if err != nil {
+		return err
+	}
+	if flags&1 != 0 {
+		m.Flags |= XLSecure
+	}
+	sdt, err := strconv.ParseUint(f[1], 16, 8)
+	if err != nil {
+		return err
+	}
+	h.SDT = uint8(sdt)
+	af, err := strconv.ParseUint(f[2], 16, 32)
+	if err != nil {
+		return err
+	}
+	h.AF = uint32(af)
+	return nil
+}
+
 var dots = strings.NewReplacer(".", "")
 
 type DataBufPool interface {
@@ -0,0 +1,26 @@
+package can
+
+import "testing"
+
+func TestMsgReset(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		attach bool
+	}{
+		{"standard payload", false},
+		{"attached buffer", true},
+	} {
+		var m Msg
+		if tc.attach {
+			pd := make(PlainData, 0, 64)
+			m.Attach(&pd)
+		}
+		m.Id = 0x123
+		m.Flags = ExtFrame
+		m.SetData([]byte{1, 2, 3})
+		m.Reset()
+		if m.Id != 0 || m.Flags != 0 || len(m.Data()) != 0 {
+			t.Errorf("%s: message not reset: %+v, data % x", tc.name, m, m.Data())
+		}
+	}
+}
@@ -62,7 +62,8 @@ type Constraints struct {
 }
 
 // Controller contains the device specific limits for the
-// bit timings of the nominal and data bitrates.
+// bit timings of the nominal and data bitrates. Data and XLData
+// are nil if the controller does not support FD resp. XL mode.
 type Controller struct {
 	// Clock is the Controller's default clock frequency
 	Clock uint32
@@ -74,6 +75,7 @@ type Controller struct {
 
 	Nominal Constraints
 	Data    *Constraints
+	XLData  *Constraints
 }
 
 // RegValue contains the bit timing value encoded into
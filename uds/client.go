@@ -0,0 +1,315 @@
+package uds
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can/isotp"
+)
+
+// latency is added to the timing parameters reported by a server
+// to account for delays caused by the transport layer.
+const latency = 50 * time.Millisecond
+
+// maxResponse is the size of the buffer used for receiving responses.
+const maxResponse = 1 << 16
+
+// Client sends requests to a UDS server using an ISO-TP transport.
+// Its methods may be called from multiple goroutines; requests are
+// serialized.
+type Client struct {
+	t isotp.Transport
+
+	// P2 is the time to wait for a response, P2Star the time to wait
+	// after a "response pending" negative response has been received.
+	// Both are updated by DiagnosticSessionControl from the values
+	// reported by the server.
+	P2     time.Duration
+	P2Star time.Duration
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewClient returns a client that uses t to communicate with a server.
+// The transport is not closed by the client.
+func NewClient(t isotp.Transport) *Client {
+	c := new(Client)
+	c.t = t
+	c.P2 = DefaultP2 + latency
+	c.P2Star = DefaultP2Star + latency
+	return c
+}
+
+// Request sends a request for service svc, with data containing the
+// bytes following the service identifier, and returns the data of the
+// positive response following the response service identifier.
+// Negative responses are returned as *NegativeResponseError; "response
+// pending" notifications extend the time waited for the final response.
+// If the request contains a sub-function with the "suppress positive
+// response" bit set, Request does not wait for a response.
+func (c *Client) Request(svc Service, data []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.request(svc, data)
+}
+
+func (c *Client) request(svc Service, data []byte) ([]byte, error) {
+	req := append([]byte{byte(svc)}, data...)
+	if _, err := c.t.Write(req); err != nil {
+		return nil, err
+	}
+	if svc.hasSubFunction() && len(data) != 0 && data[0]&suppressPosResponse != 0 {
+		return nil, nil
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, maxResponse)
+	}
+	deadline := time.Now().Add(c.P2)
+	for {
+		c.t.SetReadDeadline(deadline)
+		n, err := c.t.Read(c.buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, ErrTimeout
+			}
+			return nil, err
+		}
+		resp := c.buf[:n]
+		if n == 0 {
+			continue
+		}
+		switch resp[0] {
+		case negativeResponse:
+			if n < 3 {
+				return nil, ErrInvalidResponse
+			}
+			if Service(resp[1]) != svc {
+				continue
+			}
+			code := NRC(resp[2])
+			if code == ResponsePending {
+				deadline = time.Now().Add(c.P2Star)
+				continue
+			}
+			return nil, &NegativeResponseError{Service: svc, Code: code}
+		case byte(svc) + positiveOffset:
+			return slices.Clone(resp[1:]), nil
+		}
+		// a late response to a different request; ignore it
+	}
+}
+
+// requestSub sends a request containing a sub-function, and verifies
+// that it is echoed in the response, which is returned without it.
+func (c *Client) requestSub(svc Service, sub byte, data ...byte) ([]byte, error) {
+	resp, err := c.Request(svc, append([]byte{sub}, data...))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 || resp[0] != sub {
+		return nil, ErrInvalidResponse
+	}
+	return resp[1:], nil
+}
+
+// DiagnosticSessionControl switches to the specified session.
+// The timing parameters reported by the server are returned,
+// and used to update the client's P2 and P2Star values.
+func (c *Client) DiagnosticSessionControl(s Session) (*SessionTiming, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp, err := c.request(SvcDiagnosticSessionControl, []byte{byte(s)})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 5 || resp[0] != byte(s) {
+		return nil, ErrInvalidResponse
+	}
+	t := new(SessionTiming)
+	t.P2 = time.Duration(binary.BigEndian.Uint16(resp[1:])) * time.Millisecond
+	t.P2Star = time.Duration(binary.BigEndian.Uint16(resp[3:])) * 10 * time.Millisecond
+	c.P2 = t.P2 + latency
+	c.P2Star = t.P2Star + latency
+	return t, nil
+}
+
+// ECUReset requests a reset of the server.
+func (c *Client) ECUReset(t ResetType) error {
+	_, err := c.requestSub(SvcECUReset, byte(t))
+	return err
+}
+
+// ReadDataByIdentifier reads the value of a data identifier.
+func (c *Client) ReadDataByIdentifier(did uint16) ([]byte, error) {
+	resp, err := c.Request(SvcReadDataByIdentifier, binary.BigEndian.AppendUint16(nil, did))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != did {
+		return nil, ErrInvalidResponse
+	}
+	return resp[2:], nil
+}
+
+// WriteDataByIdentifier writes the value of a data identifier.
+func (c *Client) WriteDataByIdentifier(did uint16, data []byte) error {
+	req := binary.BigEndian.AppendUint16(nil, did)
+	resp, err := c.Request(SvcWriteDataByIdentifier, append(req, data...))
+	if err != nil {
+		return err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != did {
+		return ErrInvalidResponse
+	}
+	return nil
+}
+
+var errSecurityLevel = errors.New("uds: security level must be odd")
+
+// SecurityAccess unlocks the specified security level, which
+// must be odd. It requests a seed from the server, computes
+// the key using the key function, and sends it to the server.
+// If the server reports an all-zero seed, the level is already
+// unlocked, and the key function is not called.
+func (c *Client) SecurityAccess(level byte, key KeyFunc) error {
+	if level&1 == 0 || level >= 0x7F {
+		return errSecurityLevel
+	}
+	seed, err := c.requestSub(SvcSecurityAccess, level)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(seed, func(b byte) bool { return b != 0 }) {
+		return nil
+	}
+	k, err := key(level, seed)
+	if err != nil {
+		return err
+	}
+	_, err = c.requestSub(SvcSecurityAccess, level+1, k...)
+	return err
+}
+
+// RoutineControl starts or stops a routine, or requests its results.
+// The routine status record contained in the response is returned.
+func (c *Client) RoutineControl(t RoutineControlType, id uint16, opt []byte) ([]byte, error) {
+	req := binary.BigEndian.AppendUint16(nil, id)
+	resp, err := c.requestSub(SvcRoutineControl, byte(t), append(req, opt...)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != id {
+		return nil, ErrInvalidResponse
+	}
+	return resp[2:], nil
+}
+
+// RequestDownload initiates a download of size bytes to the memory
+// at addr. The data format identifier specifies the compression and
+// encryption methods, 0 meaning neither is used. The maximum length
+// of TransferData requests accepted by the server, including the
+// service identifier and the block sequence counter, is returned.
+func (c *Client) RequestDownload(addr, size uint32, dataFormat byte) (maxBlockLen int, err error) {
+	req := []byte{dataFormat, 0x44}
+	req = binary.BigEndian.AppendUint32(req, addr)
+	req = binary.BigEndian.AppendUint32(req, size)
+	resp, err := c.Request(SvcRequestDownload, req)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) == 0 {
+		return 0, ErrInvalidResponse
+	}
+	n := int(resp[0] >> 4)
+	if n == 0 || n > 4 || len(resp) < 1+n {
+		return 0, ErrInvalidResponse
+	}
+	for _, b := range resp[1 : 1+n] {
+		maxBlockLen = maxBlockLen<<8 | int(b)
+	}
+	return maxBlockLen, nil
+}
+
+// TransferData transfers a block of data, identified by the block
+// sequence counter seq, which starts at 1 and wraps from 255 to 0.
+// The transfer response parameters are returned.
+func (c *Client) TransferData(seq byte, data []byte) ([]byte, error) {
+	resp, err := c.Request(SvcTransferData, append([]byte{seq}, data...))
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 || resp[0] != seq {
+		return nil, ErrInvalidResponse
+	}
+	return resp[1:], nil
+}
+
+// RequestTransferExit terminates a data transfer.
+// The transfer response parameters are returned.
+func (c *Client) RequestTransferExit(params []byte) ([]byte, error) {
+	return c.Request(SvcRequestTransferExit, params)
+}
+
+// Download transfers data to the memory at addr, using RequestDownload,
+// a sequence of TransferData requests, and RequestTransferExit.
+func (c *Client) Download(addr uint32, data []byte, dataFormat byte) error {
+	maxBlockLen, err := c.RequestDownload(addr, uint32(len(data)), dataFormat)
+	if err != nil {
+		return err
+	}
+	n := maxBlockLen - 2
+	if n <= 0 {
+		return ErrInvalidResponse
+	}
+	seq := byte(1)
+	for len(data) != 0 {
+		block := data[:min(n, len(data))]
+		if _, err := c.TransferData(seq, block); err != nil {
+			return err
+		}
+		data = data[len(block):]
+		seq++
+	}
+	_, err = c.RequestTransferExit(nil)
+	return err
+}
+
+// TesterPresent sends a TesterPresent request, and waits for the response.
+func (c *Client) TesterPresent() error {
+	_, err := c.requestSub(SvcTesterPresent, 0)
+	return err
+}
+
+// KeepAlive starts a goroutine that periodically sends a TesterPresent
+// request, with the positive response suppressed, to keep a non-default
+// session active. The returned function stops the goroutine.
+func (c *Client) KeepAlive(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				c.Request(SvcTesterPresent, []byte{suppressPosResponse})
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-exited
+		})
+	}
+}
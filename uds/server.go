@@ -0,0 +1,513 @@
+package uds
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knieriem/can/isotp"
+)
+
+// HandlerFunc handles a request for a service. It receives the request
+// bytes following the service identifier, and returns the bytes of the
+// positive response following the response service identifier. If an
+// NRC is returned as error, it is sent within a negative response;
+// other errors result in a GeneralReject.
+type HandlerFunc func(req []byte) ([]byte, error)
+
+// RoutineFunc implements a routine controlled using RoutineControl.
+// It receives the routine control option record, and returns the
+// routine status record. Errors are handled like in a HandlerFunc.
+type RoutineFunc func(t RoutineControlType, opt []byte) ([]byte, error)
+
+// Server simulates an ECU. It implements the services supported by
+// Client, based on data identifiers, routines, and callback functions
+// that have been registered; further services may be added using
+// HandleService.
+//
+// SecurityAccess is available in non-default sessions, if Key is set.
+// RequestDownload requires the programming session, and, if Key is set,
+// an unlocked security level. A non-default session is left if no
+// request has been received for the time S3.
+type Server struct {
+	// Key computes the key expected for a seed. If Key is nil,
+	// SecurityAccess is not supported.
+	Key KeyFunc
+
+	// Seed returns a seed for the given security level.
+	// If nil, random four-byte seeds are used.
+	Seed func(level byte) []byte
+
+	// WriteLevel is the security level that must be unlocked for
+	// WriteDataByIdentifier to be accepted; 0 means no restriction.
+	WriteLevel byte
+
+	// Download is called with the data received after a download has
+	// been completed. If nil, the download services are not supported.
+	Download func(addr uint32, data []byte) error
+
+	// MaxBlockLen is the maximum length of TransferData requests
+	// reported to the client. A zero value means 258.
+	MaxBlockLen int
+
+	// Reset, if not nil, is called when an ECUReset request is received.
+	Reset func(t ResetType) error
+
+	// P2 is the maximum time the server needs to respond to a request;
+	// if a request takes longer, a "response pending" negative response
+	// is sent, and repeated periodically within P2Star. Zero values
+	// mean DefaultP2 and DefaultP2Star.
+	P2     time.Duration
+	P2Star time.Duration
+
+	mu       sync.Mutex
+	dids     map[uint16]*dataID
+	routines map[uint16]RoutineFunc
+	handlers map[Service]HandlerFunc
+
+	session   Session
+	unlocked  byte
+	seedLevel byte
+	seed      []byte
+	attempts  int
+	dl        *download
+}
+
+type dataID struct {
+	value    []byte
+	writable bool
+}
+
+type download struct {
+	addr uint32
+	size int
+	data []byte
+	seq  byte
+}
+
+// maxAttempts is the number of invalid keys accepted by a Server
+// before responding with ExceededNumberOfAttempts.
+const maxAttempts = 3
+
+// SetDID sets the value of a data identifier. If writable is
+// false, the value may only be read by a client.
+func (s *Server) SetDID(id uint16, value []byte, writable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dids == nil {
+		s.dids = make(map[uint16]*dataID)
+	}
+	s.dids[id] = &dataID{value: slices.Clone(value), writable: writable}
+}
+
+// DID returns the current value of a data identifier.
+func (s *Server) DID(id uint16) (value []byte, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.dids[id]
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(d.value), true
+}
+
+// HandleRoutine registers a routine.
+func (s *Server) HandleRoutine(id uint16, f RoutineFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.routines == nil {
+		s.routines = make(map[uint16]RoutineFunc)
+	}
+	s.routines[id] = f
+}
+
+// HandleService registers a handler for a service. Handlers
+// take precedence over the server's own implementations.
+func (s *Server) HandleService(svc Service, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[Service]HandlerFunc)
+	}
+	s.handlers[svc] = h
+}
+
+// Serve reads requests from t and responds to them, until reading
+// from t fails. Serve must not be called concurrently.
+func (s *Server) Serve(t isotp.Transport) error {
+	s.resetSession()
+	buf := make([]byte, maxResponse)
+	for {
+		var deadline time.Time
+		if s.session != DefaultSession {
+			deadline = time.Now().Add(S3)
+		}
+		t.SetReadDeadline(deadline)
+		n, err := t.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				s.resetSession()
+				continue
+			}
+			if err == io.ErrShortBuffer {
+				continue
+			}
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		resp, err := s.respond(t, slices.Clone(buf[:n]))
+		if err != nil {
+			return err
+		}
+		if resp != nil {
+			if _, err := t.Write(resp); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *Server) resetSession() {
+	s.session = DefaultSession
+	s.unlocked = 0
+	s.seedLevel = 0
+	s.dl = nil
+}
+
+func (s *Server) p2() time.Duration {
+	if s.P2 == 0 {
+		return DefaultP2
+	}
+	return s.P2
+}
+
+func (s *Server) p2Star() time.Duration {
+	if s.P2Star == 0 {
+		return DefaultP2Star
+	}
+	return s.P2Star
+}
+
+// respond processes a request, and returns the response to be sent,
+// which is nil if the positive response is suppressed. If processing
+// takes longer than P2, "response pending" notifications are sent.
+func (s *Server) respond(t isotp.Transport, req []byte) ([]byte, error) {
+	type result struct {
+		resp []byte
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := s.handle(req)
+		done <- result{resp, err}
+	}()
+
+	svc := Service(req[0])
+	timer := time.NewTimer(s.p2())
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-done:
+			if r.err != nil {
+				code := GeneralReject
+				errors.As(r.err, &code)
+				return []byte{negativeResponse, byte(svc), byte(code)}, nil
+			}
+			if svc.hasSubFunction() && len(req) > 1 && req[1]&suppressPosResponse != 0 {
+				return nil, nil
+			}
+			return append([]byte{byte(svc) + positiveOffset}, r.resp...), nil
+		case <-timer.C:
+			_, err := t.Write([]byte{negativeResponse, byte(svc), byte(ResponsePending)})
+			if err != nil {
+				return nil, err
+			}
+			timer.Reset(s.p2Star() / 2)
+		}
+	}
+}
+
+// handle processes a request, and returns the positive
+// response data following the response service identifier.
+func (s *Server) handle(req []byte) ([]byte, error) {
+	svc := Service(req[0])
+	s.mu.Lock()
+	h := s.handlers[svc]
+	s.mu.Unlock()
+	if h != nil {
+		return h(req[1:])
+	}
+
+	if svc.hasSubFunction() && len(req) < 2 {
+		return nil, IncorrectMessageLength
+	}
+	switch svc {
+	case SvcDiagnosticSessionControl:
+		return s.sessionControl(req[1:])
+	case SvcECUReset:
+		return s.ecuReset(req[1:])
+	case SvcReadDataByIdentifier:
+		return s.readDID(req[1:])
+	case SvcWriteDataByIdentifier:
+		return s.writeDID(req[1:])
+	case SvcSecurityAccess:
+		return s.securityAccess(req[1:])
+	case SvcRoutineControl:
+		return s.routineControl(req[1:])
+	case SvcRequestDownload:
+		return s.requestDownload(req[1:])
+	case SvcTransferData:
+		return s.transferData(req[1:])
+	case SvcRequestTransferExit:
+		return s.transferExit(req[1:])
+	case SvcTesterPresent:
+		if len(req) != 2 {
+			return nil, IncorrectMessageLength
+		}
+		if req[1]&^suppressPosResponse != 0 {
+			return nil, SubFunctionNotSupported
+		}
+		return []byte{0}, nil
+	}
+	return nil, ServiceNotSupported
+}
+
+func (s *Server) sessionControl(req []byte) ([]byte, error) {
+	if len(req) != 1 {
+		return nil, IncorrectMessageLength
+	}
+	sub := req[0] &^ suppressPosResponse
+	switch Session(sub) {
+	case DefaultSession, ProgrammingSession, ExtendedSession:
+	default:
+		return nil, SubFunctionNotSupported
+	}
+	s.resetSession()
+	s.session = Session(sub)
+	resp := []byte{sub}
+	resp = binary.BigEndian.AppendUint16(resp, uint16(s.p2()/time.Millisecond))
+	resp = binary.BigEndian.AppendUint16(resp, uint16(s.p2Star()/(10*time.Millisecond)))
+	return resp, nil
+}
+
+func (s *Server) ecuReset(req []byte) ([]byte, error) {
+	if len(req) != 1 {
+		return nil, IncorrectMessageLength
+	}
+	sub := req[0] &^ suppressPosResponse
+	switch ResetType(sub) {
+	case HardReset, KeyOffOnReset, SoftReset:
+	default:
+		return nil, SubFunctionNotSupported
+	}
+	if s.Reset != nil {
+		if err := s.Reset(ResetType(sub)); err != nil {
+			return nil, err
+		}
+	}
+	s.resetSession()
+	return []byte{sub}, nil
+}
+
+func (s *Server) readDID(req []byte) ([]byte, error) {
+	if len(req) != 2 {
+		return nil, IncorrectMessageLength
+	}
+	value, ok := s.DID(binary.BigEndian.Uint16(req))
+	if !ok {
+		return nil, RequestOutOfRange
+	}
+	return append(req[:2:2], value...), nil
+}
+
+func (s *Server) writeDID(req []byte) ([]byte, error) {
+	if len(req) < 3 {
+		return nil, IncorrectMessageLength
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.dids[binary.BigEndian.Uint16(req)]
+	if !ok || !d.writable {
+		return nil, RequestOutOfRange
+	}
+	if s.WriteLevel != 0 && s.unlocked != s.WriteLevel {
+		return nil, SecurityAccessDenied
+	}
+	d.value = slices.Clone(req[2:])
+	return req[:2], nil
+}
+
+func (s *Server) securityAccess(req []byte) ([]byte, error) {
+	if s.Key == nil {
+		return nil, ServiceNotSupported
+	}
+	if s.session == DefaultSession {
+		return nil, ServiceNotSupportedInActiveSession
+	}
+	sub := req[0] &^ suppressPosResponse
+	if sub == 0 || sub >= 0x7F {
+		return nil, SubFunctionNotSupported
+	}
+	if sub&1 == 1 {
+		// request seed
+		if len(req) != 1 {
+			return nil, IncorrectMessageLength
+		}
+		if s.attempts >= maxAttempts {
+			return nil, ExceededNumberOfAttempts
+		}
+		if s.unlocked == sub {
+			return []byte{sub, 0, 0, 0, 0}, nil
+		}
+		if s.Seed != nil {
+			s.seed = s.Seed(sub)
+		} else {
+			s.seed = binary.BigEndian.AppendUint32(nil, rand.Uint32()|1)
+		}
+		s.seedLevel = sub
+		return append([]byte{sub}, s.seed...), nil
+	}
+
+	// send key
+	level := s.seedLevel
+	if level == 0 || sub != level+1 {
+		return nil, RequestSequenceError
+	}
+	s.seedLevel = 0
+	want, err := s.Key(level, s.seed)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(req[1:], want) {
+		s.attempts++
+		if s.attempts >= maxAttempts {
+			return nil, ExceededNumberOfAttempts
+		}
+		return nil, InvalidKey
+	}
+	s.attempts = 0
+	s.unlocked = level
+	return []byte{sub}, nil
+}
+
+func (s *Server) routineControl(req []byte) ([]byte, error) {
+	if len(req) < 3 {
+		return nil, IncorrectMessageLength
+	}
+	sub := req[0] &^ suppressPosResponse
+	switch RoutineControlType(sub) {
+	case StartRoutine, StopRoutine, RequestRoutineResults:
+	default:
+		return nil, SubFunctionNotSupported
+	}
+	s.mu.Lock()
+	f := s.routines[binary.BigEndian.Uint16(req[1:])]
+	s.mu.Unlock()
+	if f == nil {
+		return nil, RequestOutOfRange
+	}
+	status, err := f(RoutineControlType(sub), req[3:])
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{sub, req[1], req[2]}, status...), nil
+}
+
+func (s *Server) maxBlockLen() int {
+	if s.MaxBlockLen == 0 {
+		return 258
+	}
+	return s.MaxBlockLen
+}
+
+func (s *Server) requestDownload(req []byte) ([]byte, error) {
+	if s.Download == nil {
+		return nil, ServiceNotSupported
+	}
+	if s.session != ProgrammingSession {
+		return nil, ServiceNotSupportedInActiveSession
+	}
+	if s.Key != nil && s.unlocked == 0 {
+		return nil, SecurityAccessDenied
+	}
+	if len(req) < 2 {
+		return nil, IncorrectMessageLength
+	}
+	if s.dl != nil {
+		return nil, ConditionsNotCorrect
+	}
+	nSize := int(req[1] >> 4)
+	nAddr := int(req[1] & 0xF)
+	if nSize == 0 || nSize > 4 || nAddr == 0 || nAddr > 4 {
+		return nil, RequestOutOfRange
+	}
+	if len(req) != 2+nAddr+nSize {
+		return nil, IncorrectMessageLength
+	}
+	if req[0] != 0 {
+		// compression and encryption are not supported
+		return nil, RequestOutOfRange
+	}
+	dl := new(download)
+	dl.addr = uint32(beUint(req[2 : 2+nAddr]))
+	dl.size = int(beUint(req[2+nAddr:]))
+	dl.seq = 1
+	s.dl = dl
+	return binary.BigEndian.AppendUint16([]byte{0x20}, uint16(s.maxBlockLen())), nil
+}
+
+func beUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func (s *Server) transferData(req []byte) ([]byte, error) {
+	dl := s.dl
+	if dl == nil {
+		return nil, RequestSequenceError
+	}
+	if len(req) < 1 || len(req)+1 > s.maxBlockLen() {
+		return nil, IncorrectMessageLength
+	}
+	seq := req[0]
+	if seq == dl.seq-1 && len(dl.data) != 0 {
+		// repeated request, data has already been stored
+		return []byte{seq}, nil
+	}
+	if seq != dl.seq {
+		return nil, WrongBlockSequenceCounter
+	}
+	if len(dl.data)+len(req)-1 > dl.size {
+		return nil, TransferDataSuspended
+	}
+	dl.data = append(dl.data, req[1:]...)
+	dl.seq++
+	return []byte{seq}, nil
+}
+
+func (s *Server) transferExit(req []byte) ([]byte, error) {
+	dl := s.dl
+	if dl == nil || len(dl.data) != dl.size {
+		return nil, RequestSequenceError
+	}
+	s.dl = nil
+	if err := s.Download(dl.addr, dl.data); err != nil {
+		var code NRC
+		if errors.As(err, &code) {
+			return nil, code
+		}
+		return nil, GeneralProgrammingFailure
+	}
+	return nil, nil
+}
@@ -0,0 +1,207 @@
+// Package uds implements Unified Diagnostic Services (ISO 14229)
+// on top of an ISO-TP transport.
+//
+// A [Client] provides typed methods for the commonly used services,
+// decodes negative responses, and handles "response pending"
+// notifications. A [Server] simulates an ECU; it may be used for
+// testing clients, for example on a virtual CAN bus.
+package uds
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Service is a UDS service identifier.
+type Service byte
+
+// Service identifiers
+const (
+	SvcDiagnosticSessionControl Service = 0x10
+	SvcECUReset                 Service = 0x11
+	SvcReadDataByIdentifier     Service = 0x22
+	SvcSecurityAccess           Service = 0x27
+	SvcWriteDataByIdentifier    Service = 0x2E
+	SvcRoutineControl           Service = 0x31
+	SvcRequestDownload          Service = 0x34
+	SvcTransferData             Service = 0x36
+	SvcRequestTransferExit      Service = 0x37
+	SvcTesterPresent            Service = 0x3E
+)
+
+const (
+	negativeResponse = 0x7F
+	positiveOffset   = 0x40
+
+	// suppressPosResponse may be set in the sub-function byte of
+	// a request to indicate that no positive response is wanted.
+	suppressPosResponse = 0x80
+)
+
+var serviceNames = map[Service]string{
+	SvcDiagnosticSessionControl: "DiagnosticSessionControl",
+	SvcECUReset:                 "ECUReset",
+	SvcReadDataByIdentifier:     "ReadDataByIdentifier",
+	SvcSecurityAccess:           "SecurityAccess",
+	SvcWriteDataByIdentifier:    "WriteDataByIdentifier",
+	SvcRoutineControl:           "RoutineControl",
+	SvcRequestDownload:          "RequestDownload",
+	SvcTransferData:             "TransferData",
+	SvcRequestTransferExit:      "RequestTransferExit",
+	SvcTesterPresent:            "TesterPresent",
+}
+
+func (s Service) String() string {
+	if name, ok := serviceNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("service %#02x", byte(s))
+}
+
+// hasSubFunction reports whether requests of the service
+// start with a sub-function byte.
+func (s Service) hasSubFunction() bool {
+	switch s {
+	case SvcDiagnosticSessionControl, SvcECUReset, SvcSecurityAccess,
+		SvcRoutineControl, SvcTesterPresent:
+		return true
+	}
+	return false
+}
+
+// NRC is a negative response code. It implements the error interface,
+// so that errors returned by a Client may be tested using errors.Is,
+// and a Server handler may return an NRC to select the negative
+// response sent to the client.
+type NRC byte
+
+// Negative response codes
+const (
+	GeneralReject                          NRC = 0x10
+	ServiceNotSupported                    NRC = 0x11
+	SubFunctionNotSupported                NRC = 0x12
+	IncorrectMessageLength                 NRC = 0x13
+	ResponseTooLong                        NRC = 0x14
+	BusyRepeatRequest                      NRC = 0x21
+	ConditionsNotCorrect                   NRC = 0x22
+	RequestSequenceError                   NRC = 0x24
+	RequestOutOfRange                      NRC = 0x31
+	SecurityAccessDenied                   NRC = 0x33
+	InvalidKey                             NRC = 0x35
+	ExceededNumberOfAttempts               NRC = 0x36
+	RequiredTimeDelayNotExpired            NRC = 0x37
+	UploadDownloadNotAccepted              NRC = 0x70
+	TransferDataSuspended                  NRC = 0x71
+	GeneralProgrammingFailure              NRC = 0x72
+	WrongBlockSequenceCounter              NRC = 0x73
+	ResponsePending                        NRC = 0x78
+	SubFunctionNotSupportedInActiveSession NRC = 0x7E
+	ServiceNotSupportedInActiveSession     NRC = 0x7F
+)
+
+var nrcText = map[NRC]string{
+	GeneralReject:                          "general reject",
+	ServiceNotSupported:                    "service not supported",
+	SubFunctionNotSupported:                "sub-function not supported",
+	IncorrectMessageLength:                 "incorrect message length or invalid format",
+	ResponseTooLong:                        "response too long",
+	BusyRepeatRequest:                      "busy, repeat request",
+	ConditionsNotCorrect:                   "conditions not correct",
+	RequestSequenceError:                   "request sequence error",
+	RequestOutOfRange:                      "request out of range",
+	SecurityAccessDenied:                   "security access denied",
+	InvalidKey:                             "invalid key",
+	ExceededNumberOfAttempts:               "exceeded number of attempts",
+	RequiredTimeDelayNotExpired:            "required time delay not expired",
+	UploadDownloadNotAccepted:              "upload/download not accepted",
+	TransferDataSuspended:                  "transfer data suspended",
+	GeneralProgrammingFailure:              "general programming failure",
+	WrongBlockSequenceCounter:              "wrong block sequence counter",
+	ResponsePending:                        "request correctly received, response pending",
+	SubFunctionNotSupportedInActiveSession: "sub-function not supported in active session",
+	ServiceNotSupportedInActiveSession:     "service not supported in active session",
+}
+
+func (c NRC) String() string {
+	if s, ok := nrcText[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("NRC %#02x", byte(c))
+}
+
+func (c NRC) Error() string {
+	return "uds: " + c.String()
+}
+
+// NegativeResponseError is returned by a Client
+// if a request has been answered with a negative response.
+type NegativeResponseError struct {
+	Service Service
+	Code    NRC
+}
+
+func (e *NegativeResponseError) Error() string {
+	return "uds: " + e.Service.String() + ": " + e.Code.String()
+}
+
+func (e *NegativeResponseError) Unwrap() error {
+	return e.Code
+}
+
+var (
+	ErrInvalidResponse = errors.New("uds: invalid response")
+	ErrTimeout         = errors.New("uds: timeout waiting for response")
+)
+
+// Session is a diagnostic session type.
+type Session byte
+
+const (
+	DefaultSession     Session = 1
+	ProgrammingSession Session = 2
+	ExtendedSession    Session = 3
+)
+
+// ResetType selects the kind of reset performed by ECUReset.
+type ResetType byte
+
+const (
+	HardReset     ResetType = 1
+	KeyOffOnReset ResetType = 2
+	SoftReset     ResetType = 3
+)
+
+// RoutineControlType selects the action of RoutineControl.
+type RoutineControlType byte
+
+const (
+	StartRoutine          RoutineControlType = 1
+	StopRoutine           RoutineControlType = 2
+	RequestRoutineResults RoutineControlType = 3
+)
+
+// KeyFunc computes the key for a seed received from an ECU
+// within SecurityAccess, for the given (odd) security level.
+type KeyFunc func(level byte, seed []byte) ([]byte, error)
+
+// SessionTiming contains the timing parameters reported by
+// a server in a response to DiagnosticSessionControl.
+type SessionTiming struct {
+	// P2 is the maximum time the server needs to respond to a request.
+	P2 time.Duration
+
+	// P2Star is the maximum time the server needs to respond after
+	// having sent a "response pending" negative response.
+	P2Star time.Duration
+}
+
+// Default timing parameters
+const (
+	DefaultP2     = 50 * time.Millisecond
+	DefaultP2Star = 5 * time.Second
+
+	// S3 is the time after which a server returns
+	// to the default session if no request has been received.
+	S3 = 5 * time.Second
+)
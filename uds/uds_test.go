@@ -0,0 +1,177 @@
+package uds
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/knieriem/can"
+	_ "github.com/knieriem/can/drv/virtual"
+	"github.com/knieriem/can/isotp"
+)
+
+func xorKey(level byte, seed []byte) ([]byte, error) {
+	key := make([]byte, len(seed))
+	for i, b := range seed {
+		key[i] = b ^ 0x5A ^ level
+	}
+	return key, nil
+}
+
+func startServer(t *testing.T, bus string, s *Server) *Client {
+	t.Helper()
+	cfg := isotp.Config{TxID: 0x7E0, RxID: 0x7E8}
+	open := func(cfg *isotp.Config) *isotp.Conn {
+		dev, err := can.Open("virtual:" + bus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return isotp.NewConn(dev, cfg)
+	}
+	tc := open(&cfg)
+	cfg.TxID, cfg.RxID = cfg.RxID, cfg.TxID
+	sc := open(&cfg)
+
+	done := make(chan struct{})
+	go func() {
+		s.Serve(sc)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		tc.Close()
+		sc.Close()
+		<-done
+	})
+	return NewClient(tc)
+}
+
+func TestDataByIdentifier(t *testing.T) {
+	s := new(Server)
+	s.SetDID(0xF190, []byte("WVWZZZ1JZXW000001"), false)
+	s.SetDID(0x0100, []byte{1, 2}, true)
+	c := startServer(t, "uds-did", s)
+
+	v, err := c.ReadDataByIdentifier(0xF190)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != "WVWZZZ1JZXW000001" {
+		t.Errorf("VIN: got %q", v)
+	}
+	if err := c.WriteDataByIdentifier(0x0100, []byte{3, 4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.DID(0x0100); !bytes.Equal(v, []byte{3, 4, 5}) {
+		t.Errorf("DID 0100: got % x", v)
+	}
+
+	_, err = c.ReadDataByIdentifier(0x1234)
+	var nr *NegativeResponseError
+	if !errors.As(err, &nr) || nr.Service != SvcReadDataByIdentifier || nr.Code != RequestOutOfRange {
+		t.Errorf("unknown DID: got %v", err)
+	}
+	if err := c.WriteDataByIdentifier(0xF190, []byte{0}); !errors.Is(err, RequestOutOfRange) {
+		t.Errorf("read-only DID: got %v", err)
+	}
+}
+
+func TestSecurityAccess(t *testing.T) {
+	s := new(Server)
+	s.Key = xorKey
+	s.WriteLevel = 1
+	s.SetDID(0x0100, []byte{0}, true)
+	c := startServer(t, "uds-security", s)
+
+	if err := c.SecurityAccess(1, xorKey); !errors.Is(err, ServiceNotSupportedInActiveSession) {
+		t.Errorf("default session: got %v", err)
+	}
+	if _, err := c.DiagnosticSessionControl(ExtendedSession); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteDataByIdentifier(0x0100, []byte{1}); !errors.Is(err, SecurityAccessDenied) {
+		t.Errorf("locked: got %v", err)
+	}
+	badKey := func(byte, []byte) ([]byte, error) { return []byte{0}, nil }
+	if err := c.SecurityAccess(1, badKey); !errors.Is(err, InvalidKey) {
+		t.Errorf("bad key: got %v", err)
+	}
+	if err := c.SecurityAccess(1, xorKey); err != nil {
+		t.Fatal(err)
+	}
+	// already unlocked: the server sends a zero seed
+	if err := c.SecurityAccess(1, badKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteDataByIdentifier(0x0100, []byte{1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResponsePending(t *testing.T) {
+	s := new(Server)
+	s.P2 = 10 * time.Millisecond
+	s.P2Star = 200 * time.Millisecond
+	s.HandleRoutine(0xFF00, func(t RoutineControlType, opt []byte) ([]byte, error) {
+		if t != StartRoutine {
+			return nil, SubFunctionNotSupported
+		}
+		time.Sleep(150 * time.Millisecond)
+		return []byte{0, opt[0] + 1}, nil
+	})
+	c := startServer(t, "uds-pending", s)
+	timing, err := c.DiagnosticSessionControl(ExtendedSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timing.P2 != s.P2 || timing.P2Star != s.P2Star {
+		t.Errorf("timing: got %+v", timing)
+	}
+
+	status, err := c.RoutineControl(StartRoutine, 0xFF00, []byte{41})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(status, []byte{0, 42}) {
+		t.Errorf("routine status: got % x", status)
+	}
+	_, err = c.RoutineControl(StopRoutine, 0xFF00, nil)
+	if !errors.Is(err, SubFunctionNotSupported) {
+		t.Errorf("stop routine: got %v", err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	var got []byte
+	var gotAddr uint32
+	s := new(Server)
+	s.MaxBlockLen = 66
+	s.Download = func(addr uint32, data []byte) error {
+		gotAddr = addr
+		got = data
+		return nil
+	}
+	c := startServer(t, "uds-download", s)
+
+	image := make([]byte, 1000)
+	for i := range image {
+		image[i] = byte(i)
+	}
+	if err := c.Download(0x8000, image, 0); !errors.Is(err, ServiceNotSupportedInActiveSession) {
+		t.Errorf("default session: got %v", err)
+	}
+	if _, err := c.DiagnosticSessionControl(ProgrammingSession); err != nil {
+		t.Fatal(err)
+	}
+	stop := c.KeepAlive(20 * time.Millisecond)
+	defer stop()
+	if err := c.Download(0x8000, image, 0); err != nil {
+		t.Fatal(err)
+	}
+	if gotAddr != 0x8000 || !bytes.Equal(got, image) {
+		t.Errorf("download: got %d bytes at %#x", len(got), gotAddr)
+	}
+	if err := c.ECUReset(HardReset); err != nil {
+		t.Fatal(err)
+	}
+}